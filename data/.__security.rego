@@ -8,6 +8,9 @@ Create{
     #input.claims.groups.username[_] == input.action.name[i] 
     #input.action.action[i] == "Create"
 }
+Admin{
+    input.claims.groups.username[_] == "rob"
+}
 Banner = "PUBLIC"
 BannerForeground = "white"
 BannerBackground = "green"
@@ -2,4 +2,19 @@ module github.com/rfielding/webdev
 
 go 1.17
 
-require github.com/open-policy-agent/opa v0.33.0
+require (
+	github.com/ghodss/yaml v1.0.0
+	github.com/open-policy-agent/opa v0.33.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+)
+
+require (
+	github.com/OneOfOne/xxhash v1.2.8 // indirect
+	github.com/gobwas/glob v0.2.3 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0 // indirect
+	github.com/xeipuuv/gojsonpointer v0.0.0-20190905194746-02993c407bfb // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	github.com/yashtewari/glob-intersection v0.0.0-20180916065949-5c77d914dd0b // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+)
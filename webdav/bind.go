@@ -0,0 +1,337 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	ixml "github.com/rfielding/webdev/webdav/internal/xml"
+)
+
+/*
+  Bindings (RFC 5842) let one resource be at several paths, as a hard
+  link is.  BIND, sent to a collection, binds a resource that is
+  already on this server as a member of it:
+
+    BIND /rob/shared/ HTTP/1.1
+
+    <D:bind xmlns:D="DAV:">
+      <D:segment>report.pdf</D:segment>
+      <D:href>/rob/projects/report.pdf</D:href>
+    </D:bind>
+
+  and UNBIND takes a member away, leaving the resource where else it
+  is bound:
+
+    UNBIND /rob/shared/ HTTP/1.1
+
+    <D:unbind xmlns:D="DAV:"><D:segment>report.pdf</D:segment></D:unbind>
+
+  Every binding shares the content, the dead properties and the locks
+  of the resource, so a lock taken through one path keeps other users
+  from changing it through the rest, and its token works through any
+  of them.  DAV:resource-id and DAV:parent-set, when named in a
+  PROPFIND, tell the bindings of a resource apart from copies of it.
+
+  Only a FileSystem that is a BindingFileSystem can bind.  Others
+  answer BIND and UNBIND with 405.
+*/
+type BindingFileSystem interface {
+	// Bind makes name, which does not exist, another binding of the
+	// resource at existing.
+	Bind(ctx context.Context, existing, name string) error
+	// Bindings are the paths of the resource at name, name among them,
+	// and the id that they share, which is "" while name is its only one.
+	Bindings(ctx context.Context, name string) (id string, paths []string, err error)
+}
+
+// http://greenbytes.de/tech/webdav/rfc5842.html#METHOD_BIND
+type bindInfo struct {
+	XMLName ixml.Name
+	Segment string `xml:"segment"`
+	Href    string `xml:"href"`
+}
+
+func readBindInfo(r io.Reader, limits XMLLimits, root string) (bi bindInfo, status int, err error) {
+	body, status, err := limits.read(r)
+	if err != nil {
+		return bi, status, err
+	}
+	if err := ixml.NewDecoder(bytes.NewReader(body)).Decode(&bi); err != nil {
+		return bi, http.StatusBadRequest, err
+	}
+	if bi.XMLName.Space != "DAV:" || bi.XMLName.Local != root {
+		return bi, http.StatusBadRequest, ErrInvalidBind
+	}
+	bi.Segment, bi.Href = strings.TrimSpace(bi.Segment), strings.TrimSpace(bi.Href)
+	if bi.Segment == "" || bi.Segment == "." || bi.Segment == ".." || strings.Contains(bi.Segment, "/") {
+		return bi, http.StatusBadRequest, ErrInvalidBind
+	}
+	return bi, 0, nil
+}
+
+/*
+  BindSource is the name in the FileSystem of the resource that r, a
+  BIND, would bind, as LocalName has it, or false when its body names
+  none.  The body is read, and put back for the Handler to read.
+*/
+func (h *Handler) BindSource(r *http.Request) (string, bool) {
+	body, _, err := h.xmlLimits().read(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	var bi bindInfo
+	if err := ixml.NewDecoder(bytes.NewReader(body)).Decode(&bi); err != nil {
+		return "", false
+	}
+	return h.LocalName(r, strings.TrimSpace(bi.Href))
+}
+
+// The collection that r is sent to, and the member of it that bi names.
+func (h *Handler) bindTarget(r *http.Request, bi bindInfo) (collection, name string, status int, err error) {
+	collection, status, err = h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return "", "", status, err
+	}
+	fi, err := h.FileSystem.Stat(r.Context(), collection)
+	if err != nil {
+		return "", "", http.StatusNotFound, err
+	}
+	if !fi.IsDir() {
+		return "", "", http.StatusMethodNotAllowed, ErrNotADirectory
+	}
+	return collection, path.Join(collection, bi.Segment), 0, nil
+}
+
+func (h *Handler) handleBind(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	bfs, ok := h.FileSystem.(BindingFileSystem)
+	if !ok {
+		return http.StatusMethodNotAllowed, ErrNotBindable
+	}
+	bi, status, err := readBindInfo(r.Body, h.xmlLimits(), "bind")
+	if err != nil {
+		return status, err
+	}
+	if bi.Href == "" {
+		return http.StatusBadRequest, ErrInvalidBind
+	}
+	u, local, err := h.destination(r, bi.Href)
+	if err != nil {
+		return http.StatusBadRequest, ErrInvalidBind
+	}
+	// a binding cannot reach another server, nor another mount
+	if !local || h.crossesMount(u.Path) {
+		return http.StatusForbidden, ErrNotBindable
+	}
+	existing, status, err := h.stripPrefix(u.Path)
+	if err != nil {
+		return status, err
+	}
+	_, name, status, err := h.bindTarget(r, bi)
+	if err != nil {
+		return status, err
+	}
+	if name == SlashClean(existing) {
+		return http.StatusOK, nil
+	}
+	release, status, err := h.confirmLocks(r, "", name)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	ctx := r.Context()
+	if _, err := h.FileSystem.Stat(ctx, existing); err != nil {
+		return http.StatusConflict, err
+	}
+	created := true
+	if _, err := h.FileSystem.Stat(ctx, name); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, os.ErrExist
+		}
+		if err := h.FileSystem.RemoveAll(ctx, name); err != nil {
+			return http.StatusForbidden, err
+		}
+		created = false
+	}
+	if err := bfs.Bind(ctx, existing, name); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusForbidden, err
+	}
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusOK, nil
+}
+
+func (h *Handler) handleUnbind(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	if _, ok := h.FileSystem.(BindingFileSystem); !ok {
+		return http.StatusMethodNotAllowed, ErrNotBindable
+	}
+	bi, status, err := readBindInfo(r.Body, h.xmlLimits(), "unbind")
+	if err != nil {
+		return status, err
+	}
+	_, name, status, err := h.bindTarget(r, bi)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, name, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	ctx := r.Context()
+	if _, err := h.FileSystem.Stat(ctx, name); err != nil {
+		return http.StatusNotFound, err
+	}
+	if err := h.FileSystem.RemoveAll(ctx, name); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusForbidden, err
+	}
+	return http.StatusOK, nil
+}
+
+// The bindings of the resource at name other than name.
+func otherBindings(ctx context.Context, fs FileSystem, name string) []string {
+	bfs, ok := fs.(BindingFileSystem)
+	if !ok || name == "" {
+		return nil
+	}
+	_, paths, err := bfs.Bindings(ctx, name)
+	if err != nil {
+		return nil
+	}
+	var others []string
+	for _, p := range paths {
+		if p = SlashClean(p); p != SlashClean(name) {
+			others = append(others, p)
+		}
+	}
+	return others
+}
+
+/*
+  Lock the other bindings of src and dst for as long as r takes, as
+  confirmLocks does src and dst, so that what is locked through one
+  binding cannot be changed through another.  A binding that is
+  locked already must be locked with a token in r's If header.
+*/
+func (h *Handler) lockBindings(r *http.Request, src, dst string) (release func(), status int, err error) {
+	ctx := r.Context()
+	others := append(otherBindings(ctx, h.FileSystem, src), otherBindings(ctx, h.FileSystem, dst)...)
+	if len(others) == 0 {
+		return func() {}, 0, nil
+	}
+	// an invalid If header is refused by confirmLocks
+	ih, _ := parseIfHeader(r.Header.Get("If"))
+	now, seen, tokens := time.Now(), map[string]bool{SlashClean(src): true, SlashClean(dst): true}, []string(nil)
+	release = func() {
+		for _, token := range tokens {
+			h.LockSystem.Unlock(now, token)
+		}
+	}
+	for _, b := range others {
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		token, status, err := h.lock(now, b)
+		if err == nil {
+			tokens = append(tokens, token)
+			continue
+		}
+		if err != ErrLocked || !h.confirmedBy(now, ih, b) {
+			release()
+			return nil, status, err
+		}
+	}
+	return release, 0, nil
+}
+
+// Whether one of the lists of ih holds the lock on name.
+func (h *Handler) confirmedBy(now time.Time, ih ifHeader, name string) bool {
+	for _, l := range ih.lists {
+		if release, err := h.LockSystem.Confirm(now, name, "", l.conditions...); err == nil {
+			release()
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm conditions for src and dst through their other bindings, as
+// a lock through one binding of a resource holds for all of them.
+func (h *Handler) confirmBound(ctx context.Context, now time.Time, src, dst string, conditions []Condition) (func(), error) {
+	srcs := append([]string{src}, otherBindings(ctx, h.FileSystem, src)...)
+	dsts := append([]string{dst}, otherBindings(ctx, h.FileSystem, dst)...)
+	if len(srcs) == 1 && len(dsts) == 1 {
+		return nil, ErrConfirmationFailed
+	}
+	for _, s := range srcs {
+		for _, d := range dsts {
+			if s == src && d == dst {
+				continue
+			}
+			release, err := h.LockSystem.Confirm(now, s, d, conditions...)
+			if err != ErrConfirmationFailed {
+				return release, err
+			}
+		}
+	}
+	return nil, ErrConfirmationFailed
+}
+
+// http://greenbytes.de/tech/webdav/rfc5842.html#PROPERTY_resource-id
+func findResourceID(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	bfs, ok := fs.(BindingFileSystem)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	id, _, err := bfs.Bindings(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", os.ErrNotExist
+	}
+	return `<D:href xmlns:D="DAV:">` + escapeXML(id) + `</D:href>`, nil
+}
+
+// http://greenbytes.de/tech/webdav/rfc5842.html#PROPERTY_parent-set
+func findParentSet(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	bfs, ok := fs.(BindingFileSystem)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	_, paths, err := bfs.Bindings(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, p := range paths {
+		if p = SlashClean(p); p == "/" {
+			continue
+		}
+		parent := path.Dir(p)
+		if parent != "/" {
+			parent += "/"
+		}
+		b.WriteString(`<D:parent xmlns:D="DAV:"><D:href>`)
+		b.WriteString(escapeXML(parent))
+		b.WriteString(`</D:href><D:segment>`)
+		b.WriteString(escapeXML(path.Base(p)))
+		b.WriteString(`</D:segment></D:parent>`)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,95 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyOpenFiles is returned when a file cannot be opened within the
+// FileBudget.  The Handler answers it with 503 Service Unavailable.
+var ErrTooManyOpenFiles = errors.New("webdav: too many open files")
+
+// RetryAfter is what the Handler tells a client to wait, in seconds, when
+// there are too many files open.
+const RetryAfter = "2"
+
+/*
+  FileBudget caps the files that a FileSystem has open at once, on the
+  whole server and for each user, so that PROPFINDs of big directories
+  and many parallel GETs cannot run the process out of file handles.
+  A file that is over the budget waits up to Wait for another to be
+  closed, and then fails with ErrTooManyOpenFiles.  Limits that are
+  not more than zero are not checked.
+*/
+type FileBudget struct {
+	Max        int
+	MaxPerUser int
+	Wait       time.Duration
+	// UserOf says whose file is being opened, or "" for nobody in particular
+	UserOf func(ctx context.Context) string
+
+	mu      sync.Mutex
+	open    int
+	perUser map[string]int
+	// closed, and replaced, when a file is released
+	released chan struct{}
+}
+
+// Acquire takes a file from the budget, returning the func that gives it back.
+func (b *FileBudget) Acquire(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	user := ""
+	if b.UserOf != nil {
+		user = b.UserOf(ctx)
+	}
+	var timeout <-chan time.Time
+	for {
+		b.mu.Lock()
+		if b.perUser == nil {
+			b.perUser = make(map[string]int)
+			b.released = make(chan struct{})
+		}
+		if (b.Max <= 0 || b.open < b.Max) && (b.MaxPerUser <= 0 || user == "" || b.perUser[user] < b.MaxPerUser) {
+			b.open++
+			if user != "" {
+				b.perUser[user]++
+			}
+			b.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(func() { b.release(user) }) }, nil
+		}
+		released := b.released
+		b.mu.Unlock()
+		if timeout == nil {
+			if b.Wait <= 0 {
+				return nil, ErrTooManyOpenFiles
+			}
+			timeout = time.After(b.Wait)
+		}
+		select {
+		case <-released:
+		case <-timeout:
+			Log().Warn("too many open files", "request_id", RequestID(ctx), "user", user)
+			return nil, ErrTooManyOpenFiles
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *FileBudget) release(user string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open--
+	if user != "" {
+		if b.perUser[user]--; b.perUser[user] <= 0 {
+			delete(b.perUser, user)
+		}
+	}
+	close(b.released)
+	b.released = make(chan struct{})
+}
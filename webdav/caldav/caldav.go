@@ -0,0 +1,406 @@
+// Package caldav serves calendars (RFC 4791) from the FileSystem of a
+// webdav.Handler, under the same permissions as the rest of it, so that
+// one server can keep both files and team calendars.
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// Namespace is that of the CalDAV elements and properties.
+const Namespace = "urn:ietf:params:xml:ns:caldav"
+
+// The largest calendar object that may be PUT, and the largest request
+// body of MKCALENDAR and REPORT.
+var MaxResourceSize int64 = 1 << 20
+
+var (
+	ErrInvalidCalendarData = errors.New("caldav: invalid calendar data")
+	ErrUIDConflict         = errors.New("caldav: another calendar object has that UID")
+	ErrUnsupportedReport   = errors.New("caldav: unsupported report")
+	ErrTooLarge            = errors.New("caldav: too large")
+)
+
+var (
+	componentSetName = xml.Name{Space: Namespace, Local: "supported-calendar-component-set"}
+	calendarDataName = xml.Name{Space: Namespace, Local: "calendar-data"}
+	homeSetName      = xml.Name{Space: Namespace, Local: "calendar-home-set"}
+)
+
+// What a calendar keeps, unless MKCALENDAR says otherwise
+var defaultComponents = []string{"VEVENT", "VTODO", "VJOURNAL"}
+
+/*
+  Install adds CalDAV to h, through its Methods: MKCALENDAR makes a
+  calendar, which is a directory with a
+  CALDAV:supported-calendar-component-set dead property, and REPORT
+  answers calendar-query and calendar-multiget.  What is PUT into a
+  calendar must be a calendar object: iCalendar data of one kind of
+  component, with a UID that no other object in the calendar has.
+
+    h := &webdav.Handler{FileSystem: fsys, LockSystem: locks}
+    caldav.Install(h, func(ctx context.Context) string {
+      user, _ := ctx.Value("username").(string)
+      return "/" + user + "/"
+    })
+
+  homeSet gives the CALDAV:calendar-home-set of the user of a request,
+  which is where clients look for calendars and make their own; if it is
+  nil or gives "", the property is not found.  Methods that h already has
+  for PUT, OPTIONS, REPORT, MKCOL and PROPFIND are kept, and called for
+  what is not CalDAV's.  COPY and MOVE into a calendar are
+  not checked, and calendars are kept in h's own FileSystem, not in its
+  Mounts.
+*/
+func Install(h *webdav.Handler, homeSet func(ctx context.Context) string) {
+	registerOnce.Do(func() {
+		webdav.RegisterResourceType(calendarType)
+		webdav.RegisterLivePropertyXML(homeSetName, true, findHomeSet)
+	})
+	if h.Methods == nil {
+		h.Methods = make(map[string]webdav.MethodHandler)
+	}
+	c := &calendars{h: h, homeSet: homeSet, next: make(map[string]webdav.MethodHandler)}
+	for _, method := range []string{"PUT", "OPTIONS", "REPORT", "MKCOL", "PROPFIND"} {
+		c.next[method] = h.Methods[method]
+		if c.next[method] == nil {
+			c.next[method] = h.DefaultMethod(method)
+		}
+	}
+	h.Methods["MKCALENDAR"] = c.mkcalendar
+	h.Methods["PUT"] = c.put
+	h.Methods["OPTIONS"] = c.options
+	h.Methods["REPORT"] = c.report
+	h.Methods["PROPFIND"] = c.propfind
+}
+
+var registerOnce sync.Once
+
+type calendars struct {
+	h       *webdav.Handler
+	homeSet func(ctx context.Context) string
+	// what the methods that were replaced would have done
+	next map[string]webdav.MethodHandler
+}
+
+// Falls back to what method would otherwise have done.
+func (c *calendars) fallback(method string, w http.ResponseWriter, r *http.Request) (int, error) {
+	if next := c.next[method]; next != nil {
+		return next(w, r)
+	}
+	return http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod
+}
+
+// The name in the FileSystem of the url path p, if it is under the Prefix.
+func (c *calendars) name(p string) (string, bool) {
+	if c.h.Prefix == "" {
+		return webdav.SlashClean(p), true
+	}
+	rest := strings.TrimPrefix(p, c.h.Prefix)
+	if len(rest) == len(p) {
+		return "", false
+	}
+	return webdav.SlashClean(rest), true
+}
+
+// The components that the calendar at name keeps, if it is one.
+func calendarAt(ctx context.Context, fs webdav.FileSystem, name string) ([]string, bool) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		return nil, false
+	}
+	props, err := f.DeadProps()
+	if err != nil {
+		return nil, false
+	}
+	set, ok := props[componentSetName]
+	if !ok {
+		return nil, false
+	}
+	var comps struct {
+		Comps []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"comp"`
+	}
+	if err := xml.Unmarshal(append(append([]byte("<s>"), set.InnerXML...), "</s>"...), &comps); err != nil {
+		return nil, false
+	}
+	var names []string
+	for _, comp := range comps.Comps {
+		names = append(names, strings.ToUpper(comp.Name))
+	}
+	return names, true
+}
+
+func calendarType(ctx context.Context, fs webdav.FileSystem, name string, fi os.FileInfo) (string, error) {
+	if !fi.IsDir() {
+		return "", nil
+	}
+	if _, ok := calendarAt(ctx, fs, name); !ok {
+		return "", nil
+	}
+	return `<C:calendar xmlns:C="urn:ietf:params:xml:ns:caldav"/>`, nil
+}
+
+type homeSetKey struct{}
+
+// The home set is per Handler, so PROPFIND passes it down in the context.
+func findHomeSet(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+	homeSet, _ := ctx.Value(homeSetKey{}).(func(ctx context.Context) string)
+	if homeSet == nil {
+		return "", os.ErrNotExist
+	}
+	href := homeSet(ctx)
+	if href == "" {
+		return "", os.ErrNotExist
+	}
+	var b bytes.Buffer
+	b.WriteString(`<D:href xmlns:D="DAV:">`)
+	xml.EscapeText(&b, []byte(href))
+	b.WriteString(`</D:href>`)
+	return b.String(), nil
+}
+
+func (c *calendars) propfind(w http.ResponseWriter, r *http.Request) (int, error) {
+	if c.homeSet != nil {
+		r = r.WithContext(context.WithValue(r.Context(), homeSetKey{}, c.homeSet))
+	}
+	return c.fallback("PROPFIND", w, r)
+}
+
+// The value of CALDAV:supported-calendar-component-set for comps.
+func componentSet(comps []string) webdav.Property {
+	var b bytes.Buffer
+	for _, comp := range comps {
+		fmt.Fprintf(&b, `<C:comp xmlns:C="%s" name="`, Namespace)
+		xml.EscapeText(&b, []byte(comp))
+		b.WriteString(`"/>`)
+	}
+	return webdav.Property{XMLName: componentSetName, InnerXML: b.Bytes()}
+}
+
+func readBody(r io.Reader) ([]byte, int, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, MaxResourceSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if int64(len(body)) > MaxResourceSize {
+		return nil, http.StatusRequestEntityTooLarge, ErrTooLarge
+	}
+	return body, 0, nil
+}
+
+func (c *calendars) options(w http.ResponseWriter, r *http.Request) (int, error) {
+	status, err := c.fallback("OPTIONS", w, r)
+	if dav := w.Header().Get("DAV"); dav != "" && status == 0 && err == nil {
+		w.Header().Set("DAV", dav+", calendar-access")
+	}
+	return status, err
+}
+
+// http://greenbytes.de/tech/webdav/rfc4791.html#METHOD_MKCALENDAR
+type mkcalendarInfo struct {
+	XMLName xml.Name
+	Set     struct {
+		Prop struct {
+			Props []rawProp `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+}
+
+type rawProp struct {
+	XMLName  xml.Name
+	InnerXML []byte `xml:",innerxml"`
+	Comps    []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"urn:ietf:params:xml:ns:caldav comp"`
+}
+
+/*
+  MKCALENDAR is a MKCOL, which takes care of locks, permissions and
+  conflicts, followed by setting the properties that it was sent with.
+*/
+func (c *calendars) mkcalendar(w http.ResponseWriter, r *http.Request) (int, error) {
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	var mi mkcalendarInfo
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &mi); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if mi.XMLName.Space != Namespace || mi.XMLName.Local != "mkcalendar" {
+			return http.StatusBadRequest, webdav.ErrInvalidProppatch
+		}
+	}
+	name, ok := c.name(r.URL.Path)
+	if !ok {
+		return http.StatusNotFound, webdav.ErrPrefixMismatch
+	}
+	mkcol := r.Clone(r.Context())
+	mkcol.Method, mkcol.Body, mkcol.ContentLength = "MKCOL", http.NoBody, 0
+	if status, err := c.fallback("MKCOL", w, mkcol); status != http.StatusCreated {
+		return status, err
+	}
+	comps := defaultComponents
+	var props []webdav.Property
+	for _, p := range mi.Set.Prop.Props {
+		if p.XMLName == componentSetName {
+			comps = nil
+			for _, comp := range p.Comps {
+				comps = append(comps, strings.ToUpper(comp.Name))
+			}
+			continue
+		}
+		// clients name calendars with DAV:displayname, which is kept as a
+		// dead property, over the name of the directory
+		if webdav.IsLiveProperty(p.XMLName) && p.XMLName.Local != "displayname" {
+			c.h.FileSystem.RemoveAll(r.Context(), name)
+			return http.StatusForbidden, webdav.ErrNotAllowed
+		}
+		props = append(props, webdav.Property{XMLName: p.XMLName, InnerXML: p.InnerXML})
+	}
+	if len(comps) == 0 {
+		comps = defaultComponents
+	}
+	props = append(props, componentSet(comps))
+	if err := c.patch(r.Context(), name, props); err != nil {
+		// a collection that is not a calendar is not what was asked for
+		c.h.FileSystem.RemoveAll(r.Context(), name)
+		return http.StatusForbidden, err
+	}
+	return http.StatusCreated, nil
+}
+
+// Set props on the resource at name.
+func (c *calendars) patch(ctx context.Context, name string, props []webdav.Property) error {
+	f, err := c.h.FileSystem.OpenFile(ctx, name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	pstats, patchErr := f.Patch([]webdav.Proppatch{{Props: props}})
+	closeErr := f.Close()
+	if patchErr != nil {
+		return patchErr
+	}
+	for _, p := range pstats {
+		if p.Status != http.StatusOK {
+			return webdav.ErrNotAllowed
+		}
+	}
+	return closeErr
+}
+
+// What is PUT into a calendar must be a calendar object that it keeps.
+func (c *calendars) put(w http.ResponseWriter, r *http.Request) (int, error) {
+	name, ok := c.name(r.URL.Path)
+	if !ok {
+		return c.fallback("PUT", w, r)
+	}
+	ctx := r.Context()
+	comps, ok := calendarAt(ctx, c.h.FileSystem, path.Dir(name))
+	if !ok {
+		return c.fallback("PUT", w, r)
+	}
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	cal, err := parseCalendar(body)
+	if err != nil {
+		return http.StatusForbidden, fmt.Errorf("%w: %v", ErrInvalidCalendarData, err)
+	}
+	uid, _, err := checkObject(cal, comps)
+	if err != nil {
+		return http.StatusForbidden, fmt.Errorf("%w: %v", ErrInvalidCalendarData, err)
+	}
+	for _, other := range c.objects(ctx, path.Dir(name)) {
+		if other.name != name && other.uid == uid {
+			return http.StatusForbidden, ErrUIDConflict
+		}
+	}
+	put := r.Clone(ctx)
+	put.Body, put.ContentLength = ioutil.NopCloser(bytes.NewReader(body)), int64(len(body))
+	return c.fallback("PUT", w, put)
+}
+
+// A calendar object, as a REPORT sees it.
+type object struct {
+	name string
+	uid  string
+	data []byte
+	cal  *vobject.Component
+}
+
+// The calendar object at name, if it is one that the user may read.
+func (c *calendars) object(ctx context.Context, name string) (*object, bool) {
+	f, err := c.h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.IsDir() || fi.Size() > MaxResourceSize {
+		return nil, false
+	}
+	if rf, ok := f.(webdav.ReadCheckedFile); ok && !rf.CanRead() {
+		return nil, false
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	cal, err := parseCalendar(data)
+	if err != nil {
+		return nil, false
+	}
+	o := &object{name: name, data: data, cal: cal}
+	for _, comp := range cal.Children {
+		if p, ok := comp.Prop("UID"); ok {
+			o.uid = p.Value
+			break
+		}
+	}
+	return o, true
+}
+
+// The calendar objects in the calendar at name.  Other files are skipped.
+func (c *calendars) objects(ctx context.Context, name string) []*object {
+	f, err := c.h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	infos, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	var objects []*object
+	for _, fi := range infos {
+		if fi.IsDir() {
+			continue
+		}
+		if o, ok := c.object(ctx, path.Join(name, fi.Name())); ok {
+			objects = append(objects, o)
+		}
+	}
+	return objects
+}
@@ -0,0 +1,156 @@
+package caldav
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// http://greenbytes.de/tech/webdav/rfc4791.html#ELEMENT_comp-filter
+type compFilter struct {
+	Name         string       `xml:"name,attr"`
+	IsNotDefined *struct{}    `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	PropFilters  []propFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	CompFilters  []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type propFilter struct {
+	Name         string        `xml:"name,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange    `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	TextMatch    *textMatch    `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+	ParamFilters []paramFilter `xml:"urn:ietf:params:xml:ns:caldav param-filter"`
+}
+
+type paramFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type textMatch struct {
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// The bounds of tr, either of which is zero when it is open.
+func (tr timeRange) bounds() (start, end time.Time, ok bool) {
+	var err error
+	if tr.Start != "" {
+		if start, err = time.Parse("20060102T150405Z", tr.Start); err != nil {
+			return start, end, false
+		}
+	}
+	if tr.End != "" {
+		if end, err = time.Parse("20060102T150405Z", tr.End); err != nil {
+			return start, end, false
+		}
+	}
+	return start, end, tr.Start != "" || tr.End != ""
+}
+
+// Whether some component of comps matches f, or, with is-not-defined,
+// none is named as f is.
+func (f compFilter) matches(comps []*vobject.Component) bool {
+	for _, c := range comps {
+		if c.Name != strings.ToUpper(f.Name) {
+			continue
+		}
+		if f.IsNotDefined != nil {
+			return false
+		}
+		if f.matchesComponent(c) {
+			return true
+		}
+	}
+	return f.IsNotDefined != nil
+}
+
+func (f compFilter) matchesComponent(c *vobject.Component) bool {
+	if f.TimeRange != nil {
+		start, end, ok := f.TimeRange.bounds()
+		if !ok || !overlaps(c, start, end) {
+			return false
+		}
+	}
+	for _, pf := range f.PropFilters {
+		if !pf.matches(c) {
+			return false
+		}
+	}
+	for _, cf := range f.CompFilters {
+		if !cf.matches(c.Children) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f propFilter) matches(c *vobject.Component) bool {
+	found := false
+	for _, p := range c.Props {
+		if p.Name != strings.ToUpper(f.Name) {
+			continue
+		}
+		found = true
+		if f.IsNotDefined != nil {
+			return false
+		}
+		if f.matchesProperty(p) {
+			return true
+		}
+	}
+	return !found && f.IsNotDefined != nil
+}
+
+func (f propFilter) matchesProperty(p vobject.Property) bool {
+	if f.TimeRange != nil {
+		start, end, ok := f.TimeRange.bounds()
+		t, _, err := parseTime(p)
+		if !ok || err != nil || (!start.IsZero() && t.Before(start)) || (!end.IsZero() && !t.Before(end)) {
+			return false
+		}
+	}
+	if f.TextMatch != nil && !f.TextMatch.matches(p.Value) {
+		return false
+	}
+	for _, pf := range f.ParamFilters {
+		values, ok := p.Params[strings.ToUpper(pf.Name)]
+		if pf.IsNotDefined != nil {
+			if ok {
+				return false
+			}
+			continue
+		}
+		if !ok {
+			return false
+		}
+		if pf.TextMatch != nil {
+			matched := false
+			for _, v := range values {
+				matched = matched || pf.TextMatch.matches(v)
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// A substring match, ignoring ASCII case unless the collation is i;octet.
+func (tm textMatch) matches(value string) bool {
+	text := tm.Text
+	if tm.Collation != "i;octet" {
+		text, value = strings.ToLower(text), strings.ToLower(value)
+	}
+	return strings.Contains(value, text) != (tm.Negate == "yes")
+}
@@ -0,0 +1,191 @@
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// Parse one VCALENDAR, which must be all that data holds.
+func parseCalendar(data []byte) (*vobject.Component, error) {
+	return vobject.Parse(data, "VCALENDAR")
+}
+
+/*
+  Check that cal is a calendar object resource (RFC 4791, section 4.1):
+  one kind of component, out of those allowed, with one UID, and no
+  iTIP METHOD.  Time zones are allowed beside it.
+*/
+func checkObject(cal *vobject.Component, allowed []string) (uid, kind string, err error) {
+	for _, name := range []string{"VERSION", "PRODID"} {
+		if _, ok := cal.Prop(name); !ok {
+			return "", "", fmt.Errorf("no %s", name)
+		}
+	}
+	if _, ok := cal.Prop("METHOD"); ok {
+		return "", "", errors.New("calendar objects cannot have a METHOD")
+	}
+	for _, c := range cal.Children {
+		if c.Name == "VTIMEZONE" {
+			continue
+		}
+		if kind == "" {
+			kind = c.Name
+		} else if c.Name != kind {
+			return "", "", fmt.Errorf("both %s and %s", kind, c.Name)
+		}
+		p, ok := c.Prop("UID")
+		if !ok || p.Value == "" {
+			return "", "", fmt.Errorf("%s has no UID", c.Name)
+		}
+		if uid == "" {
+			uid = p.Value
+		} else if p.Value != uid {
+			return "", "", errors.New("more than one UID")
+		}
+	}
+	if kind == "" {
+		return "", "", errors.New("no component")
+	}
+	for _, a := range allowed {
+		if a == kind {
+			return uid, kind, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s is not kept in this calendar", kind)
+}
+
+// The time of a DATE or DATE-TIME value, and whether it was a DATE.
+// Times with a TZID, and floating ones, are taken as UTC.
+func parseTime(p vobject.Property) (t time.Time, date bool, err error) {
+	v := p.Value
+	switch {
+	case len(v) == 8:
+		t, err = time.Parse("20060102", v)
+		return t, true, err
+	case strings.HasSuffix(v, "Z"):
+		t, err = time.Parse("20060102T150405Z", v)
+	default:
+		t, err = time.Parse("20060102T150405", v)
+	}
+	return t, false, err
+}
+
+// A DURATION value, such as -P1DT2H or P2W.
+func parseDuration(v string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(v, "-") {
+		sign, v = -1, v[1:]
+	}
+	v = strings.TrimPrefix(v, "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, errors.New("bad duration")
+	}
+	var d time.Duration
+	n := ""
+	for _, r := range v[1:] {
+		if r >= '0' && r <= '9' {
+			n += string(r)
+			continue
+		}
+		if r == 'T' {
+			continue
+		}
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, errors.New("bad duration")
+		}
+		unit := map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}[r]
+		if unit == 0 {
+			return 0, errors.New("bad duration")
+		}
+		d += time.Duration(i) * unit
+		n = ""
+	}
+	return sign * d, nil
+}
+
+/*
+  Whether c happens in [start, end), as RFC 4791, section 9.9, has it
+  for events, to-dos and journal entries.  Recurring components are
+  taken to happen from their first start on, so clients see them and
+  work out the occurrences themselves.
+*/
+func overlaps(c *vobject.Component, start, end time.Time) bool {
+	dtstart, hasStart := c.Prop("DTSTART")
+	var s, e time.Time
+	var date bool
+	var err error
+	if hasStart {
+		if s, date, err = parseTime(dtstart); err != nil {
+			return false
+		}
+	}
+	_, rrule := c.Prop("RRULE")
+	_, rdate := c.Prop("RDATE")
+	recurs := rrule || rdate
+	switch c.Name {
+	case "VEVENT":
+		if !hasStart {
+			return false
+		}
+		if p, ok := c.Prop("DTEND"); ok {
+			if e, _, err = parseTime(p); err != nil {
+				return false
+			}
+		} else if p, ok := c.Prop("DURATION"); ok {
+			d, err := parseDuration(p.Value)
+			if err != nil {
+				return false
+			}
+			e = s.Add(d)
+		} else if date {
+			e = s.AddDate(0, 0, 1)
+		} else {
+			e = s
+		}
+	case "VTODO":
+		due, hasDue := c.Prop("DUE")
+		if hasDue {
+			if e, _, err = parseTime(due); err != nil {
+				return false
+			}
+		}
+		switch {
+		case hasStart && hasDue:
+		case hasStart:
+			e = s
+			if p, ok := c.Prop("DURATION"); ok {
+				if d, err := parseDuration(p.Value); err == nil {
+					e = s.Add(d)
+				}
+			}
+		case hasDue:
+			s = e
+		default:
+			// an undated to-do is always due
+			return true
+		}
+	case "VJOURNAL":
+		if !hasStart {
+			return false
+		}
+		e = s
+		if date {
+			e = s.AddDate(0, 0, 1)
+		}
+	default:
+		return true
+	}
+	if recurs {
+		return end.IsZero() || s.Before(end)
+	}
+	if s.Equal(e) {
+		return !s.Before(start) && (end.IsZero() || s.Before(end))
+	}
+	return (end.IsZero() || s.Before(end)) && (start.IsZero() || e.After(start))
+}
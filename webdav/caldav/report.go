@@ -0,0 +1,122 @@
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// http://greenbytes.de/tech/webdav/rfc4791.html#ELEMENT_calendar-query
+// and http://greenbytes.de/tech/webdav/rfc4791.html#ELEMENT_calendar-multiget
+type reportInfo struct {
+	XMLName xml.Name
+	Prop    struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+	Filter struct {
+		CompFilter *compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+func (ri reportInfo) props() []xml.Name {
+	var names []xml.Name
+	for _, n := range ri.Prop.Names {
+		names = append(names, n.XMLName)
+	}
+	return names
+}
+
+// Escapes iCalendar data as XML text, keeping its line ends.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+
+// REPORTs of the other namespaces are left to the REPORT that there was.
+func (c *calendars) report(w http.ResponseWriter, r *http.Request) (int, error) {
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	var ri reportInfo
+	if err := xml.Unmarshal(body, &ri); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if ri.XMLName.Space != Namespace {
+		if c.next["REPORT"] == nil {
+			return http.StatusForbidden, ErrUnsupportedReport
+		}
+		next := r.Clone(r.Context())
+		next.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return c.fallback("REPORT", w, next)
+	}
+	name, ok := c.name(r.URL.Path)
+	if !ok {
+		return http.StatusNotFound, webdav.ErrPrefixMismatch
+	}
+	ctx := r.Context()
+	fi, err := c.h.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	var names []string
+	objects := make(map[string]*object)
+	switch ri.XMLName.Local {
+	case "calendar-query":
+		if ri.Filter.CompFilter == nil {
+			return http.StatusBadRequest, ErrUnsupportedReport
+		}
+		var candidates []*object
+		if !fi.IsDir() {
+			if o, ok := c.object(ctx, name); ok {
+				candidates = append(candidates, o)
+			}
+		} else if r.Header.Get("Depth") != "0" {
+			candidates = c.objects(ctx, name)
+		}
+		for _, o := range candidates {
+			if ri.Filter.CompFilter.matches([]*vobject.Component{o.cal}) {
+				names = append(names, o.name)
+				objects[o.name] = o
+			}
+		}
+	case "calendar-multiget":
+		for _, href := range ri.Hrefs {
+			u, err := url.Parse(href)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			n, ok := c.name(u.Path)
+			if !ok {
+				continue
+			}
+			names = append(names, n)
+		}
+	default:
+		return http.StatusForbidden, ErrUnsupportedReport
+	}
+	return c.h.ServeReport(w, r, webdav.Report{
+		Names: names,
+		Props: ri.props(),
+		Computed: func(ctx context.Context, name string, fi os.FileInfo, pname xml.Name) (webdav.Property, bool, error) {
+			if pname != calendarDataName {
+				return webdav.Property{}, false, nil
+			}
+			o, ok := objects[name]
+			if !ok {
+				if o, ok = c.object(ctx, name); !ok {
+					return webdav.Property{}, true, os.ErrNotExist
+				}
+			}
+			return webdav.Property{InnerXML: []byte(textEscaper.Replace(string(o.data)))}, true, nil
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package webdav
+
+import (
+	"context"
+	"os"
+)
+
+// Capabilities say what a user may do with a resource.
+type Capabilities struct {
+	Exists bool `json:"exists"`
+	Dir    bool `json:"dir"`
+	Read   bool `json:"read"`
+	Write  bool `json:"write"`
+	// Create is making the resource, when it does not exist, or making
+	// things in it, when it is a directory.
+	Create bool `json:"create"`
+	Delete bool `json:"delete"`
+	// Banner is what the policy says of the resource, to tell a user
+	// that is denied
+	Banner string `json:"banner,omitempty"`
+}
+
+// CapableFileSystem is an optional interface for a FileSystem that can say
+// what a user may do with a resource, so that OPTIONS only offers the
+// methods that would be allowed.  A resource that the user may not see
+// does not exist.
+type CapableFileSystem interface {
+	Capabilities(ctx context.Context, name string) (Capabilities, error)
+}
+
+// Methods are the methods that c allows, in the order of an Allow header.
+// LOCK and UNLOCK are left out unless locking is allowed.
+func (c Capabilities) Methods(locking bool) []string {
+	methods := []string{"OPTIONS"}
+	add := func(ok bool, names ...string) {
+		if ok {
+			methods = append(methods, names...)
+		}
+	}
+	if !c.Exists {
+		add(c.Create && locking, "LOCK")
+		add(c.Create, "PUT", "MKCOL", "MKREDIRECTREF")
+		return methods
+	}
+	add(c.Write && locking, "LOCK")
+	add(c.Read && !c.Dir, "GET", "HEAD", "POST")
+	add(c.Delete, "DELETE")
+	add(c.Write, "PROPPATCH")
+	add(c.Read, "COPY")
+	add(c.Delete, "MOVE")
+	add(c.Write && locking, "UNLOCK")
+	add(true, "PROPFIND")
+	add(c.Write && !c.Dir, "PUT", "UPDATEREDIRECTREF")
+	return methods
+}
+
+// Allowed says what the user of ctx may do with the resource at name, and
+// the methods that allows.  When the FileSystem cannot say, everything is
+// allowed on what exists.
+func (h *Handler) Allowed(ctx context.Context, name string) (Capabilities, []string, error) {
+	var c Capabilities
+	if cfs, ok := h.FileSystem.(CapableFileSystem); ok {
+		var err error
+		if c, err = cfs.Capabilities(ctx, name); err != nil && !os.IsNotExist(err) {
+			return c, nil, err
+		}
+	} else if fi, err := h.FileSystem.Stat(ctx, name); err == nil {
+		c = Capabilities{Exists: true, Dir: fi.IsDir(), Read: true, Write: true, Create: fi.IsDir(), Delete: true}
+	} else {
+		c = Capabilities{Create: true}
+	}
+	methods := c.Methods(h.lockMode(name) != LockNone)
+	if _, ok := h.FileSystem.(BindingFileSystem); ok && c.Dir && c.Create {
+		// members are bound and unbound as they are created and deleted
+		methods = append(methods, "BIND", "UNBIND")
+	}
+	return c, methods, nil
+}
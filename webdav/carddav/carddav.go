@@ -0,0 +1,319 @@
+// Package carddav serves address books (RFC 6352) from the FileSystem of a
+// webdav.Handler, under the same permissions as the rest of it, so that
+// contacts can be kept on the same server as files.
+package carddav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+// Namespace is that of the CardDAV elements and properties.
+const Namespace = "urn:ietf:params:xml:ns:carddav"
+
+// The largest vCard that may be PUT, and the largest request body of an
+// extended MKCOL and of REPORT.
+var MaxResourceSize int64 = 1 << 20
+
+var (
+	ErrInvalidAddressData = errors.New("carddav: invalid address data")
+	ErrUIDConflict        = errors.New("carddav: another vCard has that UID")
+	ErrUnsupportedReport  = errors.New("carddav: unsupported report")
+	ErrTooLarge           = errors.New("carddav: too large")
+)
+
+var (
+	addressDataSetName = xml.Name{Space: Namespace, Local: "supported-address-data"}
+	addressDataName    = xml.Name{Space: Namespace, Local: "address-data"}
+	homeSetName        = xml.Name{Space: Namespace, Local: "addressbook-home-set"}
+	addressbookName    = xml.Name{Space: Namespace, Local: "addressbook"}
+)
+
+// What an address book says it keeps
+const supportedAddressData = `<C:address-data-type xmlns:C="urn:ietf:params:xml:ns:carddav" content-type="text/vcard" version="3.0"/>` +
+	`<C:address-data-type xmlns:C="urn:ietf:params:xml:ns:carddav" content-type="text/vcard" version="4.0"/>`
+
+/*
+  Install adds CardDAV to h, through its Methods: an extended MKCOL
+  (RFC 5689) whose resourcetype has CARDDAV:addressbook makes an address
+  book, which is a directory with a CARDDAV:supported-address-data dead
+  property, and REPORT answers addressbook-query and addressbook-multiget.
+  What is PUT into an address book must be one vCard with a UID that no
+  other vCard in it has.
+
+    h := &webdav.Handler{FileSystem: fsys, LockSystem: locks}
+    carddav.Install(h, func(ctx context.Context) string {
+      user, _ := ctx.Value("username").(string)
+      return "/" + user + "/"
+    })
+
+  homeSet gives the CARDDAV:addressbook-home-set of the user of a
+  request, which is where clients look for address books and make their
+  own; if it is nil or gives "", the property is not found.  Methods that
+  h already has for PUT, OPTIONS, REPORT, MKCOL and PROPFIND are kept, and
+  called for what is not CardDAV's.  COPY and MOVE into an address book
+  are not checked.
+*/
+func Install(h *webdav.Handler, homeSet func(ctx context.Context) string) {
+	registerOnce.Do(func() {
+		webdav.RegisterResourceType(addressbookType)
+		webdav.RegisterLivePropertyXML(homeSetName, true, findHomeSet)
+	})
+	if h.Methods == nil {
+		h.Methods = make(map[string]webdav.MethodHandler)
+	}
+	a := &addressbooks{h: h, homeSet: homeSet, next: make(map[string]webdav.MethodHandler)}
+	for _, method := range []string{"PUT", "OPTIONS", "REPORT", "MKCOL", "PROPFIND"} {
+		a.next[method] = h.Methods[method]
+		if a.next[method] == nil {
+			a.next[method] = h.DefaultMethod(method)
+		}
+	}
+	h.Methods["MKCOL"] = a.mkcol
+	h.Methods["PUT"] = a.put
+	h.Methods["OPTIONS"] = a.options
+	h.Methods["REPORT"] = a.report
+	h.Methods["PROPFIND"] = a.propfind
+}
+
+var registerOnce sync.Once
+
+type addressbooks struct {
+	h       *webdav.Handler
+	homeSet func(ctx context.Context) string
+	// what the methods that were replaced would have done
+	next map[string]webdav.MethodHandler
+}
+
+// Falls back to what method would otherwise have done.
+func (a *addressbooks) fallback(method string, w http.ResponseWriter, r *http.Request) (int, error) {
+	if next := a.next[method]; next != nil {
+		return next(w, r)
+	}
+	return http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod
+}
+
+// The name in the FileSystem of the url path p, if it is under the Prefix.
+func (a *addressbooks) name(p string) (string, bool) {
+	if a.h.Prefix == "" {
+		return webdav.SlashClean(p), true
+	}
+	rest := strings.TrimPrefix(p, a.h.Prefix)
+	if len(rest) == len(p) {
+		return "", false
+	}
+	return webdav.SlashClean(rest), true
+}
+
+// Whether the directory at name is an address book.
+func addressbookAt(ctx context.Context, fs webdav.FileSystem, name string) bool {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		return false
+	}
+	props, err := f.DeadProps()
+	if err != nil {
+		return false
+	}
+	_, ok := props[addressDataSetName]
+	return ok
+}
+
+func addressbookType(ctx context.Context, fs webdav.FileSystem, name string, fi os.FileInfo) (string, error) {
+	if !fi.IsDir() || !addressbookAt(ctx, fs, name) {
+		return "", nil
+	}
+	return `<C:addressbook xmlns:C="urn:ietf:params:xml:ns:carddav"/>`, nil
+}
+
+type homeSetKey struct{}
+
+// The home set is per Handler, so PROPFIND passes it down in the context.
+func findHomeSet(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+	homeSet, _ := ctx.Value(homeSetKey{}).(func(ctx context.Context) string)
+	if homeSet == nil {
+		return "", os.ErrNotExist
+	}
+	href := homeSet(ctx)
+	if href == "" {
+		return "", os.ErrNotExist
+	}
+	var b bytes.Buffer
+	b.WriteString(`<D:href xmlns:D="DAV:">`)
+	xml.EscapeText(&b, []byte(href))
+	b.WriteString(`</D:href>`)
+	return b.String(), nil
+}
+
+func (a *addressbooks) propfind(w http.ResponseWriter, r *http.Request) (int, error) {
+	if a.homeSet != nil {
+		r = r.WithContext(context.WithValue(r.Context(), homeSetKey{}, a.homeSet))
+	}
+	return a.fallback("PROPFIND", w, r)
+}
+
+func readBody(r io.Reader) ([]byte, int, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, MaxResourceSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if int64(len(body)) > MaxResourceSize {
+		return nil, http.StatusRequestEntityTooLarge, ErrTooLarge
+	}
+	return body, 0, nil
+}
+
+func (a *addressbooks) options(w http.ResponseWriter, r *http.Request) (int, error) {
+	status, err := a.fallback("OPTIONS", w, r)
+	if dav := w.Header().Get("DAV"); dav != "" && status == 0 && err == nil {
+		w.Header().Set("DAV", dav+", addressbook")
+	}
+	return status, err
+}
+
+// http://greenbytes.de/tech/webdav/rfc5689.html#rfc.section.5.1
+type mkcolInfo struct {
+	XMLName xml.Name
+	Set     struct {
+		Prop struct {
+			Props []rawProp `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+}
+
+type rawProp struct {
+	XMLName  xml.Name
+	InnerXML []byte `xml:",innerxml"`
+	Types    []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// Whether mi asks for an address book.
+func (mi mkcolInfo) addressbook() bool {
+	for _, p := range mi.Set.Prop.Props {
+		if p.XMLName.Space != "DAV:" || p.XMLName.Local != "resourcetype" {
+			continue
+		}
+		for _, t := range p.Types {
+			if t.XMLName == addressbookName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+  An extended MKCOL of an address book is a plain MKCOL, which takes care
+  of locks, permissions and conflicts, followed by setting the properties
+  that it was sent with.  Any other MKCOL is left as it was.
+*/
+func (a *addressbooks) mkcol(w http.ResponseWriter, r *http.Request) (int, error) {
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	var mi mkcolInfo
+	if len(bytes.TrimSpace(body)) == 0 || xml.Unmarshal(body, &mi) != nil ||
+		mi.XMLName.Space != "DAV:" || mi.XMLName.Local != "mkcol" || !mi.addressbook() {
+		next := r.Clone(r.Context())
+		next.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return a.fallback("MKCOL", w, next)
+	}
+	name, ok := a.name(r.URL.Path)
+	if !ok {
+		return http.StatusNotFound, webdav.ErrPrefixMismatch
+	}
+	mkcol := r.Clone(r.Context())
+	mkcol.Body, mkcol.ContentLength = http.NoBody, 0
+	if status, err := a.fallback("MKCOL", w, mkcol); status != http.StatusCreated {
+		return status, err
+	}
+	props := []webdav.Property{{XMLName: addressDataSetName, InnerXML: []byte(supportedAddressData)}}
+	for _, p := range mi.Set.Prop.Props {
+		if p.XMLName.Space == "DAV:" && p.XMLName.Local == "resourcetype" {
+			continue
+		}
+		// clients name address books with DAV:displayname, which is kept
+		// as a dead property, over the name of the directory
+		if webdav.IsLiveProperty(p.XMLName) && p.XMLName.Local != "displayname" {
+			a.h.FileSystem.RemoveAll(r.Context(), name)
+			return http.StatusForbidden, webdav.ErrNotAllowed
+		}
+		props = append(props, webdav.Property{XMLName: p.XMLName, InnerXML: p.InnerXML})
+	}
+	if err := a.patch(r.Context(), name, props); err != nil {
+		// a collection that is not an address book is not what was asked for
+		a.h.FileSystem.RemoveAll(r.Context(), name)
+		return http.StatusForbidden, err
+	}
+	return http.StatusCreated, nil
+}
+
+// Set props on the resource at name.
+func (a *addressbooks) patch(ctx context.Context, name string, props []webdav.Property) error {
+	f, err := a.h.FileSystem.OpenFile(ctx, name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	pstats, patchErr := f.Patch([]webdav.Proppatch{{Props: props}})
+	closeErr := f.Close()
+	if patchErr != nil {
+		return patchErr
+	}
+	for _, p := range pstats {
+		if p.Status != http.StatusOK {
+			return webdav.ErrNotAllowed
+		}
+	}
+	return closeErr
+}
+
+// What is PUT into an address book must be a vCard.
+func (a *addressbooks) put(w http.ResponseWriter, r *http.Request) (int, error) {
+	name, ok := a.name(r.URL.Path)
+	if !ok {
+		return a.fallback("PUT", w, r)
+	}
+	ctx := r.Context()
+	if !addressbookAt(ctx, a.h.FileSystem, path.Dir(name)) {
+		return a.fallback("PUT", w, r)
+	}
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	card, err := parseCard(body)
+	if err != nil {
+		return http.StatusForbidden, fmt.Errorf("%w: %v", ErrInvalidAddressData, err)
+	}
+	uid, err := checkCard(card)
+	if err != nil {
+		return http.StatusForbidden, fmt.Errorf("%w: %v", ErrInvalidAddressData, err)
+	}
+	for _, other := range a.cards(ctx, path.Dir(name)) {
+		if other.name != name && other.uid == uid {
+			return http.StatusForbidden, ErrUIDConflict
+		}
+	}
+	put := r.Clone(ctx)
+	put.Body, put.ContentLength = ioutil.NopCloser(bytes.NewReader(body)), int64(len(body))
+	return a.fallback("PUT", w, put)
+}
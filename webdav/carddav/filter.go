@@ -0,0 +1,128 @@
+package carddav
+
+import (
+	"strings"
+
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// http://greenbytes.de/tech/webdav/rfc6352.html#rfc.section.10.5
+type filter struct {
+	Test        string       `xml:"test,attr"`
+	PropFilters []propFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+type propFilter struct {
+	Name         string        `xml:"name,attr"`
+	Test         string        `xml:"test,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []textMatch   `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	ParamFilters []paramFilter `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+type paramFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type textMatch struct {
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	MatchType string `xml:"match-type,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// Whether card matches f.  A filter with no prop-filters matches all.
+func (f filter) matches(card *vobject.Component) bool {
+	tests := make([]bool, len(f.PropFilters))
+	for i, pf := range f.PropFilters {
+		tests[i] = pf.matches(card)
+	}
+	return combine(f.Test, tests)
+}
+
+// Whether any of tests passed, or, for allof, all of them.  None at all
+// is a pass.
+func combine(test string, tests []bool) bool {
+	if len(tests) == 0 {
+		return true
+	}
+	all := test == "allof"
+	for _, ok := range tests {
+		if ok != all {
+			return ok
+		}
+	}
+	return all
+}
+
+// Whether some property of card named as f is matches it, or, with
+// is-not-defined, none is.
+func (f propFilter) matches(card *vobject.Component) bool {
+	found := false
+	for _, p := range card.Props {
+		if baseName(p) != strings.ToUpper(f.Name) {
+			continue
+		}
+		found = true
+		if f.IsNotDefined != nil {
+			return false
+		}
+		if f.matchesProperty(p) {
+			return true
+		}
+	}
+	return !found && f.IsNotDefined != nil
+}
+
+func (f propFilter) matchesProperty(p vobject.Property) bool {
+	var tests []bool
+	for _, tm := range f.TextMatches {
+		tests = append(tests, tm.matches(p.Value))
+	}
+	for _, pf := range f.ParamFilters {
+		tests = append(tests, pf.matches(p))
+	}
+	return combine(f.Test, tests)
+}
+
+func (f paramFilter) matches(p vobject.Property) bool {
+	values, ok := p.Params[strings.ToUpper(f.Name)]
+	if f.IsNotDefined != nil {
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+	if f.TextMatch == nil {
+		return true
+	}
+	for _, v := range values {
+		if f.TextMatch.matches(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// A match of the kind that match-type says, contains by default, ignoring
+// case unless the collation is i;octet.
+func (tm textMatch) matches(value string) bool {
+	text := tm.Text
+	if tm.Collation != "i;octet" {
+		text, value = strings.ToLower(text), strings.ToLower(value)
+	}
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == text
+	case "starts-with":
+		ok = strings.HasPrefix(value, text)
+	case "ends-with":
+		ok = strings.HasSuffix(value, text)
+	default:
+		ok = strings.Contains(value, text)
+	}
+	return ok != (tm.Negate == "yes")
+}
@@ -0,0 +1,129 @@
+package carddav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+// http://greenbytes.de/tech/webdav/rfc6352.html#rfc.section.8.6
+// and http://greenbytes.de/tech/webdav/rfc6352.html#rfc.section.8.7
+type reportInfo struct {
+	XMLName xml.Name
+	Prop    struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+	Filter *filter `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit  struct {
+		NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+func (ri reportInfo) props() []xml.Name {
+	var names []xml.Name
+	for _, n := range ri.Prop.Names {
+		names = append(names, n.XMLName)
+	}
+	return names
+}
+
+// Escapes vCard data as XML text, keeping its line ends.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+
+// REPORTs of the other namespaces are left to the REPORT that there was.
+func (a *addressbooks) report(w http.ResponseWriter, r *http.Request) (int, error) {
+	body, status, err := readBody(r.Body)
+	if err != nil {
+		return status, err
+	}
+	var ri reportInfo
+	if err := xml.Unmarshal(body, &ri); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if ri.XMLName.Space != Namespace {
+		if a.next["REPORT"] == nil {
+			return http.StatusForbidden, ErrUnsupportedReport
+		}
+		next := r.Clone(r.Context())
+		next.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return a.fallback("REPORT", w, next)
+	}
+	name, ok := a.name(r.URL.Path)
+	if !ok {
+		return http.StatusNotFound, webdav.ErrPrefixMismatch
+	}
+	ctx := r.Context()
+	fi, err := a.h.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	var names []string
+	truncated := false
+	cards := make(map[string]*card)
+	switch ri.XMLName.Local {
+	case "addressbook-query":
+		if ri.Filter == nil {
+			return http.StatusBadRequest, ErrUnsupportedReport
+		}
+		var candidates []*card
+		if !fi.IsDir() {
+			if c, ok := a.card(ctx, name); ok {
+				candidates = append(candidates, c)
+			}
+		} else if r.Header.Get("Depth") != "0" {
+			candidates = a.cards(ctx, name)
+		}
+		for _, c := range candidates {
+			if !ri.Filter.matches(c.card) {
+				continue
+			}
+			if ri.Limit.NResults > 0 && len(names) == ri.Limit.NResults {
+				truncated = true
+				break
+			}
+			names = append(names, c.name)
+			cards[c.name] = c
+		}
+	case "addressbook-multiget":
+		for _, href := range ri.Hrefs {
+			u, err := url.Parse(href)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			n, ok := a.name(u.Path)
+			if !ok {
+				continue
+			}
+			names = append(names, n)
+		}
+	default:
+		return http.StatusForbidden, ErrUnsupportedReport
+	}
+	return a.h.ServeReport(w, r, webdav.Report{
+		Names: names,
+		Props: ri.props(),
+		Computed: func(ctx context.Context, name string, fi os.FileInfo, pname xml.Name) (webdav.Property, bool, error) {
+			if pname != addressDataName {
+				return webdav.Property{}, false, nil
+			}
+			c, ok := cards[name]
+			if !ok {
+				if c, ok = a.card(ctx, name); !ok {
+					return webdav.Property{}, true, os.ErrNotExist
+				}
+			}
+			return webdav.Property{InnerXML: []byte(textEscaper.Replace(string(c.data)))}, true, nil
+		},
+		Truncated: truncated,
+	})
+}
@@ -0,0 +1,105 @@
+package carddav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/internal/vobject"
+)
+
+// Parse one VCARD, which must be all that data holds.
+func parseCard(data []byte) (*vobject.Component, error) {
+	return vobject.Parse(data, "VCARD")
+}
+
+/*
+  Check that card is an address object resource (RFC 6352, section 5.1):
+  a vCard 3.0 or 4.0 with a formatted name and a UID.
+*/
+func checkCard(card *vobject.Component) (uid string, err error) {
+	v, ok := card.Prop("VERSION")
+	if !ok {
+		return "", errors.New("no VERSION")
+	}
+	if v.Value != "3.0" && v.Value != "4.0" {
+		return "", fmt.Errorf("unsupported VERSION %s", v.Value)
+	}
+	if _, ok := card.Prop("FN"); !ok {
+		return "", errors.New("no FN")
+	}
+	p, ok := card.Prop("UID")
+	if !ok || p.Value == "" {
+		return "", errors.New("no UID")
+	}
+	return p.Value, nil
+}
+
+// The name of p without its group, as in item1.EMAIL.
+func baseName(p vobject.Property) string {
+	return p.Name[strings.LastIndexByte(p.Name, '.')+1:]
+}
+
+// A vCard, as a REPORT sees it.
+type card struct {
+	name string
+	uid  string
+	data []byte
+	card *vobject.Component
+}
+
+// The vCard at name, if it is one that the user may read.
+func (a *addressbooks) card(ctx context.Context, name string) (*card, bool) {
+	f, err := a.h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.IsDir() || fi.Size() > MaxResourceSize {
+		return nil, false
+	}
+	if rf, ok := f.(webdav.ReadCheckedFile); ok && !rf.CanRead() {
+		return nil, false
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	vc, err := parseCard(data)
+	if err != nil {
+		return nil, false
+	}
+	c := &card{name: name, data: data, card: vc}
+	if p, ok := vc.Prop("UID"); ok {
+		c.uid = p.Value
+	}
+	return c, true
+}
+
+// The vCards in the address book at name.  Other files are skipped.
+func (a *addressbooks) cards(ctx context.Context, name string) []*card {
+	f, err := a.h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	infos, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	var cards []*card
+	for _, fi := range infos {
+		if fi.IsDir() {
+			continue
+		}
+		if c, ok := a.card(ctx, path.Join(name, fi.Name())); ok {
+			cards = append(cards, c)
+		}
+	}
+	return cards
+}
@@ -0,0 +1,191 @@
+package webdav
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"hash"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+  ContentHashProperty holds the checksums that the content of a file
+  was verified with when it was PUT, as OC-Checksum gives them:
+
+    <W:content-hash xmlns:W="https://github.com/rfielding/webdev/">SHA256:9f86d08... MD5:098f6bc...</W:content-hash>
+
+  It is protected, so that only the server sets it, and it is removed
+  when the file is PUT again without checksums.
+*/
+var ContentHashProperty = xml.Name{Space: webdevNamespace, Local: "content-hash"}
+
+// The checksums that a PUT may be verified with, by their OC-Checksum type
+var checksumTypes = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA1":    sha1.New,
+	"SHA256":  sha256.New,
+	"ADLER32": func() hash.Hash { return adler32.New() },
+}
+
+// A checksum that a client sent with a PUT
+type checksum struct {
+	Type string
+	Sum  []byte
+}
+
+func (c checksum) String() string {
+	return c.Type + ":" + hex.EncodeToString(c.Sum)
+}
+
+/*
+  The checksums of r, from any of:
+
+    Content-MD5: CY9rzUYh03PK3k6DJie09g==          base64, as RFC 1864
+    OC-Checksum: SHA1:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3
+    X-Checksum-SHA256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+
+  OC-Checksum may list more than one, separated by spaces, of MD5,
+  SHA1, SHA256 and ADLER32.  A checksum that cannot be read, or of a
+  type that cannot be checked, is ErrInvalidChecksum.
+*/
+func requestChecksums(r *http.Request) ([]checksum, error) {
+	var sums []checksum
+	add := func(typ string, sum []byte, err error) error {
+		newHash, ok := checksumTypes[typ]
+		if err != nil || !ok || len(sum) != newHash().Size() {
+			return ErrInvalidChecksum
+		}
+		sums = append(sums, checksum{Type: typ, Sum: sum})
+		return nil
+	}
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err := add("MD5", sum, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range strings.Fields(r.Header.Get("OC-Checksum")) {
+		i := strings.IndexByte(v, ':')
+		if i < 0 {
+			return nil, ErrInvalidChecksum
+		}
+		sum, err := hex.DecodeString(v[i+1:])
+		if err := add(strings.ToUpper(v[:i]), sum, err); err != nil {
+			return nil, err
+		}
+	}
+	if v := strings.TrimSpace(r.Header.Get("X-Checksum-SHA256")); v != "" {
+		sum, err := hex.DecodeString(v)
+		if err != nil {
+			// some clients send it as base64
+			sum, err = base64.StdEncoding.DecodeString(v)
+		}
+		if err := add("SHA256", sum, err); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+/*
+  Spool body to a temporary file while it is hashed, so that a file is
+  not written over with what turns out to be the wrong content.  The
+  file is returned at its start, and removes itself when it is
+  closed.  When a checksum does not match, it returns the first one
+  that does not, as it was sent and as it is.
+*/
+func spoolVerified(body io.Reader, sums []checksum) (*spoolFile, *checksum, *checksum, error) {
+	f, err := ioutil.TempFile("", "webdav-put-*")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	spool := &spoolFile{f}
+	hashes := make([]hash.Hash, len(sums))
+	writers := []io.Writer{f}
+	for i, c := range sums {
+		hashes[i] = checksumTypes[c.Type]()
+		writers = append(writers, hashes[i])
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		spool.Close()
+		return nil, nil, nil, err
+	}
+	for i, c := range sums {
+		if got := hashes[i].Sum(nil); !bytes.Equal(got, c.Sum) {
+			spool.Close()
+			return nil, &sums[i], &checksum{Type: c.Type, Sum: got}, ErrChecksumMismatch
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, nil, nil, err
+	}
+	return spool, nil, nil, nil
+}
+
+type spoolFile struct {
+	*os.File
+}
+
+func (s *spoolFile) Close() error {
+	err := s.File.Close()
+	os.Remove(s.File.Name())
+	return err
+}
+
+// Say which checksum did not match, in a DAV:error body.
+func writeChecksumMismatch(w http.ResponseWriter, expected, actual *checksum) {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<D:error xmlns:D="DAV:" xmlns:W="` + webdevNamespace + `"><W:checksum-mismatch>`)
+	writeElement(&b, "W:expected", expected.String())
+	writeElement(&b, "W:actual", actual.String())
+	b.WriteString(`</W:checksum-mismatch></D:error>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(b.Bytes())
+}
+
+/*
+  Keep the checksums that f was verified with as its content hash, or
+  remove the one it had, now that its content has changed without
+  them.
+*/
+func recordChecksums(f File, sums []checksum) {
+	var patch Proppatch
+	if len(sums) > 0 {
+		var types []string
+		seen := make(map[string]bool)
+		for _, c := range sums {
+			if !seen[c.String()] {
+				seen[c.String()] = true
+				types = append(types, c.String())
+			}
+		}
+		var v bytes.Buffer
+		xml.EscapeText(&v, []byte(strings.Join(types, " ")))
+		patch.Props = []Property{{XMLName: ContentHashProperty, InnerXML: v.Bytes()}}
+	} else {
+		dead, err := f.DeadProps()
+		if err != nil {
+			return
+		}
+		if _, ok := dead[ContentHashProperty]; !ok {
+			return
+		}
+		patch.Remove = true
+		patch.Props = []Property{{XMLName: ContentHashProperty}}
+	}
+	if _, err := f.Patch([]Proppatch{patch}); err != nil {
+		Log().Warn("cannot record content hash", "err", err)
+	}
+}
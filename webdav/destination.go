@@ -0,0 +1,147 @@
+package webdav
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DestinationPolicy says which URLs in Destination headers, BIND hrefs
+// and If header resource tags are of the Handler, when a proxy in front
+// of it publishes it under another host, port, scheme or path than the
+// request that reaches it has.
+type DestinationPolicy struct {
+	// Hosts name the Handler, as well as the Host of the request.  One
+	// without a port is the Handler on any port.
+	Hosts []string
+	// PublicPrefix is taken off the path of a Destination that has it,
+	// before Prefix is, when a proxy serves the Handler under it without
+	// passing it on.
+	PublicPrefix string
+}
+
+// The default port of each scheme, which a Host may leave off
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// host, without the default port of scheme, and in lower case
+func canonicalHost(host, scheme string) string {
+	host = strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(host); err == nil && port == defaultPorts[scheme] {
+		return h
+	}
+	return host
+}
+
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Whether u, which has a host, names the server that r was made to.
+func (p *DestinationPolicy) sameServer(r *http.Request, u *url.URL) bool {
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = requestScheme(r)
+	}
+	host := canonicalHost(u.Host, scheme)
+	if host == canonicalHost(r.Host, requestScheme(r)) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	hostname := strings.ToLower(u.Hostname())
+	for _, h := range p.Hosts {
+		h = strings.ToLower(h)
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			// no port, so any will do
+			if hostname == strings.Trim(h, "[]") {
+				return true
+			}
+		} else if host == canonicalHost(h, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  Parse ref, a Destination header or an href that names a resource, as
+  RFC 4918 has it: an absolute URL, or a path that is absolute.  It is
+  local when it names this Handler, and then its path is the one that
+  the Handler is served at, with PublicPrefix taken off.  A path that
+  has a % that begins no escape is taken as it stands, as clients that
+  do not encode what they send have it, and one with a NUL in it is
+  refused, as are schemes other than http and https.
+*/
+func (h *Handler) destination(r *http.Request, ref string) (u *url.URL, local bool, err error) {
+	u, err = url.Parse(ref)
+	if err != nil {
+		if u, err = url.Parse(escapeStrayPercents(ref)); err != nil {
+			return nil, false, ErrInvalidDestination
+		}
+	}
+	switch {
+	case u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https",
+		strings.ContainsRune(u.Path, 0),
+		u.Path != "" && !strings.HasPrefix(u.Path, "/"),
+		u.Host == "" && u.Path == "":
+		return nil, false, ErrInvalidDestination
+	}
+	if err := h.checkPath(r, u.EscapedPath()); err != nil {
+		return nil, false, err
+	}
+	if u.Host != "" && !h.Destinations.sameServer(r, u) {
+		return u, false, nil
+	}
+	if p := h.Destinations; p != nil && p.PublicPrefix != "" {
+		prefix := strings.TrimSuffix(p.PublicPrefix, "/")
+		if u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			d := *u
+			d.Path, d.RawPath = "/"+strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix), "/"), ""
+			u = &d
+		}
+	}
+	return u, true, nil
+}
+
+/*
+  LocalName is the name in the FileSystem that ref, a Destination
+  header or an href, names, as the Handler would resolve it, or false
+  when it names nothing of the Handler.  What wraps the Handler, to
+  check where a request reaches, can see it as the Handler does.
+*/
+func (h *Handler) LocalName(r *http.Request, ref string) (string, bool) {
+	u, local, err := h.destination(r, ref)
+	if err != nil || !local {
+		return "", false
+	}
+	name, _, err := h.stripPrefix(u.Path)
+	if err != nil {
+		return "", false
+	}
+	return SlashClean(name), true
+}
+
+// s, with every % that does not begin an escape escaped itself
+func escapeStrayPercents(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && !(i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2])) {
+			b.WriteString("%25")
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
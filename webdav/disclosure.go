@@ -0,0 +1,58 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// How a FileSystem wants what a user may not do answered.
+type Disclosure int
+
+const (
+	// 404 Not Found for what the user may not see, and 403 Forbidden
+	// for what they may see but not do
+	DiscloseDefault Disclosure = iota
+	// 404 Not Found for anything that the user may not do, so that
+	// nothing is said of what is there
+	DiscloseHidden
+	// 403 Forbidden for anything that is there that the user may not
+	// do, even see
+	DiscloseForbidden
+)
+
+/*
+  DisclosingFileSystem is an optional interface for a FileSystem whose
+  policy says, for each subtree, whether a refusal says that what was
+  refused is there.  It is asked of the resource of a request that was
+  refused, with 403 or 404, and every method is answered alike.  It
+  only says DiscloseForbidden of what is there.
+*/
+type DisclosingFileSystem interface {
+	Disclosure(ctx context.Context, name string) Disclosure
+}
+
+/*
+  The status and error that a refusal of the resource at name is
+  answered with, as the FileSystem wants it disclosed.  One that is
+  hidden keeps its error, so that the Logger still sees that it was
+  denied.
+*/
+func (h *Handler) disclose(ctx context.Context, name string, status int, err error) (int, error) {
+	dfs, ok := h.FileSystem.(DisclosingFileSystem)
+	if !ok || name == "" {
+		return status, err
+	}
+	switch {
+	case status == http.StatusForbidden && errors.Is(err, ErrNotAllowed):
+		if dfs.Disclosure(ctx, name) == DiscloseHidden {
+			return http.StatusNotFound, err
+		}
+	case status == http.StatusNotFound && errors.Is(err, os.ErrNotExist):
+		if dfs.Disclosure(ctx, name) == DiscloseForbidden {
+			return http.StatusForbidden, ErrNotAllowed
+		}
+	}
+	return status, err
+}
@@ -0,0 +1,142 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+/*
+  A PolicyError is ErrNotAllowed, with what the policy that denied it
+  says of the resource, so that the client can be told more than 403.
+  errors.Is(err, ErrNotAllowed) is true of it.
+*/
+type PolicyError struct {
+	// Banner is the classification that the policy gives the resource
+	Banner string
+}
+
+func (e *PolicyError) Error() string {
+	return ErrNotAllowed.Error()
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrNotAllowed
+}
+
+// How an error that a FileSystem returns is answered
+type errorCode struct {
+	err    error
+	status int
+	// The precondition of a DAV:error body, in the webdev namespace as W:,
+	// or DAV: as D:
+	element string
+}
+
+/*
+  The errors that the Handler answers the same way whichever method
+  came by them, as errors.Is says.  A FileSystem returns them, wrapped
+  or not, rather than an os.ErrNotExist or os.ErrPermission that would
+  say the wrong thing to the client.
+*/
+var errorCodes = []errorCode{
+	{ErrNotAllowed, http.StatusForbidden, "W:policy-denied"},
+	{ErrRetention, http.StatusForbidden, "W:retention"},
+	{ErrQuotaExceeded, http.StatusInsufficientStorage, "D:quota-not-exceeded"},
+	{ErrScanRejected, http.StatusUnprocessableEntity, "W:scan-rejected"},
+	{ErrPolicyUnavailable, http.StatusServiceUnavailable, "W:policy-unavailable"},
+	{ErrReadOnly, http.StatusServiceUnavailable, "W:read-only"},
+	{ErrTooManyOpenFiles, http.StatusServiceUnavailable, "W:too-many-open-files"},
+	{ErrInvalidPath, http.StatusBadRequest, "W:invalid-path"},
+}
+
+func errorCodeOf(err error) (errorCode, bool) {
+	if err == nil {
+		return errorCode{}, false
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e, true
+		}
+	}
+	return errorCode{}, false
+}
+
+// The code of e, as JSON gives it
+func (e errorCode) code() string {
+	return e.element[strings.IndexByte(e.element, ':')+1:]
+}
+
+// What an error is answered with, as JSON
+type errorBody struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Banner    string `json:"banner,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// StatusOf is the status that the Handler answers err with, if it is one that it knows.
+func StatusOf(err error) (int, bool) {
+	e, ok := errorCodeOf(err)
+	return e.status, ok
+}
+
+/*
+  ServeError answers r with err, if it is one of the errors that the
+  Handler knows how to answer, and says whether it was.  A handler in
+  front of the Handler, such as one that checks a quota, uses it to
+  answer as the Handler would.
+*/
+func ServeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	e, ok := errorCodeOf(err)
+	if ok {
+		writeError(w, r, e, err)
+	}
+	return ok
+}
+
+/*
+  Say why r failed, with the status of e and a DAV:error body:
+
+    <D:error xmlns:D="DAV:" xmlns:W="https://github.com/rfielding/webdev/">
+      <W:policy-denied>
+        <W:banner>PRIVATE</W:banner>
+        <W:request-id>d0fb41374d61766dced9eeaea309e259</W:request-id>
+      </W:policy-denied>
+    </D:error>
+
+  or as JSON, when that is what the client Accepts.  The request ID is
+  what the server logged it with, so that it can be asked about.  A
+  503 says to come back after RetryAfter, unless w already says when.
+*/
+func writeError(w http.ResponseWriter, r *http.Request, e errorCode, err error) {
+	body := errorBody{Error: e.err.Error(), Code: e.code(), RequestID: RequestID(r.Context())}
+	var pe *PolicyError
+	if errors.As(err, &pe) {
+		body.Banner = pe.Banner
+	}
+	if e.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", RetryAfter)
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(e.status)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<D:error xmlns:D="DAV:" xmlns:W="` + webdevNamespace + `"><` + e.element + `>`)
+	if body.Banner != "" {
+		writeElement(&b, "W:banner", body.Banner)
+	}
+	if body.RequestID != "" {
+		writeElement(&b, "W:request-id", body.RequestID)
+	}
+	b.WriteString(`</` + e.element + `></D:error>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(e.status)
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,38 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Whether the client waits to be told to send the body of r
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+/*
+  Refuse a PUT before its body is read, when it would be refused once
+  it was.  The server only sends 100 Continue when the body is first
+  read, so a client that sent Expect: 100-continue is told no before
+  it sends any of a 5 GB upload, rather than after.  A FileSystem that
+  is not a CapableFileSystem is left to refuse it in OpenFile, as it
+  would otherwise.
+*/
+func (h *Handler) confirmPut(ctx context.Context, name string) error {
+	cfs, ok := h.FileSystem.(CapableFileSystem)
+	if !ok {
+		return nil
+	}
+	c, err := cfs.Capabilities(ctx, name)
+	if err != nil {
+		return err
+	}
+	if c.Exists && !c.Write || !c.Exists && !c.Create {
+		if c.Banner != "" {
+			return &PolicyError{Banner: c.Banner}
+		}
+		return ErrNotAllowed
+	}
+	return nil
+}
@@ -32,32 +32,57 @@ type FileSystem interface {
 
 // A File is returned by a FileSystem's OpenFile method and can be served by a
 // Handler.
+//
+// For a PROPFIND to page through a directory, Readdir(n) with n > 0 must give
+// the next n entries at most, and io.EOF when there are none, as os.File does,
+// and list them in the same order every time the directory is opened.
 type File interface {
 	http.File
 	io.Writer
 	DeadPropsHolder
 }
 
+// ReadCheckedFile is an optional interface for the File objects returned
+// by a FileSystem, for files that can be seen (in a PROPFIND, say) by
+// somebody who may not read what is in them.
+type ReadCheckedFile interface {
+	CanRead() bool
+}
+
 var (
 	// The errors need to be public so that implementations can
 	// return them, as there are equality checks done against them!
+	ErrChecksumMismatch        = errors.New("webdav: checksum mismatch")
 	ErrDestinationEqualsSource = errors.New("webdav: destination equals source")
 	ErrDirectoryNotEmpty       = errors.New("webdav: directory not empty")
 	ErrInvalidDepth            = errors.New("webdav: invalid depth")
 	ErrInvalidDestination      = errors.New("webdav: invalid destination")
 	ErrInvalidIfHeader         = errors.New("webdav: invalid If header")
 	ErrInvalidLockInfo         = errors.New("webdav: invalid lock info")
+	ErrInvalidRedirectRef      = errors.New("webdav: invalid redirect reference")
+	ErrInvalidBind             = errors.New("webdav: invalid bind")
+	ErrInvalidChecksum         = errors.New("webdav: invalid checksum")
 	ErrInvalidLockToken        = errors.New("webdav: invalid lock token")
+	ErrInvalidPage             = errors.New("webdav: invalid Limit or Offset")
+	ErrInvalidPath             = errors.New("webdav: invalid path")
 	ErrInvalidPropfind         = errors.New("webdav: invalid propfind")
 	ErrInvalidProppatch        = errors.New("webdav: invalid proppatch")
 	ErrInvalidResponse         = errors.New("webdav: invalid response")
 	ErrInvalidTimeout          = errors.New("webdav: invalid timeout")
+	ErrNameCollision           = errors.New("webdav: name collides with another in its collection")
 	ErrNoFileSystem            = errors.New("webdav: no file system")
 	ErrNoLockSystem            = errors.New("webdav: no lock system")
 	ErrNotADirectory           = errors.New("webdav: not a directory")
+	ErrNotBindable             = errors.New("webdav: cannot be bound")
 	ErrPrefixMismatch          = errors.New("webdav: prefix mismatch")
 	ErrRecursionTooDeep        = errors.New("webdav: recursion too deep")
 	ErrUnsupportedLockInfo     = errors.New("webdav: unsupported lock info")
 	ErrUnsupportedMethod       = errors.New("webdav: unsupported method")
 	ErrNotAllowed              = errors.New("webdav: not allowed")
+	ErrObligationUnfulfilled   = errors.New("webdav: obligation cannot be fulfilled")
+	ErrQuotaExceeded           = errors.New("webdav: quota exceeded")
+	ErrRetention               = errors.New("webdav: retained, and cannot be changed yet")
+	ErrScanRejected            = errors.New("webdav: content rejected by a scan")
+	ErrPolicyUnavailable       = errors.New("webdav: policy unavailable")
+	ErrReadOnly                = errors.New("webdav: read only for maintenance")
 )
@@ -0,0 +1,144 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// A ContentFilter transforms the content of a file as it is served, or as it
+// is uploaded.  Filters work on streams, so that large files are never held
+// in memory.  value is the value of the obligation that selected the filter,
+// if any.
+type ContentFilter interface {
+	Filter(ctx context.Context, r *http.Request, ctype string, value interface{}, src io.Reader) (io.Reader, error)
+}
+
+// A FilterRule says when to apply a ContentFilter.  Obligation selects it for
+// files whose policy attached that obligation, and ContentType selects it for
+// content types starting with that string (such as "image/jpeg" or "text/").
+// If both are given, both must match.
+type FilterRule struct {
+	Obligation  string
+	ContentType string
+	// Get applies the filter to content being served, and Put to uploads.
+	Get    bool
+	Put    bool
+	Filter ContentFilter
+}
+
+func (fr FilterRule) matches(obligations Obligations, ctype string) bool {
+	if fr.Obligation == "" && fr.ContentType == "" {
+		return false
+	}
+	if fr.Obligation != "" {
+		if _, ok := obligations[fr.Obligation]; !ok {
+			return false
+		}
+	}
+	return strings.HasPrefix(ctype, fr.ContentType)
+}
+
+// filtersFor picks the rules that apply, in the order given in the Handler.
+func (h *Handler) filtersFor(obligations Obligations, ctype string, put bool) []FilterRule {
+	rules := make([]FilterRule, 0)
+	for _, fr := range h.Filters {
+		if (put && fr.Put || !put && fr.Get) && fr.matches(obligations, ctype) {
+			rules = append(rules, fr)
+		}
+	}
+	return rules
+}
+
+// applyFilters chains the filters of rules onto src.  Closing what it
+// returns closes every filter in the chain, but not src, which is the
+// caller's to close.
+func applyFilters(ctx context.Context, r *http.Request, rules []FilterRule, obligations Obligations, ctype string, src io.Reader) (io.ReadCloser, error) {
+	chain := &filterChain{Reader: src}
+	for _, fr := range rules {
+		filtered, err := fr.Filter.Filter(ctx, r, ctype, obligations[fr.Obligation], chain.Reader)
+		if err != nil {
+			chain.Close()
+			return nil, err
+		}
+		if c, ok := filtered.(io.Closer); ok && filtered != chain.Reader {
+			chain.stages = append(chain.stages, c)
+		}
+		chain.Reader = filtered
+	}
+	return chain, nil
+}
+
+// A chain of filters, closed from the last, so that a filter that stops
+// reading lets those before it stop writing.
+type filterChain struct {
+	io.Reader
+	stages []io.Closer
+}
+
+func (c *filterChain) Close() error {
+	for i := len(c.stages) - 1; i >= 0; i-- {
+		c.stages[i].Close()
+	}
+	return nil
+}
+
+/*
+  Run content through the filters of rules into a temporary file, so
+  that nothing of it is served, or written to the file system, until
+  every filter has finished with it.  A filter that fails partway
+  leaves nothing behind, and the request can still be answered with
+  an error.
+*/
+func spoolFiltered(ctx context.Context, r *http.Request, rules []FilterRule, obligations Obligations, ctype string, src io.Reader) (*spoolFile, int, error) {
+	filtered, err := applyFilters(ctx, r, rules, obligations, ctype, src)
+	if err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	defer filtered.Close()
+	f, err := ioutil.TempFile("", "webdav-filter-*")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	spool := &spoolFile{f}
+	if _, err := io.Copy(f, filtered); err != nil {
+		spool.Close()
+		return nil, http.StatusInternalServerError, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, http.StatusInternalServerError, err
+	}
+	return spool, 0, nil
+}
+
+// contentTypeOf guesses the type from the name, and then from the first bytes
+// of content, leaving content rewound.
+func contentTypeOf(name string, content io.ReadSeeker) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	if content == nil {
+		return ""
+	}
+	var buf [512]byte
+	n, _ := io.ReadFull(content, buf[:])
+	content.Seek(0, os.SEEK_SET)
+	return http.DetectContentType(buf[:n])
+}
+
+// PipeFilter runs fn in the background, copying from src to the returned
+// reader while transforming it.  Closing the returned reader stops fn.  src
+// is not closed, as whoever opened it closes it.
+func PipeFilter(src io.Reader, fn func(dst io.Writer, src io.Reader) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(fn(pw, src))
+	}()
+	return pr
+}
@@ -0,0 +1,205 @@
+package fs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Bindings are hard links.  A file that has been bound gets a
+  .__f.bindings.json sidecar with the id of the resource and the paths
+  that it was bound at, and that sidecar and its dead properties are
+  hard linked at every binding, so what is patched through one is
+  seen through all of them.  Both are rewritten in place, which keeps
+  them linked.
+
+  Paths that were since moved or replaced on the volume are left out,
+  by comparing the files.  Directories cannot be bound, as few file
+  systems can hard link them.
+*/
+type bindings struct {
+	ID    string   `json:"id"`
+	Paths []string `json:"paths"`
+}
+
+// The sidecars that every binding of a file shares.
+var sharedSidecars = []string{"deadproperties.json", "bindings.json"}
+
+func readBindings(file string) (b bindings, ok bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return b, false
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		webdav.Log().Warn("cannot parse bindings", "file", file, "err", err)
+		return b, false
+	}
+	return b, true
+}
+
+func writeBindings(file string, b bindings) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func newResourceID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func (d FS) Bind(ctx context.Context, existing, name string) error {
+	if existing = d.resolve(existing); existing == "" {
+		return os.ErrNotExist
+	}
+	if name = d.resolve(name); name == "" {
+		return os.ErrNotExist
+	}
+	// what is written through the new binding changes the resource, and
+	// the new parent's policy does not guard it, so it takes a writer
+	permission := d.PermissionHandler(ctx, Action{Name: existing, Action: AllowWrite})
+	if !d.Allow(ctx, permission, AllowStat) {
+		return d.hidden(permission)
+	}
+	if !d.Allow(ctx, permission, AllowWrite) {
+		return d.denied(ctx, permission)
+	}
+	// the new binding is read under the policy of where it is, so it may
+	// not be somewhere that drops what is asked of readers, as a second
+	// factor or a redaction
+	if !keepsObligations(d.PermissionHandler(ctx, Action{Name: existing, Action: AllowRead}),
+		d.PermissionHandler(ctx, Action{Name: name, Action: AllowRead})) {
+		return webdav.ErrNotAllowed
+	}
+	// as with a new file, ask the parent
+	permission = d.PermissionHandler(ctx, Action{Name: path.Dir(name), Action: AllowCreate})
+	if !d.Allow(ctx, permission, AllowCreate) || strings.HasPrefix(filepath.Base(name), ".__") {
+		return webdav.ErrNotAllowed
+	}
+	info, err := os.Stat(existing)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".__") {
+		return webdav.ErrNotBindable
+	}
+	defer d.Stats.Invalidate(name)
+	if err := os.Link(existing, name); err != nil {
+		return err
+	}
+	// there must be a properties file to share before any are patched
+	props := NameFor(existing, "deadproperties.json")
+	if _, err := os.Stat(props); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(props, []byte("{}"), 0644); err != nil {
+			os.Remove(name)
+			return err
+		}
+	}
+	file := NameFor(existing, "bindings.json")
+	b, ok := readBindings(file)
+	if !ok {
+		b = bindings{ID: newResourceID(), Paths: []string{d.VolumePath(existing)}}
+	}
+	b.Paths = append(b.Paths, d.VolumePath(name))
+	if err := writeBindings(file, b); err != nil {
+		os.Remove(name)
+		return err
+	}
+	for _, ftype := range sharedSidecars {
+		link := NameFor(name, ftype)
+		// left over from what was at name before
+		os.Remove(link)
+		if err := os.Link(NameFor(existing, ftype), link); err != nil {
+			d.unbind(name)
+			os.Remove(name)
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether every obligation of one decision is in another, with the same value.
+func keepsObligations(from, to map[string]interface{}) bool {
+	have, _ := from["Obligations"].(map[string]interface{})
+	kept, _ := to["Obligations"].(map[string]interface{})
+	for k, v := range have {
+		if !reflect.DeepEqual(kept[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d FS) Bindings(ctx context.Context, name string) (string, []string, error) {
+	if name = d.resolve(name); name == "" {
+		return "", nil, os.ErrNotExist
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", nil, err
+	}
+	own := d.VolumePath(name)
+	if info.IsDir() {
+		return "", []string{own}, nil
+	}
+	b, ok := readBindings(NameFor(name, "bindings.json"))
+	if !ok {
+		return "", []string{own}, nil
+	}
+	permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowRead})
+	if !d.Allow(ctx, permission, AllowStat) {
+		return "", nil, d.hidden(permission)
+	}
+	paths := []string{own}
+	for _, p := range b.Paths {
+		if p == own {
+			continue
+		}
+		if other, err := os.Stat(d.resolve(p)); err == nil && os.SameFile(info, other) {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 1 {
+		return "", paths, nil
+	}
+	return b.ID, paths, nil
+}
+
+// Take the file name out of the bindings of its resource, and drop the
+// sidecars that it shares with them, so that they outlive it.
+func (d FS) unbind(name string) {
+	file := NameFor(name, "bindings.json")
+	b, ok := readBindings(file)
+	if !ok {
+		return
+	}
+	own := d.VolumePath(name)
+	kept := b.Paths[:0]
+	for _, p := range b.Paths {
+		if p != own {
+			kept = append(kept, p)
+		}
+	}
+	b.Paths = kept
+	if err := writeBindings(file, b); err != nil {
+		webdav.Log().Warn("cannot update bindings", "file", file, "err", err)
+	}
+	for _, ftype := range sharedSidecars {
+		os.Remove(NameFor(name, ftype))
+	}
+}
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+  A directory can give default dead properties to everything under
+  it, in a .__defaultproperties.json of the same form as dead
+  properties.  This labels a whole tree at once:
+
+    { "classification": "SECRET" }
+
+  The nearest directory wins, and a file's own dead properties
+  win over any default.
+*/
+func InheritedProperties(root, name string) map[string]string {
+	root = filepath.Clean(root)
+	inherited := make(map[string]string)
+	if rel, err := filepath.Rel(root, name); err != nil || strings.HasPrefix(rel, "..") {
+		return inherited
+	}
+	d := filepath.Clean(name)
+	for d != root {
+		parent := filepath.Dir(d)
+		if parent == d || d == "." {
+			break
+		}
+		d = parent
+		for k, v := range readProperties(filepath.Join(d, ".__defaultproperties.json")) {
+			if _, ok := inherited[k]; !ok {
+				inherited[k] = v
+			}
+		}
+	}
+	return inherited
+}
+
+// EffectiveProperties are the dead properties of name, over the ones it inherits.
+func EffectiveProperties(root, name string) map[string]string {
+	props := InheritedProperties(root, name)
+	if _, err := os.Stat(name); err != nil {
+		return props
+	}
+	for k, v := range readProperties(NameFor(name, "deadproperties.json")) {
+		props[k] = v
+	}
+	return props
+}
+
+// OwnProperties are the dead properties set on name itself, without the ones it inherits.
+func OwnProperties(name string) map[string]string {
+	if _, err := os.Stat(name); err != nil {
+		return make(map[string]string)
+	}
+	return readProperties(NameFor(name, "deadproperties.json"))
+}
+
+// DefaultProperties are the defaults that the directory dir gives to everything under it.
+func DefaultProperties(dir string) map[string]string {
+	return readProperties(filepath.Join(dir, ".__defaultproperties.json"))
+}
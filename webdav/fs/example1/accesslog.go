@@ -0,0 +1,255 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  One line per request, kept apart from the error log.  The
+  decision is what the policy allowed on the last thing that it
+  was asked about, such as "Read,Stat".
+*/
+type AccessRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Remote     string    `json:"remote"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Decision   string    `json:"decision,omitempty"`
+	DecisionID string    `json:"decision_id,omitempty"`
+}
+
+/*
+  What the handlers learn about a request as it goes, which
+  the access log cannot see from outside.
+*/
+type accessNotes struct {
+	mu         sync.Mutex
+	remote     string
+	user       string
+	decision   string
+	decisionID string
+}
+
+func notesOf(ctx context.Context) *accessNotes {
+	n, _ := ctx.Value("access").(*accessNotes)
+	return n
+}
+
+// noteRemote keeps the client address, when a proxy is in the way.
+func noteRemote(ctx context.Context, remote string) {
+	if n := notesOf(ctx); n != nil {
+		n.mu.Lock()
+		n.remote = remote
+		n.mu.Unlock()
+	}
+}
+
+func noteUser(ctx context.Context, username string) {
+	if n := notesOf(ctx); n != nil {
+		n.mu.Lock()
+		n.user = username
+		n.mu.Unlock()
+	}
+}
+
+// noteDecision keeps the actions that a permission allows.
+func noteDecision(ctx context.Context, permission map[string]interface{}) {
+	n := notesOf(ctx)
+	if n == nil {
+		return
+	}
+	allowed := make([]string, 0)
+	for k, v := range permission {
+		if b, ok := v.(bool); ok && b {
+			allowed = append(allowed, k)
+		}
+	}
+	sort.Strings(allowed)
+	n.mu.Lock()
+	n.decision = strings.Join(allowed, ",")
+	n.mu.Unlock()
+}
+
+func noteDecisionID(ctx context.Context, id string) {
+	if n := notesOf(ctx); n != nil {
+		n.mu.Lock()
+		n.decisionID = id
+		n.mu.Unlock()
+	}
+}
+
+// Counts what is written, to log the status and size of a response
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (c *countingWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(b)
+	c.bytes += int64(n)
+	return n, err
+}
+
+/*
+  Write access records to a file, as json lines or in the
+  Common Log Format.  On SIGHUP, the file is opened again, so
+  that logrotate can move it away.
+*/
+type accessLog struct {
+	mu     sync.Mutex
+	name   string
+	format string
+	w      io.WriteCloser
+}
+
+func newAccessLog(name, format string) (*accessLog, error) {
+	if format != "json" && format != "clf" {
+		return nil, fmt.Errorf("unknown access log format: %s", format)
+	}
+	a := &accessLog{name: name, format: format}
+	if name == "-" {
+		a.w = os.Stdout
+		return a, nil
+	}
+	if err := a.reopen(); err != nil {
+		return nil, err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := a.reopen(); err != nil {
+				webdav.Log().Error("cannot reopen access log", "file", a.name, "err", err)
+			}
+		}
+	}()
+	return a, nil
+}
+
+func (a *accessLog) reopen() error {
+	f, err := os.OpenFile(a.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	old := a.w
+	a.w = f
+	a.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (a *accessLog) write(rec AccessRecord) {
+	var line string
+	if a.format == "json" {
+		data, _ := json.Marshal(rec)
+		line = string(data) + "\n"
+	} else {
+		user := rec.User
+		if user == "" {
+			user = "-"
+		}
+		line = fmt.Sprintf("%s - %s [%s] %q %d %d\n",
+			rec.Remote, user, rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			rec.Method+" "+rec.Path+" "+rec.Proto, rec.Status, rec.Bytes)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := io.WriteString(a.w, line); err != nil {
+		webdav.Log().Error("cannot write access log", "err", err)
+	}
+}
+
+/*
+  Signed urls and share links carry what lets them in, so
+  that is kept out of the log.
+*/
+func loggedPath(r *http.Request) string {
+	p := r.URL.Path
+	if i := strings.Index(p, sharePrefix); i >= 0 {
+		rest := p[i+len(sharePrefix):]
+		if j := strings.Index(rest, "/"); j >= 0 {
+			rest = rest[j:]
+		} else {
+			rest = ""
+		}
+		p = p[:i+len(sharePrefix)] + "-" + rest
+	}
+	q := r.URL.Query()
+	if q.Get("signature") != "" {
+		q.Set("signature", "-")
+	}
+	if len(q) == 0 {
+		return p
+	}
+	return p + "?" + q.Encode()
+}
+
+// Wrap a handler so that every request it serves is logged.
+func (a *accessLog) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		notes := &accessNotes{}
+		cw := &countingWriter{ResponseWriter: w}
+		h.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), "access", notes)))
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		notes.mu.Lock()
+		defer notes.mu.Unlock()
+		if notes.remote != "" {
+			remote = notes.remote
+		}
+		a.write(AccessRecord{
+			Time:       start,
+			RequestID:  webdav.RequestID(r.Context()),
+			Remote:     remote,
+			User:       notes.user,
+			Method:     r.Method,
+			Path:       loggedPath(r),
+			Proto:      r.Proto,
+			Status:     cw.status,
+			Bytes:      cw.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Decision:   notes.decision,
+			DecisionID: notes.decisionID,
+		})
+	})
+}
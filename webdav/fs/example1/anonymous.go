@@ -0,0 +1,92 @@
+package example1
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  The user that requests without credentials are made as, when there
+  is one.  Empty, as it is by default, asks them to log in.
+*/
+var anonymousUser string
+
+func setupAnonymous(username string) {
+	if username == "" {
+		return
+	}
+	if err := validUsername(username); err != nil {
+		log.Fatalf("WEBDAV: cannot allow anonymous access: %v", err)
+	}
+	anonymousUser = username
+	webdav.Log().Info("allowing anonymous access", "user", username)
+}
+
+/*
+  The claims of a request without credentials, made up rather than
+  looked up, so that a policy can open a subtree to it:
+
+    Stat = true
+    Read = true
+    Write { not input.claims.groups.anonymous }
+
+  A policy that allows something to everyone allows it to anonymous
+  requests too.
+*/
+func anonymousClaims() Claims {
+	return Claims{Groups: map[string][]string{
+		"username":  {anonymousUser},
+		"anonymous": {"true"},
+	}}
+}
+
+/*
+  Serve r, which came without credentials, as the anonymous user.
+  What it is refused is answered with 401 rather than 403 or 404, so
+  that the client asks for a password and tries again, as it would
+  have been without anonymous access.
+*/
+func serveAnonymous(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	ctx := r.Context()
+	noteUser(ctx, anonymousUser)
+	ctx = context.WithValue(ctx, "username", anonymousUser)
+	ctx = context.WithValue(ctx, "claims", anonymousClaims())
+	serveLimited(&anonymousWriter{ResponseWriter: w}, r.WithContext(ctx), h)
+}
+
+/*
+  anonymousWriter passes a response on, unless it refuses, which it
+  turns into a request to log in instead.
+*/
+type anonymousWriter struct {
+	http.ResponseWriter
+	refused bool
+}
+
+func (a *anonymousWriter) WriteHeader(status int) {
+	if status == http.StatusForbidden || status == http.StatusNotFound {
+		a.refused = true
+		a.Header().Del("Content-Length")
+		a.Header().Del("Content-Type")
+		a.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		http.Error(a.ResponseWriter, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *anonymousWriter) Write(b []byte) (int, error) {
+	if a.refused {
+		return len(b), nil
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+func (a *anonymousWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok && !a.refused {
+		f.Flush()
+	}
+}
@@ -0,0 +1,123 @@
+package example1
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  JSON endpoints for administration live under this prefix.
+  The .__ prefix is already reserved for metadata, so they
+  can never collide with a real file.
+*/
+const apiPrefix = "/.__api/"
+
+func writeJson(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(AsJson(obj)))
+}
+
+// Errors say which request they were, so that they can be found in the logs.
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if id := w.Header().Get(webdav.RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	writeJson(w, status, body)
+}
+
+/*
+  What the user may see in the directory name, for apis that list
+  it: not its metadata, nor what would take a second factor that
+  they have not given.
+*/
+func visibleChildren(ctx context.Context, fsys fs.FS, name string) ([]os.FileInfo, error) {
+	f, err := fsys.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]os.FileInfo, 0, len(infos))
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), ".__") {
+			continue
+		}
+		child := path.Join(name, fi.Name())
+		if !mfaOf(ctx) && requiresMFA(ctx, fsys, child) {
+			continue
+		}
+		if fi, err := fsys.Stat(ctx, child); err == nil {
+			visible = append(visible, fi)
+		}
+	}
+	return visible, nil
+}
+
+/*
+  Report bytes and file counts for a directory, broken down by child.
+  At the top, the children are the users' home directories.  Only
+  what the user may Stat is named or counted.
+
+    GET /.__api/usage?path=/rob
+*/
+func usageHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		name := r.URL.Query().Get("path")
+		if name == "" {
+			name = "/"
+		}
+		name = webdav.SlashClean(name)
+		// You can only see usage of what you can see
+		if _, err := fsys.Stat(r.Context(), name); err != nil {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		usage, err := fsys.VisibleUsage(r.Context(), name)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, http.StatusOK, usage)
+	})
+}
+
+/*
+  Say what the user may do with a file, for clients that want to
+  show only what would work.  This is what OPTIONS says in Allow.
+
+    GET /.__api/capabilities?path=/rob/notes.txt
+*/
+func capabilitiesHandler(srv *webdav.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		capabilities, methods, err := srv.Allowed(r.Context(), name)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, http.StatusOK, struct {
+			Path string `json:"path"`
+			webdav.Capabilities
+			Methods []string `json:"methods"`
+		}{name, capabilities, methods})
+	})
+}
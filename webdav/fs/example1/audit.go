@@ -0,0 +1,113 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  An AuditRecord says who did what to which thing, and how it went.
+  Before and After hold the document being changed, when there is one.
+*/
+type AuditRecord struct {
+	Time   time.Time       `json:"time"`
+	User   string          `json:"user"`
+	Action string          `json:"action"`
+	Target string          `json:"target"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// The request that made the change
+	RequestID string `json:"request_id,omitempty"`
+	// The tenant it was made in, if there are tenants
+	Tenant string `json:"tenant,omitempty"`
+	// The service account that made it on behalf of User, if one did
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+/*
+  Somewhere to send audit records.
+*/
+type AuditSink interface {
+	Audit(rec AuditRecord) error
+}
+
+/*
+  Append audit records as json lines to a file.
+*/
+type fileAuditSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFileAuditSink(name string) (*fileAuditSink, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{f: f}, nil
+}
+
+func (s *fileAuditSink) Audit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+/*
+  Without an audit file, records just go to the log.
+*/
+type logAuditSink struct{}
+
+func (s logAuditSink) Audit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	webdav.Log().Info("audit", "record", string(data))
+	return nil
+}
+
+var auditSinks = []AuditSink{logAuditSink{}}
+
+func setupAudit(name string) {
+	if name == "" {
+		return
+	}
+	sink, err := newFileAuditSink(name)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot open audit file %s: %v", name, err)
+	}
+	auditSinks = []AuditSink{sink}
+}
+
+func audit(ctx context.Context, rec AuditRecord) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	if rec.RequestID == "" {
+		rec.RequestID = webdav.RequestID(ctx)
+	}
+	if rec.Tenant == "" {
+		rec.Tenant = tenantOf(ctx).Name
+	}
+	if rec.Impersonator == "" {
+		rec.Impersonator, _ = ctx.Value("impersonator").(string)
+	}
+	for _, sink := range auditSinks {
+		if err := sink.Audit(rec); err != nil {
+			webdav.Log().Error("could not write audit record", "err", err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package example1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Show directories to browsers as a page, with a form to upload
+  into them.  Everything else, and every client that does not ask
+  for html, goes on to WebDAV.  The upload is turned into a PUT,
+  so that it gets the same policy, filters and audit as any other.
+*/
+type browseHandler struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+type browseEntry struct {
+	Name    string
+	Href    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>
+<form method="POST" enctype="multipart/form-data">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="file" name="file">
+<input type="submit" value="Upload">
+</form>
+</body>
+</html>
+`))
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+/*
+  The form token for a user.  It is made with the tenant's url
+  signing key, so another site cannot know it, and a form posted
+  from there, with the user's credentials, is refused.
+*/
+func csrfToken(t *Tenant, username string) string {
+	mac := hmac.New(sha256.New, t.signer.key)
+	mac.Write([]byte("csrf\n" + username))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (b browseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := webdav.SlashClean(strings.TrimPrefix(r.URL.Path, b.Tenant.Prefix))
+	fsys := b.Tenant.fsys
+	switch {
+	case r.Method == "GET" && wantsHTML(r):
+		if info, err := fsys.Stat(ctx, name); err == nil && info.IsDir() {
+			b.list(w, r, name)
+			return
+		}
+	case r.Method == "POST" && strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data"):
+		b.upload(w, r, name)
+		return
+	}
+	b.Handler.ServeHTTP(w, r)
+}
+
+func (b browseHandler) list(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+	entries, err := visibleEntries(ctx, b.Tenant, name)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	username, _ := ctx.Value("username").(string)
+	page := map[string]interface{}{
+		"Path":    path.Join("/", b.Tenant.Prefix, name),
+		"Entries": entries,
+		"CSRF":    csrfToken(b.Tenant, username),
+	}
+	if name != "/" {
+		parent := path.Join("/", b.Tenant.Prefix, path.Dir(name))
+		if parent != "/" {
+			parent += "/"
+		}
+		page["Parent"] = parent
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := browseTemplate.Execute(w, page); err != nil {
+		webdav.Log().Warn("cannot write listing", "request_id", webdav.RequestID(ctx), "err", err)
+	}
+}
+
+// What the user of ctx may see in the directory name, by name.
+func visibleEntries(ctx context.Context, t *Tenant, name string) ([]browseEntry, error) {
+	children, err := visibleChildren(ctx, t.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]browseEntry, 0, len(children))
+	for _, c := range children {
+		href := path.Join(t.Prefix, name, c.Name())
+		if c.IsDir() {
+			href += "/"
+		}
+		entries = append(entries, browseEntry{Name: c.Name(), Href: href, IsDir: c.IsDir(), Size: c.Size(), ModTime: c.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Remembers the status of a response that is not sent on
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (s *statusRecorder) Header() http.Header { return s.header }
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+/*
+  Take an upload from the form.  The token has to come before the
+  file, as browsers send fields in the order of the form.
+*/
+func (b browseHandler) upload(w http.ResponseWriter, r *http.Request, dir string) {
+	ctx := r.Context()
+	username, _ := ctx.Value("username").(string)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := ""
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			http.Error(w, "no file in the form", http.StatusBadRequest)
+			return
+		}
+		switch part.FormName() {
+		case "csrf":
+			data, _ := io.ReadAll(io.LimitReader(part, 128))
+			token = string(data)
+		case "file":
+			if !hmac.Equal([]byte(token), []byte(csrfToken(b.Tenant, username))) {
+				audit(ctx, AuditRecord{User: username, Action: "denied", Target: dir, Error: "bad csrf token"})
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			filename := path.Base(part.FileName())
+			if filename == "" || filename == "." || filename == "/" || strings.HasPrefix(filename, ".__") {
+				http.Error(w, "bad file name", http.StatusBadRequest)
+				return
+			}
+			put, err := http.NewRequestWithContext(ctx, "PUT", "", part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			put.URL = &url.URL{Path: path.Join(b.Tenant.Prefix, dir, filename)}
+			put.Host = r.Host
+			put.RemoteAddr = r.RemoteAddr
+			put.Header.Set("Content-Type", part.Header.Get("Content-Type"))
+			rec := &statusRecorder{header: make(http.Header)}
+			b.Handler.ServeHTTP(rec, put)
+			if rec.status >= 300 {
+				http.Error(w, http.StatusText(rec.status), rec.status)
+				return
+			}
+			http.Redirect(w, r, path.Join(b.Tenant.Prefix, dir)+"/", http.StatusSeeOther)
+			return
+		}
+	}
+}
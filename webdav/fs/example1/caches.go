@@ -0,0 +1,136 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  What to forget: what is kept of a user, of everything under a path
+  of the tenant, or, with neither, of everything.
+*/
+type CacheScope struct {
+	User string `json:"user,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+/*
+  Policies are evaluated afresh for every request, and the decisions
+  are only kept for the request that made them, so a change to a
+  policy or to claims is seen by the next request.  What is kept
+  between requests is forgotten through these, by name, so that a
+  change is seen at once rather than when what was kept runs out.
+*/
+type cacheInvalidator func(ctx context.Context, scope CacheScope)
+
+var cacheInvalidators = map[string]cacheInvalidator{
+	"stats":       forgetStats,
+	"ftp-session": forgetSessionClaims,
+}
+
+// Forget what is kept within scope, returning the names of the caches that were asked to.
+func invalidateCaches(ctx context.Context, scope CacheScope) []string {
+	names := make([]string, 0, len(cacheInvalidators))
+	for name, forget := range cacheInvalidators {
+		forget(ctx, scope)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	webdav.Log().Info("invalidated caches", "request_id", webdav.RequestID(ctx), "user", scope.User, "path", scope.Path)
+	return names
+}
+
+// The stats of the volume of the tenant, which say whether a write is a create
+func forgetStats(ctx context.Context, scope CacheScope) {
+	fsys := tenantOf(ctx).fsys
+	if scope.User != "" && scope.Path == "" {
+		return
+	}
+	name := fsys.Root
+	if scope.Path != "" {
+		if name = fsys.Resolve(scope.Path); name == "" {
+			return
+		}
+	}
+	fsys.Stats.InvalidateTree(name)
+}
+
+/*
+  When claims were last forgotten, for everyone and for each user, so
+  that what holds on to them for a session, as an FTP login does,
+  knows to get them again.
+*/
+var claimsForgotten = struct {
+	sync.Mutex
+	all   time.Time
+	users map[string]time.Time
+}{users: make(map[string]time.Time)}
+
+func forgetSessionClaims(ctx context.Context, scope CacheScope) {
+	if scope.Path != "" && scope.User == "" {
+		return
+	}
+	claimsForgotten.Lock()
+	defer claimsForgotten.Unlock()
+	if scope.User == "" {
+		claimsForgotten.all = time.Now()
+		claimsForgotten.users = make(map[string]time.Time)
+		return
+	}
+	claimsForgotten.users[scope.User] = time.Now()
+}
+
+// Whether the claims of username have been forgotten since when.
+func claimsForgottenSince(username string, when time.Time) bool {
+	claimsForgotten.Lock()
+	defer claimsForgotten.Unlock()
+	return claimsForgotten.all.After(when) || claimsForgotten.users[username].After(when)
+}
+
+/*
+  Forget what is kept of claims and policies, for a user, for a
+  subtree, or for everything, as the policy and claims apis do when
+  they change them:
+
+    POST /.__api/caches  {"user": "jp"}
+    POST /.__api/caches  {"path": "/rob/projects"}
+    POST /.__api/caches  {}
+
+  It answers with the names of the caches that forgot.
+*/
+func cachesHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		var scope CacheScope
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&scope); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		if scope.Path != "" {
+			scope.Path = webdav.SlashClean(scope.Path)
+		}
+		rec := AuditRecord{User: username, Action: "invalidate caches", Target: scope.Path}
+		if rec.Target == "" {
+			rec.Target = scope.User
+		}
+		rec.After, _ = json.Marshal(scope)
+		audit(ctx, rec)
+		writeJson(w, http.StatusOK, map[string][]string{"invalidated": invalidateCaches(ctx, scope)})
+	})
+}
@@ -0,0 +1,190 @@
+package example1
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  How each security relevant audit action shows up in a SIEM.
+  Audit records with other actions are not sent.
+*/
+type cefEvent struct {
+	ID       string
+	Name     string
+	Severity int
+}
+
+var cefEvents = map[string]cefEvent{
+	"auth failed":     {"100", "Authentication failed", 7},
+	"login":           {"101", "Session started", 2},
+	"logout":          {"102", "Session ended", 2},
+	"denied":          {"200", "Permission denied", 5},
+	"delete":          {"300", "File deleted", 3},
+	"policy.update":   {"400", "Policy changed", 8},
+	"policy.rollback": {"401", "Policy rolled back", 8},
+	"claims.update":   {"402", "Claims changed", 6},
+	"gdpr.export":     {"500", "User data exported", 6},
+	"gdpr.erase":      {"501", "User erased", 8},
+	"mfa.enroll":      {"600", "Second factor enrolled", 5},
+	"mfa.drop":        {"601", "Second factor dropped", 7},
+	"mfa.invite":      {"602", "Second factor enrollment allowed", 6},
+}
+
+// Events waiting to be sent.  When the SIEM is away for longer than this
+// lasts, events are dropped and a warning is logged.
+const cefQueueSize = 1000
+
+/*
+  Send security events to a SIEM, such as Splunk or QRadar, as
+  ArcSight Common Event Format lines over tcp, or tls with
+  tls://.  Events are queued, so that a slow or missing SIEM does
+  not slow down requests, and the connection is dialed again
+  when it is lost.
+
+    CEF:0|rfielding|webdev|1.0|200|Permission denied|5|rt=1792161380000 suser=jp act=denied fname=/rob/x.txt ...
+*/
+type cefSink struct {
+	network string
+	addr    string
+	tls     *tls.Config
+	queue   chan string
+}
+
+func newCEFSink(where, caFile string) (*cefSink, error) {
+	s := &cefSink{queue: make(chan string, cefQueueSize)}
+	switch {
+	case strings.HasPrefix(where, "tcp://"):
+		s.network, s.addr = "tcp", strings.TrimPrefix(where, "tcp://")
+	case strings.HasPrefix(where, "tls://"):
+		s.network, s.addr = "tcp", strings.TrimPrefix(where, "tls://")
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return nil, err
+		}
+		s.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", caFile)
+			}
+			s.tls.RootCAs = pool
+		}
+	default:
+		return nil, fmt.Errorf("cef must be a tcp:// or tls:// address: %s", where)
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *cefSink) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		return tls.DialWithDialer(d, s.network, s.addr, s.tls)
+	}
+	return d.Dial(s.network, s.addr)
+}
+
+// Send what is queued, waiting a while between failed dials
+func (s *cefSink) run() {
+	var conn net.Conn
+	for line := range s.queue {
+		for {
+			if conn == nil {
+				var err error
+				conn, err = s.dial()
+				if err != nil {
+					webdav.Log().Warn("cannot reach siem", "addr", s.addr, "err", err)
+					time.Sleep(10 * time.Second)
+					continue
+				}
+			}
+			if _, err := conn.Write([]byte(line)); err != nil {
+				webdav.Log().Warn("lost siem connection", "addr", s.addr, "err", err)
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+func (s *cefSink) Audit(rec AuditRecord) error {
+	ev, ok := cefEvents[rec.Action]
+	if !ok {
+		return nil
+	}
+	ext := [][2]string{
+		{"rt", fmt.Sprint(rec.Time.UnixNano() / int64(time.Millisecond))},
+		{"suser", rec.User},
+		{"act", rec.Action},
+		{"fname", rec.Target},
+		{"externalId", rec.RequestID},
+	}
+	outcome := "success"
+	if rec.Action == "auth failed" || rec.Action == "denied" {
+		outcome = "failure"
+	}
+	if rec.Error != "" {
+		outcome = "failure"
+		ext = append(ext, [2]string{"msg", rec.Error})
+	}
+	ext = append(ext, [2]string{"outcome", outcome})
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|rfielding|webdev|1.0|%s|%s|%d|", ev.ID, cefHeader(ev.Name), ev.Severity)
+	first := true
+	for _, kv := range ext {
+		if kv[1] == "" {
+			continue
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(kv[0] + "=" + cefValue(kv[1]))
+	}
+	b.WriteByte('\n')
+	select {
+	case s.queue <- b.String():
+		return nil
+	default:
+		return fmt.Errorf("siem queue is full, dropped %s event", rec.Action)
+	}
+}
+
+// Escape a header field, as the CEF spec says
+func cefHeader(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(v)
+}
+
+// Escape an extension value, as the CEF spec says
+func cefValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(v)
+}
+
+/*
+  Send security events to a SIEM as well as to the other sinks.
+*/
+func setupCEF(where, caFile string) {
+	if where == "" {
+		return
+	}
+	s, err := newCEFSink(where, caFile)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot set up siem export: %v", err)
+	}
+	auditSinks = append(auditSinks, s)
+}
@@ -0,0 +1,277 @@
+package example1
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  An upload that a browser sends in chunks, one request each, so that
+  a big file survives a dropped connection, and can be sent in pieces
+  small enough for a proxy to let through:
+
+    POST   /.__api/files/upload/init?path=/rob/big.iso&size=1073741824
+    PUT    /.__api/files/upload/append?id=...&offset=0      the chunk
+    GET    /.__api/files/upload/status?id=...
+    POST   /.__api/files/upload/commit?id=...
+    DELETE /.__api/files/upload/abort?id=...
+
+  Each chunk goes at the offset it says, which has to be where the
+  last one ended, or it is refused with 409 and the offset to go on
+  from.  The chunks are kept outside of the volume, so they do not
+  count against the quota, until the upload is committed, when the
+  file is written with one PUT, as any other upload is.  Nothing of
+  it is seen on the volume before then, and an upload that is never
+  committed leaves nothing behind.
+*/
+type ChunkedUpload struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// The size that init said the file would be, or -1 if it did not
+	Size int64 `json:"size"`
+	// How much of the file has come, which is where the next chunk goes
+	Offset      int64     `json:"offset"`
+	ContentType string    `json:"content_type,omitempty"`
+	User        string    `json:"user"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+// How long an upload is kept after its last chunk
+const chunkedUploadTimeout = 24 * time.Hour
+
+// The most that one chunk may be
+const maxChunk = 64 << 20
+
+// Held while an upload is changed, by id, so that its chunks go in one at a time
+var chunkedLocks sync.Map
+
+func (h filesAPI) uploadsDir() string {
+	name := h.Tenant.Name
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(os.TempDir(), "webdev-chunks", name)
+}
+
+// Uploads that nobody went on with are swept away when another starts.
+func (h filesAPI) sweepUploads() {
+	infos, _ := ioutil.ReadDir(h.uploadsDir())
+	for _, fi := range infos {
+		if fi.IsDir() && time.Since(fi.ModTime()) > chunkedUploadTimeout {
+			os.RemoveAll(filepath.Join(h.uploadsDir(), fi.Name()))
+			chunkedLocks.Delete(fi.Name())
+		}
+	}
+}
+
+/*
+  The upload that r names, and its directory, if it is one that its
+  user started.  Anyone else is told there is no such upload.
+*/
+func (h filesAPI) chunkedUpload(r *http.Request) (string, ChunkedUpload, error) {
+	var upload ChunkedUpload
+	id := r.URL.Query().Get("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return "", upload, davErrorf(http.StatusNotFound, "no such upload")
+	}
+	dir := filepath.Join(h.uploadsDir(), id)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", upload, davErrorf(http.StatusNotFound, "no such upload")
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return "", upload, err
+	}
+	username, _ := r.Context().Value("username").(string)
+	if upload.User != username {
+		return "", upload, davErrorf(http.StatusNotFound, "no such upload")
+	}
+	return dir, upload, nil
+}
+
+/*
+  The upload that r names, as chunkedUpload has it, read again once
+  its lock is held, which unlock lets go of.  Only uploads that are
+  there get a lock.
+*/
+func (h filesAPI) lockedUpload(r *http.Request) (dir string, upload ChunkedUpload, unlock func(), err error) {
+	if _, _, err = h.chunkedUpload(r); err != nil {
+		return "", upload, nil, err
+	}
+	id := r.URL.Query().Get("id")
+	mu, _ := chunkedLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	if dir, upload, err = h.chunkedUpload(r); err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return "", upload, nil, err
+	}
+	return dir, upload, mu.(*sync.Mutex).Unlock, nil
+}
+
+func saveUpload(dir string, upload ChunkedUpload) error {
+	tmp := filepath.Join(dir, "upload.json.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(AsJson(upload)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "upload.json"))
+}
+
+func (h filesAPI) uploadInit(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	name, err := h.name(r, q.Get("path"))
+	if err != nil {
+		return err
+	}
+	if base := path.Base(name); base == "/" || strings.HasPrefix(base, ".__") {
+		return davErrorf(http.StatusBadRequest, "bad file name %q", base)
+	}
+	size := int64(-1)
+	if s := q.Get("size"); s != "" {
+		if size, err = strconv.ParseInt(s, 10, 64); err != nil || size < 0 {
+			return davErrorf(http.StatusBadRequest, "bad size %q", s)
+		}
+	}
+	if err := mayWrite(r.Context(), h.Tenant.fsys, name); err != nil {
+		return err
+	}
+	h.sweepUploads()
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(h.uploadsDir(), id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data"), nil, 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	username, _ := r.Context().Value("username").(string)
+	upload := ChunkedUpload{ID: id, Path: name, Size: size, ContentType: q.Get("type"), User: username, Initiated: time.Now().UTC()}
+	if err := saveUpload(dir, upload); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	writeJson(w, http.StatusCreated, upload)
+	return nil
+}
+
+func (h filesAPI) uploadAppend(w http.ResponseWriter, r *http.Request) error {
+	dir, upload, unlock, err := h.lockedUpload(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		return davErrorf(http.StatusBadRequest, "offset is required")
+	}
+	if offset != upload.Offset {
+		// the client lost track, as when a response did not reach it
+		writeJson(w, http.StatusConflict, upload)
+		return nil
+	}
+	limit := int64(maxChunk)
+	if upload.Size >= 0 && upload.Size-offset < limit {
+		limit = upload.Size - offset
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "data"), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r.Body, limit+1))
+	if err == nil && n > limit {
+		if limit < maxChunk {
+			err = davErrorf(http.StatusRequestEntityTooLarge, "the file is only %d bytes", upload.Size)
+		} else {
+			err = davErrorf(http.StatusRequestEntityTooLarge, "a chunk may be at most %d bytes", int64(maxChunk))
+		}
+	}
+	if err != nil {
+		// what came of the chunk is thrown away, so it can be sent again
+		f.Truncate(offset)
+		return err
+	}
+	upload.Offset += n
+	if err := saveUpload(dir, upload); err != nil {
+		f.Truncate(offset)
+		return err
+	}
+	writeJson(w, http.StatusOK, upload)
+	return nil
+}
+
+func (h filesAPI) uploadStatus(w http.ResponseWriter, r *http.Request) error {
+	_, upload, err := h.chunkedUpload(r)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, upload)
+	return nil
+}
+
+// Write the file from its chunks, through WebDAV as any other upload is.
+func (h filesAPI) uploadCommit(w http.ResponseWriter, r *http.Request) error {
+	dir, upload, unlock, err := h.lockedUpload(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if upload.Size >= 0 && upload.Offset != upload.Size {
+		return davErrorf(http.StatusConflict, "%d of %d bytes have come", upload.Offset, upload.Size)
+	}
+	if err := mayWrite(r.Context(), h.Tenant.fsys, upload.Path); err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, "data"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	put := davRequest(r, h.Tenant, "PUT", upload.Path, f, upload.Offset)
+	if upload.ContentType != "" {
+		put.Header.Set("Content-Type", upload.ContentType)
+	}
+	if err := h.do(put); err != nil {
+		return err
+	}
+	os.RemoveAll(dir)
+	chunkedLocks.Delete(upload.ID)
+	fi, err := h.Tenant.fsys.Stat(r.Context(), upload.Path)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusCreated, h.entry(r, upload.Path, fi))
+	return nil
+}
+
+func (h filesAPI) uploadAbort(w http.ResponseWriter, r *http.Request) error {
+	dir, upload, unlock, err := h.lockedUpload(r)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	chunkedLocks.Delete(upload.ID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,208 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+var ErrNotAdmin = errors.New("webdav: admin permission required")
+
+// The claims of a user live in their home directory.
+func claimsFileFor(root, username string) string {
+	return fmt.Sprintf("%s/%s/.__claims.json", root, username)
+}
+
+/*
+  Where the claims of a user come from, for the policies to see.
+*/
+type ClaimsProvider interface {
+	Claims(ctx context.Context, root, username string) (Claims, error)
+}
+
+// The claims files in home directories, unless something else is set up
+var claimsProvider ClaimsProvider = fileClaims{}
+
+type fileClaims struct{}
+
+func (fileClaims) Claims(ctx context.Context, root, username string) (Claims, error) {
+	var claims Claims
+	data, err := ioutil.ReadFile(claimsFileFor(root, username))
+	if err != nil {
+		return claims, err
+	}
+	err = json.Unmarshal(data, &claims)
+	return claims, err
+}
+
+/*
+  Usernames double as the home directory name,
+  so they must not be able to walk out of it.
+*/
+func validUsername(username string) error {
+	if username == "" || username == "." || username == ".." ||
+		strings.HasPrefix(username, ".") || strings.ContainsAny(username, "/\\\x00") {
+		return fmt.Errorf("invalid username: %q", username)
+	}
+	return nil
+}
+
+/*
+  These may come along from a JWT, and are allowed in a claims file
+  alongside groups.  Anything else is most likely a typo.
+*/
+var registeredClaims = map[string]bool{
+	"iss": true,
+	"sub": true,
+	"aud": true,
+	"exp": true,
+	"nbf": true,
+	"iat": true,
+	"jti": true,
+}
+
+/*
+  Check that a claims document has the shape that the rego
+  policies expect, and that it really belongs to username.
+*/
+func validateClaims(username string, data []byte) (Claims, error) {
+	var claims Claims
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return claims, fmt.Errorf("claims must be a json object: %v", err)
+	}
+	for k := range fields {
+		if k != "groups" && !registeredClaims[k] {
+			return claims, fmt.Errorf("unknown claim: %s", k)
+		}
+	}
+	if _, ok := fields["groups"]; !ok {
+		return claims, fmt.Errorf("claims must have groups")
+	}
+	if err := json.Unmarshal(fields["groups"], &claims.Groups); err != nil {
+		return claims, fmt.Errorf("groups must map names to lists of strings: %v", err)
+	}
+	for g, values := range claims.Groups {
+		for _, v := range values {
+			if v == "" {
+				return claims, fmt.Errorf("group %s has an empty value", g)
+			}
+		}
+	}
+	found := false
+	for _, u := range claims.Groups["username"] {
+		if u == username {
+			found = true
+		}
+	}
+	if !found {
+		return claims, fmt.Errorf("groups.username must include %s", username)
+	}
+	return claims, nil
+}
+
+/*
+  Admins are whoever the top level policy says is allowed to Admin.
+*/
+func isAdmin(ctx context.Context, fsys fs.FS) bool {
+	permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Root, Action: fs.AllowAdmin})
+	return fsys.Allow(ctx, permission, fs.AllowAdmin)
+}
+
+/*
+  Manage the claims of users.
+
+    GET  /.__api/claims?user=jp            read claims
+    PUT  /.__api/claims?user=jp            create or replace claims
+    POST /.__api/claims/validate?user=jp   check claims without saving them
+*/
+func claimsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		actor, _ := ctx.Value("username").(string)
+		user := r.URL.Query().Get("user")
+		if err := validUsername(user); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		claimsFile := claimsFileFor(fsys.Root, user)
+		validateOnly := path.Base(r.URL.Path) == "validate"
+
+		switch {
+		case r.Method == "GET" && !validateOnly:
+			data, err := ioutil.ReadFile(claimsFile)
+			if os.IsNotExist(err) {
+				writeJsonError(w, http.StatusNotFound, err)
+				return
+			}
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+		case r.Method == "POST" && validateOnly, r.Method == "PUT" && !validateOnly:
+			data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			claims, err := validateClaims(user, data)
+			if err != nil {
+				writeJsonError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			if validateOnly {
+				writeJson(w, http.StatusOK, claims)
+				return
+			}
+			before, _ := ioutil.ReadFile(claimsFile)
+			err = writeClaims(claimsFile, data)
+			rec := AuditRecord{
+				User:   actor,
+				Action: "claims.update",
+				Target: user,
+				After:  json.RawMessage(data),
+			}
+			if json.Valid(before) {
+				rec.Before = json.RawMessage(before)
+			}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			invalidateCaches(ctx, CacheScope{User: user})
+			status := http.StatusOK
+			if len(before) == 0 {
+				status = http.StatusCreated
+			}
+			writeJson(w, status, claims)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+func writeClaims(claimsFile string, data []byte) error {
+	if err := os.MkdirAll(path.Dir(claimsFile), 0744); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(claimsFile, data, 0644)
+}
@@ -0,0 +1,190 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Comments longer than this are refused.
+const maxCommentSize = 8 << 10
+
+/*
+  A comment on a file.  Replies name the comment that
+  they answer as their Parent, which makes threads.
+*/
+type Comment struct {
+	ID     string    `json:"id"`
+	Parent string    `json:"parent,omitempty"`
+	Author string    `json:"author"`
+	Time   time.Time `json:"time"`
+	Body   string    `json:"body"`
+}
+
+type CommentRequest struct {
+	Parent string `json:"parent,omitempty"`
+	Body   string `json:"body"`
+}
+
+var commentsMu sync.Mutex
+
+// Comments are kept beside the file, as .__f.comments.json
+func readComments(file string) ([]Comment, error) {
+	comments := make([]Comment, 0)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return comments, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func writeComments(file string, comments []Comment) error {
+	if len(comments) == 0 {
+		err := os.Remove(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(comments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+/*
+  Comments on a file, oldest first.  Anyone who can Read the
+  file can read and add comments.  Authors can delete their own,
+  and so can anyone who can Write the file.  Deleting a comment
+  deletes the replies to it.
+
+    GET    /.__api/comments?path=/rob/cat.jpg
+    POST   /.__api/comments?path=/rob/cat.jpg  {"body": "nice cat", "parent": "..."}
+    DELETE /.__api/comments?path=/rob/cat.jpg&id=...
+*/
+func commentsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		if _, err := fsys.Stat(ctx, name); err != nil || name == "/" || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		resolved := fsys.Resolve(name)
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: resolved, Action: fs.AllowRead})
+		if !fsys.Allow(ctx, permission, fs.AllowRead) {
+			writeJsonError(w, http.StatusForbidden, os.ErrPermission)
+			return
+		}
+		if r.Method != "GET" && !mayChange(w, tenantOf(ctx), name) {
+			return
+		}
+		file := fs.NameFor(resolved, "comments.json")
+		commentsMu.Lock()
+		defer commentsMu.Unlock()
+		comments, err := readComments(file)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, comments)
+			return
+		case "POST":
+			var req CommentRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommentSize*2)).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			req.Body = strings.TrimSpace(req.Body)
+			if req.Body == "" || len(req.Body) > maxCommentSize {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("comments must have a body of at most %d bytes", maxCommentSize))
+				return
+			}
+			if req.Parent != "" && indexOfComment(comments, req.Parent) < 0 {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("no comment %s to reply to", req.Parent))
+				return
+			}
+			c := Comment{
+				ID:     newUUID(),
+				Parent: req.Parent,
+				Author: username,
+				Time:   time.Now().UTC(),
+				Body:   req.Body,
+			}
+			if err := writeComments(file, append(comments, c)); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusCreated, c)
+		case "DELETE":
+			id := r.URL.Query().Get("id")
+			i := indexOfComment(comments, id)
+			if i < 0 {
+				writeJsonError(w, http.StatusNotFound, fmt.Errorf("no comment %s", id))
+				return
+			}
+			if comments[i].Author != username {
+				permission := fsys.PermissionHandler(ctx, fs.Action{Name: resolved, Action: fs.AllowWrite})
+				if !fsys.Allow(ctx, permission, fs.AllowWrite) {
+					writeJsonError(w, http.StatusForbidden, os.ErrPermission)
+					return
+				}
+			}
+			removed := removeThread(comments, id)
+			rec := AuditRecord{User: username, Action: "delete comment", Target: name}
+			rec.Before, _ = json.Marshal(comments[i])
+			if err := writeComments(file, removed); err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, rec)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+func indexOfComment(comments []Comment, id string) int {
+	for i, c := range comments {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeThread drops the comment id and every reply under it.
+func removeThread(comments []Comment, id string) []Comment {
+	gone := map[string]bool{id: true}
+	// Replies always come after what they reply to
+	kept := make([]Comment, 0, len(comments))
+	for _, c := range comments {
+		if gone[c.ID] || (c.Parent != "" && gone[c.Parent]) {
+			gone[c.ID] = true
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
@@ -0,0 +1,93 @@
+package example1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A .__security_data.json beside a rego file is loaded as OPA data,
+  so that tables like group to permission mappings can be edited
+  as data, rather than rewriting rego:
+
+    { "writers": ["rob", "jp"] }
+
+  is used from rego as data.writers.  Documents from the top of
+  the volume down to the file are merged, with the nearest one
+  winning where they disagree.  The params of a policy manifest
+  are merged in the same way, as data.params.  Nothing above root
+  is read, however root and name are spelled.
+*/
+func dataOf(root, name string) map[string]interface{} {
+	docs := make([]map[string]interface{}, 0)
+	root, name = absPath(root), absPath(name)
+	for ; within(root, name); name = filepath.Dir(name) {
+		if params := paramsOf(name); params != nil {
+			docs = append(docs, params)
+		}
+		if doc := readData(fs.NameFor(name, "security_data.json")); doc != nil {
+			docs = append(docs, doc)
+		}
+		if name == root {
+			break
+		}
+	}
+	merged := make(map[string]interface{})
+	for i := len(docs) - 1; i >= 0; i-- {
+		mergeData(merged, docs[i])
+	}
+	return merged
+}
+
+// p, clean and absolute, so that paths spelled differently compare equal
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
+
+// Whether name is root or under it, both being absPath
+func within(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func readData(dataFile string) map[string]interface{} {
+	if dataFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(dataFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		webdav.Log().Warn("cannot read policy data", "err", err)
+		return nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		webdav.Log().Warn("cannot parse policy data", "file", dataFile, "err", err)
+		return nil
+	}
+	return doc
+}
+
+// mergeData deep merges src into dst, overwriting anything that is not an object in both.
+func mergeData(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcOk := v.(map[string]interface{})
+		dstObj, dstOk := dst[k].(map[string]interface{})
+		if srcOk && dstOk {
+			mergeData(dstObj, srcObj)
+			continue
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,105 @@
+package example1
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  How the policy engines are doing, for /debug/vars: how many
+  decisions they made, how many failed or ran out of time, and the
+  seconds spent making them and waiting for a worker to.
+*/
+var policyStats = expvar.NewMap("policy")
+
+func notePolicy(took, waited time.Duration, err error) {
+	policyStats.Add("decisions", 1)
+	policyStats.AddFloat("seconds", took.Seconds())
+	policyStats.AddFloat("waited_seconds", waited.Seconds())
+	switch {
+	case errors.Is(err, errPolicyTimeout):
+		policyStats.Add("timeouts", 1)
+	case err != nil:
+		policyStats.Add("errors", 1)
+	}
+}
+
+/*
+  Request paths that the rules of -paths refuse, by rule, for
+  /debug/vars, whether they were refused, or only would have been.
+*/
+var pathStats = expvar.NewMap("paths")
+
+func notePath(r *http.Request, err *webdav.PathError) {
+	pathStats.Add(err.Rule, 1)
+	webdav.Log().Warn("path breaks a rule", "request_id", webdav.RequestID(r.Context()), "rule", err.Rule, "method", r.Method, "path", err.Path, "mode", pathPolicy.Mode)
+}
+
+/*
+  Serve pprof and expvar on an address of their own, which is not
+  the one that clients use, so that the server can be profiled in
+  production without showing anyone else its insides:
+
+    go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+    curl http://localhost:6060/debug/vars
+
+  With users, only they are let in, with their passwords checked as
+  any other login is.
+*/
+func listenDebug(ln net.Listener, users string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	var handler http.Handler = mux
+	if users != "" {
+		allowed := make(map[string]bool)
+		for _, u := range strings.Split(users, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				allowed[u] = true
+			}
+		}
+		handler = debugAuth{Users: allowed, Handler: mux}
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: headerTimeout,
+	}
+	webdav.Log().Info("starting debug server", "url", fmt.Sprintf("http://%s/debug/pprof/", ln.Addr()), "users", users)
+	if err := srv.Serve(ln); err != nil {
+		log.Fatalf("WEBDAV: cannot serve debug endpoints: %v", err)
+	}
+}
+
+// Only let Users in to the debug endpoints.
+type debugAuth struct {
+	Users   map[string]bool
+	Handler http.Handler
+}
+
+func (d debugAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if ok && d.Users[username] {
+		if _, err := authenticator.Verify(r.Context(), username, password); err == nil {
+			d.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if ok {
+		audit(r.Context(), AuditRecord{User: username, Action: "auth failed", Target: r.URL.Path, Error: "debug login refused"})
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Debug"`)
+	http.Error(w, "Not authorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,266 @@
+package example1
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A decision in OPA's decision log format, so that the tools
+  that already collect decisions from OPA services can take ours.
+  The policy that made the decision is named in bundles, with a
+  hash of its source as the revision.
+*/
+type Decision struct {
+	Labels     map[string]string            `json:"labels"`
+	DecisionID string                       `json:"decision_id"`
+	RequestID  string                       `json:"request_id,omitempty"`
+	Bundles    map[string]map[string]string `json:"bundles,omitempty"`
+	Path       string                       `json:"path"`
+	Input      interface{}                  `json:"input"`
+	Result     interface{}                  `json:"result,omitempty"`
+	Error      string                       `json:"error,omitempty"`
+	Timestamp  time.Time                    `json:"timestamp"`
+	Metrics    map[string]int64             `json:"metrics"`
+}
+
+/*
+  Engines that can say which policy decides an input
+  get the policy named in their decision logs.
+*/
+type PolicyIdentifier interface {
+	PolicyID(input ClaimsContext) (id string, revision string)
+}
+
+func (e regoEngine) PolicyID(input ClaimsContext) (string, string) {
+	id, opaObj := policyOf(e.Root, input.Action.Name)
+	return id, revisionOf([]byte(opaObj))
+}
+
+func (e *aclEngine) PolicyID(input ClaimsContext) (string, string) {
+	data, _ := json.Marshal(e.Entries)
+	return "acl", revisionOf(data)
+}
+
+func revisionOf(source []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(source))[:16]
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+type DecisionSink interface {
+	Log(d Decision) error
+}
+
+/*
+  Wrap an engine so that every decision it makes is logged.
+*/
+type loggedEngine struct {
+	Engine PolicyEngine
+	Sink   DecisionSink
+	Labels map[string]string
+}
+
+func (e loggedEngine) Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error) {
+	start := time.Now()
+	result, err := e.Engine.Decide(ctx, input)
+	d := Decision{
+		Labels:     e.Labels,
+		DecisionID: newUUID(),
+		RequestID:  webdav.RequestID(ctx),
+		Path:       "policy",
+		Input:      input,
+		Result:     result,
+		Timestamp:  start.UTC(),
+		Metrics:    map[string]int64{"timer_server_handler_ns": time.Since(start).Nanoseconds()},
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if pi, ok := e.Engine.(PolicyIdentifier); ok {
+		id, revision := pi.PolicyID(input)
+		d.Bundles = map[string]map[string]string{id: {"revision": revision}}
+	}
+	noteDecisionID(ctx, d.DecisionID)
+	if logErr := e.Sink.Log(d); logErr != nil {
+		webdav.Log().Error("could not log decision", "err", logErr)
+	}
+	return result, err
+}
+
+/*
+  Write decisions as json lines to a file, or to stdout for "-".
+*/
+type fileDecisionSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (s *fileDecisionSink) Log(d Decision) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+/*
+  Send batches of decisions to a decision log service, as
+  OPA does: a gzipped json array POSTed to the url.
+*/
+type httpDecisionSink struct {
+	url     string
+	mu      sync.Mutex
+	pending []Decision
+}
+
+const decisionBatchSize = 100
+
+func newHttpDecisionSink(url string, interval time.Duration) *httpDecisionSink {
+	s := &httpDecisionSink{url: url}
+	go func() {
+		for range time.Tick(interval) {
+			s.flush()
+		}
+	}()
+	return s
+}
+
+func (s *httpDecisionSink) Log(d Decision) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, d)
+	full := len(s.pending) >= decisionBatchSize
+	s.mu.Unlock()
+	if full {
+		go s.flush()
+	}
+	return nil
+}
+
+func (s *httpDecisionSink) flush() {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(batch); err != nil {
+		webdav.Log().Warn("cannot encode decisions", "err", err)
+		return
+	}
+	gz.Close()
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		webdav.Log().Warn("cannot send decisions", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		webdav.Log().Warn("dropped decisions", "count", len(batch), "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		webdav.Log().Warn("dropped decisions", "count", len(batch), "status", resp.Status)
+	}
+}
+
+/*
+  Log decisions made by engine to where, which is
+  a file name, "-" for stdout, or an http(s) url.
+*/
+func withDecisionLog(engine PolicyEngine, where string) (PolicyEngine, error) {
+	if where == "" {
+		return engine, nil
+	}
+	hostname, _ := os.Hostname()
+	labels := map[string]string{"id": hostname, "app": "webdev"}
+	var sink DecisionSink
+	switch {
+	case where == "-":
+		sink = &fileDecisionSink{f: os.Stdout}
+	case strings.HasPrefix(where, "http://") || strings.HasPrefix(where, "https://"):
+		sink = newHttpDecisionSink(where, 5*time.Second)
+	default:
+		f, err := os.OpenFile(where, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		sink = &fileDecisionSink{f: f}
+	}
+	return loggedEngine{Engine: engine, Sink: sink, Labels: labels}, nil
+}
+
+/*
+  The decisions made for one request, so that a COPY, a MOVE or a
+  listing that asks about the same path for the same action again
+  and again evaluates the policy for it once.  A request sees the
+  policy as it was when the request first asked.
+*/
+type decisionMemo struct {
+	mu        sync.Mutex
+	decisions map[string]map[string]interface{}
+}
+
+// withDecisionMemo gives each request to h a memo of its own.
+func withDecisionMemo(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(withMemo(r.Context())))
+	})
+}
+
+func withMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, "decisions", &decisionMemo{decisions: make(map[string]map[string]interface{})})
+}
+
+/*
+  memoized is the permission for action in ctx, from the memo of the
+  request if it was decided already, or from decide.  The user is in
+  the key, for requests that change who they are acting as.
+*/
+func memoized(ctx context.Context, action fs.Action, decide func() map[string]interface{}) map[string]interface{} {
+	memo, _ := ctx.Value("decisions").(*decisionMemo)
+	if memo == nil {
+		return decide()
+	}
+	username, _ := ctx.Value("username").(string)
+	key := username + "\x00" + string(action.Action) + "\x00" + action.Name
+	memo.mu.Lock()
+	permission, ok := memo.decisions[key]
+	memo.mu.Unlock()
+	if ok {
+		return permission
+	}
+	permission = decide()
+	memo.mu.Lock()
+	memo.decisions[key] = permission
+	memo.mu.Unlock()
+	return permission
+}
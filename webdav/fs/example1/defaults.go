@@ -0,0 +1,80 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Read and set the default properties that a directory gives to
+  everything under it.  Anyone who can see the directory can read
+  them; only admins can change them, since policies may depend on
+  them.  An empty object removes the defaults.
+
+    GET /.__api/defaults?path=/eng
+    PUT /.__api/defaults?path=/eng  {"classification": "SECRET"}
+*/
+func defaultsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		info, err := fsys.Stat(ctx, name)
+		if err != nil || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		if !info.IsDir() {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("only directories have default properties"))
+			return
+		}
+		dir := fsys.Resolve(name)
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, fs.DefaultProperties(dir))
+		case "PUT":
+			if !isAdmin(ctx, fsys) {
+				writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+				return
+			}
+			if !mayChange(w, tenantOf(ctx), name) {
+				return
+			}
+			var defaults map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&defaults); err != nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("defaults must be an object of strings: %v", err))
+				return
+			}
+			file := filepath.Join(dir, ".__defaultproperties.json")
+			rec := AuditRecord{User: username, Action: "defaults", Target: name}
+			rec.Before, _ = json.Marshal(fs.DefaultProperties(dir))
+			rec.After, _ = json.Marshal(defaults)
+			if len(defaults) == 0 {
+				err = os.Remove(file)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				data, _ := json.MarshalIndent(defaults, "", "  ")
+				err = ioutil.WriteFile(file, data, 0644)
+			}
+			if err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, rec)
+			writeJson(w, http.StatusOK, defaults)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+  A PolicyEngine turns the claims of a user trying an action into
+  permissions: Create, Read, Write, Delete, Stat, Admin, and the banner.
+  Rego is the default, but anything that can answer that question
+  (Cedar, Casbin, a static table) can sit behind the PermissionHandler.
+*/
+type PolicyEngine interface {
+	Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error)
+}
+
+/*
+  Evaluate the .__security.rego (or template) nearest to the file.
+*/
+type regoEngine struct {
+	Root string
+}
+
+func (e regoEngine) Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error) {
+	return evalRego(ctx, input, regoOf(e.Root, input.Action.Name), dataOf(e.Root, input.Action.Name))
+}
+
+/*
+  One row of a static access control list.  Users with one of Values
+  in the claim Attribute get the Allow permissions on Path and below.
+  A value of "*" matches everyone.
+*/
+type ACLEntry struct {
+	Path             string   `json:"path"`
+	Attribute        string   `json:"attribute"`
+	Values           []string `json:"values"`
+	Allow            []string `json:"allow"`
+	Banner           string   `json:"banner,omitempty"`
+	BannerForeground string   `json:"bannerForeground,omitempty"`
+	BannerBackground string   `json:"bannerBackground,omitempty"`
+	// Disclosure is "hide" or "forbid", for everyone, whoever the entry is for
+	Disclosure string `json:"disclosure,omitempty"`
+}
+
+/*
+  A static ACL for deployments that don't want a policy language.
+  As with rego, the entries for the deepest path that has any
+  are the only ones that apply.
+*/
+type aclEngine struct {
+	Root    string
+	Entries []ACLEntry
+}
+
+func newACLEngine(root, aclFile string) (*aclEngine, error) {
+	data, err := ioutil.ReadFile(aclFile)
+	if err != nil {
+		return nil, err
+	}
+	e := &aclEngine{Root: root}
+	if err := json.Unmarshal(data, &e.Entries); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", aclFile, err)
+	}
+	for i := range e.Entries {
+		e.Entries[i].Path = filepath.ToSlash(filepath.Clean("/" + e.Entries[i].Path))
+	}
+	return e, nil
+}
+
+// The name of the file relative to the top of the volume, with a leading slash.
+func (e *aclEngine) relative(name string) string {
+	rel, err := filepath.Rel(filepath.Clean(e.Root), name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "/"
+	}
+	return filepath.ToSlash(filepath.Clean("/" + rel))
+}
+
+func under(name, prefix string) bool {
+	return prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+func (e *aclEngine) Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error) {
+	name := e.relative(input.Action.Name)
+	deepest := ""
+	for _, entry := range e.Entries {
+		if under(name, entry.Path) && len(entry.Path) > len(deepest) {
+			deepest = entry.Path
+		}
+	}
+	permission := make(map[string]interface{})
+	for _, entry := range e.Entries {
+		if entry.Path == deepest && entry.Disclosure != "" {
+			permission["Disclosure"] = entry.Disclosure
+		}
+		if entry.Path != deepest || !e.matches(input.Claims, entry) {
+			continue
+		}
+		for _, a := range entry.Allow {
+			permission[a] = true
+		}
+		if entry.Banner != "" {
+			permission["Banner"] = entry.Banner
+			permission["BannerForeground"] = entry.BannerForeground
+			permission["BannerBackground"] = entry.BannerBackground
+		}
+	}
+	return permission, nil
+}
+
+func (e *aclEngine) matches(claims Claims, entry ACLEntry) bool {
+	for _, want := range entry.Values {
+		if want == "*" {
+			return true
+		}
+		for _, have := range claims.Groups[entry.Attribute] {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+  Pick the engine named on the command line.
+*/
+func newPolicyEngine(engine, root, aclFile string) (PolicyEngine, error) {
+	switch engine {
+	case "", "rego":
+		return regoEngine{Root: root}, nil
+	case "acl":
+		return newACLEngine(root, aclFile)
+	}
+	return nil, fmt.Errorf("unknown policy engine: %s", engine)
+}
+
+var _ PolicyEngine = regoEngine{}
+var _ PolicyEngine = &aclEngine{}
+
+// errPolicyTimeout is a decision that was not made in time, which denies.
+var errPolicyTimeout = errors.New("policy evaluation timed out")
+
+/*
+  A pooledEngine makes decisions on at most as many goroutines at
+  once as Workers has room for, each in at most Timeout, waiting for
+  a worker included, so that a pathological policy or a giant claims
+  document cannot stall every request.  Rego stops evaluating when
+  its time is up, which frees the worker.  Workers is shared by all
+  tenants, since they share the CPUs.
+*/
+type pooledEngine struct {
+	Engine  PolicyEngine
+	Workers chan struct{}
+	Timeout time.Duration
+}
+
+func (e pooledEngine) Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if e.Workers != nil {
+		select {
+		case e.Workers <- struct{}{}:
+			defer func() { <-e.Workers }()
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// the request was cancelled, which is not the policy's fault
+				return nil, ctx.Err()
+			}
+			err := fmt.Errorf("%w waiting for a worker: %s", errPolicyTimeout, input.Action.Name)
+			notePolicy(0, time.Since(start), err)
+			return nil, err
+		}
+	}
+	waited := time.Since(start)
+	permission, err := e.Engine.Decide(ctx, input)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %v: %s", errPolicyTimeout, e.Timeout, input.Action.Name)
+		permission = nil
+	}
+	notePolicy(time.Since(start)-waited, waited, err)
+	return permission, err
+}
+
+var _ PolicyEngine = pooledEngine{}
@@ -0,0 +1,219 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Favorites of a user live in their home directory, beside their claims.
+func favoritesFileFor(root, username string) string {
+	return fmt.Sprintf("%s/%s/.__favorites.json", root, username)
+}
+
+type Favorite struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+var favoritesMu sync.Mutex
+
+func readFavorites(file string) ([]Favorite, error) {
+	favorites := make([]Favorite, 0)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return favorites, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &favorites); err != nil {
+		return nil, err
+	}
+	return favorites, nil
+}
+
+func writeFavorites(file string, favorites []Favorite) error {
+	data, err := json.MarshalIndent(favorites, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0744); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+/*
+  Star and unstar paths.  Only what you can still see is listed.
+
+    GET    /.__api/favorites
+    PUT    /.__api/favorites?path=/rob/cat.jpg
+    DELETE /.__api/favorites?path=/rob/cat.jpg
+*/
+func favoritesHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if err := validUsername(username); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		file := favoritesFileFor(fsys.Root, username)
+		favoritesMu.Lock()
+		defer favoritesMu.Unlock()
+		favorites, err := readFavorites(file)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		switch r.Method {
+		case "GET":
+			visible := make([]Favorite, 0, len(favorites))
+			for _, f := range favorites {
+				if _, err := fsys.Stat(ctx, f.Path); err == nil {
+					visible = append(visible, f)
+				}
+			}
+			writeJson(w, http.StatusOK, visible)
+			return
+		case "PUT":
+			if _, err := fsys.Stat(ctx, name); err != nil {
+				writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+				return
+			}
+			for _, f := range favorites {
+				if f.Path == name {
+					writeJson(w, http.StatusOK, favorites)
+					return
+				}
+			}
+			favorites = append(favorites, Favorite{Path: name, Time: time.Now().UTC()})
+		case "DELETE":
+			kept := make([]Favorite, 0, len(favorites))
+			for _, f := range favorites {
+				if f.Path != name {
+					kept = append(kept, f)
+				}
+			}
+			favorites = kept
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		// they are kept in the user's directory
+		if !mayChange(w, tenantOf(ctx), "/"+username) {
+			return
+		}
+		if err := writeFavorites(file, favorites); err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, http.StatusOK, favorites)
+	})
+}
+
+/*
+  Something a user did to a file.
+*/
+type Activity struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+}
+
+// How much activity to remember for each user.
+const maxActivity = 200
+
+/*
+  Recent activity of each user, kept in memory.  When there is a
+  change journal, it is filled from that on a restart, though reads
+  are not journaled, so only changes come back.
+*/
+type activityLog struct {
+	mu    sync.Mutex
+	users map[string][]Activity
+}
+
+// These are the methods worth showing in a feed.
+var feedMethods = map[string]bool{
+	"GET": true, "PUT": true, "DELETE": true, "MKCOL": true, "COPY": true, "MOVE": true,
+}
+
+func (l *activityLog) record(r *http.Request) {
+	username, _ := r.Context().Value("username").(string)
+	if username == "" || !feedMethods[r.Method] {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := append(l.users[username], Activity{
+		Time:   time.Now().UTC(),
+		User:   username,
+		Method: r.Method,
+		Path:   webdav.SlashClean(strings.TrimPrefix(r.URL.Path, tenantOf(r.Context()).Prefix)),
+	})
+	if len(list) > maxActivity {
+		list = list[len(list)-maxActivity:]
+	}
+	l.users[username] = list
+}
+
+// forget drops the activity of a user.
+func (l *activityLog) forget(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, username)
+}
+
+// newest first
+func (l *activityLog) of(username string) []Activity {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := append([]Activity(nil), l.users[username]...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	return list
+}
+
+/*
+  Your recent activity, newest first, for things you can still see.
+
+    GET /.__api/recent?limit=20
+*/
+func recentHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		feed := make([]Activity, 0)
+		for _, a := range tenantOf(ctx).recent.of(username) {
+			if len(feed) >= limit {
+				break
+			}
+			if _, err := fsys.Stat(ctx, a.Path); err == nil || a.Method == "DELETE" {
+				feed = append(feed, a)
+			}
+		}
+		writeJson(w, http.StatusOK, feed)
+	})
+}
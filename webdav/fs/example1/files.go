@@ -0,0 +1,436 @@
+package example1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A json api over the volume, for web frontends and scripts that
+  would rather not parse a multistatus.  Reads come straight from
+  the file system, and changes are made as WebDAV requests, so both
+  are allowed by the same policy as WebDAV, and changes are locked,
+  journaled and audited the same way.
+
+    GET    /.__api/files/stat?path=/rob/notes.txt
+    GET    /.__api/files/list?path=/rob&limit=100&after=notes.txt
+    GET    /.__api/files/download?path=/rob/notes.txt
+    POST   /.__api/files/upload?path=/rob         multipart, with file parts
+    POST   /.__api/files/upload/init?path=/rob/big.iso&size=1073741824, and the rest of chunks.go
+    POST   /.__api/files/mkdir?path=/rob/photos
+    POST   /.__api/files/move                     {"from": "/rob/a.txt", "to": "/rob/b.txt", "overwrite": false}
+    POST   /.__api/files/copy                     {"from": "/rob/a.txt", "to": "/rob/b.txt", "overwrite": false}
+    DELETE /.__api/files/delete?path=/rob/b.txt
+    GET    /.__api/files/props?path=/rob/notes.txt
+    PATCH  /.__api/files/props?path=/rob/notes.txt {"set": {"{urn:x}color": "red"}, "remove": ["{urn:x}size"]}
+
+  Errors are {"error": ..., "request_id": ...}, with the status that
+  WebDAV would have given.
+*/
+func filesHandler(t *Tenant, dav http.Handler) http.Handler {
+	h := filesAPI{Tenant: t, DAV: dav}
+	calls := map[string]struct {
+		method string
+		call   func(w http.ResponseWriter, r *http.Request) error
+	}{
+		"stat":     {"GET", h.stat},
+		"list":     {"GET", h.list},
+		"download": {"GET", h.download},
+		"upload":   {"POST", h.upload},
+		"mkdir":    {"POST", h.mkdir},
+		"move":     {"POST", h.copyMove("MOVE")},
+		"copy":     {"POST", h.copyMove("COPY")},
+		"delete":   {"DELETE", h.delete},
+		"props":    {"", h.props},
+
+		// uploads in chunks, as chunks.go has it
+		"upload/init":   {"POST", h.uploadInit},
+		"upload/append": {"PUT", h.uploadAppend},
+		"upload/status": {"GET", h.uploadStatus},
+		"upload/commit": {"POST", h.uploadCommit},
+		"upload/abort":  {"DELETE", h.uploadAbort},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := calls[strings.TrimPrefix(r.URL.Path, apiPrefix+"files/")]
+		if !ok {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		if c.method != "" && r.Method != c.method {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		if !sameOrigin(r) {
+			writeJsonError(w, http.StatusForbidden, fmt.Errorf("cross-origin request"))
+			return
+		}
+		if err := c.call(w, r); err != nil {
+			status := statusOf(err)
+			if status == http.StatusInternalServerError {
+				webdav.Log().Warn("files api failed", "request_id", webdav.RequestID(r.Context()), "user", r.Context().Value("username"), "url", r.URL, "err", err)
+			}
+			if err.Error() == mfaNeeded {
+				w.Header().Set("X-MFA-Required", "totp")
+			}
+			writeJsonError(w, status, err)
+		}
+	})
+}
+
+/*
+  Browsers send basic auth credentials with forms posted from other
+  sites, and an upload is such a form, so changes are refused when
+  they come from a page that this server did not serve.
+*/
+func sameOrigin(r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+type filesAPI struct {
+	Tenant *Tenant
+	DAV    http.Handler
+}
+
+// FileEntry is a file or directory, as the files api gives it.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+type FileList struct {
+	Entries []FileEntry `json:"entries"`
+	// The after to ask for the next page with, if there is one
+	Next string `json:"next,omitempty"`
+}
+
+type FileCopyRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+type FilePropsRequest struct {
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+func (h filesAPI) entry(r *http.Request, name string, fi os.FileInfo) FileEntry {
+	etag, _ := webdav.ETagOf(r.Context(), fi)
+	return FileEntry{
+		Name:    fi.Name(),
+		Path:    name,
+		Size:    fi.Size(),
+		IsDir:   fi.IsDir(),
+		ModTime: fi.ModTime(),
+		ETag:    etag,
+	}
+}
+
+// The name that a call is about, if it is one that can be reached.
+func (h filesAPI) name(r *http.Request, p string) (string, error) {
+	if p == "" {
+		return "", davErrorf(http.StatusBadRequest, "path is required")
+	}
+	name := webdav.SlashClean(p)
+	if isMetadataPath(name) {
+		return "", davErrorf(http.StatusNotFound, "%s not found", name)
+	}
+	if !mfaOf(r.Context()) && requiresMFA(r.Context(), h.Tenant.fsys, name) {
+		return "", davErrorf(http.StatusUnauthorized, mfaNeeded)
+	}
+	return name, nil
+}
+
+// Make a WebDAV request, and say how it failed, if it did.
+func (h filesAPI) do(req *http.Request) error {
+	res := &statusRecorder{header: make(http.Header)}
+	h.DAV.ServeHTTP(res, req)
+	if res.status >= 300 {
+		return davErrorf(res.status, "%s %s: %s", req.Method, strings.TrimPrefix(req.URL.Path, h.Tenant.Prefix), http.StatusText(res.status))
+	}
+	return nil
+}
+
+func (h filesAPI) stat(w http.ResponseWriter, r *http.Request) error {
+	name, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	fi, err := h.Tenant.fsys.Stat(r.Context(), name)
+	if err != nil {
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	}
+	writeJson(w, http.StatusOK, h.entry(r, name, fi))
+	return nil
+}
+
+/*
+  List a directory in order of name, limit entries at a time.  The
+  next page is the one after the last name of this one, so it is not
+  thrown off by what is added or removed in the meantime.
+*/
+func (h filesAPI) list(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	q := r.URL.Query()
+	name, err := h.name(r, q.Get("path"))
+	if err != nil {
+		return err
+	}
+	limit := 100
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			return davErrorf(http.StatusBadRequest, "bad limit %q", s)
+		}
+		if limit > 1000 {
+			limit = 1000
+		}
+	}
+	after := q.Get("after")
+	if fi, err := h.Tenant.fsys.Stat(ctx, name); err != nil {
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	} else if !fi.IsDir() {
+		return davErrorf(http.StatusConflict, "%s is not a directory", name)
+	}
+	infos, err := visibleChildren(ctx, h.Tenant.fsys, name)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	list := FileList{Entries: make([]FileEntry, 0)}
+	for _, fi := range infos {
+		if fi.Name() <= after {
+			continue
+		}
+		if len(list.Entries) == limit {
+			list.Next = list.Entries[limit-1].Name
+			break
+		}
+		list.Entries = append(list.Entries, h.entry(r, path.Join(name, fi.Name()), fi))
+	}
+	writeJson(w, http.StatusOK, list)
+	return nil
+}
+
+/*
+  jsonErrorWriter passes a WebDAV response on, unless it is an error,
+  which it gives as json instead.
+*/
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	failed bool
+}
+
+func (j *jsonErrorWriter) WriteHeader(status int) {
+	if status >= 400 {
+		j.failed = true
+		j.Header().Del("Content-Length")
+		j.Header().Del("Content-Disposition")
+		writeJsonError(j.ResponseWriter, status, errors.New(http.StatusText(status)))
+		return
+	}
+	j.ResponseWriter.WriteHeader(status)
+}
+
+func (j *jsonErrorWriter) Write(b []byte) (int, error) {
+	if j.failed {
+		return len(b), nil
+	}
+	return j.ResponseWriter.Write(b)
+}
+
+// A file, with the ranges and conditions that GET takes.
+func (h filesAPI) download(w http.ResponseWriter, r *http.Request) error {
+	name, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	if fi, err := h.Tenant.fsys.Stat(r.Context(), name); err != nil {
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	} else if fi.IsDir() {
+		return davErrorf(http.StatusConflict, "%s is a directory", name)
+	}
+	get := davRequest(r, h.Tenant, "GET", name, nil, 0)
+	for _, k := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if v := r.Header.Get(k); v != "" {
+			get.Header.Set(k, v)
+		}
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	h.DAV.ServeHTTP(&jsonErrorWriter{ResponseWriter: w}, get)
+	return nil
+}
+
+/*
+  Put each file of a multipart form into the directory at path, as
+  a PUT of its own.  The files before one that fails stay put.
+*/
+func (h filesAPI) upload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	dir, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return davErrorf(http.StatusBadRequest, "%v", err)
+	}
+	uploaded := make([]FileEntry, 0)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return davErrorf(http.StatusBadRequest, "%v", err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		filename := part.FileName()
+		if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, "/\\") || strings.HasPrefix(filename, ".__") {
+			return davErrorf(http.StatusBadRequest, "bad file name %q", filename)
+		}
+		name := path.Join(dir, filename)
+		if err := mayWrite(ctx, h.Tenant.fsys, name); err != nil {
+			return err
+		}
+		put := davRequest(r, h.Tenant, "PUT", name, part, -1)
+		if ct := part.Header.Get("Content-Type"); ct != "" {
+			put.Header.Set("Content-Type", ct)
+		}
+		if err := h.do(put); err != nil {
+			return err
+		}
+		fi, err := h.Tenant.fsys.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+		uploaded = append(uploaded, h.entry(r, name, fi))
+	}
+	if len(uploaded) == 0 {
+		return davErrorf(http.StatusBadRequest, "no file in the form")
+	}
+	writeJson(w, http.StatusCreated, uploaded)
+	return nil
+}
+
+func (h filesAPI) mkdir(w http.ResponseWriter, r *http.Request) error {
+	name, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	if err := h.do(davRequest(r, h.Tenant, "MKCOL", name, nil, 0)); err != nil {
+		return err
+	}
+	return h.stat(w, r)
+}
+
+func (h filesAPI) copyMove(method string) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var req FileCopyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return davErrorf(http.StatusBadRequest, "%v", err)
+		}
+		from, err := h.name(r, req.From)
+		if err != nil {
+			return err
+		}
+		to, err := h.name(r, req.To)
+		if err != nil {
+			return err
+		}
+		if from == "/" || to == "/" {
+			return davErrorf(http.StatusForbidden, "cannot %s the root", strings.ToLower(method))
+		}
+		if method == "MOVE" {
+			if err := mayDelete(r.Context(), h.Tenant.fsys, from); err != nil {
+				return err
+			}
+		}
+		dav := davRequest(r, h.Tenant, method, from, nil, 0)
+		dav.Header.Set("Destination", (&url.URL{Path: h.Tenant.Prefix + to}).EscapedPath())
+		dav.Header.Set("Overwrite", "F")
+		if req.Overwrite {
+			dav.Header.Set("Overwrite", "T")
+		}
+		if err := h.do(dav); err != nil {
+			return err
+		}
+		fi, err := h.Tenant.fsys.Stat(r.Context(), to)
+		if err != nil {
+			return err
+		}
+		writeJson(w, http.StatusOK, h.entry(r, to, fi))
+		return nil
+	}
+}
+
+func (h filesAPI) delete(w http.ResponseWriter, r *http.Request) error {
+	name, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	if name == "/" {
+		return davErrorf(http.StatusForbidden, "cannot delete the root")
+	}
+	if err := mayDelete(r.Context(), h.Tenant.fsys, name); err != nil {
+		return err
+	}
+	if err := h.do(davRequest(r, h.Tenant, "DELETE", name, nil, 0)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Dead properties, named as {namespace}name, with inner xml as values.
+func (h filesAPI) props(w http.ResponseWriter, r *http.Request) error {
+	name, err := h.name(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case "GET":
+	case "PATCH":
+		var req FilePropsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return davErrorf(http.StatusBadRequest, "%v", err)
+		}
+		if err := propPatch(r, h.Tenant, h.DAV, name, req.Set, req.Remove); err != nil {
+			return err
+		}
+	default:
+		return davErrorf(http.StatusMethodNotAllowed, "%v", webdav.ErrUnsupportedMethod)
+	}
+	props, err := deadProps(r.Context(), h.Tenant.fsys, name)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, props)
+	return nil
+}
@@ -0,0 +1,194 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Content filters, selected by obligation, content type or both.
+  They are applied in this order, so redaction happens before
+  anything is added to the content.
+
+    Obligations = {"redact-columns": ["ssn", "salary"]}
+    Obligations = {"strip-metadata": true}
+    Obligations = {"must-watermark": true}
+
+  There is no filter for watermarking PDFs yet, so a PDF with
+  a must-watermark obligation is refused rather than served bare.
+*/
+var contentFilters = []webdav.FilterRule{
+	{Obligation: "redact-columns", ContentType: "text/csv", Get: true, Filter: csvRedactFilter{}},
+	{Obligation: "strip-metadata", ContentType: "image/jpeg", Get: true, Filter: jpegMetadataFilter{}},
+	{Obligation: "must-watermark", ContentType: "text/", Get: true, Filter: watermarkFilter{}},
+}
+
+/*
+  Rule to strip metadata from every jpeg that is uploaded,
+  so that things like gps locations are never stored.
+*/
+var stripMetadataOnPut = webdav.FilterRule{
+	ContentType: "image/jpeg", Put: true, Filter: jpegMetadataFilter{},
+}
+
+/*
+  Mark text files with who downloaded them, and when.
+*/
+type watermarkFilter struct{}
+
+func (f watermarkFilter) Filter(ctx context.Context, r *http.Request, ctype string, value interface{}, src io.Reader) (io.Reader, error) {
+	if required, _ := value.(bool); !required {
+		return src, nil
+	}
+	username, _ := ctx.Value("username").(string)
+	mark := fmt.Sprintf("Downloaded by %s at %s\n", username, time.Now().UTC().Format(time.RFC3339))
+	return io.MultiReader(strings.NewReader(mark), src), nil
+}
+
+/*
+  Replace the named columns of a csv file with REDACTED.
+  The first row is taken to be the column names.
+*/
+type csvRedactFilter struct{}
+
+func (f csvRedactFilter) Filter(ctx context.Context, r *http.Request, ctype string, value interface{}, src io.Reader) (io.Reader, error) {
+	names, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redact-columns obligation must be a list of column names")
+	}
+	redact := make(map[string]bool)
+	for _, n := range names {
+		if s, ok := n.(string); ok {
+			redact[s] = true
+		}
+	}
+	return webdav.PipeFilter(src, func(dst io.Writer, src io.Reader) error {
+		in := csv.NewReader(src)
+		in.FieldsPerRecord = -1
+		in.LazyQuotes = true
+		out := csv.NewWriter(dst)
+		header, err := in.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		columns := make([]int, 0)
+		for i, name := range header {
+			if redact[strings.TrimSpace(name)] {
+				columns = append(columns, i)
+			}
+		}
+		if err := out.Write(header); err != nil {
+			return err
+		}
+		for {
+			record, err := in.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for _, i := range columns {
+				if i < len(record) {
+					record[i] = "REDACTED"
+				}
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	}), nil
+}
+
+/*
+  Drop the segments of a jpeg that carry metadata (EXIF and XMP
+  in APP1, IPTC in APP13, comments), leaving the image intact.
+  Everything from the start of scan is copied as it is.
+*/
+type jpegMetadataFilter struct{}
+
+func (f jpegMetadataFilter) Filter(ctx context.Context, r *http.Request, ctype string, value interface{}, src io.Reader) (io.Reader, error) {
+	if required, ok := value.(bool); ok && !required {
+		return src, nil
+	}
+	return webdav.PipeFilter(src, stripJpegMetadata), nil
+}
+
+func stripJpegMetadata(dst io.Writer, src io.Reader) error {
+	in := bufio.NewReader(src)
+	var soi [2]byte
+	if _, err := io.ReadFull(in, soi[:]); err != nil {
+		return err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return fmt.Errorf("%w: not a jpeg", webdav.ErrScanRejected)
+	}
+	if _, err := dst.Write(soi[:]); err != nil {
+		return err
+	}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(in, marker[:]); err != nil {
+			return err
+		}
+		if marker[0] != 0xFF {
+			return fmt.Errorf("%w: bad jpeg marker", webdav.ErrScanRejected)
+		}
+		// Markers may be padded with any number of 0xFF
+		for marker[1] == 0xFF {
+			b, err := in.ReadByte()
+			if err != nil {
+				return err
+			}
+			marker[1] = b
+		}
+		switch {
+		case marker[1] == 0xD9:
+			_, err := dst.Write(marker[:])
+			return err
+		case marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7):
+			if _, err := dst.Write(marker[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(in, size[:]); err != nil {
+			return err
+		}
+		length := int64(size[0])<<8 | int64(size[1])
+		if length < 2 {
+			return fmt.Errorf("%w: bad jpeg segment", webdav.ErrScanRejected)
+		}
+		if marker[1] == 0xE1 || marker[1] == 0xED || marker[1] == 0xFE {
+			if _, err := io.CopyN(ioutil.Discard, in, length-2); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := dst.Write(append(marker[:], size[:]...)); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, in, length-2); err != nil {
+			return err
+		}
+		if marker[1] == 0xDA {
+			_, err := io.Copy(dst, in)
+			return err
+		}
+	}
+}
@@ -0,0 +1,1076 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  An FTP server over the volume, for scanners, PLCs and other devices
+  that cannot do anything else.  Users log in with the password they
+  would give over WebDAV, and files are read and written through the
+  WebDAV handler, so that they are allowed by the same policy, and
+  changes are locked, journaled and audited the same way.
+
+    go run server.go -ftp 2121 -ftppasv 30000-30099
+
+  It does passive and active transfers, and FTPS with AUTH TLS when
+  there is a cert.pem and key.pem.  Active transfers only connect
+  back to the client, so the server cannot be bounced off.  Uploads
+  cannot be resumed or appended to, and nothing that a policy asks a
+  second factor for can be reached, as FTP has no way to give one.
+*/
+type ftpServer struct {
+	Tenants []*Tenant
+	// AUTH TLS is offered with a config, and required with RequireTLS
+	TLS        *tls.Config
+	RequireTLS bool
+	// The ports to take passive transfers on, or any if zero
+	PassiveMin, PassiveMax int
+	// The address to give for passive transfers, if not the server's own
+	PublicIP net.IP
+	Network  *networkPolicy
+}
+
+const (
+	// how long a connection may sit idle
+	ftpIdleTimeout = 5 * time.Minute
+	// how long to wait for the client to open or take a data connection
+	ftpDataTimeout = 30 * time.Second
+	// how many times a password may be wrong before the connection is closed
+	ftpMaxFailures = 3
+	ftpMaxLine     = 4096
+)
+
+func newFTPServer(tenants []*Tenant, passive, host string, requireTLS bool) (*ftpServer, error) {
+	srv := &ftpServer{Tenants: tenants, RequireTLS: requireTLS}
+	var err error
+	srv.PassiveMin, srv.PassiveMax, err = parsePortRange(passive)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		if srv.PublicIP = net.ParseIP(host); srv.PublicIP == nil {
+			return nil, fmt.Errorf("bad address %q", host)
+		}
+	}
+	// FTPS with the same cert as -s, if there is one
+	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
+	switch {
+	case err == nil:
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	case requireTLS:
+		return nil, err
+	}
+	return srv, nil
+}
+
+func listenFTP(ln net.Listener, srv *ftpServer) {
+	webdav.Log().Info("starting ftp server", "addr", ln.Addr(), "tls", srv.TLS != nil)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			webdav.Log().Warn("cannot accept ftp connection", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go srv.serve(conn)
+	}
+}
+
+// The range of ports for passive transfers, as 30000-30099.
+func parsePortRange(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.Atoi(parts[0])
+	max := min
+	if err == nil && len(parts) == 2 {
+		max, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || min < 1024 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("bad port range %q", s)
+	}
+	return min, max, nil
+}
+
+/*
+  ftpSession is one control connection.  Commands are handled one at
+  a time, so a transfer is over before the next command is read.
+*/
+type ftpSession struct {
+	srv    *ftpServer
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	remote net.IP
+	tls    bool
+	// whether transfers are over TLS, as PROT P asks
+	private bool
+	// who is logging in, and who did
+	user     string
+	ctx      context.Context
+	tenant   *Tenant
+	failures int
+	// when the claims in ctx were got
+	claimsAt time.Time
+	cwd      string
+	// where the next RETR starts, as REST says
+	offset     int64
+	renameFrom string
+	// where the next transfer goes: a passive listener, or an address to connect to
+	pasv   net.Listener
+	active string
+}
+
+func (srv *ftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s := &ftpSession{
+		srv:    srv,
+		conn:   conn,
+		r:      bufio.NewReaderSize(conn, ftpMaxLine),
+		w:      bufio.NewWriter(conn),
+		remote: conn.RemoteAddr().(*net.TCPAddr).IP,
+		cwd:    "/",
+	}
+	defer s.closeData()
+	ctx := context.Background()
+	if np := srv.Network; np != nil {
+		if !np.allowed(s.remote) {
+			webdav.Log().Warn("refused by network policy", "ip", s.remote, "remote", conn.RemoteAddr())
+			audit(ctx, AuditRecord{Action: "denied", Target: "ftp", Error: fmt.Sprintf("network policy refused %s", s.remote)})
+			s.reply(421, "Not allowed from here.")
+			return
+		}
+		n := &NetworkInput{IP: s.remote.String(), Zone: np.zoneOf(s.remote)}
+		if err := enrichGeo(np.geo, s.remote, n); err != nil {
+			webdav.Log().Warn("cannot look up client", "ip", s.remote, "err", err)
+		}
+		ctx = context.WithValue(ctx, "network", n)
+	}
+	s.ctx = ctx
+	s.reply(220, "WebDev FTP ready.")
+	for {
+		conn.SetReadDeadline(time.Now().Add(ftpIdleTimeout))
+		line, err := s.readLine()
+		if err != nil {
+			if err != io.EOF && !strings.Contains(err.Error(), "closed") {
+				s.reply(421, "Timeout.")
+			}
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		cmd = strings.ToUpper(cmd)
+		if username, _ := s.ctx.Value("username").(string); username != "" && claimsForgottenSince(username, s.claimsAt) {
+			// the claims changed since the login, so get them again for each command
+			s.ctx = context.WithValue(s.ctx, "claims", nil)
+			s.claimsAt = time.Now()
+		}
+		if !s.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+func (s *ftpSession) readLine() (string, error) {
+	line, err := s.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (s *ftpSession) reply(code int, format string, args ...interface{}) {
+	lines := strings.Split(fmt.Sprintf(format, args...), "\n")
+	for i, line := range lines {
+		if i < len(lines)-1 {
+			fmt.Fprintf(s.w, "%d-%s\r\n", code, line)
+		} else {
+			fmt.Fprintf(s.w, "%d %s\r\n", code, line)
+		}
+	}
+	s.w.Flush()
+}
+
+type ftpCommand struct {
+	login bool
+	run   func(s *ftpSession, arg string) bool
+}
+
+var ftpCommands map[string]ftpCommand
+
+func init() {
+	ftpCommands = map[string]ftpCommand{
+		"USER": {false, (*ftpSession).userCmd},
+		"PASS": {false, (*ftpSession).pass},
+		"AUTH": {false, (*ftpSession).auth},
+		"PBSZ": {false, (*ftpSession).pbsz},
+		"PROT": {false, (*ftpSession).prot},
+		"FEAT": {false, (*ftpSession).feat},
+		"SYST": {false, func(s *ftpSession, arg string) bool { s.reply(215, "UNIX Type: L8"); return true }},
+		"NOOP": {false, func(s *ftpSession, arg string) bool { s.reply(200, "OK."); return true }},
+		"OPTS": {false, (*ftpSession).opts},
+		"QUIT": {false, func(s *ftpSession, arg string) bool { s.reply(221, "Goodbye."); return false }},
+		"TYPE": {true, (*ftpSession).typeCmd},
+		"MODE": {true, func(s *ftpSession, arg string) bool { return s.only(arg, "S") }},
+		"STRU": {true, func(s *ftpSession, arg string) bool { return s.only(arg, "F") }},
+		"ALLO": {true, func(s *ftpSession, arg string) bool { s.reply(202, "No storage allocation necessary."); return true }},
+		"PWD":  {true, (*ftpSession).pwd},
+		"XPWD": {true, (*ftpSession).pwd},
+		"CWD":  {true, (*ftpSession).cwdCmd},
+		"XCWD": {true, (*ftpSession).cwdCmd},
+		"CDUP": {true, func(s *ftpSession, arg string) bool { return s.cwdCmd("..") }},
+		"XCUP": {true, func(s *ftpSession, arg string) bool { return s.cwdCmd("..") }},
+		"PASV": {true, (*ftpSession).pasvCmd},
+		"EPSV": {true, (*ftpSession).epsv},
+		"PORT": {true, (*ftpSession).port},
+		"EPRT": {true, (*ftpSession).eprt},
+		"LIST": {true, (*ftpSession).list},
+		"NLST": {true, (*ftpSession).nlst},
+		"MLSD": {true, (*ftpSession).mlsd},
+		"MLST": {true, (*ftpSession).mlst},
+		"SIZE": {true, (*ftpSession).size},
+		"MDTM": {true, (*ftpSession).mdtm},
+		"REST": {true, (*ftpSession).rest},
+		"RETR": {true, (*ftpSession).retr},
+		"STOR": {true, (*ftpSession).stor},
+		"DELE": {true, (*ftpSession).dele},
+		"MKD":  {true, (*ftpSession).mkd},
+		"XMKD": {true, (*ftpSession).mkd},
+		"RMD":  {true, (*ftpSession).rmd},
+		"XRMD": {true, (*ftpSession).rmd},
+		"RNFR": {true, (*ftpSession).rnfr},
+		"RNTO": {true, (*ftpSession).rnto},
+		"ABOR": {true, func(s *ftpSession, arg string) bool { s.reply(226, "No transfer to abort."); return true }},
+	}
+}
+
+// Handle a command, saying whether the session goes on.
+func (s *ftpSession) handle(cmd, arg string) bool {
+	c, ok := ftpCommands[cmd]
+	switch {
+	case !ok:
+		s.reply(502, "%s is not implemented.", cmd)
+		return true
+	case c.login && s.tenant == nil:
+		s.reply(530, "Please log in with USER and PASS.")
+		return true
+	}
+	// RNFR and REST are for the command right after them
+	if cmd != "RNTO" {
+		s.renameFrom = ""
+	}
+	if cmd != "RETR" {
+		s.offset = 0
+	}
+	return c.run(s, arg)
+}
+
+func (s *ftpSession) only(arg, supported string) bool {
+	if strings.EqualFold(arg, supported) {
+		s.reply(200, "OK.")
+	} else {
+		s.reply(504, "Only %s is supported.", supported)
+	}
+	return true
+}
+
+func (s *ftpSession) feat(arg string) bool {
+	features := []string{"EPRT", "EPSV", "MDTM", "MLST type*;size*;modify*;perm*;", "PASV", "REST STREAM", "SIZE", "UTF8"}
+	if s.srv.TLS != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+	s.reply(211, "Features:\n %s\nEnd", strings.Join(features, "\n "))
+	return true
+}
+
+func (s *ftpSession) opts(arg string) bool {
+	if strings.EqualFold(arg, "UTF8 ON") {
+		s.reply(200, "Always in UTF8 mode.")
+	} else {
+		s.reply(501, "Option not understood.")
+	}
+	return true
+}
+
+func (s *ftpSession) typeCmd(arg string) bool {
+	// everything is sent as it is, which is what ASCII clients get from most servers
+	switch strings.ToUpper(arg) {
+	case "A", "A N", "I", "L 8":
+		s.reply(200, "Type set to %s.", arg)
+	default:
+		s.reply(504, "Type %s is not supported.", arg)
+	}
+	return true
+}
+
+func (s *ftpSession) auth(arg string) bool {
+	if s.srv.TLS == nil {
+		s.reply(502, "TLS is not set up.")
+		return true
+	}
+	if s.tls {
+		s.reply(503, "Already using TLS.")
+		return true
+	}
+	if a := strings.ToUpper(arg); a != "TLS" && a != "TLS-C" && a != "SSL" {
+		s.reply(504, "Only AUTH TLS is supported.")
+		return true
+	}
+	s.reply(234, "Go ahead with TLS.")
+	conn := tls.Server(s.conn, s.srv.TLS)
+	conn.SetDeadline(time.Now().Add(ftpDataTimeout))
+	if err := conn.Handshake(); err != nil {
+		webdav.Log().Warn("ftp tls handshake failed", "remote", s.conn.RemoteAddr(), "err", err)
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+	s.conn = conn
+	s.r = bufio.NewReaderSize(conn, ftpMaxLine)
+	s.w = bufio.NewWriter(conn)
+	s.tls = true
+	return true
+}
+
+func (s *ftpSession) pbsz(arg string) bool {
+	if !s.tls {
+		s.reply(503, "AUTH TLS first.")
+	} else {
+		s.reply(200, "PBSZ=0")
+	}
+	return true
+}
+
+func (s *ftpSession) prot(arg string) bool {
+	switch {
+	case !s.tls:
+		s.reply(503, "AUTH TLS first.")
+	case strings.EqualFold(arg, "P"):
+		s.private = true
+		s.reply(200, "Transfers are private.")
+	case strings.EqualFold(arg, "C") && !s.srv.RequireTLS:
+		s.private = false
+		s.reply(200, "Transfers are clear.")
+	default:
+		s.reply(536, "PROT %s is not supported.", arg)
+	}
+	return true
+}
+
+func (s *ftpSession) userCmd(arg string) bool {
+	if s.srv.RequireTLS && !s.tls {
+		s.reply(530, "AUTH TLS first.")
+		return true
+	}
+	if s.tenant != nil {
+		s.reply(530, "Already logged in.")
+		return true
+	}
+	s.user = arg
+	s.reply(331, "Password please.")
+	return true
+}
+
+/*
+  Check the password as WebDAV would.  With a tenants file, users log
+  in as user@tenant, since FTP has no host to tell tenants apart by.
+*/
+func (s *ftpSession) pass(arg string) bool {
+	if s.user == "" || s.tenant != nil {
+		s.reply(503, "USER first.")
+		return true
+	}
+	username, t := s.user, s.srv.tenantFor(s.user)
+	if t != nil && t != defaultTenant {
+		username = username[:strings.LastIndexByte(username, '@')]
+	}
+	ctx := s.ctx
+	var claims Claims
+	err := fmt.Errorf("no such tenant")
+	if t != nil {
+		claims, err = authenticator.Verify(context.WithValue(ctx, "tenant", t), username, arg)
+	}
+	if err != nil {
+		audit(ctx, AuditRecord{User: username, Action: "auth failed", Target: "ftp", Error: err.Error()})
+		s.failures++
+		s.user = ""
+		// slow down guessing
+		time.Sleep(time.Second)
+		s.reply(530, "Login incorrect.")
+		return s.failures < ftpMaxFailures
+	}
+	ctx = context.WithValue(ctx, "tenant", t)
+	onboard(ctx, username, claims)
+	ctx = context.WithValue(ctx, "username", username)
+	if claims.Groups != nil {
+		ctx = context.WithValue(ctx, "claims", claims)
+	}
+	ctx = context.WithValue(ctx, "password", arg)
+	s.ctx, s.tenant, s.user, s.claimsAt = ctx, t, username, time.Now()
+	webdav.Log().Info("ftp login", "user", username, "tenant", t.Name, "remote", s.conn.RemoteAddr(), "tls", s.tls)
+	s.reply(230, "Logged in.")
+	return true
+}
+
+func (srv *ftpServer) tenantFor(user string) *Tenant {
+	if len(srv.Tenants) == 1 && srv.Tenants[0] == defaultTenant {
+		return defaultTenant
+	}
+	i := strings.LastIndexByte(user, '@')
+	if i < 0 {
+		return nil
+	}
+	for _, t := range srv.Tenants {
+		if t.Name == user[i+1:] {
+			return t
+		}
+	}
+	return nil
+}
+
+// The name that arg is, from the working directory.
+func (s *ftpSession) name(arg string) (string, bool) {
+	name := webdav.SlashClean(path.Join(s.cwd, arg))
+	if strings.HasPrefix(arg, "/") {
+		name = webdav.SlashClean(arg)
+	}
+	if isMetadataPath(name) || (!mfaOf(s.ctx) && requiresMFA(s.ctx, s.tenant.fsys, name)) {
+		s.reply(550, "%s: No such file or directory.", arg)
+		return "", false
+	}
+	return name, true
+}
+
+// What is at arg, if the user can see it.
+func (s *ftpSession) stat(arg string) (string, os.FileInfo, bool) {
+	name, ok := s.name(arg)
+	if !ok {
+		return "", nil, false
+	}
+	fi, err := s.tenant.fsys.Stat(s.ctx, name)
+	if err != nil {
+		s.reply(550, "%s: No such file or directory.", arg)
+		return "", nil, false
+	}
+	return name, fi, true
+}
+
+/*
+  A WebDAV request for name, on behalf of the user, as if it had come
+  over HTTP from the same client.
+*/
+func (s *ftpSession) davRequest(method, name string, body io.Reader, size int64) *http.Request {
+	r, _ := http.NewRequestWithContext(s.ctx, method, "/", nil)
+	r.RemoteAddr = s.conn.RemoteAddr().String()
+	r.Header.Set("User-Agent", "ftp")
+	return davRequest(r, s.tenant, method, name, body, size)
+}
+
+func (s *ftpSession) dav(req *http.Request, w http.ResponseWriter) {
+	serveLimited(w, req.WithContext(withMemo(req.Context())), webdav.WithRequestID(s.tenant.dav))
+}
+
+// The reply for a WebDAV status that is not a success.
+func (s *ftpSession) failed(status int, what string) {
+	switch status {
+	case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed:
+		s.reply(550, "%s: Permission denied.", what)
+	case http.StatusInsufficientStorage, http.StatusRequestEntityTooLarge:
+		s.reply(552, "%s: Exceeded storage allocation.", what)
+	case http.StatusLocked:
+		s.reply(450, "%s: File is locked.", what)
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		s.reply(553, "%s: Not allowed here.", what)
+	default:
+		s.reply(451, "%s: %s.", what, http.StatusText(status))
+	}
+}
+
+func (s *ftpSession) pwd(arg string) bool {
+	s.reply(257, "%q is the current directory.", s.cwd)
+	return true
+}
+
+func (s *ftpSession) cwdCmd(arg string) bool {
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	if !fi.IsDir() {
+		s.reply(550, "%s: Not a directory.", arg)
+		return true
+	}
+	s.cwd = name
+	s.reply(250, "Directory is now %s.", name)
+	return true
+}
+
+func (s *ftpSession) closeData() {
+	if s.pasv != nil {
+		s.pasv.Close()
+		s.pasv = nil
+	}
+	s.active = ""
+}
+
+// Listen for a passive transfer, on one of the ports that are allowed.
+func (s *ftpSession) listenPassive() (net.Listener, error) {
+	s.closeData()
+	host := s.conn.LocalAddr().(*net.TCPAddr).IP
+	if s.srv.PassiveMin == 0 {
+		return net.Listen("tcp", net.JoinHostPort(host.String(), "0"))
+	}
+	for port := s.srv.PassiveMin; port <= s.srv.PassiveMax; port++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host.String(), strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("no passive ports are free")
+}
+
+func (s *ftpSession) pasvCmd(arg string) bool {
+	ip := s.srv.PublicIP
+	if ip == nil {
+		ip = s.conn.LocalAddr().(*net.TCPAddr).IP
+	}
+	if ip.To4() == nil {
+		s.reply(425, "Use EPSV with IPv6.")
+		return true
+	}
+	ln, err := s.listenPassive()
+	if err != nil {
+		s.reply(425, "Cannot open a data connection: %v", err)
+		return true
+	}
+	s.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	ip4 := ip.To4()
+	s.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d).", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff)
+	return true
+}
+
+func (s *ftpSession) epsv(arg string) bool {
+	if strings.EqualFold(arg, "ALL") {
+		s.reply(200, "EPSV ALL ok.")
+		return true
+	}
+	ln, err := s.listenPassive()
+	if err != nil {
+		s.reply(425, "Cannot open a data connection: %v", err)
+		return true
+	}
+	s.pasv = ln
+	s.reply(229, "Entering Extended Passive Mode (|||%d|).", ln.Addr().(*net.TCPAddr).Port)
+	return true
+}
+
+/*
+  Connect back for an active transfer, but only to where the client
+  is, and not to a privileged port, so that nobody can have the
+  server connect to anything else for them.
+*/
+func (s *ftpSession) activeTo(ip net.IP, port int) bool {
+	s.closeData()
+	if ip == nil || !ip.Equal(s.remote) || port < 1024 || port > 65535 {
+		s.reply(501, "Transfers can only be to your own address, on an unprivileged port.")
+		return true
+	}
+	s.active = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	s.reply(200, "OK.")
+	return true
+}
+
+func (s *ftpSession) port(arg string) bool {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		s.reply(501, "Bad PORT.")
+		return true
+	}
+	n := make([]int, 6)
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || v < 0 || v > 255 {
+			s.reply(501, "Bad PORT.")
+			return true
+		}
+		n[i] = v
+	}
+	return s.activeTo(net.IPv4(byte(n[0]), byte(n[1]), byte(n[2]), byte(n[3])), n[4]<<8|n[5])
+}
+
+func (s *ftpSession) eprt(arg string) bool {
+	if len(arg) < 2 {
+		s.reply(501, "Bad EPRT.")
+		return true
+	}
+	parts := strings.Split(arg, arg[:1])
+	if len(parts) != 5 {
+		s.reply(501, "Bad EPRT.")
+		return true
+	}
+	port, err := strconv.Atoi(parts[3])
+	if err != nil {
+		s.reply(501, "Bad EPRT.")
+		return true
+	}
+	return s.activeTo(net.ParseIP(parts[2]), port)
+}
+
+// Open the data connection that PASV, EPSV, PORT or EPRT set up.
+func (s *ftpSession) dataConn() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case s.pasv != nil:
+		ln := s.pasv.(*net.TCPListener)
+		ln.SetDeadline(time.Now().Add(ftpDataTimeout))
+		for {
+			if conn, err = ln.Accept(); err != nil {
+				break
+			}
+			// only the client may take its transfer
+			if conn.RemoteAddr().(*net.TCPAddr).IP.Equal(s.remote) {
+				break
+			}
+			conn.Close()
+		}
+	case s.active != "":
+		conn, err = net.DialTimeout("tcp", s.active, ftpDataTimeout)
+	default:
+		err = fmt.Errorf("use PASV or PORT first")
+	}
+	s.closeData()
+	if err != nil {
+		return nil, err
+	}
+	if s.private {
+		tconn := tls.Server(conn, s.srv.TLS)
+		tconn.SetDeadline(time.Now().Add(ftpDataTimeout))
+		if err := tconn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tconn.SetDeadline(time.Time{})
+		conn = tconn
+	}
+	return conn, nil
+}
+
+/*
+  Open the data connection for a transfer, run it, and say how it
+  went.  send has the connection, and says what went wrong, if
+  anything did, as an FTP reply.
+*/
+func (s *ftpSession) transfer(what string, send func(conn net.Conn) (int, string)) {
+	if s.pasv == nil && s.active == "" {
+		s.reply(425, "Use PASV or PORT first.")
+		return
+	}
+	if s.srv.RequireTLS && !s.private {
+		s.reply(521, "PROT P first.")
+		return
+	}
+	s.reply(150, "Opening data connection for %s.", what)
+	conn, err := s.dataConn()
+	if err != nil {
+		s.reply(425, "Cannot open data connection: %v", err)
+		return
+	}
+	code, msg := send(conn)
+	if err := conn.Close(); err != nil && code == 0 {
+		code, msg = 426, "Connection closed; transfer aborted."
+	}
+	if code == 0 {
+		code, msg = 226, "Transfer complete."
+	}
+	s.reply(code, "%s", msg)
+}
+
+// The entries that a listing of arg has, and where, ignoring ls options.
+func (s *ftpSession) listing(arg string) (string, []os.FileInfo, bool) {
+	for strings.HasPrefix(arg, "-") {
+		rest := ""
+		if i := strings.IndexByte(arg, ' '); i >= 0 {
+			rest = strings.TrimSpace(arg[i:])
+		}
+		arg = rest
+	}
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return "", nil, false
+	}
+	if !fi.IsDir() {
+		return path.Dir(name), []os.FileInfo{fi}, true
+	}
+	infos, err := visibleChildren(s.ctx, s.tenant.fsys, name)
+	if err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return "", nil, false
+	}
+	return name, infos, true
+}
+
+func (s *ftpSession) list(arg string) bool {
+	_, infos, ok := s.listing(arg)
+	if !ok {
+		return true
+	}
+	s.transfer("the listing", func(conn net.Conn) (int, string) {
+		w := bufio.NewWriter(conn)
+		now := time.Now()
+		for _, fi := range infos {
+			mode := "-rw-r--r--"
+			if fi.IsDir() {
+				mode = "drwxr-xr-x"
+			}
+			when := fi.ModTime().Format("Jan _2 15:04")
+			if fi.ModTime().Before(now.AddDate(0, -6, 0)) || fi.ModTime().After(now) {
+				when = fi.ModTime().Format("Jan _2  2006")
+			}
+			fmt.Fprintf(w, "%s 1 owner group %12d %s %s\r\n", mode, fi.Size(), when, fi.Name())
+		}
+		if err := w.Flush(); err != nil {
+			return 426, "Transfer aborted."
+		}
+		return 0, ""
+	})
+	return true
+}
+
+func (s *ftpSession) nlst(arg string) bool {
+	_, infos, ok := s.listing(arg)
+	if !ok {
+		return true
+	}
+	s.transfer("the listing", func(conn net.Conn) (int, string) {
+		w := bufio.NewWriter(conn)
+		for _, fi := range infos {
+			fmt.Fprintf(w, "%s\r\n", fi.Name())
+		}
+		if err := w.Flush(); err != nil {
+			return 426, "Transfer aborted."
+		}
+		return 0, ""
+	})
+	return true
+}
+
+// The facts of MLSD and MLST, from RFC 3659.
+func (s *ftpSession) facts(name string, fi os.FileInfo) string {
+	typ := "file"
+	if fi.IsDir() {
+		typ = "dir"
+	}
+	perm := ""
+	if c, err := s.tenant.fsys.Capabilities(s.ctx, name); err == nil {
+		switch {
+		case fi.IsDir():
+			if c.Read {
+				perm += "el"
+			}
+			if c.Create {
+				perm += "cm"
+			}
+		case c.Read:
+			perm += "r"
+		}
+		if c.Write && !fi.IsDir() {
+			perm += "w"
+		}
+		if c.Delete {
+			perm += "df"
+		}
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;perm=%s; %s", typ, fi.Size(), fi.ModTime().UTC().Format("20060102150405"), perm, fi.Name())
+}
+
+func (s *ftpSession) mlsd(arg string) bool {
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	if !fi.IsDir() {
+		s.reply(501, "%s: Not a directory.", arg)
+		return true
+	}
+	infos, err := visibleChildren(s.ctx, s.tenant.fsys, name)
+	if err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	s.transfer("the listing", func(conn net.Conn) (int, string) {
+		w := bufio.NewWriter(conn)
+		for _, fi := range infos {
+			fmt.Fprintf(w, "%s\r\n", s.facts(path.Join(name, fi.Name()), fi))
+		}
+		if err := w.Flush(); err != nil {
+			return 426, "Transfer aborted."
+		}
+		return 0, ""
+	})
+	return true
+}
+
+func (s *ftpSession) mlst(arg string) bool {
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	s.reply(250, "Listing %s\n %s\nEnd", name, s.facts(name, fi))
+	return true
+}
+
+func (s *ftpSession) size(arg string) bool {
+	if _, fi, ok := s.stat(arg); ok {
+		if fi.IsDir() {
+			s.reply(550, "%s: Not a file.", arg)
+		} else {
+			s.reply(213, "%d", fi.Size())
+		}
+	}
+	return true
+}
+
+func (s *ftpSession) mdtm(arg string) bool {
+	if _, fi, ok := s.stat(arg); ok {
+		s.reply(213, "%s", fi.ModTime().UTC().Format("20060102150405"))
+	}
+	return true
+}
+
+func (s *ftpSession) rest(arg string) bool {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		s.reply(501, "Bad offset.")
+		return true
+	}
+	s.offset = offset
+	s.reply(350, "Restarting at %d.", offset)
+	return true
+}
+
+// ftpWriter sends what a WebDAV GET writes to the data connection.
+type ftpWriter struct {
+	conn   net.Conn
+	header http.Header
+	status int
+	err    error
+}
+
+func (fw *ftpWriter) Header() http.Header {
+	return fw.header
+}
+
+func (fw *ftpWriter) WriteHeader(status int) {
+	if fw.status == 0 {
+		fw.status = status
+	}
+}
+
+func (fw *ftpWriter) Write(b []byte) (int, error) {
+	fw.WriteHeader(http.StatusOK)
+	if fw.status >= 300 {
+		return len(b), nil
+	}
+	n, err := fw.conn.Write(b)
+	if err != nil {
+		fw.err = err
+	}
+	return n, err
+}
+
+func (s *ftpSession) retr(arg string) bool {
+	offset := s.offset
+	s.offset = 0
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	if fi.IsDir() {
+		s.reply(550, "%s: Not a file.", arg)
+		return true
+	}
+	if c, err := s.tenant.fsys.Capabilities(s.ctx, name); err != nil || !c.Read {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	get := s.davRequest("GET", name, nil, 0)
+	if offset > 0 {
+		if offset >= fi.Size() {
+			s.reply(554, "Offset %d is past the end.", offset)
+			return true
+		}
+		get.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	s.transfer(name, func(conn net.Conn) (int, string) {
+		fw := &ftpWriter{conn: conn, header: make(http.Header)}
+		s.dav(get, fw)
+		switch {
+		case fw.err != nil:
+			return 426, "Transfer aborted."
+		case fw.status >= 300:
+			return 550, fmt.Sprintf("%s: %s.", arg, http.StatusText(fw.status))
+		}
+		return 0, ""
+	})
+	return true
+}
+
+func (s *ftpSession) stor(arg string) bool {
+	name, ok := s.name(arg)
+	if !ok {
+		return true
+	}
+	if err := mayWrite(s.ctx, s.tenant.fsys, name); err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	s.transfer(name, func(conn net.Conn) (int, string) {
+		put := s.davRequest("PUT", name, conn, -1)
+		res := &statusRecorder{header: make(http.Header)}
+		s.dav(put, res)
+		if res.status >= 300 {
+			s.failed(res.status, arg)
+			// the reply is given, so the transfer has none of its own
+			return -1, ""
+		}
+		return 0, ""
+	})
+	return true
+}
+
+func (s *ftpSession) dele(arg string) bool {
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	if fi.IsDir() {
+		s.reply(550, "%s: Is a directory.", arg)
+		return true
+	}
+	if err := mayDelete(s.ctx, s.tenant.fsys, name); err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	if s.do(s.davRequest("DELETE", name, nil, 0), arg) {
+		s.reply(250, "Deleted %s.", name)
+	}
+	return true
+}
+
+// Make a WebDAV request, replying if it fails, and saying whether it did not.
+func (s *ftpSession) do(req *http.Request, what string) bool {
+	res := &statusRecorder{header: make(http.Header)}
+	s.dav(req, res)
+	if res.status >= 300 {
+		s.failed(res.status, what)
+		return false
+	}
+	return true
+}
+
+func (s *ftpSession) mkd(arg string) bool {
+	name, ok := s.name(arg)
+	if !ok {
+		return true
+	}
+	// WebDAV refuses to make what is there already as it refuses what may not be done
+	if _, err := s.tenant.fsys.Stat(s.ctx, name); err == nil {
+		s.reply(550, "%s: File exists.", arg)
+		return true
+	}
+	if s.do(s.davRequest("MKCOL", name, nil, 0), arg) {
+		s.reply(257, "%q created.", name)
+	}
+	return true
+}
+
+/*
+  Remove a directory, if it is empty.  WebDAV would remove what is in
+  it too, even what the user cannot see, so it has to have nothing
+  in it but metadata.
+*/
+func (s *ftpSession) rmd(arg string) bool {
+	name, fi, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	if !fi.IsDir() || name == "/" {
+		s.reply(550, "%s: Not a directory that can be removed.", arg)
+		return true
+	}
+	entries, err := os.ReadDir(s.tenant.fsys.Resolve(name))
+	if err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".__") {
+			s.reply(550, "%s: Directory not empty.", arg)
+			return true
+		}
+	}
+	if err := mayDelete(s.ctx, s.tenant.fsys, name); err != nil {
+		s.reply(550, "%s: Permission denied.", arg)
+		return true
+	}
+	if s.do(s.davRequest("DELETE", name, nil, 0), arg) {
+		s.reply(250, "Removed %s.", name)
+	}
+	return true
+}
+
+func (s *ftpSession) rnfr(arg string) bool {
+	name, _, ok := s.stat(arg)
+	if !ok {
+		return true
+	}
+	s.renameFrom = name
+	s.reply(350, "Ready for RNTO.")
+	return true
+}
+
+func (s *ftpSession) rnto(arg string) bool {
+	from := s.renameFrom
+	s.renameFrom = ""
+	if from == "" {
+		s.reply(503, "RNFR first.")
+		return true
+	}
+	to, ok := s.name(arg)
+	if !ok {
+		return true
+	}
+	if err := mayDelete(s.ctx, s.tenant.fsys, from); err != nil {
+		s.reply(550, "%s: Permission denied.", from)
+		return true
+	}
+	move := s.davRequest("MOVE", from, nil, 0)
+	move.Header.Set("Destination", (&url.URL{Path: s.tenant.Prefix + to}).EscapedPath())
+	move.Header.Set("Overwrite", "F")
+	if s.do(move, arg) {
+		s.reply(250, "Renamed to %s.", to)
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package example1
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  What an erasure removed.
+*/
+type ErasureReport struct {
+	User          string    `json:"user"`
+	Time          time.Time `json:"time"`
+	HomeRemoved   bool      `json:"home_removed"`
+	SharesRevoked int       `json:"shares_revoked"`
+	// What was deleted from their home, and is still in the trash
+	TrashPurged int `json:"trash_purged,omitempty"`
+	// Changes in the journal that no longer name the user
+	ChangesScrubbed int `json:"changes_scrubbed"`
+}
+
+/*
+  Everything kept about a user, for GDPR requests.  Their home
+  directory holds their files, claims, favorites, tags and
+  comments.  Export and erasure are admin only, and audited.
+
+    GET    /.__api/gdpr?user=jp              a zip of everything about jp
+    DELETE /.__api/gdpr?user=jp&confirm=jp   erase jp
+
+  The export has home/ with the home directory, and shares.json
+  and audit.json with jp's share links and audit records.  An
+  erasure must be confirmed by naming the user again.  It removes
+  the home directory and recent activity, takes the name of the
+  user out of the change journal, and revokes the share links of
+  the user.  Audit records are kept, as the trail of what
+  was done, including the erasure itself.
+*/
+func gdprHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		actor, _ := ctx.Value("username").(string)
+		user := r.URL.Query().Get("user")
+		if err := validUsername(user); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		t := tenantOf(ctx)
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", user+"-export.zip"))
+			err := exportUser(w, t, fsys.Root, user)
+			rec := AuditRecord{User: actor, Action: "gdpr.export", Target: user}
+			if err != nil {
+				// the zip has begun, so all that can be done is to stop
+				webdav.Log().Error("cannot export user", "request_id", webdav.RequestID(ctx), "user", user, "err", err)
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+		case "DELETE":
+			if r.URL.Query().Get("confirm") != user {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("confirm the erasure with confirm=%s", user))
+				return
+			}
+			report, err := eraseUser(t, fsys.Root, user)
+			rec := AuditRecord{User: actor, Action: "gdpr.erase", Target: user, After: json.RawMessage(AsJson(report))}
+			if err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, rec)
+			writeJson(w, http.StatusOK, report)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+func sharesOf(t *Tenant, user string) []Share {
+	owned := make([]Share, 0)
+	if t.shares == nil {
+		return owned
+	}
+	for _, s := range t.shares.List() {
+		if s.Owner == user {
+			owned = append(owned, s)
+		}
+	}
+	return owned
+}
+
+func exportUser(w io.Writer, t *Tenant, root, user string) error {
+	z := zip.NewWriter(w)
+	home := filepath.Join(root, user)
+	err := filepath.Walk(home, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == home {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(home, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = "home/" + filepath.ToSlash(rel)
+		hdr.Method = zip.Deflate
+		zw, err := z.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(zw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	shares := make([]Share, 0)
+	for _, s := range sharesOf(t, user) {
+		shares = append(shares, s.public())
+	}
+	if err := zipJson(z, "shares.json", shares); err != nil {
+		return err
+	}
+	records := make([]AuditRecord, 0)
+	if reader := auditReader(); reader != nil {
+		records, err = reader.Records(t.Name, user, time.Time{}, time.Now().Add(time.Hour))
+		if err != nil {
+			return err
+		}
+	}
+	if err := zipJson(z, "audit.json", records); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func zipJson(z *zip.Writer, name string, v interface{}) error {
+	zw, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(zw, AsJson(v))
+	return err
+}
+
+func eraseUser(t *Tenant, root, user string) (ErasureReport, error) {
+	report := ErasureReport{User: user, Time: time.Now().UTC()}
+	for _, s := range sharesOf(t, user) {
+		if err := t.shares.Revoke(s.Token); err != nil {
+			return report, err
+		}
+		report.SharesRevoked++
+	}
+	if t.recent != nil {
+		t.recent.forget(user)
+	}
+	if t.journal != nil {
+		n, err := t.journal.forget(user)
+		if err != nil {
+			return report, err
+		}
+		report.ChangesScrubbed = n
+	}
+	home := filepath.Join(root, user)
+	if _, err := os.Stat(home); err == nil {
+		if err := os.RemoveAll(home); err != nil {
+			return report, err
+		}
+		report.HomeRemoved = true
+	}
+	n, err := t.fsys.PurgeTrash(context.Background(), "/"+user)
+	report.TrashPurged = n
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
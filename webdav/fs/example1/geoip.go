@@ -0,0 +1,295 @@
+package example1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+/*
+  A MaxMind database, such as GeoLite2-Country or GeoLite2-ASN,
+  read into memory.  Only what it takes to look up an address is
+  here: the search tree, and the data that it points into.  See
+  https://maxmind.github.io/MaxMind-DB/ for the format.
+*/
+type geoDB struct {
+	name       string
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// where the data section starts
+	dataStart uint
+	// the node that IPv4 addresses start from in an IPv6 tree
+	ipv4Start uint
+}
+
+var geoMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openGeoDB(file string) (*geoDB, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	at := bytes.LastIndex(data, geoMetadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind database", file)
+	}
+	metaStart := uint(at + len(geoMetadataMarker))
+	meta, _, err := (&geoDB{data: data, dataStart: metaStart}).decode(metaStart)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad metadata: %v", file, err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: bad metadata", file)
+	}
+	db := &geoDB{name: file, data: data}
+	db.nodeCount, _ = asUint(m["node_count"])
+	db.recordSize, _ = asUint(m["record_size"])
+	db.ipVersion, _ = asUint(m["ip_version"])
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unknown record size %d", file, db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	db.dataStart = treeSize + 16
+	if db.dataStart > uint(len(data)) {
+		return nil, fmt.Errorf("%s: truncated", file)
+	}
+	if db.ipVersion == 6 {
+		// IPv4 addresses are ::a.b.c.d, so walk the 96 zero bits once
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record is the left (0) or right (1) record of a node.
+func (db *geoDB) record(node uint, bit uint) uint {
+	b := db.data[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+/*
+  Look up an address, returning nil when the database has
+  nothing for it.
+*/
+func (db *geoDB) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	addr := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	v, _, err := db.decode(db.dataStart + node - db.nodeCount - 16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", db.name, err)
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+var errGeoCorrupt = fmt.Errorf("corrupt data section")
+
+/*
+  Decode the value at offset, returning it and the offset after
+  it.  Maps are map[string]interface{}, and numbers are uint64,
+  int64 or float64.
+*/
+func (db *geoDB) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(db.data)) {
+		return nil, 0, errGeoCorrupt
+	}
+	ctrl := db.data[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+	if kind == 1 {
+		return db.decodePointer(ctrl, offset)
+	}
+	if kind == 0 {
+		if offset >= uint(len(db.data)) {
+			return nil, 0, errGeoCorrupt
+		}
+		kind = 7 + uint(db.data[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(db.data)) {
+			return nil, 0, errGeoCorrupt
+		}
+		extra := uint(0)
+		for _, c := range db.data[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	switch kind {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errGeoCorrupt
+			}
+			v, next, err := db.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, held in the size
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(db.data)) {
+		return nil, 0, errGeoCorrupt
+	}
+	b := db.data[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2: // string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errGeoCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errGeoCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 4: // bytes
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9, 10: // unsigned, of up to 128 bits, kept to the low 64
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8: // int32
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+// A pointer is an offset into the data section, to a value kept once.
+func (db *geoDB) decodePointer(ctrl byte, offset uint) (interface{}, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(db.data)) {
+		return nil, 0, errGeoCorrupt
+	}
+	p := uint(0)
+	if n < 4 {
+		p = uint(ctrl & 0x7)
+	}
+	for _, c := range db.data[offset : offset+n] {
+		p = p<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	v, _, err := db.decode(db.dataStart + p)
+	return v, offset + n, err
+}
+
+func asUint(v interface{}) (uint, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n), true
+	case int64:
+		return uint(n), n >= 0
+	}
+	return 0, false
+}
+
+/*
+  Add the country and autonomous system of the client to what
+  the policy sees, from whichever databases know them.
+*/
+func enrichGeo(dbs []*geoDB, ip net.IP, n *NetworkInput) error {
+	for _, db := range dbs {
+		m, err := db.lookup(ip)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		if n.Country == "" {
+			for _, field := range []string{"country", "registered_country"} {
+				if c, ok := m[field].(map[string]interface{}); ok {
+					if code, ok := c["iso_code"].(string); ok {
+						n.Country = code
+						break
+					}
+				}
+			}
+		}
+		if asn, ok := asUint(m["autonomous_system_number"]); ok && n.ASN == 0 {
+			n.ASN = asn
+			n.ASOrg, _ = m["autonomous_system_organization"].(string)
+		}
+	}
+	return nil
+}
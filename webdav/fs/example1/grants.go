@@ -0,0 +1,225 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A Grant gives one user some permissions on a path and everything
+  under it, on top of whatever the policy says.  Grants are kept as
+  policy data, in the path's .__security_data.json:
+
+    { "grants": { "jp": { "allow": ["Read", "Stat"], "by": "rob", "time": "..." } } }
+
+  so that rego can also use them, as data.grants.  Only the grants
+  api writes them: fs.FS refuses to write any .__ file through
+  WebDAV, or whoever can create a file in a directory could grant
+  themselves anything there.
+*/
+type Grant struct {
+	Allow []string  `json:"allow"`
+	By    string    `json:"by"`
+	Time  time.Time `json:"time"`
+}
+
+// Admin is never granted, and only these are.
+var grantable = map[string]bool{
+	string(fs.AllowStat):   true,
+	string(fs.AllowRead):   true,
+	string(fs.AllowWrite):  true,
+	string(fs.AllowCreate): true,
+	string(fs.AllowDelete): true,
+}
+
+/*
+  Wrap an engine so that grants are added to its decisions,
+  whichever engine it is.
+*/
+type grantingEngine struct {
+	Engine PolicyEngine
+	Root   string
+}
+
+func (e grantingEngine) Decide(ctx context.Context, input ClaimsContext) (map[string]interface{}, error) {
+	permission, err := e.Engine.Decide(ctx, input)
+	if err != nil {
+		return permission, err
+	}
+	grants, _ := dataOf(e.Root, input.Action.Name)["grants"].(map[string]interface{})
+	for _, user := range input.Claims.Groups["username"] {
+		grant, _ := grants[user].(map[string]interface{})
+		allow, _ := grant["allow"].([]interface{})
+		for _, a := range allow {
+			if s, ok := a.(string); ok && grantable[s] {
+				if permission == nil {
+					permission = make(map[string]interface{})
+				}
+				permission[s] = true
+			}
+		}
+	}
+	return permission, nil
+}
+
+func (e grantingEngine) PolicyID(input ClaimsContext) (string, string) {
+	if pi, ok := e.Engine.(PolicyIdentifier); ok {
+		return pi.PolicyID(input)
+	}
+	return "", ""
+}
+
+// Grants are read, changed and written back as a whole.
+var grantsMu sync.Mutex
+
+func grantsOf(dataFile string) map[string]Grant {
+	grants := make(map[string]Grant)
+	doc := readData(dataFile)
+	if doc == nil {
+		return grants
+	}
+	if _, ok := doc["grants"].(map[string]interface{}); !ok {
+		return grants
+	}
+	data, _ := json.Marshal(doc["grants"])
+	json.Unmarshal(data, &grants)
+	return grants
+}
+
+/*
+  Change the grants in dataFile, leaving any other data as it is.
+*/
+func updateGrants(dataFile string, change func(grants map[string]Grant)) (before, after map[string]Grant, err error) {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+	doc := readData(dataFile)
+	if doc == nil {
+		if _, err := os.Stat(dataFile); err == nil {
+			return nil, nil, fmt.Errorf("%s is not a json object", dataFile)
+		}
+		doc = make(map[string]interface{})
+	}
+	before = grantsOf(dataFile)
+	after = grantsOf(dataFile)
+	change(after)
+	if len(after) == 0 {
+		delete(doc, "grants")
+	} else {
+		doc["grants"] = after
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, ioutil.WriteFile(dataFile, data, 0644)
+}
+
+type GrantRequest struct {
+	User  string   `json:"user"`
+	Allow []string `json:"allow"`
+}
+
+/*
+  Share a path with another user, without an admin editing policy.
+  You can only grant what you can do yourself, on a path you can Write,
+  and only to someone else.
+
+    GET    /.__api/grants?path=/rob/pics
+    POST   /.__api/grants?path=/rob/pics  {"user": "jp", "allow": ["Read", "Stat"]}
+    DELETE /.__api/grants?path=/rob/pics&user=jp
+*/
+func grantsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		if _, err := fsys.Stat(ctx, name); err != nil || name == "/" || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		dataFile := fs.NameFor(fsys.Resolve(name), "security_data.json")
+		if r.Method == "GET" {
+			writeJson(w, http.StatusOK, grantsOf(dataFile))
+			return
+		}
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowWrite})
+		admin := isAdmin(ctx, fsys)
+		if !admin && !fsys.Allow(ctx, permission, fs.AllowWrite) {
+			writeJsonError(w, http.StatusForbidden, os.ErrPermission)
+			return
+		}
+		if !mayChange(w, tenantOf(ctx), name) {
+			return
+		}
+		var change func(grants map[string]Grant)
+		var target string
+		switch r.Method {
+		case "POST":
+			var req GrantRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := validUsername(req.User); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if req.User == username {
+				// what you hold here, you have already
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("you cannot grant to yourself"))
+				return
+			}
+			if len(req.Allow) == 0 {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("allow must name at least one permission"))
+				return
+			}
+			for _, a := range req.Allow {
+				if !grantable[a] {
+					writeJsonError(w, http.StatusBadRequest, fmt.Errorf("%s cannot be granted", a))
+					return
+				}
+				if !admin && !fsys.Allow(ctx, permission, fs.Allow(a)) {
+					writeJsonError(w, http.StatusForbidden, fmt.Errorf("you cannot grant %s, which you do not have", a))
+					return
+				}
+			}
+			sort.Strings(req.Allow)
+			target = req.User
+			change = func(grants map[string]Grant) {
+				grants[req.User] = Grant{Allow: req.Allow, By: username, Time: time.Now().UTC()}
+			}
+		case "DELETE":
+			target = r.URL.Query().Get("user")
+			change = func(grants map[string]Grant) {
+				delete(grants, target)
+			}
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		before, after, err := updateGrants(dataFile, change)
+		rec := AuditRecord{User: username, Action: "grant " + target, Target: name}
+		rec.Before, _ = json.Marshal(before)
+		rec.After, _ = json.Marshal(after)
+		if err != nil {
+			rec.Error = err.Error()
+			audit(ctx, rec)
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		audit(ctx, rec)
+		writeJson(w, http.StatusOK, after)
+	})
+}
+
+var _ PolicyEngine = grantingEngine{}
@@ -0,0 +1,1453 @@
+package example1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A GraphQL endpoint for the metadata of the volume, so that a
+  dashboard can ask for files, their properties, tags, shares and
+  versions in one request, rather than PROPFIND after PROPFIND:
+
+    POST /.__api/graphql  {"query": "{ file(path: \"/rob\") { children { name tags shares { token } } } }"}
+    GET  /.__api/graphql?query={file(path:"/rob"){size}}
+
+  It only reads, so there are queries but no mutations.  Everything
+  is resolved as the user, so what the policy hides from them is not
+  there, as it would not be over WebDAV.  The schema:
+
+    type Query {
+      file(path: String!): File
+      tagged(tag: String!): [File]
+      shares(all: Boolean): [Share]
+      snapshots: [Snapshot]          # admin only
+    }
+    type File {
+      path: String
+      name: String
+      size: Int
+      isDir: Boolean
+      modTime: String
+      etag: String
+      props: [Property]
+      prop(name: String!): String
+      tags: [String]
+      children(limit: Int, after: String): [File]
+      parent: File
+      capabilities: Capabilities
+      shares: [Share]
+      versions: [Version]
+    }
+    type Property { name: String, value: String }
+    type Capabilities { read: Boolean, write: Boolean, create: Boolean, delete: Boolean }
+    type Share {
+      token: String, owner: String, path: String, protected: Boolean, expires: String,
+      maxDownloads: Int, downloads: Int, allowUpload: Boolean, created: String, file: File
+    }
+    type Snapshot { name: String, path: String, time: String, size: Int }
+    type Version { snapshot: String, path: String, size: Int, modTime: String, etag: String }
+
+  Variables, aliases, fragments and @skip and @include are supported.
+  Introspection is not.
+*/
+func graphqlHandler(t *Tenant) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}
+		switch r.Method {
+		case "GET":
+			q := r.URL.Query()
+			req.Query = q.Get("query")
+			req.OperationName = q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					writeGraphQLErrors(w, fmt.Errorf("bad variables: %v", err))
+					return
+				}
+			}
+		case "POST":
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, gqlMaxQuery)).Decode(&req); err != nil {
+				writeGraphQLErrors(w, fmt.Errorf("bad request: %v", err))
+				return
+			}
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		if len(req.Query) > gqlMaxQuery {
+			writeGraphQLErrors(w, fmt.Errorf("query is too long"))
+			return
+		}
+		doc, err := parseGraphQL(req.Query)
+		if err != nil {
+			writeGraphQLErrors(w, err)
+			return
+		}
+		op, err := doc.operation(req.OperationName)
+		if err != nil {
+			writeGraphQLErrors(w, err)
+			return
+		}
+		if err := doc.validate("Query", op.Selections, 0, nil); err != nil {
+			writeGraphQLErrors(w, err)
+			return
+		}
+		vars, err := op.variables(req.Variables)
+		if err != nil {
+			writeGraphQLErrors(w, err)
+			return
+		}
+		e := &gqlExec{
+			r:     r,
+			t:     t,
+			doc:   doc,
+			vars:  vars,
+			props: make(map[string]map[string]string),
+		}
+		data := e.object("Query", nil, op.Selections, nil)
+		writeJson(w, http.StatusOK, gqlResponse{Data: data, Errors: e.errors})
+	})
+}
+
+// Whether to serve the GraphQL endpoint, as -graphql says
+var graphqlEnabled bool
+
+const (
+	// how long a query may be, in bytes
+	gqlMaxQuery = 64 * 1024
+	// how deeply fields may be nested
+	gqlMaxDepth = 12
+	// how many objects a query may resolve
+	gqlMaxObjects = 10000
+)
+
+type gqlResponse struct {
+	Data   *gqlObject `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// A request that could not be run at all is 400 Bad Request, with no data.
+func writeGraphQLErrors(w http.ResponseWriter, err error) {
+	writeJson(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: err.Error()}}})
+}
+
+/*
+  gqlObject is an object of the result, whose fields are in the order
+  that they were asked for, as GraphQL has them.
+*/
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+/*
+  The schema, as the fields of each type.  A field's type is a scalar
+  (String, Int or Boolean), or a type here, or a list of either in
+  brackets.  Arguments are typed the same way, with ! if they must be
+  given.
+*/
+type gqlField struct {
+	Type    string
+	Args    map[string]string
+	Resolve gqlResolver
+}
+
+type gqlResolver func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error)
+
+var gqlSchema map[string]map[string]gqlField
+
+func init() {
+	gqlSchema = map[string]map[string]gqlField{
+		"Query": {
+			"file":      {Type: "File", Args: map[string]string{"path": "String!"}, Resolve: gqlQueryFile},
+			"tagged":    {Type: "[File]", Args: map[string]string{"tag": "String!"}, Resolve: gqlQueryTagged},
+			"shares":    {Type: "[Share]", Args: map[string]string{"all": "Boolean"}, Resolve: gqlQueryShares},
+			"snapshots": {Type: "[Snapshot]", Resolve: gqlQuerySnapshots},
+		},
+		"File": {
+			"path":    {Type: "String", Resolve: gqlFileField(func(f *gqlFile) interface{} { return f.name })},
+			"name":    {Type: "String", Resolve: gqlFileField(func(f *gqlFile) interface{} { return f.info.Name() })},
+			"size":    {Type: "Int", Resolve: gqlFileField(func(f *gqlFile) interface{} { return f.info.Size() })},
+			"isDir":   {Type: "Boolean", Resolve: gqlFileField(func(f *gqlFile) interface{} { return f.info.IsDir() })},
+			"modTime": {Type: "String", Resolve: gqlFileField(func(f *gqlFile) interface{} { return f.info.ModTime().UTC().Format(time.RFC3339Nano) })},
+			"etag": {Type: "String", Resolve: func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				return webdav.ETagOf(e.r.Context(), parent.(*gqlFile).info)
+			}},
+			"props":        {Type: "[Property]", Resolve: gqlFileProps},
+			"prop":         {Type: "String", Args: map[string]string{"name": "String!"}, Resolve: gqlFileProp},
+			"tags":         {Type: "[String]", Resolve: gqlFileTags},
+			"children":     {Type: "[File]", Args: map[string]string{"limit": "Int", "after": "String"}, Resolve: gqlFileChildren},
+			"parent":       {Type: "File", Resolve: gqlFileParent},
+			"capabilities": {Type: "Capabilities", Resolve: gqlFileCapabilities},
+			"shares":       {Type: "[Share]", Resolve: gqlFileShares},
+			"versions":     {Type: "[Version]", Resolve: gqlFileVersions},
+		},
+		"Property": {
+			"name":  {Type: "String", Resolve: gqlKey("name")},
+			"value": {Type: "String", Resolve: gqlKey("value")},
+		},
+		"Capabilities": {
+			"read":   {Type: "Boolean", Resolve: gqlKey("read")},
+			"write":  {Type: "Boolean", Resolve: gqlKey("write")},
+			"create": {Type: "Boolean", Resolve: gqlKey("create")},
+			"delete": {Type: "Boolean", Resolve: gqlKey("delete")},
+		},
+		"Share": {
+			"token":        {Type: "String", Resolve: gqlShare(func(s Share) interface{} { return s.Token })},
+			"owner":        {Type: "String", Resolve: gqlShare(func(s Share) interface{} { return s.Owner })},
+			"path":         {Type: "String", Resolve: gqlShare(func(s Share) interface{} { return s.Path })},
+			"protected":    {Type: "Boolean", Resolve: gqlShare(func(s Share) interface{} { return s.Protected })},
+			"maxDownloads": {Type: "Int", Resolve: gqlShare(func(s Share) interface{} { return s.MaxDownloads })},
+			"downloads":    {Type: "Int", Resolve: gqlShare(func(s Share) interface{} { return s.Downloads })},
+			"allowUpload":  {Type: "Boolean", Resolve: gqlShare(func(s Share) interface{} { return s.AllowUpload })},
+			"created":      {Type: "String", Resolve: gqlShare(func(s Share) interface{} { return s.Created.UTC().Format(time.RFC3339) })},
+			"expires": {Type: "String", Resolve: gqlShare(func(s Share) interface{} {
+				if s.Expires == nil {
+					return nil
+				}
+				return s.Expires.UTC().Format(time.RFC3339)
+			})},
+			"file": {Type: "File", Resolve: func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				return e.file(parent.(Share).Path)
+			}},
+		},
+		"Snapshot": {
+			"name": {Type: "String", Resolve: gqlKey("name")},
+			"path": {Type: "String", Resolve: gqlKey("path")},
+			"time": {Type: "String", Resolve: gqlKey("time")},
+			"size": {Type: "Int", Resolve: gqlKey("size")},
+		},
+		"Version": {
+			"snapshot": {Type: "String", Resolve: gqlKey("snapshot")},
+			"path":     {Type: "String", Resolve: gqlKey("path")},
+			"size":     {Type: "Int", Resolve: gqlKey("size")},
+			"modTime":  {Type: "String", Resolve: gqlKey("modTime")},
+			"etag":     {Type: "String", Resolve: gqlKey("etag")},
+		},
+	}
+}
+
+// A resolver for objects that are just maps.
+func gqlKey(key string) gqlResolver {
+	return func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+		return parent.(map[string]interface{})[key], nil
+	}
+}
+
+func gqlFileField(get func(f *gqlFile) interface{}) gqlResolver {
+	return func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(parent.(*gqlFile)), nil
+	}
+}
+
+func gqlShare(get func(s Share) interface{}) gqlResolver {
+	return func(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(parent.(Share)), nil
+	}
+}
+
+// The type that a field's type is made of, without brackets or !.
+func gqlNamedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+func gqlIsList(typ string) bool {
+	return strings.HasPrefix(typ, "[")
+}
+
+/*
+  gqlExec runs one query, as the user of r.  What is looked up more
+  than once, such as the dead properties of a file, is kept for the
+  rest of the query.
+*/
+type gqlExec struct {
+	r         *http.Request
+	t         *Tenant
+	doc       *gqlDocument
+	vars      map[string]interface{}
+	errors    []gqlError
+	objects   int
+	props     map[string]map[string]string
+	snapshots []StoredSnapshot
+}
+
+// A file that the user may see, with what Stat said of it.
+type gqlFile struct {
+	name string
+	info os.FileInfo
+}
+
+// The file at p, or nil if the user cannot see it.
+func (e *gqlExec) file(p string) (interface{}, error) {
+	ctx := e.r.Context()
+	name := webdav.SlashClean(p)
+	if isMetadataPath(name) {
+		return nil, nil
+	}
+	if !mfaOf(ctx) && requiresMFA(ctx, e.t.fsys, name) {
+		return nil, fmt.Errorf(mfaNeeded)
+	}
+	fi, err := e.t.fsys.Stat(ctx, name)
+	if err != nil {
+		return nil, nil
+	}
+	return &gqlFile{name: name, info: fi}, nil
+}
+
+func (e *gqlExec) deadProps(name string) (map[string]string, error) {
+	if props, ok := e.props[name]; ok {
+		return props, nil
+	}
+	props, err := deadProps(e.r.Context(), e.t.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	e.props[name] = props
+	return props, nil
+}
+
+/*
+  Resolve the fields of an object of type typ.  A field that fails is
+  null, with an error that says where it is, and the rest go on.
+*/
+func (e *gqlExec) object(typ string, parent interface{}, sels []gqlSelection, at []interface{}) *gqlObject {
+	obj := &gqlObject{values: make(map[string]interface{})}
+	e.objects++
+	if e.objects > gqlMaxObjects {
+		if e.objects == gqlMaxObjects+1 {
+			e.errors = append(e.errors, gqlError{Message: fmt.Sprintf("the query resolves more than %d objects", gqlMaxObjects), Path: at})
+		}
+		return nil
+	}
+	keys, fields := e.doc.collect(typ, sels, e.vars)
+	for _, key := range keys {
+		field := fields[key][0]
+		where := append(append([]interface{}{}, at...), key)
+		if field.Name == "__typename" {
+			obj.set(key, typ)
+			continue
+		}
+		def := gqlSchema[typ][field.Name]
+		args, err := e.args(def, field)
+		var value interface{}
+		if err == nil {
+			value, err = def.Resolve(e, parent, args)
+		}
+		if err != nil {
+			e.errors = append(e.errors, gqlError{Message: err.Error(), Path: where})
+			obj.set(key, nil)
+			continue
+		}
+		obj.set(key, e.complete(def.Type, value, gqlMerged(fields[key]), where))
+	}
+	return obj
+}
+
+// The selections of every field that answers to the same key.
+func gqlMerged(fields []gqlSelection) []gqlSelection {
+	if len(fields) == 1 {
+		return fields[0].Selections
+	}
+	var sels []gqlSelection
+	for _, f := range fields {
+		sels = append(sels, f.Selections...)
+	}
+	return sels
+}
+
+// What a field resolved to, as its type says it is in the result.
+func (e *gqlExec) complete(typ string, value interface{}, sels []gqlSelection, at []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	named := gqlNamedType(typ)
+	if gqlIsList(typ) {
+		items, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = e.complete(named, item, sels, append(append([]interface{}{}, at...), i))
+		}
+		return list
+	}
+	if _, ok := gqlSchema[named]; ok {
+		if obj := e.object(named, value, sels, at); obj != nil {
+			return obj
+		}
+		return nil
+	}
+	return value
+}
+
+// The arguments of a field, with variables filled in, as their types say.
+func (e *gqlExec) args(def gqlField, field gqlSelection) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for name, typ := range def.Args {
+		raw, given := field.Args[name]
+		var value interface{}
+		if given {
+			value = gqlValueOf(raw, e.vars)
+		}
+		if value == nil {
+			if strings.HasSuffix(typ, "!") {
+				return nil, fmt.Errorf("argument %s of %s is required", name, field.Name)
+			}
+			continue
+		}
+		v, err := gqlCoerce(strings.TrimSuffix(typ, "!"), value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s: %v", name, field.Name, err)
+		}
+		args[name] = v
+	}
+	return args, nil
+}
+
+func gqlCoerce(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Int":
+		switch n := value.(type) {
+		case int64:
+			return int(n), nil
+		case float64:
+			if n == float64(int(n)) {
+				return int(n), nil
+			}
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s", typ)
+}
+
+func gqlQueryFile(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	return e.file(args["path"].(string))
+}
+
+func gqlQueryTagged(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	files := make([]interface{}, 0)
+	for _, name := range visibleTagged(e.r, e.t.fsys, args["tag"].(string)) {
+		if f, err := e.file(name); err == nil && f != nil {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// The user's own shares, or everyone's for an admin that asks for all.
+func gqlQueryShares(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	return e.shares(func(s Share) bool { return true }, args["all"] == true)
+}
+
+func (e *gqlExec) shares(match func(s Share) bool, all bool) (interface{}, error) {
+	ctx := e.r.Context()
+	list := make([]interface{}, 0)
+	if e.t.shares == nil {
+		return list, nil
+	}
+	username, _ := ctx.Value("username").(string)
+	all = all && isAdmin(ctx, e.t.fsys)
+	for _, s := range e.t.shares.List() {
+		if (all || s.Owner == username) && match(s) {
+			list = append(list, s.public())
+		}
+	}
+	return list, nil
+}
+
+func gqlQuerySnapshots(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	if !isAdmin(e.r.Context(), e.t.fsys) {
+		return nil, ErrNotAdmin
+	}
+	stored, err := e.storedSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0, len(stored))
+	for _, s := range stored {
+		snapshot := map[string]interface{}{
+			"name": s.Name,
+			"time": s.Time.UTC().Format(time.RFC3339),
+			"size": s.Size,
+		}
+		if sfs, _, err := e.t.tree.split(e.r.Context(), "/"+strings.TrimSuffix(s.Name, ".tar")); err == nil {
+			snapshot["path"] = sfs.Info.Path
+		}
+		list = append(list, snapshot)
+	}
+	return list, nil
+}
+
+func (e *gqlExec) storedSnapshots() ([]StoredSnapshot, error) {
+	if e.t.snapshots == nil {
+		return nil, nil
+	}
+	if e.snapshots == nil {
+		stored, err := e.t.snapshots.List(e.r.Context())
+		if err != nil {
+			return nil, err
+		}
+		e.snapshots = stored
+	}
+	return e.snapshots, nil
+}
+
+func gqlFileProps(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	props, err := e.deadProps(parent.(*gqlFile).name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]interface{}, len(keys))
+	for i, k := range keys {
+		list[i] = map[string]interface{}{"name": k, "value": props[k]}
+	}
+	return list, nil
+}
+
+func gqlFileProp(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	props, err := e.deadProps(parent.(*gqlFile).name)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := props[fs.PropKey(fs.PropName(args["name"].(string)))]; ok {
+		return value, nil
+	}
+	return nil, nil
+}
+
+func gqlFileTags(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	props, err := e.deadProps(parent.(*gqlFile).name)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0)
+	for _, tag := range fs.TagsOf(props[fs.PropKey(fs.TagsProperty)]) {
+		list = append(list, tag)
+	}
+	return list, nil
+}
+
+// A directory in order of name, 100 at a time unless there is a limit.
+func gqlFileChildren(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	f := parent.(*gqlFile)
+	if !f.info.IsDir() {
+		return nil, nil
+	}
+	limit, _ := args["limit"].(int)
+	switch {
+	case limit < 0:
+		return nil, fmt.Errorf("limit cannot be negative")
+	case limit == 0:
+		limit = 100
+	case limit > 1000:
+		limit = 1000
+	}
+	after, _ := args["after"].(string)
+	infos, err := visibleChildren(e.r.Context(), e.t.fsys, f.name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	list := make([]interface{}, 0)
+	for _, fi := range infos {
+		if fi.Name() <= after {
+			continue
+		}
+		if len(list) == limit {
+			break
+		}
+		list = append(list, &gqlFile{name: path.Join(f.name, fi.Name()), info: fi})
+	}
+	return list, nil
+}
+
+func gqlFileParent(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	f := parent.(*gqlFile)
+	if f.name == "/" {
+		return nil, nil
+	}
+	return e.file(path.Dir(f.name))
+}
+
+func gqlFileCapabilities(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	c, err := e.t.fsys.Capabilities(e.r.Context(), parent.(*gqlFile).name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"read": c.Read, "write": c.Write, "create": c.Create, "delete": c.Delete}, nil
+}
+
+func gqlFileShares(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	name := parent.(*gqlFile).name
+	return e.shares(func(s Share) bool { return webdav.SlashClean(s.Path) == name }, true)
+}
+
+/*
+  The file as it was in each stored snapshot that has it, oldest
+  first, where it can be read under /.snapshots.  The policy decides
+  what may be seen of them, as it does when they are browsed.
+*/
+func gqlFileVersions(e *gqlExec, parent interface{}, args map[string]interface{}) (interface{}, error) {
+	ctx := e.r.Context()
+	name := parent.(*gqlFile).name
+	list := make([]interface{}, 0)
+	stored, err := e.storedSnapshots()
+	if err != nil || stored == nil {
+		return list, err
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Time.Before(stored[j].Time) })
+	for _, s := range stored {
+		dir := "/" + strings.TrimSuffix(s.Name, ".tar")
+		sfs, _, err := e.t.tree.split(ctx, dir)
+		if err != nil {
+			continue
+		}
+		of := webdav.SlashClean(sfs.Info.Path)
+		var rest string
+		switch {
+		case of == "/":
+			rest = name
+		case name == of:
+			rest = "/"
+		case strings.HasPrefix(name, of+"/"):
+			rest = strings.TrimPrefix(name, of)
+		default:
+			continue
+		}
+		fi, err := sfs.Stat(ctx, rest)
+		if err != nil {
+			continue
+		}
+		etag, _ := webdav.ETagOf(ctx, fi)
+		list = append(list, map[string]interface{}{
+			"snapshot": s.Name,
+			"path":     path.Join(e.t.Prefix+snapshotTreePrefix, dir, rest),
+			"size":     fi.Size(),
+			"modTime":  fi.ModTime().UTC().Format(time.RFC3339Nano),
+			"etag":     etag,
+		})
+	}
+	return list, nil
+}
+
+/*
+  The query language, as far as this endpoint needs it.  A document
+  is parsed into its operations and fragments, then checked against
+  the schema before anything is resolved.
+*/
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	Type       string
+	Name       string
+	Vars       []gqlVarDef
+	Selections []gqlSelection
+}
+
+type gqlVarDef struct {
+	Name    string
+	Type    string
+	Default interface{}
+}
+
+type gqlFragment struct {
+	On         string
+	Selections []gqlSelection
+}
+
+/*
+  A selection is a field, a spread of a named fragment, or an inline
+  fragment, with its directives.
+*/
+type gqlSelection struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Directives map[string]map[string]interface{}
+	Selections []gqlSelection
+	// a spread of the named fragment
+	Spread string
+	// an inline fragment, on a type if there is one
+	Inline bool
+	On     string
+}
+
+func (s gqlSelection) key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// A variable in a value, to be filled in when the query is run.
+type gqlVariable string
+
+// Values with their variables filled in.
+func gqlValueOf(v interface{}, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVariable:
+		return vars[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = gqlValueOf(item, vars)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{})
+		for k, item := range v {
+			obj[k] = gqlValueOf(item, vars)
+		}
+		return obj
+	}
+	return v
+}
+
+// The operation to run: the one named, or the only one.
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	var op *gqlOperation
+	for _, o := range d.operations {
+		if name == "" || o.Name == name {
+			if op != nil {
+				return nil, fmt.Errorf("operationName is required when there is more than one operation")
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("no operation named %q", name)
+	}
+	if op.Type != "query" {
+		return nil, fmt.Errorf("only queries are supported, not %ss", op.Type)
+	}
+	return op, nil
+}
+
+// The variables for an operation, from those given and the defaults.
+func (op *gqlOperation) variables(given map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.Vars {
+		value, ok := given[def.Name]
+		if !ok {
+			value = def.Default
+		}
+		if value == nil {
+			if strings.HasSuffix(def.Type, "!") {
+				return nil, fmt.Errorf("variable $%s is required", def.Name)
+			}
+			continue
+		}
+		if !gqlIsList(def.Type) {
+			v, err := gqlCoerce(strings.TrimSuffix(def.Type, "!"), value)
+			if err != nil {
+				return nil, fmt.Errorf("variable $%s: %v", def.Name, err)
+			}
+			value = v
+		}
+		vars[def.Name] = value
+	}
+	return vars, nil
+}
+
+/*
+  The fields of sels on typ, by the keys that they answer to, in order,
+  with fragments spread out and what @skip and @include leave out
+  left out.
+*/
+func (d *gqlDocument) collect(typ string, sels []gqlSelection, vars map[string]interface{}) ([]string, map[string][]gqlSelection) {
+	var keys []string
+	fields := make(map[string][]gqlSelection)
+	var walk func(sels []gqlSelection, seen map[string]bool)
+	walk = func(sels []gqlSelection, seen map[string]bool) {
+		for _, s := range sels {
+			if !s.included(vars) {
+				continue
+			}
+			switch {
+			case s.Spread != "":
+				f := d.fragments[s.Spread]
+				if f == nil || seen[s.Spread] || f.On != typ {
+					continue
+				}
+				seen[s.Spread] = true
+				walk(f.Selections, seen)
+				delete(seen, s.Spread)
+			case s.Inline:
+				if s.On == "" || s.On == typ {
+					walk(s.Selections, seen)
+				}
+			default:
+				k := s.key()
+				if _, ok := fields[k]; !ok {
+					keys = append(keys, k)
+				}
+				fields[k] = append(fields[k], s)
+			}
+		}
+	}
+	walk(sels, make(map[string]bool))
+	return keys, fields
+}
+
+func (s gqlSelection) included(vars map[string]interface{}) bool {
+	if args, ok := s.Directives["skip"]; ok && gqlValueOf(args["if"], vars) == true {
+		return false
+	}
+	if args, ok := s.Directives["include"]; ok && gqlValueOf(args["if"], vars) != true {
+		return false
+	}
+	return true
+}
+
+// Check that what is asked for is in the schema, before resolving any of it.
+func (d *gqlDocument) validate(typ string, sels []gqlSelection, depth int, spreading []string) error {
+	if depth > gqlMaxDepth {
+		return fmt.Errorf("the query is nested more than %d deep", gqlMaxDepth)
+	}
+	for _, s := range sels {
+		switch {
+		case s.Spread != "":
+			f := d.fragments[s.Spread]
+			if f == nil {
+				return fmt.Errorf("unknown fragment %s", s.Spread)
+			}
+			for _, name := range spreading {
+				if name == s.Spread {
+					return fmt.Errorf("fragment %s spreads itself", s.Spread)
+				}
+			}
+			if _, ok := gqlSchema[f.On]; !ok {
+				return fmt.Errorf("unknown type %s", f.On)
+			}
+			if err := d.validate(f.On, f.Selections, depth, append(spreading, s.Spread)); err != nil {
+				return err
+			}
+		case s.Inline:
+			on := s.On
+			if on == "" {
+				on = typ
+			} else if _, ok := gqlSchema[on]; !ok {
+				return fmt.Errorf("unknown type %s", on)
+			}
+			if err := d.validate(on, s.Selections, depth, spreading); err != nil {
+				return err
+			}
+		case s.Name == "__typename":
+			if len(s.Selections) > 0 {
+				return fmt.Errorf("__typename has no fields")
+			}
+		default:
+			def, ok := gqlSchema[typ][s.Name]
+			if !ok {
+				return fmt.Errorf("cannot query field %s on type %s", s.Name, typ)
+			}
+			for name := range s.Args {
+				if _, ok := def.Args[name]; !ok {
+					return fmt.Errorf("unknown argument %s of %s", name, s.Name)
+				}
+			}
+			named := gqlNamedType(def.Type)
+			if _, object := gqlSchema[named]; object {
+				if len(s.Selections) == 0 {
+					return fmt.Errorf("field %s of type %s needs a selection of fields", s.Name, def.Type)
+				}
+				if err := d.validate(named, s.Selections, depth+1, spreading); err != nil {
+					return err
+				}
+			} else if len(s.Selections) > 0 {
+				return fmt.Errorf("field %s of type %s has no fields", s.Name, def.Type)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+  gqlParser reads a document, a token at a time.  Commas are
+  whitespace, as are comments from # to the end of the line.
+*/
+type gqlParser struct {
+	src string
+	pos int
+	// the token that was read last, and what kind it is
+	tok  string
+	kind int
+	at   int
+}
+
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{src: src}
+	doc := &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.kind != gqlEOF {
+		switch {
+		case p.is("{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{Type: "query", Selections: sels})
+		case p.kind == gqlName && p.tok == "fragment":
+			name, f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[name] != nil {
+				return nil, fmt.Errorf("fragment %s is defined twice", name)
+			}
+			doc.fragments[name] = f
+		case p.kind == gqlName && (p.tok == "query" || p.tok == "mutation" || p.tok == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		default:
+			return nil, p.errorf("unexpected %q", p.tok)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the query has no operation")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range p.src[:p.at] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("syntax error at %d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (p *gqlParser) is(punct string) bool {
+	return p.kind == gqlPunct && p.tok == punct
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if !p.is(punct) {
+		return p.errorf("expected %q, not %q", punct, p.tok)
+	}
+	return p.next()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.kind != gqlName {
+		return "", p.errorf("expected a name, not %q", p.tok)
+	}
+	name := p.tok
+	return name, p.next()
+}
+
+// Read the next token.
+func (p *gqlParser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if strings.HasPrefix(p.src[p.pos:], "\ufeff") {
+			p.pos += 3
+		} else {
+			break
+		}
+	}
+	p.at = p.pos
+	if p.pos >= len(p.src) {
+		p.kind, p.tok = gqlEOF, "end of query"
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.kind, p.tok = gqlPunct, "..."
+		p.pos += 3
+	case strings.IndexByte("!$()&:=@[]{}|", c) >= 0:
+		p.kind, p.tok = gqlPunct, string(c)
+		p.pos++
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		end := p.pos + 1
+		for end < len(p.src) && (p.src[end] == '_' || p.src[end] >= 'a' && p.src[end] <= 'z' || p.src[end] >= 'A' && p.src[end] <= 'Z' || p.src[end] >= '0' && p.src[end] <= '9') {
+			end++
+		}
+		p.kind, p.tok = gqlName, p.src[p.pos:end]
+		p.pos = end
+	case c == '-' || c >= '0' && c <= '9':
+		end := p.pos + 1
+		p.kind = gqlInt
+		for end < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[end]) >= 0 {
+			if strings.IndexByte(".eE", p.src[end]) >= 0 {
+				p.kind = gqlFloat
+			}
+			end++
+		}
+		p.tok = p.src[p.pos:end]
+		p.pos = end
+	case c == '"':
+		return p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return p.errorf("unexpected character %q", r)
+	}
+	return nil
+}
+
+// A string, or a block string in triple quotes, taken as it is.
+func (p *gqlParser) string() error {
+	p.kind = gqlString
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			return p.errorf("unterminated string")
+		}
+		p.tok = strings.TrimSpace(p.src[p.pos+3 : p.pos+3+end])
+		p.pos += end + 6
+		return nil
+	}
+	var b strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch c := p.src[i]; c {
+		case '"':
+			p.tok = b.String()
+			p.pos = i + 1
+			return nil
+		case '\n', '\r':
+			return p.errorf("unterminated string")
+		case '\\':
+			if i+1 >= len(p.src) {
+				return p.errorf("unterminated string")
+			}
+			i++
+			switch e := p.src[i]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+5 > len(p.src) {
+					return p.errorf("bad escape in string")
+				}
+				n, err := strconv.ParseUint(p.src[i+1:i+5], 16, 32)
+				if err != nil {
+					return p.errorf("bad escape in string")
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			default:
+				return p.errorf("bad escape in string")
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return p.errorf("unterminated string")
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{Type: p.tok}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.kind == gqlName {
+		op.Name = p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.is(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			var def gqlVarDef
+			var err error
+			if def.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if def.Type, err = p.typeRef(); err != nil {
+				return nil, err
+			}
+			if p.is("=") {
+				if err := p.next(); err != nil {
+					return nil, err
+				}
+				if def.Default, err = p.value(true); err != nil {
+					return nil, err
+				}
+			}
+			op.Vars = append(op.Vars, def)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = sels
+	return op, nil
+}
+
+// A type, as String!, or [String] or [String!]!
+func (p *gqlParser) typeRef() (string, error) {
+	var typ string
+	if p.is("[") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.is("!") {
+		typ += "!"
+		return typ, p.next()
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) fragment() (string, *gqlFragment, error) {
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, p.errorf("a fragment cannot be named on")
+	}
+	if p.kind != gqlName || p.tok != "on" {
+		return "", nil, p.errorf("expected on, not %q", p.tok)
+	}
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	f := &gqlFragment{}
+	if f.On, err = p.name(); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	if f.Selections, err = p.selectionSet(); err != nil {
+		return "", nil, err
+	}
+	return name, f, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.is("}") {
+		if p.kind == gqlEOF {
+			return nil, p.errorf("expected \"}\"")
+		}
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, s)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("a selection cannot be empty")
+	}
+	return sels, p.next()
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var s gqlSelection
+	var err error
+	if p.is("...") {
+		if err := p.next(); err != nil {
+			return s, err
+		}
+		if p.kind == gqlName && p.tok != "on" {
+			s.Spread = p.tok
+			if err := p.next(); err != nil {
+				return s, err
+			}
+			s.Directives, err = p.directives()
+			return s, err
+		}
+		s.Inline = true
+		if p.kind == gqlName && p.tok == "on" {
+			if err := p.next(); err != nil {
+				return s, err
+			}
+			if s.On, err = p.name(); err != nil {
+				return s, err
+			}
+		}
+		if s.Directives, err = p.directives(); err != nil {
+			return s, err
+		}
+		s.Selections, err = p.selectionSet()
+		return s, err
+	}
+	if s.Name, err = p.name(); err != nil {
+		return s, err
+	}
+	if p.is(":") {
+		if err := p.next(); err != nil {
+			return s, err
+		}
+		s.Alias = s.Name
+		if s.Name, err = p.name(); err != nil {
+			return s, err
+		}
+	}
+	if s.Args, err = p.arguments(); err != nil {
+		return s, err
+	}
+	if s.Directives, err = p.directives(); err != nil {
+		return s, err
+	}
+	if p.is("{") {
+		s.Selections, err = p.selectionSet()
+	}
+	return s, err
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	if !p.is("(") {
+		return nil, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	args := make(map[string]interface{})
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := args[name]; ok {
+			return nil, p.errorf("argument %s is given twice", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.next()
+}
+
+func (p *gqlParser) directives() (map[string]map[string]interface{}, error) {
+	var directives map[string]map[string]interface{}
+	for p.is("@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if name != "skip" && name != "include" {
+			return nil, p.errorf("unknown directive @%s", name)
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		if directives == nil {
+			directives = make(map[string]map[string]interface{})
+		}
+		directives[name] = args
+	}
+	return directives, nil
+}
+
+// A value, which cannot have variables in it if it is constant.
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch {
+	case p.is("$"):
+		if constant {
+			return nil, p.errorf("a default cannot be a variable")
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return gqlVariable(name), err
+	case p.is("["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, 0)
+		for !p.is("]") {
+			if p.kind == gqlEOF {
+				return nil, p.errorf("expected \"]\"")
+			}
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.next()
+	case p.is("{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{})
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.next()
+	case p.kind == gqlInt:
+		n, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %s", tok)
+		}
+		return n, p.next()
+	case p.kind == gqlFloat:
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %s", tok)
+		}
+		return f, p.next()
+	case p.kind == gqlString:
+		return tok, p.next()
+	case p.kind == gqlName:
+		var v interface{}
+		switch tok {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			// an enum, which this schema has none of
+			v = tok
+		}
+		return v, p.next()
+	}
+	return nil, p.errorf("expected a value, not %q", tok)
+}
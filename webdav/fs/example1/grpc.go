@@ -0,0 +1,837 @@
+package example1
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A gRPC api over the volume, for services that want typed access
+  with deadlines rather than WebDAV.  The service is FileService in
+  fileservice.proto:
+
+    Stat      what is at a path
+    List      a directory, a page at a time
+    Read      a file, streamed
+    Write     a file, streamed from the caller
+    GetProps  dead properties
+    SetProps  set and remove dead properties
+
+  It is served over TLS, on a port of its own.  A caller is the user
+  named by the common name of its client certificate, when that is
+  signed by a CA in -grpcca, or else by basic auth in the
+  authorization metadata, as over HTTP.  Reads, writes and property
+  changes go through the WebDAV handler, so they are allowed, denied,
+  journaled and audited as the same change through WebDAV would be.
+*/
+type grpcHandler struct {
+	Tenant *Tenant
+	// DAV serves WebDAV for the tenant, once the user is known
+	DAV http.Handler
+}
+
+const grpcService = "/webdev.FileService/"
+
+// The largest message taken from a caller, as in most gRPC libraries
+const grpcMaxMessage = 4 << 20
+
+// How much of a file each ReadResponse carries
+const grpcReadChunk = 64 << 10
+
+// gRPC status codes, as in google.golang.org/grpc/codes
+const (
+	grpcOK                 = 0
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcAborted            = 10
+	grpcOutOfRange         = 11
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+type grpcStatus struct {
+	Code    int
+	Message string
+}
+
+func (s *grpcStatus) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", s.Code, s.Message)
+}
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcStatus{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// The gRPC status for an HTTP one that WebDAV gave.
+func grpcStatusFor(status int) *grpcStatus {
+	code := grpcUnknown
+	switch status {
+	case http.StatusBadRequest:
+		code = grpcInvalidArgument
+	case http.StatusUnauthorized:
+		code = grpcUnauthenticated
+	case http.StatusForbidden:
+		code = grpcPermissionDenied
+	case http.StatusNotFound:
+		code = grpcNotFound
+	case http.StatusMethodNotAllowed, http.StatusConflict, http.StatusPreconditionFailed:
+		code = grpcFailedPrecondition
+	case http.StatusLocked:
+		code = grpcAborted
+	case http.StatusRequestedRangeNotSatisfiable:
+		code = grpcOutOfRange
+	case http.StatusInsufficientStorage, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		code = grpcResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = grpcUnavailable
+	case http.StatusNotImplemented:
+		code = grpcUnimplemented
+	default:
+		if status >= 500 {
+			code = grpcInternal
+		}
+	}
+	return &grpcStatus{Code: code, Message: http.StatusText(status)}
+}
+
+func grpcStatusOf(ctx context.Context, err error) *grpcStatus {
+	var s *grpcStatus
+	var de *davError
+	switch {
+	case err == nil:
+		return &grpcStatus{Code: grpcOK}
+	case errors.As(err, &s):
+		return s
+	case errors.As(err, &de):
+		s = grpcStatusFor(de.Status)
+		s.Message = de.Message
+		return s
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded:
+		return &grpcStatus{Code: grpcDeadlineExceeded, Message: "deadline exceeded"}
+	case errors.Is(err, os.ErrNotExist):
+		return &grpcStatus{Code: grpcNotFound, Message: "not found"}
+	case errors.Is(err, os.ErrPermission), errors.Is(err, webdav.ErrNotAllowed):
+		return &grpcStatus{Code: grpcPermissionDenied, Message: "permission denied"}
+	}
+	if status, ok := webdav.StatusOf(err); ok {
+		s = grpcStatusFor(status)
+		s.Message = err.Error()
+		return s
+	}
+	return &grpcStatus{Code: grpcInternal, Message: err.Error()}
+}
+
+// grpc-message is percent encoded, outside of printable ascii.
+func grpcEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+/*
+  grpc-timeout is a number of up to 8 digits and a unit:
+  H, M, S, m, u or n.
+*/
+func parseGRPCTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("bad grpc-timeout %q", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad grpc-timeout %q", s)
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("bad grpc-timeout %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+/*
+  Protocol buffers, as far as fileservice.proto needs them.  A
+  message is decoded into its fields by number, and read from there
+  as the type that the field is meant to be.
+*/
+type pbField struct {
+	varint uint64
+	data   []byte
+}
+
+type pbMessage map[int][]pbField
+
+func pbDecode(b []byte) (pbMessage, error) {
+	m := make(pbMessage)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		var f pbField
+		switch key & 7 {
+		case 0:
+			if f.varint, n = binary.Uvarint(b); n <= 0 {
+				return nil, fmt.Errorf("bad varint in field %d", field)
+			}
+			b = b[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, fmt.Errorf("short field %d", field)
+			}
+			f.data, b = b[:size], b[size:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, fmt.Errorf("bad length of field %d", field)
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", key&7, field)
+		}
+		m[field] = append(m[field], f)
+	}
+	return m, nil
+}
+
+// Scalars take the last value that was sent, as protobuf says they should.
+func (m pbMessage) last(field int) pbField {
+	if values := m[field]; len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return pbField{}
+}
+
+func (m pbMessage) String(field int) string {
+	return string(m.last(field).data)
+}
+
+func (m pbMessage) Bytes(field int) []byte {
+	return m.last(field).data
+}
+
+func (m pbMessage) Int(field int) int64 {
+	return int64(m.last(field).varint)
+}
+
+func (m pbMessage) Strings(field int) []string {
+	var list []string
+	for _, f := range m[field] {
+		list = append(list, string(f.data))
+	}
+	return list
+}
+
+// A map<string, string> is a list of entries, with the key as 1 and the value as 2.
+func (m pbMessage) Map(field int) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, f := range m[field] {
+		entry, err := pbDecode(f.data)
+		if err != nil {
+			return nil, err
+		}
+		result[entry.String(1)] = entry.String(2)
+	}
+	return result, nil
+}
+
+func pbVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func pbKey(b []byte, field int, wire uint64) []byte {
+	return pbVarint(b, uint64(field)<<3|wire)
+}
+
+// As in proto3, what is zero is not sent.
+func pbInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return pbVarint(pbKey(b, field, 0), uint64(v))
+}
+
+func pbBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return pbInt(b, field, 1)
+}
+
+func pbBytes(b []byte, field int, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+	return append(pbVarint(pbKey(b, field, 2), uint64(len(data))), data...)
+}
+
+func pbString(b []byte, field int, s string) []byte {
+	return pbBytes(b, field, []byte(s))
+}
+
+// A message inside another is sent even when it is empty.
+func pbMessageField(b []byte, field int, data []byte) []byte {
+	return append(pbVarint(pbKey(b, field, 2), uint64(len(data))), data...)
+}
+
+func pbMap(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = pbMessageField(b, field, pbString(pbString(nil, 1, k), 2, m[k]))
+	}
+	return b
+}
+
+/*
+  Messages are framed with a byte that says whether they are
+  compressed, which they never are here, and a big endian length.
+*/
+func readGRPCMessage(r io.Reader) (pbMessage, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes is larger than %d", size, grpcMaxMessage)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+	}
+	m, err := pbDecode(data)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return m, nil
+}
+
+// The one message of a call that is not streamed from the caller.
+func readGRPCRequest(r io.Reader) (pbMessage, error) {
+	m, err := readGRPCMessage(r)
+	if err == io.EOF {
+		return nil, grpcErrorf(grpcInvalidArgument, "no request")
+	}
+	return m, err
+}
+
+/*
+  grpcStream answers a call.  Headers go with the first message, and
+  the status follows in the trailers, or in the headers alone when
+  there are no messages.
+*/
+type grpcStream struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *grpcStream) send(msg []byte) error {
+	if !s.started {
+		s.started = true
+		s.w.WriteHeader(http.StatusOK)
+	}
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := s.w.Write(append(prefix[:], msg...)); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *grpcStream) finish(status *grpcStatus) {
+	key := http.TrailerPrefix
+	if !s.started {
+		key = ""
+	}
+	s.w.Header().Set(key+"Grpc-Status", strconv.Itoa(status.Code))
+	if status.Message != "" {
+		s.w.Header().Set(key+"Grpc-Message", grpcEscape(status.Message))
+	}
+	if !s.started {
+		s.started = true
+		s.w.WriteHeader(http.StatusOK)
+	}
+}
+
+/*
+  grpcAuthWriter turns the errors of authWrappedHandler into the
+  status that gRPC callers understand.
+*/
+type grpcAuthWriter struct {
+	http.ResponseWriter
+	failed bool
+}
+
+func (aw *grpcAuthWriter) WriteHeader(status int) {
+	if status < 400 {
+		aw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	aw.failed = true
+	aw.Header().Del("Content-Length")
+	aw.Header().Set("Content-Type", "application/grpc")
+	(&grpcStream{w: aw.ResponseWriter}).finish(grpcStatusFor(status))
+}
+
+func (aw *grpcAuthWriter) Write(b []byte) (int, error) {
+	if aw.failed {
+		return len(b), nil
+	}
+	return aw.ResponseWriter.Write(b)
+}
+
+func (aw *grpcAuthWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok && !aw.failed {
+		f.Flush()
+	}
+}
+
+/*
+  The gRPC api of each tenant, on its own listener.  Tenants are
+  told apart by host alone, as gRPC calls have fixed paths.
+*/
+type grpcRouter struct {
+	Tenants []*Tenant
+}
+
+func (gr grpcRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := tenantRouter{Tenants: gr.Tenants}.findByHost(r)
+	if t == nil || t.grpc == nil {
+		w.Header().Set("Content-Type", "application/grpc")
+		(&grpcStream{w: w}).finish(&grpcStatus{Code: grpcNotFound, Message: "no such tenant"})
+		return
+	}
+	ctx := context.WithValue(r.Context(), "tenant", t)
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		// A client certificate stands in for the user it names
+		username := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if username != "" {
+			noteUser(ctx, username)
+			t.grpc.ServeHTTP(w, r.WithContext(context.WithValue(ctx, "username", username)))
+			return
+		}
+	}
+	(&authWrappedHandler{Handler: t.grpc}).ServeHTTP(&grpcAuthWriter{ResponseWriter: w}, r.WithContext(ctx))
+}
+
+func (h grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Del("WWW-Authenticate")
+	if r.Method != "POST" || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	s := &grpcStream{w: w}
+	ctx := r.Context()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := parseGRPCTimeout(timeout)
+		if err != nil {
+			s.finish(&grpcStatus{Code: grpcInvalidArgument, Message: err.Error()})
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	calls := map[string]func(*grpcStream, *http.Request) error{
+		"Stat":     h.stat,
+		"List":     h.list,
+		"Read":     h.read,
+		"Write":    h.write,
+		"GetProps": h.getProps,
+		"SetProps": h.setProps,
+	}
+	call, ok := calls[strings.TrimPrefix(r.URL.Path, grpcService)]
+	err := ctx.Err()
+	switch {
+	case !ok:
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	case err == nil:
+		err = call(s, r)
+	}
+	// a call that ran out of time failed, even if it got to the end
+	if err == nil {
+		err = ctx.Err()
+	}
+	status := grpcStatusOf(ctx, err)
+	if status.Code == grpcInternal || status.Code == grpcUnknown {
+		webdav.Log().Warn("grpc call failed", "request_id", webdav.RequestID(ctx), "user", ctx.Value("username"), "method", r.URL.Path, "err", err)
+	}
+	s.finish(status)
+}
+
+// The name that a call is about, if it is one that can be reached.
+func (h grpcHandler) name(ctx context.Context, p string) (string, error) {
+	if p == "" {
+		return "", grpcErrorf(grpcInvalidArgument, "path is required")
+	}
+	name := webdav.SlashClean(p)
+	if isMetadataPath(name) {
+		return "", grpcErrorf(grpcNotFound, "%s not found", name)
+	}
+	if !mfaOf(ctx) && requiresMFA(ctx, h.Tenant.fsys, name) {
+		return "", grpcErrorf(grpcUnauthenticated, mfaNeeded)
+	}
+	return name, nil
+}
+
+func (h grpcHandler) stat(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, req.String(1))
+	if err != nil {
+		return err
+	}
+	fi, err := h.Tenant.fsys.Stat(ctx, name)
+	if err != nil {
+		return grpcErrorf(grpcNotFound, "%s not found", name)
+	}
+	return s.send(grpcFileInfo(ctx, name, fi))
+}
+
+func grpcFileInfo(ctx context.Context, name string, fi os.FileInfo) []byte {
+	etag, _ := webdav.ETagOf(ctx, fi)
+	b := pbString(nil, 1, name)
+	b = pbString(b, 2, fi.Name())
+	b = pbInt(b, 3, fi.Size())
+	b = pbInt(b, 4, fi.ModTime().UnixNano())
+	b = pbBool(b, 5, fi.IsDir())
+	return pbString(b, 6, etag)
+}
+
+/*
+  List a directory in order of name.  Page tokens are the last name
+  of the page before, so a page is never thrown off by what is added
+  or removed in the meantime.
+*/
+func (h grpcHandler) list(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, req.String(1))
+	if err != nil {
+		return err
+	}
+	size := int(req.Int(2))
+	switch {
+	case size < 0:
+		return grpcErrorf(grpcInvalidArgument, "page_size cannot be negative")
+	case size == 0:
+		size = 100
+	case size > 1000:
+		size = 1000
+	}
+	after, err := base64.RawURLEncoding.DecodeString(req.String(3))
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "bad page_token")
+	}
+	if fi, err := h.Tenant.fsys.Stat(ctx, name); err != nil {
+		return grpcErrorf(grpcNotFound, "%s not found", name)
+	} else if !fi.IsDir() {
+		return grpcErrorf(grpcFailedPrecondition, "%s is not a directory", name)
+	}
+	infos, err := visibleChildren(ctx, h.Tenant.fsys, name)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	var b []byte
+	count := 0
+	for i, fi := range infos {
+		if fi.Name() <= string(after) {
+			continue
+		}
+		if count == size {
+			b = pbString(b, 2, base64.RawURLEncoding.EncodeToString([]byte(infos[i-1].Name())))
+			break
+		}
+		b = pbMessageField(b, 1, grpcFileInfo(ctx, webdav.SlashClean(name+"/"+fi.Name()), fi))
+		count++
+	}
+	return s.send(b)
+}
+
+/*
+  grpcReader streams what a WebDAV GET writes as ReadResponses, or
+  keeps the status when it fails.
+*/
+type grpcReader struct {
+	s      *grpcStream
+	header http.Header
+	status int
+	buf    []byte
+	err    error
+}
+
+func (gr *grpcReader) Header() http.Header {
+	return gr.header
+}
+
+func (gr *grpcReader) WriteHeader(status int) {
+	if gr.status == 0 {
+		gr.status = status
+	}
+}
+
+func (gr *grpcReader) Write(b []byte) (int, error) {
+	gr.WriteHeader(http.StatusOK)
+	if gr.status >= 300 || gr.err != nil {
+		return len(b), nil
+	}
+	gr.buf = append(gr.buf, b...)
+	for len(gr.buf) >= grpcReadChunk && gr.err == nil {
+		gr.err = gr.s.send(pbBytes(nil, 1, gr.buf[:grpcReadChunk]))
+		gr.buf = gr.buf[grpcReadChunk:]
+	}
+	if gr.err != nil {
+		return 0, gr.err
+	}
+	return len(b), nil
+}
+
+func (h grpcHandler) read(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, req.String(1))
+	if err != nil {
+		return err
+	}
+	offset, length := req.Int(2), req.Int(3)
+	if offset < 0 || length < 0 {
+		return grpcErrorf(grpcInvalidArgument, "offset and length cannot be negative")
+	}
+	fi, err := h.Tenant.fsys.Stat(ctx, name)
+	if err != nil {
+		return grpcErrorf(grpcNotFound, "%s not found", name)
+	}
+	if fi.IsDir() {
+		return grpcErrorf(grpcFailedPrecondition, "%s is a directory", name)
+	}
+	get := davRequest(r, h.Tenant, "GET", name, nil, 0)
+	if offset > 0 || length > 0 {
+		if offset >= fi.Size() {
+			return grpcErrorf(grpcOutOfRange, "offset %d is past the end", offset)
+		}
+		rng := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			rng += strconv.FormatInt(offset+length-1, 10)
+		}
+		get.Header.Set("Range", rng)
+	}
+	gr := &grpcReader{s: s, header: make(http.Header)}
+	h.DAV.ServeHTTP(gr, get)
+	if gr.status >= 300 {
+		return grpcStatusFor(gr.status)
+	}
+	if gr.err != nil {
+		return gr.err
+	}
+	if len(gr.buf) > 0 || !s.started {
+		return s.send(pbBytes(nil, 1, gr.buf))
+	}
+	return ctx.Err()
+}
+
+var errWriteDone = errors.New("the write is over")
+
+/*
+  Stream what the caller sends into a WebDAV PUT.  If the PUT fails
+  before it has read everything, what is left is not waited for.
+*/
+func (h grpcHandler) write(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	first, err := readGRPCMessage(r.Body)
+	if err == io.EOF {
+		return grpcErrorf(grpcInvalidArgument, "no request")
+	}
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, first.String(1))
+	if err != nil {
+		return err
+	}
+	if err := mayWrite(ctx, h.Tenant.fsys, name); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	put := davRequest(r, h.Tenant, "PUT", name, pr, -1)
+	done := make(chan *statusRecorder, 1)
+	go func() {
+		res := &statusRecorder{header: make(http.Header)}
+		h.DAV.ServeHTTP(res, put)
+		pr.CloseWithError(errWriteDone)
+		done <- res
+	}()
+	_, err = pw.Write(first.Bytes(2))
+	for err == nil {
+		var m pbMessage
+		m, err = readGRPCMessage(r.Body)
+		if err != nil {
+			break
+		}
+		if p := m.String(1); p != "" && webdav.SlashClean(p) != name {
+			err = grpcErrorf(grpcInvalidArgument, "every message must be for %s", name)
+			break
+		}
+		_, err = pw.Write(m.Bytes(2))
+	}
+	if err == io.EOF {
+		pw.Close()
+	} else {
+		pw.CloseWithError(err)
+	}
+	res := <-done
+	if err != nil && err != io.EOF && err != errWriteDone {
+		return err
+	}
+	if res.status >= 300 {
+		return grpcStatusFor(res.status)
+	}
+	fi, err := h.Tenant.fsys.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.send(grpcFileInfo(ctx, name, fi))
+}
+
+// The dead properties of name that the user may see, as Props.
+func (h grpcHandler) props(ctx context.Context, name string) ([]byte, error) {
+	props, err := deadProps(ctx, h.Tenant.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return pbMap(nil, 1, props), nil
+}
+
+func (h grpcHandler) getProps(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, req.String(1))
+	if err != nil {
+		return err
+	}
+	b, err := h.props(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.send(b)
+}
+
+// Set and remove properties, as PROPPATCH does.
+func (h grpcHandler) setProps(s *grpcStream, r *http.Request) error {
+	ctx := r.Context()
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name, err := h.name(ctx, req.String(1))
+	if err != nil {
+		return err
+	}
+	set, err := req.Map(2)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if err := propPatch(r, h.Tenant, h.DAV, name, set, req.Strings(3)); err != nil {
+		return err
+	}
+	b, err := h.props(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.send(b)
+}
+
+/*
+  TLS for the gRPC listener, which takes client certificates signed
+  by a CA in caFile, if there is one.
+*/
+func grpcTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
+// gRPC needs HTTP/2, which needs TLS here, with cert.pem and key.pem as for -s.
+func listenGRPC(ln net.Listener, config *tls.Config, handler http.Handler) {
+	srv := &http.Server{Handler: handler, TLSConfig: config, ReadHeaderTimeout: headerTimeout}
+	webdav.Log().Info("starting grpc server", "url", fmt.Sprintf("https://%s", ln.Addr()))
+	if err := srv.ServeTLS(ln, "", ""); err != nil {
+		log.Fatalf("WEBDAV: cannot serve grpc: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+var ErrImpersonationDenied = errors.New("webdav: impersonation not allowed")
+
+// The header that a service account names the user it acts for in
+const impersonateHeader = "X-Impersonate"
+
+/*
+  Which service accounts may act as which users, so that backup and
+  migration tools see each user's files as that user would, and
+  policies decide as they would for them.
+
+    {
+      "backup":   ["*"],
+      "migrator": ["jp", "contractor-*"]
+    }
+
+  Users are names, or patterns as path.Match takes them.  An account
+  that is not here may not act as anyone.
+*/
+type impersonationPolicy map[string][]string
+
+// The policy, which a reload replaces
+var impersonation = struct {
+	sync.RWMutex
+	policy impersonationPolicy
+}{}
+
+func loadImpersonationPolicy(file string) (impersonationPolicy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p impersonationPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	for account, users := range p {
+		for _, u := range users {
+			if _, err := path.Match(u, ""); err != nil {
+				return nil, fmt.Errorf("%s: bad pattern %q: %v", account, u, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func setupImpersonation(file string) {
+	if file == "" {
+		return
+	}
+	p, err := loadImpersonationPolicy(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot load impersonation policy: %v", err)
+	}
+	impersonation.policy = p
+	webdav.Log().Info("using impersonation policy", "file", file, "accounts", len(p))
+}
+
+// Whether account may act as user.
+func (p impersonationPolicy) allows(account, user string) bool {
+	if account == user {
+		return false
+	}
+	for _, pattern := range p[account] {
+		if ok, _ := path.Match(pattern, user); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  Act as the user that r names in X-Impersonate, on behalf of the
+  service account that logged in, if the impersonation policy lets
+  it.  The claims and password of the account are left behind, so
+  that the claims of the user are looked up, and remotes are not
+  logged in to as the account.  Every request made this way is
+  audited, as are those refused, and the audit records of whatever
+  it changes say which account it was.
+*/
+func impersonate(ctx context.Context, r *http.Request, account string) (*http.Request, error) {
+	user := r.Header.Get(impersonateHeader)
+	rec := AuditRecord{User: user, Action: "impersonate", Target: r.Method + " " + r.URL.Path, Impersonator: account}
+	impersonation.RLock()
+	p := impersonation.policy
+	impersonation.RUnlock()
+	err := validUsername(user)
+	if err == nil && !p.allows(account, user) {
+		err = ErrImpersonationDenied
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		audit(ctx, rec)
+		return r, err
+	}
+	audit(ctx, rec)
+	noteUser(ctx, user)
+	ctx = context.WithValue(ctx, "username", user)
+	ctx = context.WithValue(ctx, "impersonator", account)
+	ctx = context.WithValue(ctx, "claims", nil)
+	ctx = context.WithValue(ctx, "password", nil)
+	return r.WithContext(ctx), nil
+}
@@ -0,0 +1,181 @@
+package example1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  The background maintenance jobs for a tenant.
+  Only the jobs named in the -j flag are started.
+*/
+func startJobs(t *Tenant, enabled string) {
+	scheduler := fs.NewScheduler()
+	t.scheduler = scheduler
+	if lc, ok := t.fsys.Locks.(fs.LockCollector); ok {
+		scheduler.Add(fs.LockGCJob(lc, time.Minute))
+	}
+	scheduler.Add(fs.OrphanGCJob(t.fsys, time.Hour))
+	if t.shares != nil {
+		scheduler.Add(shareGCJob(t.shares, time.Hour))
+	}
+	if t.replicator != nil {
+		scheduler.Add(replicateJob(t.replicator, 10*time.Second))
+	}
+	if t.snapshots != nil {
+		scheduler.Add(snapshotJob(t))
+	}
+	if trashFor > 0 {
+		scheduler.Add(trashJob(t, time.Hour))
+	}
+	scheduler.Add(policyVersionsJob(t, time.Hour))
+	scheduler.Add(quotaJob(t, time.Minute))
+	for _, name := range strings.Split(enabled, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || (name == "shares" && t.shares == nil) || (name == "replicate" && t.replicator == nil) || (name == "snapshot" && t.snapshots == nil) || (name == "trash" && trashFor <= 0) {
+			continue
+		}
+		if err := scheduler.Enable(name, true); err != nil {
+			webdav.Log().Warn("cannot enable job", "err", err, "known", scheduler.Names())
+		}
+	}
+	scheduler.Start(context.Background())
+}
+
+/*
+  How the maintenance jobs of a tenant are doing, for admins, and
+  running one of them now, rather than waiting for it, whether or not
+  -j started it:
+
+    curl -u rob:rob -k 'https://localhost:8000/.__api/maintenance/jobs'
+    curl -u rob:rob -k -X POST 'https://localhost:8000/.__api/maintenance/jobs?name=sidecars'
+*/
+func maintenanceJobsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		scheduler := tenantOf(ctx).scheduler
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, scheduler.Stats())
+		case "POST":
+			name := r.URL.Query().Get("name")
+			if _, ok := scheduler.Stats()[name]; !ok {
+				writeJsonError(w, http.StatusNotFound, fmt.Errorf("no such job: %q", name))
+				return
+			}
+			rec := AuditRecord{User: username, Action: "run job", Target: name}
+			err := scheduler.RunNow(ctx, name)
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusOK, scheduler.Stats()[name])
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+// How long what is deleted is kept in the trash, or 0 for no trash
+var trashFor time.Duration
+
+// The trash of a volume at root, beside it, on the same file system.
+func trashOf(root string) string {
+	if trashFor <= 0 {
+		return ""
+	}
+	return filepath.Clean(root) + ".trash"
+}
+
+// Empty what has been in the trash for longer than -trash, of the volume and its mounts.
+func trashJob(t *Tenant, interval time.Duration) fs.Job {
+	return fs.Job{
+		Name:     "trash",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			volumes := []fs.FS{t.fsys}
+			for _, m := range t.mounts {
+				if fsys, ok := m.FileSystem.(fs.FS); ok {
+					volumes = append(volumes, fsys)
+				}
+			}
+			for _, fsys := range volumes {
+				if err := fs.TrashExpiryJob(fsys, trashFor, interval).Run(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/*
+  What a tenant uses of its quota, as the quota job last counted it,
+  with what has been written since, so that an upload is not held
+  up walking the volume.  Until the job has counted, it is not
+  known, and quotaHandler walks the volume itself.  What is written
+  is added as it comes, and what is removed only when it is counted
+  again, so that a tenant is never let over its quota in between.
+*/
+type usageCount struct {
+	mu      sync.Mutex
+	bytes   int64
+	counted bool
+}
+
+func (u *usageCount) get() (int64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.bytes, u.counted
+}
+
+func (u *usageCount) set(bytes int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes, u.counted = bytes, true
+}
+
+func (u *usageCount) add(bytes int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes += bytes
+}
+
+// Count what a tenant with a quota uses again, from the volume.
+func quotaJob(t *Tenant, interval time.Duration) fs.Job {
+	return fs.Job{
+		Name:     "quota",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			if atomic.LoadInt64(&t.Quota) <= 0 {
+				return nil
+			}
+			usage, err := t.fsys.Usage(ctx, "/")
+			if err != nil {
+				return err
+			}
+			t.used.set(usage.Bytes + usage.MetadataBytes)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,347 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A change to the volume, numbered in the order that they were
+  made.  The numbers carry on across restarts, so a client that
+  has seen up to a number can ask for what came after it.
+*/
+type Change struct {
+	Seq         uint64    `json:"seq"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user,omitempty"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Destination string    `json:"destination,omitempty"`
+}
+
+// An offset into the journal is kept for every this many changes
+const journalMarkEvery = 1024
+
+// The most changes returned at once
+const maxChanges = 1000
+
+type journalMark struct {
+	seq    uint64
+	offset int64
+}
+
+/*
+  Every change to a tenant's volume, as lines of json appended to
+  a file, and synced before the change is reported.
+*/
+type changeJournal struct {
+	mu    sync.Mutex
+	name  string
+	file  *os.File
+	seq   uint64
+	size  int64
+	marks []journalMark
+}
+
+func openJournal(name string) (*changeJournal, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &changeJournal{name: name, file: f}
+	if err := j.index(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return j, nil
+}
+
+/*
+  Find the last sequence number, and where to start reading for
+  any other.  A torn last line, from a crash, is cut off.
+*/
+func (j *changeJournal) index() error {
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(j.file)
+	offset := int64(0)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				webdav.Log().Warn("cutting off a torn journal entry", "journal", j.name, "offset", offset)
+				if err := j.file.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return fmt.Errorf("bad entry at %d: %v", offset, err)
+		}
+		if c.Seq <= j.seq {
+			return fmt.Errorf("sequence %d after %d", c.Seq, j.seq)
+		}
+		if len(j.marks) == 0 || c.Seq-j.marks[len(j.marks)-1].seq >= journalMarkEvery {
+			j.marks = append(j.marks, journalMark{seq: c.Seq, offset: offset})
+		}
+		j.seq = c.Seq
+		offset += int64(len(line))
+	}
+	j.size = offset
+	_, err := j.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// last is the sequence number of the newest change.
+func (j *changeJournal) last() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+func (j *changeJournal) append(c Change) (Change, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c.Seq = j.seq + 1
+	line, err := json.Marshal(c)
+	if err != nil {
+		return c, err
+	}
+	line = append(line, '\n')
+	if _, err := j.file.WriteAt(line, j.size); err != nil {
+		return c, err
+	}
+	if err := j.file.Sync(); err != nil {
+		return c, err
+	}
+	if len(j.marks) == 0 || c.Seq-j.marks[len(j.marks)-1].seq >= journalMarkEvery {
+		j.marks = append(j.marks, journalMark{seq: c.Seq, offset: j.size})
+	}
+	j.seq = c.Seq
+	j.size += int64(len(line))
+	return c, nil
+}
+
+/*
+  Changes after a sequence number, oldest first, for as long as
+  keep says to go on.
+*/
+func (j *changeJournal) since(after uint64, keep func(c Change) bool) error {
+	j.mu.Lock()
+	f, offset, size := j.file, int64(0), j.size
+	for _, m := range j.marks {
+		if m.seq > after {
+			break
+		}
+		offset = m.offset
+	}
+	j.mu.Unlock()
+	r := bufio.NewReader(io.NewSectionReader(f, offset, size-offset))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		if c.Seq > after && !keep(c) {
+			return nil
+		}
+	}
+}
+
+/*
+  Drop the name of a user from their changes, for an erasure.  The
+  journal is written anew, with the same sequence numbers.
+*/
+func (j *changeJournal) forget(user string) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	tmp, err := os.OpenFile(j.name+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	scrubbed := 0
+	w := bufio.NewWriter(tmp)
+	r := bufio.NewReader(io.NewSectionReader(j.file, 0, j.size))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		var c Change
+		if err := json.Unmarshal(line, &c); err == nil && c.User == user {
+			c.User = ""
+			scrubbed++
+			line, _ = json.Marshal(c)
+			line = append(line, '\n')
+		}
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := os.Rename(j.name+".tmp", j.name); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	j.file.Close()
+	j.file = tmp
+	j.seq, j.size, j.marks = 0, 0, nil
+	return scrubbed, j.index()
+}
+
+// The methods that change the volume
+var journaledMethods = auditedMethods
+
+/*
+  Note a request that changed the volume.  A change that cannot be
+  journaled is logged, since it has already been made.
+*/
+func (j *changeJournal) record(r *http.Request, name string) {
+	if j == nil || !journaledMethods[r.Method] {
+		return
+	}
+	ctx := r.Context()
+	username, _ := ctx.Value("username").(string)
+	c := Change{Time: time.Now().UTC(), User: username, Method: r.Method, Path: name}
+	if dst := r.Header.Get("Destination"); dst != "" {
+		if u, err := url.Parse(dst); err == nil {
+			c.Destination = webdav.SlashClean(strings.TrimPrefix(u.Path, tenantOf(ctx).Prefix))
+		}
+	}
+	if _, err := j.append(c); err != nil {
+		webdav.Log().Error("cannot journal change", "request_id", webdav.RequestID(ctx), "journal", j.name, "err", err)
+	}
+}
+
+/*
+  Changes after a cursor, to what you can see, for clients that
+  keep a copy in sync.
+
+    GET /.__api/changes?since=0&limit=100
+
+  The cursor that comes back is where to ask from next time.  It
+  moves past changes that you cannot see, so they are not asked
+  about again.  A path that is gone is only shown for a DELETE or
+  MOVE, as the name that was removed.
+*/
+func changesHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		ctx := r.Context()
+		j := tenantOf(ctx).journal
+		if j == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no change journal"))
+			return
+		}
+		since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+		if err != nil && r.URL.Query().Get("since") != "" {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > maxChanges {
+			limit = maxChanges
+		}
+		last := j.last()
+		changes := make([]Change, 0)
+		cursor := since
+		err = j.since(since, func(c Change) bool {
+			if c.Seq > last || len(changes) >= limit {
+				return false
+			}
+			cursor = c.Seq
+			if visibleChange(ctx, fsys, c) {
+				changes = append(changes, c)
+			}
+			return true
+		})
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"changes": changes,
+			"cursor":  cursor,
+			"more":    cursor < last,
+		})
+	})
+}
+
+func visibleChange(ctx context.Context, fsys fs.FS, c Change) bool {
+	if _, err := fsys.Stat(ctx, c.Path); err == nil {
+		return true
+	}
+	if c.Destination != "" {
+		if _, err := fsys.Stat(ctx, c.Destination); err == nil {
+			return true
+		}
+	}
+	// what was removed can be seen if its parent can
+	if c.Method == "DELETE" || c.Method == "MOVE" {
+		_, err := fsys.Stat(ctx, path.Dir(c.Path))
+		return err == nil
+	}
+	return false
+}
+
+/*
+  Fill the recent activity of a tenant from its journal, so that
+  the feed outlives a restart.
+*/
+func (l *activityLog) replay(j *changeJournal) error {
+	if j == nil {
+		return nil
+	}
+	return j.since(0, func(c Change) bool {
+		if c.User == "" || !feedMethods[c.Method] {
+			return true
+		}
+		l.mu.Lock()
+		list := append(l.users[c.User], Activity{Time: c.Time, User: c.User, Method: c.Method, Path: c.Path})
+		if len(list) > maxActivity {
+			list = list[len(list)-maxActivity:]
+		}
+		l.users[c.User] = list
+		l.mu.Unlock()
+		return true
+	})
+}
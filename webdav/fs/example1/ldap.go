@@ -0,0 +1,895 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Check passwords against LDAP, or Active Directory, and take the
+  claims of users from their directory entries.
+
+    {
+      "url": "ldaps://ldap.example.com",
+      "bind_dn": "cn=webdev,ou=services,dc=example,dc=com",
+      "bind_password": "secret",
+      "base_dn": "ou=people,dc=example,dc=com",
+      "user_filter": "(&(objectClass=person)(uid=%s))",
+      "attributes": {"citizen": "c", "age": "employeeType"}
+    }
+
+  Users are found with user_filter, with %s as the username, and
+  their password is checked by binding as what is found.  Groups
+  are the common names of memberOf, or of what group_filter finds
+  under group_base_dn, with %s as the DN of the user.  For Active
+  Directory, nested groups are found with:
+
+    "group_filter": "(member:1.2.840.113556.1.4.1941:=%s)"
+
+  The policies get the groups as claims.groups.groups, and each
+  of attributes as the claim it is mapped to.
+*/
+type LDAPConfig struct {
+	URL      string `json:"url"`
+	StartTLS bool   `json:"start_tls,omitempty"`
+	// File of CA certificates to trust.  Default is the system's.
+	CA           string `json:"ca,omitempty"`
+	BindDN       string `json:"bind_dn,omitempty"`
+	BindPassword string `json:"bind_password,omitempty"`
+	BaseDN       string `json:"base_dn"`
+	UserFilter   string `json:"user_filter"`
+	GroupBaseDN  string `json:"group_base_dn,omitempty"`
+	GroupFilter  string `json:"group_filter,omitempty"`
+	// The claim that groups go into.  Default is groups.
+	GroupsClaim string `json:"groups_claim,omitempty"`
+	// Claims to fill from attributes of the user, as claim: attribute
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Connections to keep open.  Default is 4.
+	Pool int `json:"pool,omitempty"`
+	// How long to keep claims, and passwords that worked.  Default is 5m.
+	Cache string `json:"cache,omitempty"`
+	// How long to wait on the server.  Default is 10s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Read from the environment when the config has no bind_password
+const ldapPasswordEnv = "WEBDEV_LDAP_PASSWORD"
+
+const (
+	ldapSuccess             = 0
+	ldapInvalidCredentials  = 49
+	ldapStartTLSOID         = "1.3.6.1.4.1.1466.20037"
+	ldapMaxMessage          = 16 << 20
+	ldapScopeSubtree        = 2
+	ldapDerefNever          = 0
+	ldapDefaultGroupsClaim  = "groups"
+	ldapDefaultPool         = 4
+	ldapDefaultCacheTimeout = 5 * time.Minute
+)
+
+// BER tags of LDAP messages, RFC 4511
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berBoolean     = 0x01
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+)
+
+type ldapError struct {
+	Code    int
+	Message string
+}
+
+func (e ldapError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result %d: %s", e.Code, e.Message)
+}
+
+var ErrBadPassword = fmt.Errorf("wrong username or password")
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := append([]byte{tag}, berLength(n)...)
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+// berNext splits the first element off of b.
+func berNext(b []byte) (tag byte, content []byte, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	tag = b[0]
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, fmt.Errorf("ldap: bad length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n > len(b) {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+func berAsInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// berRead reads one whole message.
+func berRead(r *bufio.Reader) ([]byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	n := int(head[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return nil, fmt.Errorf("ldap: bad length")
+		}
+		ext := make([]byte, size)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		head = append(head, ext...)
+		n = berAsInt(ext)
+	}
+	if n > ldapMaxMessage {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too big", n)
+	}
+	msg := make([]byte, len(head)+n)
+	copy(msg, head)
+	_, err := io.ReadFull(r, msg[len(head):])
+	return msg, err
+}
+
+/*
+  Escape a value to go into a filter, RFC 4515, so that a
+  username cannot change what the filter matches.
+*/
+func ldapEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func ldapUnescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: bad escape in %q", s)
+		}
+		b = append(b, c...)
+		i += 2
+	}
+	return string(b), nil
+}
+
+/*
+  Compile a filter, such as (&(objectClass=person)(uid=rob)), to
+  BER.  Equality, presence and extensible matches can be joined
+  with &, | and !.
+*/
+func ldapFilter(s string) ([]byte, error) {
+	f, rest, err := ldapParseFilter(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: trailing %q in filter", rest)
+	}
+	return f, nil
+}
+
+func ldapParseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter %q must start with (", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	switch s[0] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': 0xa0, '|': 0xa1, '!': 0xa2}[s[0]]
+		s = s[1:]
+		var parts [][]byte
+		for strings.HasPrefix(s, "(") {
+			part, rest, err := ldapParseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") || len(parts) == 0 || (tag == 0xa2 && len(parts) != 1) {
+			return nil, "", fmt.Errorf("ldap: bad filter near %q", s)
+		}
+		return berTLV(tag, parts...), s[1:], nil
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("ldap: bad filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.HasSuffix(attr, ":") {
+		// attr:rule: or attr:dn:rule:
+		fields := strings.Split(strings.TrimSuffix(attr, ":"), ":")
+		v, err := ldapUnescape(value)
+		if err != nil {
+			return nil, "", err
+		}
+		var parts [][]byte
+		dn := false
+		for i, f := range fields[1:] {
+			if f == "dn" && i == 0 {
+				dn = true
+				continue
+			}
+			parts = append(parts, berString(0x81, f))
+		}
+		if fields[0] != "" {
+			parts = append(parts, berString(0x82, fields[0]))
+		}
+		parts = append(parts, berString(0x83, v))
+		if dn {
+			parts = append(parts, berTLV(0x84, []byte{0xff}))
+		}
+		return berTLV(0xa9, parts...), rest, nil
+	}
+	if value == "*" {
+		return berString(0x87, attr), rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("ldap: substring filters are not supported: %q", item)
+	}
+	v, err := ldapUnescape(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berTLV(0xa3, berString(berOctetString, attr), berString(berOctetString, v)), rest, nil
+}
+
+// The leading common name of a DN, as a group name.
+func ldapCN(dn string) string {
+	rdn := dn
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' {
+			i++
+			continue
+		}
+		if dn[i] == ',' {
+			rdn = dn[:i]
+			break
+		}
+	}
+	if eq := strings.IndexByte(rdn, '='); eq >= 0 {
+		rdn = rdn[eq+1:]
+	}
+	return strings.ReplaceAll(strings.TrimSpace(rdn), "\\", "")
+}
+
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// The values of an attribute, whatever its case
+func (e ldapEntry) get(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+/*
+  One connection to the server.  Requests on it are made one at
+  a time, by whoever took it from the pool.
+*/
+type ldapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	id      int
+	timeout time.Duration
+	// bound as the service account, rather than a user
+	service bool
+}
+
+func (c *ldapConn) roundTrip(op []byte, handle func(tag byte, content []byte) (bool, error)) error {
+	c.id++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(berTLV(berSequence, berInt(berInteger, c.id), op)); err != nil {
+		return err
+	}
+	for {
+		msg, err := berRead(c.r)
+		if err != nil {
+			return err
+		}
+		_, body, _, err := berNext(msg)
+		if err != nil {
+			return err
+		}
+		_, id, body, err := berNext(body)
+		if err != nil {
+			return err
+		}
+		if berAsInt(id) != c.id {
+			// a notice of disconnection, or something stale
+			return fmt.Errorf("ldap: unexpected message %d", berAsInt(id))
+		}
+		tag, content, _, err := berNext(body)
+		if err != nil {
+			return err
+		}
+		done, err := handle(tag, content)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func ldapResult(content []byte) error {
+	_, code, rest, err := berNext(content)
+	if err != nil {
+		return err
+	}
+	_, _, rest, err = berNext(rest)
+	if err != nil {
+		return err
+	}
+	_, message, _, _ := berNext(rest)
+	if c := berAsInt(code); c != ldapSuccess {
+		return ldapError{Code: c, Message: string(message)}
+	}
+	return nil
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	op := berTLV(ldapBindRequest, berInt(berInteger, 3), berString(berOctetString, dn), berString(0x80, password))
+	return c.roundTrip(op, func(tag byte, content []byte) (bool, error) {
+		if tag != ldapBindResponse {
+			return true, fmt.Errorf("ldap: unexpected response %#x to bind", tag)
+		}
+		return true, ldapResult(content)
+	})
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	op := berTLV(ldapExtendedRequest, berString(0x80, ldapStartTLSOID))
+	err := c.roundTrip(op, func(tag byte, content []byte) (bool, error) {
+		if tag != ldapExtendedResponse {
+			return true, fmt.Errorf("ldap: unexpected response %#x to starttls", tag)
+		}
+		return true, ldapResult(content)
+	})
+	if err != nil {
+		return err
+	}
+	conn := tls.Client(c.conn, config)
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	return nil
+}
+
+func (c *ldapConn) search(base, filter string, attributes []string) ([]ldapEntry, error) {
+	f, err := ldapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([][]byte, 0, len(attributes))
+	for _, a := range attributes {
+		attrs = append(attrs, berString(berOctetString, a))
+	}
+	op := berTLV(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, ldapScopeSubtree),
+		berInt(berEnumerated, ldapDerefNever),
+		berInt(berInteger, 0),
+		berInt(berInteger, int(c.timeout/time.Second)),
+		berTLV(berBoolean, []byte{0}),
+		f,
+		berTLV(berSequence, attrs...),
+	)
+	var entries []ldapEntry
+	err = c.roundTrip(op, func(tag byte, content []byte) (bool, error) {
+		switch tag {
+		case ldapSearchEntry:
+			e, err := ldapParseEntry(content)
+			if err != nil {
+				return true, err
+			}
+			entries = append(entries, e)
+			return false, nil
+		case ldapSearchReference:
+			return false, nil
+		case ldapSearchDone:
+			return true, ldapResult(content)
+		}
+		return true, fmt.Errorf("ldap: unexpected response %#x to search", tag)
+	})
+	return entries, err
+}
+
+func ldapParseEntry(content []byte) (ldapEntry, error) {
+	e := ldapEntry{Attributes: make(map[string][]string)}
+	_, dn, rest, err := berNext(content)
+	if err != nil {
+		return e, err
+	}
+	e.DN = string(dn)
+	_, list, _, err := berNext(rest)
+	if err != nil {
+		return e, err
+	}
+	for len(list) > 0 {
+		var attr []byte
+		_, attr, list, err = berNext(list)
+		if err != nil {
+			return e, err
+		}
+		_, name, vals, err := berNext(attr)
+		if err != nil {
+			return e, err
+		}
+		_, vals, _, err = berNext(vals)
+		if err != nil {
+			return e, err
+		}
+		for len(vals) > 0 {
+			var v []byte
+			_, v, vals, err = berNext(vals)
+			if err != nil {
+				return e, err
+			}
+			e.Attributes[string(name)] = append(e.Attributes[string(name)], string(v))
+		}
+	}
+	return e, nil
+}
+
+func (c *ldapConn) close() {
+	c.id++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(berTLV(berSequence, berInt(berInteger, c.id), berTLV(ldapUnbindRequest)))
+	c.conn.Close()
+}
+
+type ldapCached struct {
+	claims  Claims
+	expires time.Time
+	// a salted hash of the password that last worked, if one did
+	password []byte
+}
+
+/*
+  Claims, and password checks, from a directory.  Connections are
+  pooled and bound as the service account, and what is found is
+  cached, since a WebDAV client sends its password with every
+  request.
+*/
+type ldapProvider struct {
+	config  LDAPConfig
+	u       *url.URL
+	tls     *tls.Config
+	timeout time.Duration
+	ttl     time.Duration
+	pool    chan *ldapConn
+	salt    [16]byte
+	mu      sync.Mutex
+	cache   map[string]ldapCached
+}
+
+func newLDAPProvider(config LDAPConfig) (*ldapProvider, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("ldap url must be ldap:// or ldaps://, not %s", config.URL)
+	}
+	if config.BaseDN == "" || config.UserFilter == "" {
+		return nil, fmt.Errorf("ldap needs a base_dn and a user_filter")
+	}
+	if _, err := ldapFilter(fmt.Sprintf(config.UserFilter, "x")); err != nil {
+		return nil, err
+	}
+	if config.BindPassword == "" {
+		config.BindPassword = os.Getenv(ldapPasswordEnv)
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = ldapDefaultGroupsClaim
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	if config.Pool <= 0 {
+		config.Pool = ldapDefaultPool
+	}
+	p := &ldapProvider{
+		config:  config,
+		u:       u,
+		tls:     &tls.Config{ServerName: u.Hostname()},
+		timeout: 10 * time.Second,
+		ttl:     ldapDefaultCacheTimeout,
+		pool:    make(chan *ldapConn, config.Pool),
+		cache:   make(map[string]ldapCached),
+	}
+	if config.Timeout != "" {
+		if p.timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if config.Cache != "" {
+		if p.ttl, err = time.ParseDuration(config.Cache); err != nil {
+			return nil, err
+		}
+	}
+	if config.CA != "" {
+		pem, err := ioutil.ReadFile(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		p.tls.RootCAs = x509.NewCertPool()
+		if !p.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.CA)
+		}
+	}
+	if _, err := rand.Read(p.salt[:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ldapProvider) dial() (*ldapConn, error) {
+	host := p.u.Host
+	if p.u.Port() == "" {
+		if p.u.Scheme == "ldaps" {
+			host = net.JoinHostPort(p.u.Hostname(), "636")
+		} else {
+			host = net.JoinHostPort(p.u.Hostname(), "389")
+		}
+	}
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var conn net.Conn
+	var err error
+	if p.u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, p.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn), timeout: p.timeout}
+	if p.config.StartTLS && p.u.Scheme == "ldap" {
+		if err := c.startTLS(p.tls); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// get takes a connection bound as the service account.
+func (p *ldapProvider) get() (*ldapConn, error) {
+	var c *ldapConn
+	select {
+	case c = <-p.pool:
+	default:
+		var err error
+		if c, err = p.dial(); err != nil {
+			return nil, err
+		}
+	}
+	if !c.service {
+		if err := c.bind(p.config.BindDN, p.config.BindPassword); err != nil {
+			c.close()
+			return nil, err
+		}
+		c.service = true
+	}
+	return c, nil
+}
+
+// put gives back a connection that worked, or closes it if the pool is full.
+func (p *ldapProvider) put(c *ldapConn) {
+	select {
+	case p.pool <- c:
+	default:
+		c.close()
+	}
+}
+
+/*
+  Find a user, and their claims.  The entry is nil when there is
+  no such user.
+*/
+func (p *ldapProvider) lookup(c *ldapConn, username string) (*ldapEntry, Claims, error) {
+	claims := Claims{Groups: make(map[string][]string)}
+	attributes := []string{"memberOf"}
+	for _, a := range p.config.Attributes {
+		attributes = append(attributes, a)
+	}
+	filter := fmt.Sprintf(p.config.UserFilter, ldapEscape(username))
+	entries, err := c.search(p.config.BaseDN, filter, attributes)
+	if err != nil {
+		return nil, claims, err
+	}
+	if len(entries) == 0 {
+		return nil, claims, nil
+	}
+	if len(entries) > 1 {
+		return nil, claims, fmt.Errorf("ldap: %d entries for %s", len(entries), username)
+	}
+	user := entries[0]
+	claims.Groups["username"] = []string{username}
+	for claim, attr := range p.config.Attributes {
+		if v := user.get(attr); len(v) > 0 {
+			claims.Groups[claim] = v
+		}
+	}
+	groups := make(map[string]bool)
+	for _, dn := range user.get("memberOf") {
+		groups[ldapCN(dn)] = true
+	}
+	if p.config.GroupFilter != "" {
+		found, err := c.search(p.config.GroupBaseDN, fmt.Sprintf(p.config.GroupFilter, ldapEscape(user.DN)), []string{"cn"})
+		if err != nil {
+			return nil, claims, err
+		}
+		for _, g := range found {
+			if cn := g.get("cn"); len(cn) > 0 {
+				groups[cn[0]] = true
+			} else {
+				groups[ldapCN(g.DN)] = true
+			}
+		}
+	}
+	for g := range groups {
+		if g != "" {
+			claims.Groups[p.config.GroupsClaim] = append(claims.Groups[p.config.GroupsClaim], g)
+		}
+	}
+	return &user, claims, nil
+}
+
+func (p *ldapProvider) hash(password string) []byte {
+	sum := sha256.Sum256(append(p.salt[:], password...))
+	return sum[:]
+}
+
+func (p *ldapProvider) cached(username string) (ldapCached, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.cache[username]
+	if !ok || time.Now().After(c.expires) {
+		return ldapCached{}, false
+	}
+	return c, true
+}
+
+func (p *ldapProvider) remember(username string, c ldapCached) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, v := range p.cache {
+		if now.After(v.expires) {
+			delete(p.cache, k)
+		}
+	}
+	c.expires = now.Add(p.ttl)
+	p.cache[username] = c
+}
+
+// Forget the claims, and the password, of the user of scope, or of everyone.
+func (p *ldapProvider) forget(ctx context.Context, scope CacheScope) {
+	if scope.Path != "" && scope.User == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if scope.User == "" {
+		p.cache = make(map[string]ldapCached)
+		return
+	}
+	delete(p.cache, scope.User)
+}
+
+func (p *ldapProvider) Claims(ctx context.Context, root, username string) (Claims, error) {
+	if c, ok := p.cached(username); ok {
+		return c.claims, nil
+	}
+	conn, err := p.get()
+	if err != nil {
+		return Claims{}, err
+	}
+	user, claims, err := p.lookup(conn, username)
+	if err != nil {
+		conn.close()
+		return claims, err
+	}
+	p.put(conn)
+	if user == nil {
+		return claims, fmt.Errorf("ldap: no such user %s", username)
+	}
+	p.remember(username, ldapCached{claims: claims})
+	return claims, nil
+}
+
+/*
+  Check a password by binding as the user.  An empty password is
+  refused here, since servers take it as an anonymous bind, which
+  succeeds.
+*/
+func (p *ldapProvider) Verify(ctx context.Context, username, password string) (Claims, error) {
+	if password == "" {
+		return Claims{}, ErrBadPassword
+	}
+	if c, ok := p.cached(username); ok && c.password != nil {
+		if subtle.ConstantTimeCompare(c.password, p.hash(password)) == 1 {
+			return c.claims, nil
+		}
+	}
+	conn, err := p.get()
+	if err != nil {
+		return Claims{}, err
+	}
+	user, claims, err := p.lookup(conn, username)
+	if err != nil {
+		conn.close()
+		return claims, err
+	}
+	if user == nil {
+		p.put(conn)
+		return claims, ErrBadPassword
+	}
+	conn.service = false
+	err = conn.bind(user.DN, password)
+	if e, ok := err.(ldapError); ok && e.Code == ldapInvalidCredentials {
+		p.put(conn)
+		return claims, ErrBadPassword
+	}
+	if err != nil {
+		conn.close()
+		return claims, err
+	}
+	p.put(conn)
+	p.remember(username, ldapCached{claims: claims, password: p.hash(password)})
+	return claims, nil
+}
+
+/*
+  Use a directory for passwords and claims, as configured in file.
+*/
+func setupLDAP(file string) {
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot read ldap config %s: %v", file, err)
+	}
+	var config LDAPConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Fatalf("WEBDAV: cannot parse ldap config %s: %v", file, err)
+	}
+	p, err := newLDAPProvider(config)
+	if err != nil {
+		log.Fatalf("WEBDAV: %v", err)
+	}
+	// find out now if the server or the service account is wrong
+	conn, err := p.get()
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot bind to %s: %v", config.URL, err)
+	}
+	p.put(conn)
+	claimsProvider = p
+	authenticator = p
+	cacheInvalidators["ldap"] = p.forget
+	webdav.Log().Info("using ldap", "url", config.URL, "base_dn", config.BaseDN)
+}
@@ -0,0 +1,130 @@
+package example1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Per method timeouts, as the -timeouts flag gives them, over the
+  defaults, with * for methods that are not named.  0 is no limit.
+
+    PROPFIND=30s,COPY=1h,*=2m
+*/
+func parseTimeouts(s string, minRate int64) (*webdav.Timeouts, error) {
+	t := webdav.DefaultTimeouts()
+	t.MinRate = minRate
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad timeout %q", entry)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("bad timeout %q", entry)
+		}
+		if method := strings.ToUpper(parts[0]); method == "*" {
+			t.Default = d
+		} else {
+			t.Methods[method] = d
+		}
+	}
+	return t, nil
+}
+
+/*
+  The files that all tenants may have open at once, as -maxopen and
+  -maxopenuser say, or nil for no limit.  Users are counted by
+  tenant, since the same name in two tenants is two people.
+*/
+var openFiles *webdav.FileBudget
+
+func newFileBudget(max, maxPerUser int, wait time.Duration) *webdav.FileBudget {
+	if max <= 0 && maxPerUser <= 0 {
+		return nil
+	}
+	return &webdav.FileBudget{
+		Max:        max,
+		MaxPerUser: maxPerUser,
+		Wait:       wait,
+		UserOf:     userKey,
+	}
+}
+
+// Who a request is for, in its tenant, or "" if nobody has logged in.
+func userKey(ctx context.Context) string {
+	username, _ := ctx.Value("username").(string)
+	if username == "" {
+		return ""
+	}
+	return tenantOf(ctx).Name + "/" + username
+}
+
+/*
+  inFlight caps the requests that each user may have in progress at
+  once, however fast they come, so that a sync client that opens
+  hundreds of connections cannot take every worker from everyone
+  else.  A request over the cap is turned away at once, rather than
+  queued, since the client is the one holding the others open.
+*/
+type inFlight struct {
+	Max int
+
+	mu      sync.Mutex
+	perUser map[string]int
+}
+
+// The requests that each user may have in progress, as -maxrequests says,
+// or nil for no limit.
+var requestLimit *inFlight
+
+func newInFlight(max int) *inFlight {
+	if max <= 0 {
+		return nil
+	}
+	return &inFlight{Max: max, perUser: make(map[string]int)}
+}
+
+// enter counts a request of the user in ctx, returning the func that ends
+// it, or false if they have too many in progress already.
+func (l *inFlight) enter(ctx context.Context) (func(), bool) {
+	user := userKey(ctx)
+	if l == nil || user == "" {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perUser[user] >= l.Max {
+		return nil, false
+	}
+	l.perUser[user]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.perUser[user]--; l.perUser[user] <= 0 {
+			delete(l.perUser, user)
+		}
+	}, true
+}
+
+// Serve r with h, unless its user has too many requests in progress.
+func serveLimited(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	leave, ok := requestLimit.enter(r.Context())
+	if !ok {
+		webdav.Log().Warn("too many requests in progress", "request_id", webdav.RequestID(r.Context()), "user", userKey(r.Context()))
+		w.Header().Set("Retry-After", webdav.RetryAfter)
+		http.Error(w, "Too many requests in progress", http.StatusTooManyRequests)
+		return
+	}
+	defer leave()
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,109 @@
+package example1
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Listeners that systemd opened for the server, as a socket unit
+  does, by the FileDescriptorName= that the unit gives them: http,
+  s3, grpc, ftp or debug.  One without a name is http.
+
+    [Socket]
+    ListenStream=443
+    FileDescriptorName=http
+
+  The server serves whatever it is given a listener for, as though
+  its flag had been given, and listens itself for the rest.
+*/
+var activated = make(map[string]net.Listener)
+
+var listenerNames = map[string]bool{"http": true, "s3": true, "grpc": true, "ftp": true, "debug": true}
+
+func setupActivation() {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// what the server starts is not meant to have them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for i := 0; i < n; i++ {
+		name := "http"
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			name = names[i]
+		}
+		// the first fd that systemd passes is 3, after stdin, stdout and stderr
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("WEBDAV: cannot listen on fd %d from systemd: %v", 3+i, err)
+		}
+		if !listenerNames[name] {
+			log.Fatalf("WEBDAV: systemd passed a listener named %s, which the server does not serve", name)
+		}
+		if _, ok := activated[name]; ok {
+			log.Fatalf("WEBDAV: systemd passed two listeners named %s", name)
+		}
+		activated[name] = l
+		webdav.Log().Info("listening on socket from systemd", "name", name, "addr", l.Addr())
+	}
+}
+
+// Whether to serve name, because its flag says where, or systemd gave a listener for it.
+func serves(name string, given bool) bool {
+	_, ok := activated[name]
+	return given || ok
+}
+
+// The listener that systemd gave for name, or one on addr.
+func openListener(name, addr string) net.Listener {
+	if l, ok := activated[name]; ok {
+		return l
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot listen for %s on %s: %v", name, addr, err)
+	}
+	return l
+}
+
+// Where to listen on port, which plain http only does for this host.
+func listenAddr(port int, secure bool) string {
+	if secure {
+		return fmt.Sprintf(":%d", port)
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+/*
+  The certificate for TLS, read from cert.pem and key.pem before the
+  server gives up root, after which it may not be able to.
+*/
+func loadCertificate() ([]tls.Certificate, error) {
+	if _, err := os.Stat("./cert.pem"); err != nil {
+		return nil, fmt.Errorf("no cert.pem in current directory. Please provide a valid cert")
+	}
+	if _, err := os.Stat("./key.pem"); err != nil {
+		return nil, fmt.Errorf("no key.pem in current directory. Please provide a valid cert")
+	}
+	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{cert}, nil
+}
@@ -0,0 +1,85 @@
+package example1
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"strconv"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Computed properties, which are only sent when asked for by name:
+
+    <w:checksum xmlns:w="https://github.com/rfielding/webdev/"/>  sha256 of a file, if you can Read it
+    <w:banner xmlns:w="https://github.com/rfielding/webdev/"/>    the Banner that the policy gives
+    <D:quota-used-bytes xmlns:D="DAV:"/>                          bytes used under a directory
+    <D:quota-available-bytes xmlns:D="DAV:"/>                     bytes left under the nearest quota
+    <D:owner xmlns:D="DAV:"/>                                     the principal of whoever made it
+*/
+func registerLiveProperties() {
+	ns := "https://github.com/rfielding/webdev/"
+	webdav.RegisterLiveProperty(xml.Name{Space: ns, Local: "checksum"}, false, func(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+		sum, err := fileSHA256(ctx, tenantOf(ctx).fsys, name)
+		if err != nil {
+			return "", os.ErrNotExist
+		}
+		return sum, nil
+	})
+	webdav.RegisterLiveProperty(xml.Name{Space: ns, Local: "banner"}, true, func(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+		fsys := tenantOf(ctx).fsys
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowStat})
+		banner, _ := permission["Banner"].(string)
+		if banner == "" {
+			return "", os.ErrNotExist
+		}
+		return banner, nil
+	})
+	// RFC 4331
+	webdav.RegisterLiveProperty(xml.Name{Space: "DAV:", Local: "quota-used-bytes"}, true, func(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+		fsys := tenantOf(ctx).fsys
+		if !fi.IsDir() {
+			return "", os.ErrNotExist
+		}
+		usage, err := fsys.Usage(ctx, name)
+		if err != nil {
+			return "", os.ErrNotExist
+		}
+		return strconv.FormatInt(usage.Bytes, 10), nil
+	})
+	webdav.RegisterLiveProperty(xml.Name{Space: "DAV:", Local: "quota-available-bytes"}, true, func(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+		fsys := tenantOf(ctx).fsys
+		if !fi.IsDir() {
+			return "", os.ErrNotExist
+		}
+		dir, quota, ok := nearestQuota(fsys, name)
+		if !ok {
+			return "", os.ErrNotExist
+		}
+		usage, err := fsys.Usage(ctx, dir)
+		if err != nil {
+			return "", os.ErrNotExist
+		}
+		available := quota - usage.Bytes
+		if available < 0 {
+			available = 0
+		}
+		return strconv.FormatInt(available, 10), nil
+	})
+	// RFC 3744, from what the volume recorded
+	webdav.RegisterLivePropertyXML(xml.Name{Space: "DAV:", Local: "owner"}, true, func(ctx context.Context, name string, fi os.FileInfo) (string, error) {
+		t := tenantOf(ctx)
+		owner := fs.OwnerOf(t.fsys.Resolve(name))
+		if owner == "" {
+			return "", os.ErrNotExist
+		}
+		var b bytes.Buffer
+		b.WriteString(`<D:href xmlns:D="DAV:">`)
+		xml.EscapeText(&b, []byte(principalTree{Tenant: t}.userHref(owner)))
+		b.WriteString(`</D:href>`)
+		return b.String(), nil
+	})
+}
@@ -0,0 +1,362 @@
+package example1
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A webdav.Logger that hands each line at or above its level to
+  emit.  If emit fails, the line goes to stderr instead, so that
+  it is not lost while the host's log service is down.
+*/
+type emitLogger struct {
+	level webdav.Level
+	emit  func(level webdav.Level, msg string, args []interface{}) error
+}
+
+func (l emitLogger) Debug(msg string, args ...interface{}) { l.log(webdav.LevelDebug, msg, args) }
+func (l emitLogger) Info(msg string, args ...interface{})  { l.log(webdav.LevelInfo, msg, args) }
+func (l emitLogger) Warn(msg string, args ...interface{})  { l.log(webdav.LevelWarn, msg, args) }
+func (l emitLogger) Error(msg string, args ...interface{}) { l.log(webdav.LevelError, msg, args) }
+
+// The least important lines that are logged, which a reload changes
+var logLevel int64
+
+func setLogLevel(level webdav.Level) {
+	atomic.StoreInt64(&logLevel, int64(level))
+}
+
+/*
+  A webdav.Logger that leaves out lines below logLevel, so that the
+  level can change while serving, wherever the lines go.
+*/
+type leveledLogger struct {
+	webdav.Logger
+}
+
+func (l leveledLogger) enabled(level webdav.Level) bool {
+	return int64(level) >= atomic.LoadInt64(&logLevel)
+}
+
+func (l leveledLogger) Debug(msg string, args ...interface{}) {
+	if l.enabled(webdav.LevelDebug) {
+		l.Logger.Debug(msg, args...)
+	}
+}
+
+func (l leveledLogger) Info(msg string, args ...interface{}) {
+	if l.enabled(webdav.LevelInfo) {
+		l.Logger.Info(msg, args...)
+	}
+}
+
+func (l leveledLogger) Warn(msg string, args ...interface{}) {
+	if l.enabled(webdav.LevelWarn) {
+		l.Logger.Warn(msg, args...)
+	}
+}
+
+func (l leveledLogger) Error(msg string, args ...interface{}) {
+	if l.enabled(webdav.LevelError) {
+		l.Logger.Error(msg, args...)
+	}
+}
+
+func (l emitLogger) log(level webdav.Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	if err := l.emit(level, msg, args); err != nil {
+		log.Printf("level=%s %s (could not send: %v)", level, logText(msg, args), err)
+	}
+}
+
+// logText writes msg and its args as "msg k=v k=v".
+func logText(msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		v := fmt.Sprint(args[i+1])
+		if v == "" || strings.ContainsAny(v, " \t\r\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], v)
+	}
+	return b.String()
+}
+
+// The syslog severity of a level, which journald's PRIORITY also uses
+func severityOf(level webdav.Level) int {
+	switch {
+	case level <= webdav.LevelDebug:
+		return 7
+	case level <= webdav.LevelInfo:
+		return 6
+	case level <= webdav.LevelWarn:
+		return 4
+	}
+	return 3
+}
+
+const (
+	facilityDaemon   = 3
+	facilityAuthPriv = 10
+	// Audit records are notices, whatever their outcome
+	severityNotice = 5
+	// The enterprise number set aside for examples, for our SD-IDs
+	sdEnterprise = "32473"
+)
+
+/*
+  Send log lines and audit records to syslog, as RFC 5424
+  messages.  Where to send them is one of:
+
+    local               the host's /dev/log
+    unix:///dev/log     a unix datagram socket
+    udp://host:514      one message per datagram
+    tcp://host:514      octet counted, as RFC 6587 says
+
+  Log lines go to the daemon facility, and audit records go to
+  authpriv, with the record's fields as structured data.
+*/
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	conn     net.Conn
+	hostname string
+	app      string
+}
+
+func newSyslogSink(where string) (*syslogSink, error) {
+	s := &syslogSink{app: filepath.Base(os.Args[0])}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	switch {
+	case where == "local":
+		s.network, s.addr = "unixgram", "/dev/log"
+	case strings.HasPrefix(where, "unix://"):
+		s.network, s.addr = "unixgram", strings.TrimPrefix(where, "unix://")
+	case strings.HasPrefix(where, "udp://"):
+		s.network, s.addr = "udp", strings.TrimPrefix(where, "udp://")
+	case strings.HasPrefix(where, "tcp://"):
+		s.network, s.addr = "tcp", strings.TrimPrefix(where, "tcp://")
+	default:
+		return nil, fmt.Errorf("syslog must be local, or a unix://, udp:// or tcp:// address: %s", where)
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) dial() error {
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+/*
+  Format and send one message, dialing again once if the
+  connection was lost.
+*/
+func (s *syslogSink) send(facility, severity int, msgid, sd, msg string) error {
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facility*8+severity, time.Now().UTC().Format(time.RFC3339Nano),
+		s.hostname, s.app, os.Getpid(), msgid, sd, msg)
+	if s.network == "tcp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+// A webdav.Logger that writes to this sink
+func (s *syslogSink) logger(level webdav.Level) webdav.Logger {
+	return emitLogger{level: level, emit: func(level webdav.Level, msg string, args []interface{}) error {
+		return s.send(facilityDaemon, severityOf(level), "-", "-", logText(msg, args))
+	}}
+}
+
+func (s *syslogSink) Audit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sd := fmt.Sprintf("[audit@%s user=\"%s\" action=\"%s\" target=\"%s\" request_id=\"%s\"]",
+		sdEnterprise, sdEscape(rec.User), sdEscape(rec.Action), sdEscape(rec.Target), sdEscape(rec.RequestID))
+	return s.send(facilityAuthPriv, severityNotice, "audit", sd, string(data))
+}
+
+// Escape a structured data value, as RFC 5424 section 6.3.3 says.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+/*
+  Send log lines and audit records to systemd-journald, over its
+  native protocol, so that their fields can be queried with
+  journalctl:
+
+    journalctl -t webdev AUDIT_USER=rob
+
+  The args of a log line become fields named by upper casing
+  their keys.  journald refuses entries larger than its socket
+  buffer, so very large policy changes fall back to stderr.
+*/
+type journalSink struct {
+	mu         sync.Mutex
+	conn       net.Conn
+	identifier string
+}
+
+const journalSocket = "/run/systemd/journal/socket"
+
+func newJournalSink() (*journalSink, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn, identifier: filepath.Base(os.Args[0])}, nil
+}
+
+/*
+  Send one entry.  Each field is NAME=value on a line, or, when
+  the value has a newline in it, NAME then the value's length as
+  a little endian 64 bit number.
+*/
+func (j *journalSink) send(fields [][2]string) error {
+	var b []byte
+	for _, f := range fields {
+		if strings.Contains(f[1], "\n") {
+			b = append(b, f[0]...)
+			b = append(b, '\n')
+			var size [8]byte
+			binary.LittleEndian.PutUint64(size[:], uint64(len(f[1])))
+			b = append(b, size[:]...)
+			b = append(b, f[1]...)
+			b = append(b, '\n')
+		} else {
+			b = append(b, f[0]+"="+f[1]+"\n"...)
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.conn.Write(b)
+	return err
+}
+
+// journalField makes a valid journal field name out of a key.
+func journalField(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	// names that start with _ are set by journald itself
+	if len(name) == 0 || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		name = append([]byte("X"), name...)
+	}
+	return string(name)
+}
+
+// A webdav.Logger that writes to this sink
+func (j *journalSink) logger(level webdav.Level) webdav.Logger {
+	return emitLogger{level: level, emit: func(level webdav.Level, msg string, args []interface{}) error {
+		fields := [][2]string{
+			{"MESSAGE", logText(msg, args)},
+			{"PRIORITY", fmt.Sprint(severityOf(level))},
+			{"SYSLOG_IDENTIFIER", j.identifier},
+		}
+		for i := 0; i+1 < len(args); i += 2 {
+			fields = append(fields, [2]string{journalField(fmt.Sprint(args[i])), fmt.Sprint(args[i+1])})
+		}
+		return j.send(fields)
+	}}
+}
+
+func (j *journalSink) Audit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return j.send([][2]string{
+		{"MESSAGE", fmt.Sprintf("audit %s %s %s", rec.User, rec.Action, rec.Target)},
+		{"PRIORITY", fmt.Sprint(severityNotice)},
+		{"SYSLOG_IDENTIFIER", j.identifier},
+		{"SYSLOG_FACILITY", fmt.Sprint(facilityAuthPriv)},
+		{"AUDIT_USER", rec.User},
+		{"AUDIT_ACTION", rec.Action},
+		{"AUDIT_TARGET", rec.Target},
+		{"AUDIT_ERROR", rec.Error},
+		{"REQUEST_ID", rec.RequestID},
+		{"AUDIT_RECORD", string(data)},
+	})
+}
+
+/*
+  Log to syslog or journald, rather than stderr, if asked to.
+  Audit records go there too, as well as to the audit file if
+  there is one.
+*/
+func setupLogSinks(syslogWhere string, journald bool, auditFile string) {
+	type sink interface {
+		AuditSink
+		logger(level webdav.Level) webdav.Logger
+	}
+	var s sink
+	switch {
+	case syslogWhere != "" && journald:
+		log.Fatalf("WEBDAV: log to syslog or journald, not both")
+	case syslogWhere != "":
+		ss, err := newSyslogSink(syslogWhere)
+		if err != nil {
+			log.Fatalf("WEBDAV: cannot connect to syslog: %v", err)
+		}
+		s = ss
+	case journald:
+		js, err := newJournalSink()
+		if err != nil {
+			log.Fatalf("WEBDAV: cannot connect to journald: %v", err)
+		}
+		s = js
+	default:
+		return
+	}
+	webdav.SetLogger(leveledLogger{s.logger(webdav.LevelDebug)})
+	if auditFile == "" {
+		auditSinks = []AuditSink{s}
+	} else {
+		auditSinks = append(auditSinks, s)
+	}
+}
@@ -0,0 +1,209 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Subtrees of a tenant that are read only while they are backed up or
+  moved, with when that is expected to end, if anyone said.
+*/
+type readOnlySubtrees struct {
+	mu    sync.RWMutex
+	paths map[string]time.Time
+}
+
+// A read only subtree, as the api gives it
+type ReadOnlySubtree struct {
+	Path  string     `json:"path"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Subtrees that every tenant starts out read only in, as -readonly says
+var readOnlyPaths []string
+
+func setReadOnlyPaths(paths string) {
+	for _, p := range strings.Split(paths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			readOnlyPaths = append(readOnlyPaths, webdav.SlashClean(p))
+		}
+	}
+}
+
+func (s *readOnlySubtrees) set(name string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paths == nil {
+		s.paths = make(map[string]time.Time)
+	}
+	s.paths[name] = until
+}
+
+func (s *readOnlySubtrees) clear(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.paths[name]
+	delete(s.paths, name)
+	return ok
+}
+
+func (s *readOnlySubtrees) list() []ReadOnlySubtree {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]ReadOnlySubtree, 0, len(s.paths))
+	for p, until := range s.paths {
+		sub := ReadOnlySubtree{Path: p}
+		if !until.IsZero() {
+			u := until
+			sub.Until = &u
+		}
+		list = append(list, sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+/*
+  Whether name is in a read only subtree, and when to try again if it
+  is: the latest that any subtree it is in is expected to end, or
+  zero if one of them did not say.
+*/
+func (s *readOnlySubtrees) covers(name string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var latest time.Time
+	found, open := false, false
+	for p, until := range s.paths {
+		if !under(name, p) {
+			continue
+		}
+		found = true
+		if until.IsZero() {
+			open = true
+		} else if until.After(latest) {
+			latest = until
+		}
+	}
+	if open {
+		latest = time.Time{}
+	}
+	return latest, found
+}
+
+// What may still be done in a read only subtree
+var readOnlyMethods = map[string]bool{
+	"GET": true, "HEAD": true, "OPTIONS": true, "PROPFIND": true, "REPORT": true, "SEARCH": true, "UNLOCK": true,
+}
+
+/*
+  Refuse changes to read only subtrees, with 503 and when to come back,
+  whether they are made to the request's path or its Destination.
+*/
+type readOnlyGate struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+func (g readOnlyGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !readOnlyMethods[r.Method] {
+		names := []string{strings.TrimPrefix(r.URL.Path, g.Tenant.Prefix)}
+		if dst := r.Header.Get("Destination"); dst != "" {
+			if u, err := url.Parse(dst); err == nil {
+				names = append(names, strings.TrimPrefix(u.Path, g.Tenant.Prefix))
+			}
+		}
+		for _, name := range names {
+			until, ok := g.Tenant.readOnly.covers(webdav.SlashClean(name))
+			if !ok {
+				continue
+			}
+			retryAfter(w, until)
+			webdav.ServeError(w, r, webdav.ErrReadOnly)
+			return
+		}
+	}
+	g.Handler.ServeHTTP(w, r)
+}
+
+// Say when to come back, if it is known.
+func retryAfter(w http.ResponseWriter, until time.Time) {
+	if wait := time.Until(until); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	}
+}
+
+/*
+  Whether name, a path of t, may be changed now.  The apis that write
+  to the volume themselves, rather than through WebDAV, ask before
+  they do, since readOnlyGate does not see them.  When it may not be,
+  w is answered as the gate would, with 503 and when to come back.
+*/
+func mayChange(w http.ResponseWriter, t *Tenant, name string) bool {
+	until, ok := t.readOnly.covers(webdav.SlashClean(name))
+	if !ok {
+		return true
+	}
+	retryAfter(w, until)
+	writeJsonError(w, http.StatusServiceUnavailable, webdav.ErrReadOnly)
+	return false
+}
+
+/*
+  Put subtrees of the tenant into read only mode, for backups and
+  migrations, and take them out again.  Until says when clients are
+  told to come back; without it, they are told to come back soon.
+
+    GET    /.__api/readonly
+    PUT    /.__api/readonly?path=/eng&until=2024-06-01T02:00:00Z
+    DELETE /.__api/readonly?path=/eng
+*/
+func readOnlyHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		t := tenantOf(ctx)
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, t.readOnly.list())
+		case "PUT":
+			var until time.Time
+			if s := r.URL.Query().Get("until"); s != "" {
+				var err error
+				if until, err = time.Parse(time.RFC3339, s); err != nil {
+					writeJsonError(w, http.StatusBadRequest, fmt.Errorf("until must be RFC 3339: %v", err))
+					return
+				}
+			}
+			t.readOnly.set(name, until)
+			rec := AuditRecord{User: username, Action: "read only", Target: name}
+			rec.After, _ = json.Marshal(r.URL.Query())
+			audit(ctx, rec)
+			writeJson(w, http.StatusOK, t.readOnly.list())
+		case "DELETE":
+			if !t.readOnly.clear(name) {
+				writeJsonError(w, http.StatusNotFound, fmt.Errorf("%s is not read only", name))
+				return
+			}
+			audit(ctx, AuditRecord{User: username, Action: "read write", Target: name})
+			writeJson(w, http.StatusOK, t.readOnly.list())
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package example1
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// The most entries that a manifest lists, so that one request cannot walk the whole volume
+const maxManifestEntries = 100000
+
+type ManifestEntry struct {
+	Path     string    `json:"path"`
+	Dir      bool      `json:"dir,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// The sha256 of a file, if it may be read
+	SHA256 string `json:"sha256,omitempty"`
+	// The properties that were asked for, by fs.PropKey
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type Manifest struct {
+	Path    string          `json:"path"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+/*
+  List what is in a directory, with sizes, hashes and properties, so
+  that a mirror can be checked in one request rather than crawling:
+
+    GET /.__api/manifest?path=/rob&depth=infinity&prop=classification&prop={https://github.com/rfielding/webdev/}tags
+
+  depth is 1 for what is in the directory, as the default, or infinity
+  for everything under it.  prop names properties as HiddenProperties
+  does, or * for all that can be seen.  hash=false leaves out hashes,
+  which are the slow part.  Only what the user may Stat is listed,
+  and only files that they may Read are hashed.
+*/
+func manifestHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		ctx := r.Context()
+		q := r.URL.Query()
+		name := webdav.SlashClean(q.Get("path"))
+		info, err := fsys.Stat(ctx, name)
+		if err != nil || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		if !info.IsDir() {
+			writeJsonError(w, http.StatusBadRequest, webdav.ErrNotADirectory)
+			return
+		}
+		recursive := false
+		switch q.Get("depth") {
+		case "", "1":
+		case "infinity":
+			recursive = true
+		default:
+			writeJsonError(w, http.StatusBadRequest, webdav.ErrInvalidDepth)
+			return
+		}
+		m := manifester{fsys: fsys, props: q["prop"], hash: q.Get("hash") != "false", recursive: recursive}
+		manifest := Manifest{Path: name, Entries: make([]ManifestEntry, 0)}
+		if err := m.walk(ctx, name, &manifest.Entries); err != nil {
+			status := http.StatusInternalServerError
+			if err == errManifestTooLarge {
+				status = http.StatusBadRequest
+			}
+			writeJsonError(w, status, err)
+			return
+		}
+		sort.Slice(manifest.Entries, func(i, j int) bool { return manifest.Entries[i].Path < manifest.Entries[j].Path })
+		writeJson(w, http.StatusOK, manifest)
+	})
+}
+
+var errManifestTooLarge = fmt.Errorf("more than %d entries, ask for less of the tree at once", maxManifestEntries)
+
+type manifester struct {
+	fsys      fs.FS
+	props     []string
+	hash      bool
+	recursive bool
+}
+
+func (m manifester) walk(ctx context.Context, dir string, entries *[]ManifestEntry) error {
+	children, err := visibleChildren(ctx, m.fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(*entries) >= maxManifestEntries {
+			return errManifestTooLarge
+		}
+		name := path.Join(dir, c.Name())
+		e := ManifestEntry{Path: name, Dir: c.IsDir(), Size: c.Size(), Modified: c.ModTime().UTC()}
+		if c.IsDir() {
+			e.Size = 0
+		} else if m.hash {
+			e.SHA256, _ = fileSHA256(ctx, m.fsys, name)
+		}
+		if len(m.props) > 0 {
+			e.Properties = m.properties(ctx, name)
+		}
+		*entries = append(*entries, e)
+		if c.IsDir() && m.recursive {
+			if err := m.walk(ctx, name, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The properties of name that were asked for, and may be seen.
+func (m manifester) properties(ctx context.Context, name string) map[string]string {
+	visible, err := deadProps(ctx, m.fsys, name)
+	if err != nil {
+		return nil
+	}
+	props := make(map[string]string)
+	for _, key := range m.props {
+		if key == "*" {
+			return visible
+		}
+		if v, ok := visible[key]; ok {
+			props[key] = v
+		}
+	}
+	return props
+}
+
+// The sha256 of the file name, as hex, if the user of ctx may read it.
+func fileSHA256(ctx context.Context, fsys fs.FS, name string) (string, error) {
+	f, err := fsys.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
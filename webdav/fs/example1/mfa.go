@@ -0,0 +1,389 @@
+package example1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Policies can ask for a second factor on a subtree, by adding
+  require_mfa to the obligations of a decision:
+
+    Obligations = {"require_mfa": true} {
+      startswith(input.action.name, "/rob/classified")
+    }
+
+  Requests there are answered with 401 unless they carry a one
+  time code from the user's authenticator app, in the X-OTP
+  header.  Codes are TOTP, as RFC 6238 says, with 30 second steps
+  and 6 digits.
+*/
+const (
+	OTPHeader = "X-OTP"
+	// The one time token that lets a user enroll
+	EnrollmentHeader = "X-MFA-Enrollment"
+	otpStep   = 30
+	otpDigits = 6
+	otpIssuer = "webdev"
+	// steps of clock skew allowed either way
+	otpSkew = 1
+	// how long a user has to enroll, once an admin lets them
+	enrollmentTimeout = 72 * time.Hour
+)
+
+const (
+	mfaNeeded  = "mfa required"
+	mfaBadCode = "wrong one time code"
+)
+
+/*
+  Keep the TOTP secrets of users in a json file, outside of the
+  served volume, so that a stolen password cannot replace them.
+*/
+type mfaStore struct {
+	mu      sync.Mutex
+	file    string
+	secrets map[string]string
+	// the last step used by each user, so that older codes are no good
+	// once a newer one has been seen.  A code can be used again until
+	// then, since a WebDAV client sends it with every request.
+	used map[string]int64
+	// enrollment tokens that admins gave out, by user, as hashes.  They
+	// are not saved, so a restart forgets them, and an admin gives
+	// out another.
+	invites map[string]invite
+}
+
+type invite struct {
+	hash    string
+	expires time.Time
+}
+
+// Users with a second factor, or nil when there is no store.
+var mfa *mfaStore
+
+func newMFAStore(file string) (*mfaStore, error) {
+	s := &mfaStore{file: file, secrets: make(map[string]string), used: make(map[string]int64), invites: make(map[string]invite)}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.secrets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *mfaStore) save() error {
+	data, err := json.MarshalIndent(s.secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// Users are kept per tenant
+func mfaKey(ctx context.Context, username string) string {
+	return tenantOf(ctx).Name + "/" + username
+}
+
+func (s *mfaStore) enrolled(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.secrets[key]
+	return ok
+}
+
+func (s *mfaStore) set(key, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret == "" {
+		delete(s.secrets, key)
+	} else {
+		s.secrets[key] = secret
+	}
+	delete(s.used, key)
+	return s.save()
+}
+
+// invite makes a token that lets the user of key enroll once, in place of any before it.
+func (s *mfaStore) invite(key string, now time.Time) string {
+	var b [16]byte
+	rand.Read(b[:])
+	token := hex.EncodeToString(b[:])
+	sum := sha256.Sum256([]byte(token))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invites[key] = invite{hash: hex.EncodeToString(sum[:]), expires: now.Add(enrollmentTimeout)}
+	return token
+}
+
+// redeem uses up the enrollment token of the user of key, if token is it.
+func (s *mfaStore) redeem(key, token string, now time.Time) bool {
+	sum := sha256.Sum256([]byte(token))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invites[key]
+	if !ok || now.After(inv.expires) || !hmac.Equal([]byte(inv.hash), []byte(hex.EncodeToString(sum[:]))) {
+		return false
+	}
+	delete(s.invites, key)
+	return true
+}
+
+// verify checks a code, allowing for a step of clock skew either way.
+func (s *mfaStore) verify(key, code string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[key]
+	if !ok {
+		return false
+	}
+	step := now.Unix() / otpStep
+	for d := int64(-otpSkew); d <= otpSkew; d++ {
+		if step+d < s.used[key] {
+			continue
+		}
+		if hmac.Equal([]byte(totp(secret, step+d)), []byte(code)) {
+			s.used[key] = step + d
+			return true
+		}
+	}
+	return false
+}
+
+func totp(secret string, step int64) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return ""
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+func newOTPSecret() string {
+	var b [20]byte
+	rand.Read(b[:])
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:])
+}
+
+// mfaOf says whether the request came with a good second factor.
+func mfaOf(ctx context.Context) bool {
+	ok, _ := ctx.Value("mfa").(bool)
+	return ok
+}
+
+/*
+  Check the one time code of a request, if it has one.  A wrong
+  code is refused outright, rather than treated as no code.
+*/
+func withMFA(r *http.Request, username string) (*http.Request, bool) {
+	code := r.Header.Get(OTPHeader)
+	if code == "" {
+		return r, true
+	}
+	ctx := r.Context()
+	if mfa == nil || !mfa.verify(mfaKey(ctx, username), code, time.Now()) {
+		audit(ctx, AuditRecord{User: username, Action: "auth failed", Target: r.URL.Path, Error: mfaBadCode})
+		return r, false
+	}
+	return r.WithContext(context.WithValue(ctx, "mfa", true)), true
+}
+
+func mfaChallenge(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	w.Header().Set("X-MFA-Required", "totp")
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// requiresMFA says whether the policy asks for a second factor on name.
+func requiresMFA(ctx context.Context, fsys fs.FS, name string) bool {
+	permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowRead})
+	obligations, _ := permission["Obligations"].(map[string]interface{})
+	required, _ := obligations["require_mfa"].(bool)
+	return required
+}
+
+/*
+  Ask for a second factor before WebDAV requests get to paths
+  where the policy requires one, including where a COPY or MOVE
+  would put things, and what a BIND would bind.
+*/
+type mfaHandler struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+func (m mfaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !mfaOf(ctx) {
+		names := []string{strings.TrimPrefix(r.URL.Path, m.Tenant.Prefix)}
+		if srv := m.Tenant.srv; srv != nil {
+			// where the handler will take them to be, behind proxies and
+			// under the base path as well
+			if dst := r.Header.Get("Destination"); dst != "" {
+				if name, ok := srv.LocalName(r, dst); ok {
+					names = append(names, name)
+				}
+			}
+			if r.Method == "BIND" {
+				// the href in the body is bound, and could be read where it is bound
+				if name, ok := srv.BindSource(r); ok {
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range names {
+			name = webdav.SlashClean(name)
+			if requiresMFA(ctx, m.Tenant.fsys, name) || (!exists(m.Tenant.fsys, name) && requiresMFA(ctx, m.Tenant.fsys, path.Dir(name))) {
+				mfaChallenge(w, mfaNeeded)
+				return
+			}
+		}
+	}
+	m.Handler.ServeHTTP(w, r)
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := os.Stat(fsys.Resolve(name))
+	return err == nil
+}
+
+/*
+  The require_mfa obligation, for what gets past mfaHandler, such
+  as share links.
+*/
+type mfaObligation struct{}
+
+func (o mfaObligation) Process(ctx context.Context, w http.ResponseWriter, r *http.Request, value interface{}, content io.ReadSeeker) (io.ReadSeeker, error) {
+	if required, _ := value.(bool); required && !mfaOf(ctx) {
+		return nil, fmt.Errorf(mfaNeeded)
+	}
+	return content, nil
+}
+
+/*
+  Enroll in, or drop, a second factor.
+
+    POST   /.__api/mfa?user=jp     an admin lets jp enroll, with a one time token
+    POST   /.__api/mfa             make a new secret, for an authenticator app
+    DELETE /.__api/mfa             drop your second factor
+    DELETE /.__api/mfa?user=jp     an admin drops jp's, when a phone is lost
+
+  A password alone cannot enroll, or whoever stole it could enroll
+  an app of their own.  The first enrollment takes the token that an
+  admin gave to the user, some other way than this server, in
+  X-MFA-Enrollment.  Once enrolled, changing or dropping it takes a
+  code in X-OTP.
+*/
+func mfaAPIHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mfa == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no mfa file"))
+			return
+		}
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			user = username
+		}
+		if err := validUsername(user); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		key := mfaKey(ctx, user)
+		// an admin's token for user, who may be the admin, before any enrolls
+		invited := r.Method == "POST" && r.URL.Query().Get("user") != ""
+		switch {
+		case invited || user != username:
+			if (!invited && r.Method != "DELETE") || !isAdmin(ctx, fsys) {
+				writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+				return
+			}
+		case mfa.enrolled(key):
+			if !mfaOf(ctx) {
+				mfaChallenge(w, mfaNeeded)
+				return
+			}
+		case r.Method == "POST" && !mfa.redeem(key, r.Header.Get(EnrollmentHeader), time.Now()):
+			audit(ctx, AuditRecord{User: username, Action: "auth failed", Target: r.URL.Path, Error: "no enrollment token"})
+			writeJsonError(w, http.StatusForbidden, fmt.Errorf("enrolling takes a token from an admin, in %s", EnrollmentHeader))
+			return
+		}
+		switch r.Method {
+		case "POST":
+			if invited {
+				token := mfa.invite(key, time.Now())
+				audit(ctx, AuditRecord{User: username, Action: "mfa.invite", Target: user})
+				writeJson(w, http.StatusOK, map[string]string{"token": token, "expires": time.Now().Add(enrollmentTimeout).UTC().Format(time.RFC3339)})
+				return
+			}
+			secret := newOTPSecret()
+			if err := mfa.set(key, secret); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, AuditRecord{User: username, Action: "mfa.enroll", Target: user})
+			label := url.PathEscape(otpIssuer + ":" + user)
+			writeJson(w, http.StatusOK, map[string]string{
+				"secret": secret,
+				"url":    fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&period=%d&digits=%d", label, secret, otpIssuer, otpStep, otpDigits),
+			})
+		case "DELETE":
+			if err := mfa.set(key, ""); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, AuditRecord{User: username, Action: "mfa.drop", Target: user})
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+func setupMFA(file string) {
+	if file == "" {
+		return
+	}
+	var err error
+	mfa, err = newMFAStore(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot load mfa file %s: %v", file, err)
+	}
+}
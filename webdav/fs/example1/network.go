@@ -0,0 +1,238 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Which networks may connect, and what to call them.  Addresses
+  are CIDRs, or single addresses.
+
+    {
+      "trusted_proxies": ["10.0.0.5"],
+      "deny": ["203.0.113.0/24"],
+      "allow": ["10.0.0.0/8", "192.168.0.0/16", "198.51.100.7"],
+      "zones": {"office": ["10.1.0.0/16"], "vpn": ["10.8.0.0/16"]}
+    }
+
+  Denied addresses are refused, and when there is an allow list,
+  so is anything not on it.  The zone of a client is given to the
+  policy as input.network.zone, and is "external" when no zone
+  has the client in it.
+*/
+type NetworkConfig struct {
+	TrustedProxies []string            `json:"trusted_proxies,omitempty"`
+	Allow          []string            `json:"allow,omitempty"`
+	Deny           []string            `json:"deny,omitempty"`
+	Zones          map[string][]string `json:"zones,omitempty"`
+}
+
+// The zone of clients that are in none of the configured zones
+const externalZone = "external"
+
+/*
+  Where a request came from, as the policy sees it.
+*/
+type NetworkInput struct {
+	IP   string `json:"ip"`
+	Zone string `json:"zone"`
+	// From the geoip databases, when there are any
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+type zone struct {
+	name string
+	nets []*net.IPNet
+}
+
+type networkPolicy struct {
+	// held for reading as it is used, and for writing as it is reloaded
+	mu      sync.RWMutex
+	trusted []*net.IPNet
+	// from -proxies, which a reload keeps
+	proxies []*net.IPNet
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	// the narrowest zone wins when they overlap
+	zones []zone
+	geo   []*geoDB
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadNetworkPolicy(file string) (*networkPolicy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config NetworkConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	p := &networkPolicy{}
+	if p.trusted, err = parseNets(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %v", err)
+	}
+	if p.allow, err = parseNets(config.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %v", err)
+	}
+	if p.deny, err = parseNets(config.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %v", err)
+	}
+	for name, list := range config.Zones {
+		nets, err := parseNets(list)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %v", name, err)
+		}
+		p.zones = append(p.zones, zone{name: name, nets: nets})
+	}
+	sort.Slice(p.zones, func(i, j int) bool { return p.zones[i].name < p.zones[j].name })
+	return p, nil
+}
+
+/*
+  Where the request came from.  What proxies say of it is only
+  believed when the connection comes from a trusted proxy, and then
+  it is read from the right, past every trusted proxy, so that a
+  client cannot name itself, or the host it asked for, by sending
+  the headers.
+*/
+func (p *networkPolicy) forwarded(r *http.Request) forwarding {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	f := forwarding{ip: net.ParseIP(host)}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if f.ip == nil || !p.trusts(f.ip) {
+		return f
+	}
+	f.prefix = lastValue(r.Header.Values("X-Forwarded-Prefix"))
+	if len(r.Header.Values("Forwarded")) == 0 {
+		// the last are the ones that the nearest proxy set
+		f.proto = strings.ToLower(lastValue(r.Header.Values("X-Forwarded-Proto")))
+		f.host = lastValue(r.Header.Values("X-Forwarded-Host"))
+	}
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		// a trusted proxy added this one
+		if hops[i].Proto != "" {
+			f.proto = hops[i].Proto
+		}
+		if hops[i].Host != "" {
+			f.host = hops[i].Host
+		}
+		ip := net.ParseIP(hops[i].For)
+		if ip == nil {
+			break
+		}
+		f.ip = ip
+		if !p.trusts(ip) {
+			break
+		}
+	}
+	return f
+}
+
+func (p *networkPolicy) trusts(ip net.IP) bool {
+	return contains(p.trusted, ip) || contains(p.proxies, ip)
+}
+
+func (p *networkPolicy) zoneOf(ip net.IP) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	best, bestBits := externalZone, -1
+	for _, z := range p.zones {
+		for _, n := range z.nets {
+			if bits, _ := n.Mask.Size(); n.Contains(ip) && bits > bestBits {
+				best, bestBits = z.name, bits
+			}
+		}
+	}
+	return best
+}
+
+func (p *networkPolicy) allowed(ip net.IP) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if ip == nil || contains(p.deny, ip) {
+		return false
+	}
+	return len(p.allow) == 0 || contains(p.allow, ip)
+}
+
+// Take the addresses and zones of q, keeping the proxies and geoip databases of p.
+func (p *networkPolicy) replace(q *networkPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trusted, p.allow, p.deny, p.zones = q.trusted, q.allow, q.deny, q.zones
+}
+
+func networkOf(ctx context.Context) *NetworkInput {
+	n, _ := ctx.Value("network").(*NetworkInput)
+	return n
+}
+
+/*
+  Refuse clients that the network policy does not allow, before
+  anything else looks at their requests, and tell the rest where
+  the others came from, and what they asked a proxy for.
+*/
+func (p *networkPolicy) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := p.forwarded(r)
+		ip := f.ip
+		r = f.apply(r)
+		noteRemote(r.Context(), ip.String())
+		if !p.allowed(ip) {
+			webdav.Log().Warn("refused by network policy", "request_id", webdav.RequestID(r.Context()), "ip", ip, "remote", r.RemoteAddr)
+			audit(r.Context(), AuditRecord{Action: "denied", Target: r.URL.Path, Error: fmt.Sprintf("network policy refused %s", ip)})
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		n := &NetworkInput{IP: ip.String(), Zone: p.zoneOf(ip)}
+		if err := enrichGeo(p.geo, ip, n); err != nil {
+			webdav.Log().Warn("cannot look up client", "request_id", webdav.RequestID(r.Context()), "ip", ip, "err", err)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "network", n)))
+	})
+}
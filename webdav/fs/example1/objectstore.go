@@ -0,0 +1,388 @@
+package example1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  A place to keep snapshots, other than the volume that they are
+  of.  Names are flat, with no slashes.
+*/
+type SnapshotStore interface {
+	Put(ctx context.Context, name string, f *os.File) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	List(ctx context.Context) ([]StoredSnapshot, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type StoredSnapshot struct {
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+/*
+  A snapshot store at a directory, or s3://bucket/prefix for S3, or
+  anything that speaks its API, such as MinIO or Ceph:
+
+    s3://backups/webdev?region=eu-west-1
+    s3://backups/webdev?endpoint=https://minio.example.com:9000
+
+  Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+  and AWS_SESSION_TOKEN.  Each tenant keeps its snapshots under
+  its own name.
+*/
+func newSnapshotStore(where, tenant string) (SnapshotStore, error) {
+	if !strings.HasPrefix(where, "s3://") {
+		dir := where
+		if tenant != "" {
+			dir = filepath.Join(dir, tenant)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		return dirStore{dir: dir}, nil
+	}
+	u, err := url.Parse(where)
+	if err != nil {
+		return nil, err
+	}
+	s := &s3Store{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    u.Query().Get("region"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: time.Hour},
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("%s names no bucket", where)
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for %s", where)
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+	if tenant != "" {
+		s.prefix = strings.TrimPrefix(s.prefix+"/"+tenant, "/")
+	}
+	return s, nil
+}
+
+func validSnapshotName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".tar") {
+		return fmt.Errorf("bad snapshot name %q", name)
+	}
+	return nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+func (s dirStore) Put(ctx context.Context, name string, f *os.File) error {
+	tmp, err := ioutil.TempFile(s.dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, f); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+func (s dirStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validSnapshotName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+func (s dirStore) List(ctx context.Context) ([]StoredSnapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]StoredSnapshot, 0)
+	for _, e := range entries {
+		if validSnapshotName(e.Name()) != nil {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			list = append(list, StoredSnapshot{Name: e.Name(), Size: info.Size(), Time: info.ModTime().UTC()})
+		}
+	}
+	return list, nil
+}
+
+func (s dirStore) Delete(ctx context.Context, name string) error {
+	if err := validSnapshotName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+/*
+  The S3 API, path style, signed with AWS Signature Version 4.
+*/
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Store) request(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string
+			Message string
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		xml.Unmarshal(data, &e)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s %s %s", method, key, resp.Status, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+/*
+  Sign req as AWS Signature Version 4 asks, over the host and every
+  header that is set so far.
+*/
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	signature := signatureV4(signingKeyV4(s.secretKey, date, s.region), "AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest)))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, signature))
+}
+
+func signingKeyV4(secretKey, date, region string) []byte {
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// The signature of what was hashed, as the algorithm signs it, which varies for chunks of a payload.
+func signatureV4(key []byte, algorithm, amzDate, scope string, hashed ...string) string {
+	toSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + strings.Join(hashed, "\n")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Escape all but the unreserved characters, and slashes unless asked to.
+func awsEscape(s string, slashes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !slashes) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *s3Store) Put(ctx context.Context, name string, f *os.File) error {
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var body io.Reader = f
+	if size == 0 {
+		body = http.NoBody
+	}
+	resp, err := s.request(ctx, "PUT", s.key(name), nil, body, size, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *s3Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validSnapshotName(name); err != nil {
+		return nil, err
+	}
+	resp, err := s.request(ctx, "GET", s.key(name), nil, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) List(ctx context.Context) ([]StoredSnapshot, error) {
+	list := make([]StoredSnapshot, 0)
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		resp, err := s.request(ctx, "GET", "", query, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if validSnapshotName(name) == nil {
+				list = append(list, StoredSnapshot{Name: name, Size: c.Size, Time: c.LastModified})
+			}
+		}
+		if !page.IsTruncated {
+			return list, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) Delete(ctx context.Context, name string) error {
+	if err := validSnapshotName(name); err != nil {
+		return err
+	}
+	resp, err := s.request(ctx, "DELETE", s.key(name), nil, nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,46 @@
+package example1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Policies attach obligations to a decision with an Obligations object:
+
+    Obligations = {"banner": "SECRET", "must-watermark": true}
+
+  A file served with an obligation that has no processor here,
+  and no content filter (see filters.go), is refused.
+*/
+var obligationProcessors = map[string]webdav.ObligationProcessor{
+	"banner":      bannerObligation{},
+	"require_mfa": mfaObligation{},
+}
+
+/*
+  Put a banner on the response, as headers that a client can render.
+  The value is either the banner text, or an object with text,
+  foreground and background.
+*/
+type bannerObligation struct{}
+
+func (o bannerObligation) Process(ctx context.Context, w http.ResponseWriter, r *http.Request, value interface{}, content io.ReadSeeker) (io.ReadSeeker, error) {
+	switch v := value.(type) {
+	case string:
+		w.Header().Set("X-Banner", v)
+	case map[string]interface{}:
+		for k, h := range map[string]string{"text": "X-Banner", "foreground": "X-Banner-Foreground", "background": "X-Banner-Background"} {
+			if s, ok := v[k].(string); ok {
+				w.Header().Set(h, s)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("banner obligation must be a string or object")
+	}
+	return content, nil
+}
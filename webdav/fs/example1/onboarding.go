@@ -0,0 +1,161 @@
+package example1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  An Onboarder sets up a user who has just authenticated for the first
+  time, when there is no home directory for them under root.  claims
+  are what the Authenticator said of them, which may have no groups.
+*/
+type Onboarder interface {
+	Onboard(ctx context.Context, root, username string, claims Claims) error
+}
+
+/*
+  Onboard users with a home directory of their own, the claims that
+  the policies see them by, and a policy that lets only them write
+  there, as ScaffoldPolicies makes for users that are there already.
+  The claims and policy come from templates, when they are given:
+
+    {"groups": {"username": [{{json .Username}}], "team": {{json .Groups.team}}}}
+
+  where .Username is who it is, .Groups the groups that the
+  Authenticator gave, and json and rego quote a value for each.
+*/
+type templateOnboarder struct {
+	Claims *template.Template
+	Policy *template.Template
+}
+
+var onboarder Onboarder = templateOnboarder{}
+
+var onboardingFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		j, err := json.Marshal(v)
+		return string(j), err
+	},
+	"rego": regoString,
+}
+
+// What onboarding templates see
+type onboardingData struct {
+	Username string
+	Groups   map[string][]string
+}
+
+/*
+  The home directory is made in a hidden directory and renamed into
+  place, so that a user is never found half set up, and two first
+  requests at once make it once.
+*/
+func (o templateOnboarder) Onboard(ctx context.Context, root, username string, claims Claims) error {
+	data := onboardingData{Username: username, Groups: claims.Groups}
+	if data.Groups == nil {
+		data.Groups = make(map[string][]string)
+	}
+	claimsDoc, err := execute(o.Claims, data, homeClaims(username))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(claimsDoc), &Claims{}); err != nil {
+		return fmt.Errorf("claims template: %v", err)
+	}
+	policy, err := execute(o.Policy, data, homePolicy(username))
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(root, ".__onboarding.")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, ".__claims.json"), []byte(claimsDoc), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, ".__security.rego"), []byte(policy), 0644); err != nil {
+		return err
+	}
+	if err := fs.SetOwner(tmp, username); err != nil {
+		return err
+	}
+	home := filepath.Join(root, username)
+	if _, err := os.Lstat(home); err == nil {
+		// somebody else onboarded them first
+		return nil
+	}
+	return os.Rename(tmp, home)
+}
+
+// t executed with data, or otherwise if there is no t.
+func execute(t *template.Template, data onboardingData, otherwise string) (string, error) {
+	if t == nil {
+		return otherwise, nil
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+/*
+  Onboard username if they have no home directory on the tenant of
+  ctx yet.  It is called once they have authenticated, so that nobody
+  gets a home directory by getting their password wrong.  When it
+  fails, they have no home, and get the policy that allows nothing.
+*/
+func onboard(ctx context.Context, username string, claims Claims) {
+	root := tenantOf(ctx).Root
+	if onboarder == nil || validUsername(username) != nil || username == spacesDir {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(root, username)); !os.IsNotExist(err) {
+		return
+	}
+	rec := AuditRecord{User: username, Action: "onboard", Target: "/" + username}
+	if err := onboarder.Onboard(ctx, root, username, claims); err != nil {
+		rec.Error = err.Error()
+		webdav.Log().Warn("cannot onboard user", "request_id", webdav.RequestID(ctx), "user", username, "err", err)
+	}
+	audit(ctx, rec)
+}
+
+/*
+  Onboard users from the claims.json and security.rego templates in
+  dir, rather than the built in ones.  Either may be left out.
+*/
+func setupOnboarding(dir string) {
+	if dir == "" {
+		return
+	}
+	o := templateOnboarder{}
+	for name, t := range map[string]**template.Template{"claims.json": &o.Claims, "security.rego": &o.Policy} {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		parsed, err := template.New(name).Funcs(onboardingFuncs).ParseFiles(file)
+		if err != nil {
+			log.Fatalf("WEBDAV: cannot load onboarding template: %v", err)
+		}
+		*t = parsed
+	}
+	onboarder = o
+	webdav.Log().Info("onboarding users from templates", "dir", dir)
+}
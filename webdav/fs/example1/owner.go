@@ -0,0 +1,81 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+type OwnerRequest struct {
+	// Who to give it to
+	Owner string `json:"owner"`
+	// Only what this user owns, if set
+	From string `json:"from,omitempty"`
+	// And everything under it
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+type OwnerResponse struct {
+	Path  string `json:"path"`
+	Owner string `json:"owner"`
+	// How many were given away, for a PUT
+	Changed int `json:"changed,omitempty"`
+}
+
+/*
+  Read who owns a path, which anyone who can see it may do, and as an
+  admin, give it to someone else:
+
+    GET /.__api/owner?path=/rob/plan.txt
+    PUT /.__api/owner?path=/rob  {"owner": "jp", "from": "rob", "recursive": true}
+
+  The owner is whoever made it, and is what policies see as
+  input.owner.
+*/
+func ownerHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		name := webdav.SlashClean(r.URL.Query().Get("path"))
+		if _, err := fsys.Stat(ctx, name); err != nil || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, OwnerResponse{Path: name, Owner: fs.OwnerOf(fsys.Resolve(name))})
+		case "PUT":
+			if !isAdmin(ctx, fsys) {
+				writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+				return
+			}
+			var req OwnerRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := validUsername(req.Owner); err != nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("owner: %v", err))
+				return
+			}
+			rec := AuditRecord{User: username, Action: "chown", Target: name}
+			rec.Before, _ = json.Marshal(OwnerResponse{Path: name, Owner: fs.OwnerOf(fsys.Resolve(name))})
+			changed, err := fsys.Chown(ctx, name, req.From, req.Owner, req.Recursive)
+			rec.After, _ = json.Marshal(req)
+			if err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, rec)
+			writeJson(w, http.StatusOK, OwnerResponse{Path: name, Owner: fs.OwnerOf(fsys.Resolve(name)), Changed: changed})
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
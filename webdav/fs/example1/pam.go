@@ -0,0 +1,138 @@
+//go:build pam
+// +build pam
+
+package example1
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+// Answer every password prompt with the password, and nothing else.
+static int webdev_conv(int n, const struct pam_message **msg, struct pam_response **resp, void *password) {
+	struct pam_response *r = calloc(n, sizeof(struct pam_response));
+	if (r == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < n; i++) {
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+			r[i].resp = strdup((const char *)password);
+			break;
+		case PAM_PROMPT_ECHO_ON:
+			for (int j = 0; j < i; j++) {
+				free(r[j].resp);
+			}
+			free(r);
+			return PAM_CONV_ERR;
+		}
+	}
+	*resp = r;
+	return PAM_SUCCESS;
+}
+
+static int webdev_pam_check(const char *service, const char *user, const char *password, const char **message) {
+	struct pam_conv conv = { webdev_conv, (void *)password };
+	pam_handle_t *h = NULL;
+	int rc = pam_start(service, user, &conv, &h);
+	if (rc != PAM_SUCCESS) {
+		*message = "pam_start failed";
+		return rc;
+	}
+	rc = pam_authenticate(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (rc == PAM_SUCCESS) {
+		rc = pam_acct_mgmt(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	}
+	*message = pam_strerror(h, rc);
+	pam_end(h, rc);
+	return rc;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/user"
+	"unsafe"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Check passwords with PAM, as the service named with -pam, which
+  needs a file in /etc/pam.d.  Claims come from the claims file of
+  the user, with their unix groups added as claims.groups.groups.
+  This is only built with -tags pam, since it needs libpam.
+*/
+type pamAuthenticator struct {
+	service  string
+	verified *passwordCache
+}
+
+func (p *pamAuthenticator) Verify(ctx context.Context, username, password string) (Claims, error) {
+	if password == "" {
+		return Claims{}, ErrBadPassword
+	}
+	if p.verified.ok(username, password) {
+		return Claims{}, nil
+	}
+	service := C.CString(p.service)
+	defer C.free(unsafe.Pointer(service))
+	name := C.CString(username)
+	defer C.free(unsafe.Pointer(name))
+	pw := C.CString(password)
+	defer C.free(unsafe.Pointer(pw))
+	var message *C.char
+	rc := C.webdev_pam_check(service, name, pw, &message)
+	switch rc {
+	case C.PAM_SUCCESS:
+		p.verified.add(username, password)
+		return Claims{}, nil
+	case C.PAM_AUTH_ERR, C.PAM_USER_UNKNOWN, C.PAM_MAXTRIES, C.PAM_ACCT_EXPIRED, C.PAM_NEW_AUTHTOK_REQD, C.PAM_PERM_DENIED:
+		return Claims{}, ErrBadPassword
+	}
+	return Claims{}, fmt.Errorf("pam: %s", C.GoString(message))
+}
+
+func (p *pamAuthenticator) Claims(ctx context.Context, root, username string) (Claims, error) {
+	claims, err := fileClaims{}.Claims(ctx, root, username)
+	if err != nil || claims.Groups == nil {
+		claims = Claims{Groups: make(map[string][]string)}
+	}
+	claims.Groups["username"] = []string{username}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return claims, nil
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return claims, nil
+	}
+	var groups []string
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	if len(groups) > 0 {
+		claims.Groups[ldapDefaultGroupsClaim] = groups
+	}
+	return claims, nil
+}
+
+func setupPAM(service string) {
+	if service == "" {
+		return
+	}
+	verified, err := newPasswordCache()
+	if err != nil {
+		log.Fatalf("WEBDAV: %v", err)
+	}
+	p := &pamAuthenticator{service: service, verified: verified}
+	authenticator = p
+	claimsProvider = p
+	webdav.Log().Info("using pam", "service", service)
+}
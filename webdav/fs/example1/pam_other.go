@@ -0,0 +1,13 @@
+//go:build !pam
+// +build !pam
+
+package example1
+
+import "log"
+
+// PAM needs libpam, so it is only built with -tags pam
+func setupPAM(service string) {
+	if service != "" {
+		log.Fatalf("WEBDAV: this server was built without pam. Build it with -tags pam")
+	}
+}
@@ -0,0 +1,311 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// ErrNotRego is answered by the policy api when the engine does not read rego files.
+var ErrNotRego = errors.New("webdav: the policy engine is not rego")
+
+/*
+  A policy has to compile, and it has to produce the
+  permission object that we look for in package policy.
+  Otherwise it would silently lock everyone out.
+*/
+func validateRego(opaObj string) error {
+	_, err := evalRego(context.Background(), emptyClaims, opaObj)
+	return err
+}
+
+/*
+  Previous versions of a policy are kept beside it as
+  .__security.rego.1, .__security.rego.2, and so on.
+*/
+type PolicyVersion struct {
+	Version int       `json:"version"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+func policyVersions(regoFile string) ([]PolicyVersion, error) {
+	entries, err := ioutil.ReadDir(path.Dir(regoFile))
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Base(regoFile) + "."
+	versions := make([]PolicyVersion, 0)
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(e.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, PolicyVersion{Version: n, ModTime: e.ModTime(), Size: e.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// How many previous versions of each policy the versions job keeps, or 0 for all of them
+var policyVersionsKeep = 20
+
+/*
+  Drop the oldest previous versions of every policy on the volume,
+  beyond the ones to keep.
+*/
+func policyVersionsJob(t *Tenant, interval time.Duration) fs.Job {
+	return fs.Job{
+		Name:     "versions",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			if policyVersionsKeep <= 0 {
+				return nil
+			}
+			return filepath.Walk(t.fsys.Root, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if info.IsDir() || !strings.HasPrefix(info.Name(), ".__") || !strings.HasSuffix(info.Name(), "security.rego") {
+					return nil
+				}
+				versions, err := policyVersions(p)
+				if err != nil {
+					return err
+				}
+				for i := 0; i < len(versions)-policyVersionsKeep; i++ {
+					if err := os.Remove(fmt.Sprintf("%s.%d", p, versions[i].Version)); err != nil {
+						return err
+					}
+					webdav.Log().Info("dropped policy version", "policy", p, "version", versions[i].Version)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+/*
+  Keep a copy of the current policy (if any), then replace it.
+*/
+func writePolicy(regoFile string, opaObj []byte) (before []byte, err error) {
+	before, err = ioutil.ReadFile(regoFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		versions, err := policyVersions(regoFile)
+		if err != nil {
+			return nil, err
+		}
+		next := 1
+		if len(versions) > 0 {
+			next = versions[len(versions)-1].Version + 1
+		}
+		err = ioutil.WriteFile(fmt.Sprintf("%s.%d", regoFile, next), before, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return before, ioutil.WriteFile(regoFile, opaObj, 0644)
+}
+
+/*
+  A sample input for a dry run.  The result is what
+  the policy would decide for it.
+*/
+type DryRun struct {
+	Claims Claims                 `json:"claims"`
+	Action fs.Action              `json:"action"`
+	Result map[string]interface{} `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type dryRunRequest struct {
+	// Rego is the policy to try out.  Default is the policy in effect.
+	Rego   string   `json:"rego,omitempty"`
+	Inputs []DryRun `json:"inputs"`
+}
+
+/*
+  Manage the rego policy attached to a file or directory.
+
+    GET  /.__api/policy?path=/rob                     policy on this path, and the one in effect
+    PUT  /.__api/policy?path=/rob                     validate and replace the policy
+    POST /.__api/policy/dryrun?path=/rob              evaluate sample inputs
+    POST /.__api/policy/test?path=/rob                run .__policy_tests.json against the posted rego, or the policy in effect
+    GET  /.__api/policy/versions?path=/rob            list previous versions
+    POST /.__api/policy/rollback?path=/rob&version=2  restore a previous version
+
+  These are all of rego files, so with any other engine, which would
+  not read what they check and write, they are 501.
+*/
+func policyHandler(fsys fs.FS, engine string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if engine != "rego" {
+			writeJsonError(w, http.StatusNotImplemented, ErrNotRego)
+			return
+		}
+		actor, _ := ctx.Value("username").(string)
+		name := fsys.Resolve(r.URL.Query().Get("path"))
+		if _, err := os.Stat(name); name == "" || err != nil {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		regoFile := fs.NameFor(name, "security.rego")
+		op := strings.TrimPrefix(r.URL.Path, apiPrefix+"policy")
+
+		switch {
+		case op == "" && r.Method == "GET":
+			own, _ := ioutil.ReadFile(regoFile)
+			writeJson(w, http.StatusOK, map[string]string{
+				"rego":      string(own),
+				"effective": regoOf(fsys.Root, name),
+			})
+		case op == "" && r.Method == "PUT":
+			if !mayChange(w, tenantOf(ctx), r.URL.Query().Get("path")) {
+				return
+			}
+			opaObj, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := validateRego(string(opaObj)); err != nil {
+				writeJsonError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			// Don't deploy a policy that fails the tests for this directory
+			report, err := RunPolicyTests(fsys.Root, name, string(opaObj))
+			if err != nil {
+				writeJsonError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			if report.Failed > 0 {
+				writeJson(w, http.StatusUnprocessableEntity, report)
+				return
+			}
+			before, err := writePolicy(regoFile, opaObj)
+			auditPolicy(ctx, actor, "policy.update", regoFile, before, opaObj, err)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			invalidateCaches(ctx, CacheScope{Path: webdav.SlashClean(r.URL.Query().Get("path"))})
+			writeJson(w, http.StatusOK, map[string]string{"rego": string(opaObj)})
+		case op == "/dryrun" && r.Method == "POST":
+			var req dryRunRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if req.Rego == "" {
+				req.Rego = regoOf(fsys.Root, name)
+			}
+			for i := range req.Inputs {
+				in := &req.Inputs[i]
+				if in.Action.Name == "" {
+					in.Action.Name = name
+				}
+				result, err := evalRego(r.Context(), ClaimsContext{Claims: in.Claims, Action: in.Action}, req.Rego, dataOf(fsys.Root, name))
+				in.Result = result
+				if err != nil {
+					in.Error = err.Error()
+				}
+			}
+			writeJson(w, http.StatusOK, req.Inputs)
+		case op == "/test" && r.Method == "POST":
+			opaObj, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			report, err := RunPolicyTests(fsys.Root, name, string(opaObj))
+			if err != nil {
+				writeJsonError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			status := http.StatusOK
+			if report.Failed > 0 {
+				status = http.StatusUnprocessableEntity
+			}
+			writeJson(w, status, report)
+		case op == "/versions" && r.Method == "GET":
+			versions, err := policyVersions(regoFile)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusOK, versions)
+		case op == "/rollback" && r.Method == "POST":
+			if !mayChange(w, tenantOf(ctx), r.URL.Query().Get("path")) {
+				return
+			}
+			version, err := strconv.Atoi(r.URL.Query().Get("version"))
+			if err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			opaObj, err := ioutil.ReadFile(fmt.Sprintf("%s.%d", regoFile, version))
+			if err != nil {
+				writeJsonError(w, http.StatusNotFound, err)
+				return
+			}
+			if err := validateRego(string(opaObj)); err != nil {
+				writeJsonError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			before, err := writePolicy(regoFile, opaObj)
+			auditPolicy(ctx, actor, "policy.rollback", regoFile, before, opaObj, err)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			invalidateCaches(ctx, CacheScope{Path: webdav.SlashClean(r.URL.Query().Get("path"))})
+			writeJson(w, http.StatusOK, map[string]string{"rego": string(opaObj)})
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+func auditPolicy(ctx context.Context, actor, action, regoFile string, before, after []byte, err error) {
+	rec := AuditRecord{
+		User:   actor,
+		Action: action,
+		Target: regoFile,
+		Before: json.RawMessage(AsJson(string(before))),
+		After:  json.RawMessage(AsJson(string(after))),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	audit(ctx, rec)
+}
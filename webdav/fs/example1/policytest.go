@@ -0,0 +1,109 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A PolicyTest is one row of a .__policy_tests.json file:
+  given these claims trying this action, the policy must
+  decide at least what is in Expect.  A false in Expect
+  also matches a rule that is undefined for the input.
+
+  [
+    {
+      "name": "rob can write",
+      "claims": {"groups": {"username": ["rob"]}},
+      "action": {"action": "Write"},
+      "expect": {"Write": true, "Delete": true}
+    }
+  ]
+*/
+type PolicyTest struct {
+	Name   string                 `json:"name"`
+	Claims Claims                 `json:"claims"`
+	Action fs.Action              `json:"action"`
+	Expect map[string]interface{} `json:"expect"`
+}
+
+type PolicyTestResult struct {
+	Name     string                 `json:"name"`
+	Pass     bool                   `json:"pass"`
+	Got      map[string]interface{} `json:"got,omitempty"`
+	Mismatch []string               `json:"mismatch,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+type PolicyTestReport struct {
+	Tests  string             `json:"tests"`
+	Passed int                `json:"passed"`
+	Failed int                `json:"failed"`
+	Cases  []PolicyTestResult `json:"cases"`
+}
+
+func policyTestsFor(name string) string {
+	return fs.NameFor(name, "policy_tests.json")
+}
+
+/*
+  Run the tests attached to name (a path on the volume) against
+  opaObj, or against the policy in effect there if opaObj is empty.
+  It is not an error for there to be no tests.
+*/
+func RunPolicyTests(root, name, opaObj string) (PolicyTestReport, error) {
+	report := PolicyTestReport{Tests: policyTestsFor(name), Cases: make([]PolicyTestResult, 0)}
+	data, err := ioutil.ReadFile(report.Tests)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	var tests []PolicyTest
+	if err := json.Unmarshal(data, &tests); err != nil {
+		return report, fmt.Errorf("parsing %s: %v", report.Tests, err)
+	}
+	if opaObj == "" {
+		opaObj = regoOf(root, name)
+	}
+	policyData := dataOf(root, name)
+	for i, t := range tests {
+		result := PolicyTestResult{Name: t.Name, Pass: true}
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("case %d", i+1)
+		}
+		if t.Action.Name == "" {
+			t.Action.Name = name
+		}
+		got, err := evalRego(context.Background(), ClaimsContext{Claims: t.Claims, Action: t.Action}, opaObj, policyData)
+		result.Got = got
+		if err != nil {
+			result.Pass = false
+			result.Error = err.Error()
+		}
+		for k, want := range t.Expect {
+			v, ok := got[k]
+			if !ok && want == false {
+				continue
+			}
+			if !reflect.DeepEqual(v, want) {
+				result.Pass = false
+				result.Mismatch = append(result.Mismatch, fmt.Sprintf("%s: expected %v, got %v", k, want, v))
+			}
+		}
+		if result.Pass {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	return report, nil
+}
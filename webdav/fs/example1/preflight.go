@@ -0,0 +1,171 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+// The most checks that one preflight may ask for
+const maxPreflightChecks = 10000
+
+/*
+  One thing that a client means to do.  The action is one of the
+  policy's, create, read, write, delete or stat, or a WebDAV method.
+  COPY and MOVE also check that the destination can be written.
+*/
+type PreflightCheck struct {
+	Path        string `json:"path"`
+	Action      string `json:"action"`
+	Destination string `json:"destination,omitempty"`
+}
+
+type PreflightResult struct {
+	PreflightCheck
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type PreflightReport struct {
+	Allowed int               `json:"allowed"`
+	Denied  int               `json:"denied"`
+	Results []PreflightResult `json:"results"`
+}
+
+var webdavMethods = map[string]bool{
+	"OPTIONS": true, "GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"MKCOL": true, "COPY": true, "MOVE": true, "LOCK": true, "UNLOCK": true,
+	"PROPFIND": true, "PROPPATCH": true,
+}
+
+type preflightAnswer struct {
+	capabilities webdav.Capabilities
+	methods      []string
+	err          error
+}
+
+/*
+  Check many actions at once, before starting on a big upload or
+  move, rather than finding out half way through.
+
+    POST /.__api/preflight
+    [{"path": "/rob/a.txt", "action": "PUT"},
+     {"path": "/rob/old", "action": "MOVE", "destination": "/rob/new"},
+     {"path": "/jp/b.txt", "action": "read"}]
+
+  Each path is asked of the policy once, however many checks name it.
+*/
+func preflightHandler(srv *webdav.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 8<<20))
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		var checks []PreflightCheck
+		if err := json.Unmarshal(data, &checks); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(checks) > maxPreflightChecks {
+			writeJsonError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("at most %d checks at once", maxPreflightChecks))
+			return
+		}
+		ctx := r.Context()
+		answers := make(map[string]preflightAnswer)
+		ask := func(name string) preflightAnswer {
+			name = webdav.SlashClean(name)
+			a, ok := answers[name]
+			if !ok {
+				a.capabilities, a.methods, a.err = srv.Allowed(ctx, name)
+				answers[name] = a
+			}
+			return a
+		}
+		report := PreflightReport{Results: make([]PreflightResult, 0, len(checks))}
+		for _, check := range checks {
+			result := PreflightResult{PreflightCheck: check}
+			result.Allowed, result.Reason = preflight(check, ask)
+			if result.Allowed {
+				report.Allowed++
+			} else {
+				report.Denied++
+			}
+			report.Results = append(report.Results, result)
+		}
+		writeJson(w, http.StatusOK, report)
+	})
+}
+
+func preflight(check PreflightCheck, ask func(name string) preflightAnswer) (bool, string) {
+	if check.Path == "" {
+		return false, "no path"
+	}
+	a := ask(check.Path)
+	if a.err != nil {
+		return false, a.err.Error()
+	}
+	c := a.capabilities
+	method := strings.ToUpper(check.Action)
+	if webdavMethods[method] {
+		allowed := false
+		for _, m := range a.methods {
+			allowed = allowed || m == method
+		}
+		if !allowed {
+			if !c.Exists && method != "PUT" && method != "MKCOL" && method != "LOCK" {
+				return false, "not found"
+			}
+			return false, "denied"
+		}
+		if method == "COPY" || method == "MOVE" {
+			if check.Destination == "" {
+				return false, "no destination"
+			}
+			d := ask(check.Destination)
+			if d.err != nil {
+				return false, d.err.Error()
+			}
+			if !(d.capabilities.Exists && d.capabilities.Write) && !(!d.capabilities.Exists && d.capabilities.Create) {
+				return false, "destination denied"
+			}
+		}
+		return true, ""
+	}
+	var allowed bool
+	switch strings.ToLower(check.Action) {
+	case "create":
+		if c.Exists {
+			return false, "exists"
+		}
+		if !c.Create {
+			return false, "denied"
+		}
+		return true, ""
+	case "stat":
+		allowed = c.Exists
+	case "read":
+		allowed = c.Read
+	case "write":
+		allowed = c.Write
+	case "delete":
+		allowed = c.Delete
+	default:
+		return false, fmt.Sprintf("unknown action %s", check.Action)
+	}
+	if !c.Exists {
+		return false, "not found"
+	}
+	if !allowed {
+		return false, "denied"
+	}
+	return true, ""
+}
@@ -0,0 +1,309 @@
+package example1
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/caldav"
+	"github.com/rfielding/webdev/webdav/carddav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Where the users and groups of a tenant are, as principals, under its prefix
+const principalsPrefix = "/.principals/"
+
+/*
+  The users and groups of a tenant, as WebDAV principals (RFC 3744),
+  which calendar and contacts clients look for, and which a picker of
+  whom to share with can list:
+
+    /.principals/users/rob/
+    /.principals/groups/engineering/
+
+  Users are those with a home directory that the ClaimsProvider knows,
+  and groups are the values of their groups claim, and the groups that
+  spaces are provisioned for, which have no members until somebody is
+  put in them.  A user's display
+  name and email come from their name and email claims.
+*/
+type principalTree struct {
+	Tenant *Tenant
+}
+
+var _ webdav.FileSystem = principalTree{}
+
+// The href of the principal of username.
+func (pt principalTree) userHref(username string) string {
+	return pt.href("users", username)
+}
+
+func (pt principalTree) href(kind, name string) string {
+	return (&url.URL{Path: path.Join("/", pt.Tenant.Prefix, principalsPrefix, kind, name) + "/"}).EscapedPath()
+}
+
+// The users, with their claims, by name.
+func (pt principalTree) users(ctx context.Context) map[string]Claims {
+	infos, err := ioutil.ReadDir(pt.Tenant.Root)
+	if err != nil {
+		return nil
+	}
+	users := make(map[string]Claims)
+	for _, fi := range infos {
+		if !fi.IsDir() || validUsername(fi.Name()) != nil || fi.Name() == spacesDir {
+			continue
+		}
+		claims, err := claimsProvider.Claims(ctx, pt.Tenant.Root, fi.Name())
+		if err != nil {
+			continue
+		}
+		users[fi.Name()] = claims
+	}
+	return users
+}
+
+// The members of each group, by name, with the groups of spaces.
+func groupsOf(users map[string]Claims, spaces []Space) map[string][]string {
+	groups := make(map[string][]string)
+	for _, s := range spaces {
+		groups[s.Group] = nil
+	}
+	for username, claims := range users {
+		for _, g := range claims.Groups[ldapDefaultGroupsClaim] {
+			groups[g] = append(groups[g], username)
+		}
+	}
+	for _, members := range groups {
+		sort.Strings(members)
+	}
+	return groups
+}
+
+// The principal, or collection of them, at name.
+func (pt principalTree) open(ctx context.Context, name string) (*principalDir, error) {
+	parts := strings.Split(strings.Trim(webdav.SlashClean(name), "/"), "/")
+	now := time.Now()
+	d := &principalDir{info: principalInfo{name: parts[len(parts)-1], modTime: now}, props: make(map[xml.Name]webdav.Property)}
+	switch {
+	case parts[0] == "":
+		d.info.name = "/"
+		for _, kind := range []string{"users", "groups"} {
+			d.list = append(d.list, principalInfo{name: kind, modTime: now})
+		}
+		return d, nil
+	case len(parts) > 2 || (parts[0] != "users" && parts[0] != "groups"):
+		return nil, os.ErrNotExist
+	}
+	users := pt.users(ctx)
+	if parts[0] == "users" {
+		if len(parts) == 1 {
+			for username := range users {
+				d.list = append(d.list, principalInfo{name: username, modTime: now})
+			}
+			d.sortList()
+			return d, nil
+		}
+		claims, ok := users[parts[1]]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		pt.userProps(d.props, parts[1], claims)
+		return d, nil
+	}
+	groups := groupsOf(users, spacesOf(pt.Tenant.Root))
+	if len(parts) == 1 {
+		for g := range groups {
+			d.list = append(d.list, principalInfo{name: g, modTime: now})
+		}
+		d.sortList()
+		return d, nil
+	}
+	members, ok := groups[parts[1]]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	principalProps(d.props, parts[1], pt.href("groups", parts[1]))
+	var hrefs []string
+	for _, username := range members {
+		hrefs = append(hrefs, pt.userHref(username))
+	}
+	setHrefs(d.props, xml.Name{Space: "DAV:", Local: "group-member-set"}, hrefs)
+	return d, nil
+}
+
+// The properties of the principal of username.
+func (pt principalTree) userProps(props map[xml.Name]webdav.Property, username string, claims Claims) {
+	displayName := username
+	if names := claims.Groups["name"]; len(names) > 0 {
+		displayName = names[0]
+	}
+	principalProps(props, displayName, pt.userHref(username))
+	var groups, mailto []string
+	for _, g := range claims.Groups[ldapDefaultGroupsClaim] {
+		groups = append(groups, pt.href("groups", g))
+	}
+	for _, email := range claims.Groups["email"] {
+		mailto = append(mailto, "mailto:"+email)
+	}
+	setHrefs(props, xml.Name{Space: "DAV:", Local: "group-membership"}, groups)
+	setHrefs(props, xml.Name{Space: "DAV:", Local: "alternate-URI-set"}, mailto)
+	// calendar and contacts clients look for their homes here
+	home := path.Join("/", pt.Tenant.Prefix, username) + "/"
+	if caldavEnabled {
+		setHrefs(props, xml.Name{Space: caldav.Namespace, Local: "calendar-home-set"}, []string{home})
+		setHrefs(props, xml.Name{Space: caldav.Namespace, Local: "calendar-user-address-set"}, append(mailto, pt.userHref(username)))
+	}
+	if carddavEnabled {
+		setHrefs(props, xml.Name{Space: carddav.Namespace, Local: "addressbook-home-set"}, []string{home})
+	}
+}
+
+// What every principal has.  These are served as dead properties, which
+// come before the live ones.
+func principalProps(props map[xml.Name]webdav.Property, displayName, href string) {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(displayName))
+	props[xml.Name{Space: "DAV:", Local: "displayname"}] = webdav.Property{
+		XMLName:  xml.Name{Space: "DAV:", Local: "displayname"},
+		InnerXML: b.Bytes(),
+	}
+	props[xml.Name{Space: "DAV:", Local: "resourcetype"}] = webdav.Property{
+		XMLName:  xml.Name{Space: "DAV:", Local: "resourcetype"},
+		InnerXML: []byte(`<D:collection xmlns:D="DAV:"/><D:principal xmlns:D="DAV:"/>`),
+	}
+	setHrefs(props, xml.Name{Space: "DAV:", Local: "principal-URL"}, []string{href})
+}
+
+func setHrefs(props map[xml.Name]webdav.Property, pname xml.Name, hrefs []string) {
+	var b bytes.Buffer
+	for _, href := range hrefs {
+		b.WriteString(`<D:href xmlns:D="DAV:">`)
+		xml.EscapeText(&b, []byte(href))
+		b.WriteString(`</D:href>`)
+	}
+	props[pname] = webdav.Property{XMLName: pname, InnerXML: b.Bytes()}
+}
+
+func (pt principalTree) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return webdav.ErrNotAllowed
+}
+
+func (pt principalTree) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	return pt.open(ctx, name)
+}
+
+func (pt principalTree) RemoveAll(ctx context.Context, name string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (pt principalTree) Rename(ctx context.Context, oldName, newName string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (pt principalTree) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	d, err := pt.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.info, nil
+}
+
+type principalInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi principalInfo) Name() string       { return fi.name }
+func (fi principalInfo) Size() int64        { return 0 }
+func (fi principalInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (fi principalInfo) ModTime() time.Time { return fi.modTime }
+func (fi principalInfo) IsDir() bool        { return true }
+func (fi principalInfo) Sys() interface{}   { return nil }
+
+// A principal, or a collection of them, which are all directories.
+type principalDir struct {
+	info  principalInfo
+	list  []os.FileInfo
+	pos   int
+	props map[xml.Name]webdav.Property
+}
+
+func (d *principalDir) sortList() {
+	sort.Slice(d.list, func(i, j int) bool { return d.list[i].Name() < d.list[j].Name() })
+}
+
+func (d *principalDir) Read(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (d *principalDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (d *principalDir) Write(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (d *principalDir) Close() error {
+	return nil
+}
+
+func (d *principalDir) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		rest := d.list[d.pos:]
+		d.pos = len(d.list)
+		return rest, nil
+	}
+	if d.pos >= len(d.list) {
+		return nil, io.EOF
+	}
+	end := d.pos + count
+	if end > len(d.list) {
+		end = len(d.list)
+	}
+	rest := d.list[d.pos:end]
+	d.pos = end
+	return rest, nil
+}
+
+func (d *principalDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *principalDir) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return d.props, nil
+}
+
+func (d *principalDir) Patch(p []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return nil, webdav.ErrNotAllowed
+}
+
+/*
+  Browse the principals, read only, as clients that discover
+  calendars and address books do.
+*/
+func principalsHandler(t *Tenant, principal func(ctx context.Context) string) http.Handler {
+	return &webdav.Handler{
+		Prefix:     t.Prefix + strings.TrimSuffix(principalsPrefix, "/"),
+		FileSystem: webdav.ReadOnlyFS{FileSystem: principalTree{Tenant: t}},
+		LockSystem: fs.NewMemLS(),
+		Principal:  principal,
+		// nothing here changes, so there is nothing to lock
+		LockModes: map[string]webdav.LockMode{"/": webdav.LockNone},
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				webdav.Log().Warn("request failed", "request_id", webdav.RequestID(r.Context()), "user", r.Context().Value("username"), "method", r.Method, "url", r.URL, "err", err)
+			}
+		},
+	}
+}
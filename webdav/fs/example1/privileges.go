@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package example1
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  Become the user that spec names, as user or user:group, once the
+  server has bound its ports, so that it can listen on 443 and 21 as
+  root and serve as someone who is not.  Without a group, it is the
+  user's own.
+*/
+func dropPrivileges(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	name, group := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	gidString := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gidString = g.Gid
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("uid of %s: %v", name, err)
+	}
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return fmt.Errorf("gid of %s: %v", spec, err)
+	}
+	// the group goes first, while there is still the right to change it
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %v", err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("could become root again after becoming %s", name)
+	}
+	webdav.Log().Info("dropped privileges", "user", name, "uid", uid, "gid", gid)
+	return nil
+}
@@ -0,0 +1,11 @@
+package example1
+
+import "errors"
+
+// There is no root to give up on Windows, where a service runs as the account it is given.
+func dropPrivileges(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	return errors.New("-user is not supported on windows")
+}
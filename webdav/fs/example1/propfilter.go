@@ -0,0 +1,37 @@
+package example1
+
+import (
+	"context"
+	"encoding/xml"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Policies hide properties from a user with a HiddenProperties list.
+  Properties in the DAV: namespace are named by their local name,
+  and anything else as {namespace}name:
+
+    HiddenProperties = ["classification", "{https://github.com/rfielding/webdev/}tags"] {
+      not Write
+    }
+*/
+func propertyFilter(fsys fs.FS) webdav.PropertyFilter {
+	return func(ctx context.Context, name string) func(xml.Name) bool {
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowStat})
+		list, _ := permission["HiddenProperties"].([]interface{})
+		if len(list) == 0 {
+			return nil
+		}
+		hidden := make(map[string]bool)
+		for _, h := range list {
+			if s, ok := h.(string); ok {
+				hidden[s] = true
+			}
+		}
+		return func(pname xml.Name) bool {
+			return !hidden[fs.PropKey(pname)]
+		}
+	}
+}
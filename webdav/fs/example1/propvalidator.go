@@ -0,0 +1,39 @@
+package example1
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Policies protect properties with a ProtectedProperties list,
+  named the same way as HiddenProperties.  Only admins can set
+  or remove them, so that a label like classification cannot be
+  changed by whoever can write the file:
+
+    ProtectedProperties = ["classification"]
+
+  The quota of a directory is always protected, as it is what holds
+  a space to the size it was provisioned with.
+*/
+type propertyValidator struct {
+	fsys fs.FS
+}
+
+func (v propertyValidator) ValidateProperty(ctx context.Context, name string, p webdav.Property, remove bool) error {
+	permission := v.fsys.PermissionHandler(ctx, fs.Action{Name: v.fsys.Resolve(name), Action: fs.AllowWrite})
+	list, _ := permission["ProtectedProperties"].([]interface{})
+	key := fs.PropKey(p.XMLName)
+	if p.XMLName == quotaProperty && !isAdmin(ctx, v.fsys) {
+		return fmt.Errorf("only admins may change %s", key)
+	}
+	for _, protected := range list {
+		if protected == key && !isAdmin(ctx, v.fsys) {
+			return fmt.Errorf("only admins may change %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package example1
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  The path that the server is served under by a proxy in front of it,
+  as https://example.com/dav/ is.  Every tenant's prefix is under it,
+  so that hrefs carry it, and Destination headers that carry it are
+  understood.  A proxy that takes it off before passing a request on
+  says so in X-Forwarded-Prefix, and the server puts it back.
+*/
+var basePath string
+
+func setBasePath(p string) {
+	if p = strings.TrimSpace(p); p != "" && p != "/" {
+		basePath = webdav.SlashClean(p)
+	}
+}
+
+// prefix, under the base path
+func underBasePath(prefix string) string {
+	if basePath == "" {
+		return prefix
+	}
+	return path.Join(basePath, prefix)
+}
+
+/*
+  The hosts that proxies publish the server as, which a Destination
+  may name it by, as well as the Host of the request.  One without a
+  port is the server on any port.
+*/
+var destinations *webdav.DestinationPolicy
+
+func setPublicHosts(hosts string) {
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if destinations == nil {
+			destinations = &webdav.DestinationPolicy{}
+		}
+		destinations.Hosts = append(destinations.Hosts, h)
+	}
+}
+
+// Proxies that -proxies trusts, as well as those that -network does
+func parseProxies(list string) ([]*net.IPNet, error) {
+	var proxies []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			proxies = append(proxies, s)
+		}
+	}
+	return parseNets(proxies)
+}
+
+/*
+  A proxy that a request passed through, and what it said of the
+  client that it came from: its address, and the scheme and host
+  that it asked for.
+*/
+type hop struct {
+	For   string
+	Proto string
+	Host  string
+}
+
+/*
+  The proxies that a request passed through, from the client's end,
+  as Forwarded (RFC 7239) has them:
+
+    Forwarded: for=192.0.2.60;proto=https;host=dav.example.com, for=10.0.0.5
+
+  or, when it is not there, as X-Forwarded-For does, which has no
+  scheme or host for each of them.
+*/
+func forwardedHops(r *http.Request) []hop {
+	var hops []hop
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				var h hop
+				for _, pair := range strings.Split(element, ";") {
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) != 2 {
+						continue
+					}
+					value := strings.Trim(kv[1], `"`)
+					switch strings.ToLower(kv[0]) {
+					case "for":
+						h.For = forwardedAddr(value)
+					case "proto":
+						h.Proto = strings.ToLower(value)
+					case "host":
+						h.Host = value
+					}
+				}
+				hops = append(hops, h)
+			}
+		}
+		return hops
+	}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(v, ",") {
+			hops = append(hops, hop{For: strings.TrimSpace(addr)})
+		}
+	}
+	return hops
+}
+
+// The address in a for= of Forwarded, which may have a port, and brackets around IPv6.
+func forwardedAddr(s string) string {
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+}
+
+// The last of a list of values, which may be split over headers, or with commas
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	list := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(list[len(list)-1])
+}
+
+/*
+  What trusted proxies said of a request: who the client is, and the
+  scheme, host and base path that it asked for.  Anything that a proxy
+  did not say is empty.
+*/
+type forwarding struct {
+	ip     net.IP
+	proto  string
+	host   string
+	prefix string
+}
+
+/*
+  The request as the client made it, before the proxies: with the
+  host it asked for, so that Destination headers that name it are
+  for this server, and with the base path that a proxy took off.
+*/
+func (f forwarding) apply(r *http.Request) *http.Request {
+	if f.proto == "" && f.host == "" && f.prefix == "" {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	r2.URL = &u
+	if f.host != "" {
+		r2.Host = f.host
+	}
+	if f.proto == "http" || f.proto == "https" {
+		r2.URL.Scheme = f.proto
+	}
+	if f.prefix != "" && basePath != "" && webdav.SlashClean(f.prefix) == basePath &&
+		u.Path != basePath && !strings.HasPrefix(u.Path, basePath+"/") {
+		r2.URL.Path = basePath + u.Path
+		if u.RawPath != "" {
+			r2.URL.RawPath = basePath + u.RawPath
+		}
+		r2.RequestURI = r2.URL.RequestURI()
+	}
+	return r2
+}
+
+// The scheme that the client used, to the server or to the proxy in front of it.
+func schemeOf(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,328 @@
+package example1
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A config file holds flags, one to a line, with # for comments:
+
+    loglevel=info
+    tenants=./tenants.json
+    impersonation=./impersonation.json
+
+  Flags given on the command line are taken over the file.
+*/
+var configFile string
+
+// The flags that were given on the command line
+var commandLine = make(map[string]bool)
+
+func readConfig(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		name := strings.TrimLeft(strings.TrimSpace(parts[0]), "-")
+		if len(parts) != 2 || flag.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s:%d: not a flag: %s", file, i+1, line)
+		}
+		config[name] = strings.TrimSpace(parts[1])
+	}
+	return config, nil
+}
+
+// Set the flags that the config file has, and the command line does not.
+func setupConfig(file string) {
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+	if file == "" {
+		return
+	}
+	config, err := readConfig(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot read config: %v", err)
+	}
+	for name, value := range config {
+		if commandLine[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("WEBDAV: config %s: %v", name, err)
+		}
+	}
+	configFile = file
+}
+
+/*
+  The flags that a reload takes up.  Any other that changes waits for
+  a restart, though the tenants file that -tenants names is read again.
+*/
+var reloadableFlags = map[string]bool{
+	"loglevel":      true,
+	"mounts":        true,
+	"network":       true,
+	"impersonation": true,
+}
+
+// What is served, for a reload to change
+var served struct {
+	sync.Mutex
+	tenants []*Tenant
+	network *networkPolicy
+}
+
+/*
+  Reload on SIGHUP, as well as when an admin asks.
+*/
+func setupReload(tenants []*Tenant, np *networkPolicy) {
+	served.tenants, served.network = tenants, np
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := reload(); err != nil {
+				webdav.Log().Error("cannot reload", "err", err)
+			}
+		}
+	}()
+}
+
+/*
+  What a reload did: the settings it read again, and the flags that
+  changed but are only taken up by a restart.
+*/
+type ReloadResult struct {
+	Reloaded []string `json:"reloaded"`
+	Restart  []string `json:"restart,omitempty"`
+}
+
+/*
+  Read the config file again, and the files that it and the command
+  line name, and take up what they say.  Everything is read and
+  checked before anything changes, so a reload that fails leaves the
+  server as it was, and one that works changes it all at once.
+  Requests being served, uploads among them, carry on with what they
+  began with.
+*/
+func reload() (ReloadResult, error) {
+	served.Lock()
+	defer served.Unlock()
+	var result ReloadResult
+
+	// what the flags would be, if the server started now
+	config := make(map[string]string)
+	if configFile != "" {
+		var err error
+		if config, err = readConfig(configFile); err != nil {
+			return result, err
+		}
+	}
+	want := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := config[f.Name]
+		switch {
+		case commandLine[f.Name]:
+			value = f.Value.String()
+		case !ok:
+			value = f.DefValue
+		}
+		want[f.Name] = value
+		if value != f.Value.String() && !reloadableFlags[f.Name] {
+			result.Restart = append(result.Restart, f.Name)
+		}
+	})
+
+	level, err := webdav.ParseLevel(want["loglevel"])
+	if err != nil {
+		return result, err
+	}
+	result.Reloaded = append(result.Reloaded, "loglevel")
+
+	network := served.network
+	if network != nil {
+		np := &networkPolicy{}
+		if want["network"] != "" {
+			if np, err = loadNetworkPolicy(want["network"]); err != nil {
+				return result, fmt.Errorf("network: %v", err)
+			}
+		}
+		result.Reloaded = append(result.Reloaded, "network")
+		network = np
+	} else if want["network"] != "" {
+		result.Restart = append(result.Restart, "network")
+	}
+
+	var policy impersonationPolicy
+	if want["impersonation"] != "" {
+		if policy, err = loadImpersonationPolicy(want["impersonation"]); err != nil {
+			return result, fmt.Errorf("impersonation: %v", err)
+		}
+		result.Reloaded = append(result.Reloaded, "impersonation")
+	}
+
+	changes, err := reloadTenants(want, &result)
+	if err != nil {
+		return result, err
+	}
+
+	// all is well: take it up
+	for name, value := range want {
+		if reloadableFlags[name] {
+			flag.Set(name, value)
+		}
+	}
+	setLogLevel(level)
+	if network != nil {
+		served.network.replace(network)
+	}
+	impersonation.Lock()
+	impersonation.policy = policy
+	impersonation.Unlock()
+	for _, c := range changes {
+		atomic.StoreInt64(&c.tenant.Quota, c.quota)
+		c.tenant.Mounts, c.tenant.mounts = c.config, c.mounts
+		c.tenant.srv.SetMounts(c.mounts)
+	}
+	sort.Strings(result.Restart)
+	webdav.Log().Info("reloaded", "reloaded", strings.Join(result.Reloaded, ","), "restart", strings.Join(result.Restart, ","))
+	return result, nil
+}
+
+// What a reload changes of a tenant
+type tenantChange struct {
+	tenant *Tenant
+	quota  int64
+	config map[string]string
+	mounts map[string]webdav.Mount
+}
+
+/*
+  The quotas and mounts that the tenants file, or -mounts, now gives
+  each tenant.  Tenants that come or go, or change anything else, wait
+  for a restart.
+*/
+func reloadTenants(want map[string]string, result *ReloadResult) ([]tenantChange, error) {
+	configs := []*Tenant{{Name: defaultTenant.Name, Quota: defaultTenant.Quota}}
+	if want["tenants"] != flag.Lookup("tenants").Value.String() {
+		// already waiting for a restart
+		return nil, nil
+	}
+	if want["tenants"] == "" {
+		mounts, err := parseMounts(want["mounts"])
+		if err != nil {
+			return nil, fmt.Errorf("mounts: %v", err)
+		}
+		configs[0].Mounts = mounts
+	} else {
+		var err error
+		if configs, err = loadTenants(want["tenants"]); err != nil {
+			return nil, fmt.Errorf("tenants: %v", err)
+		}
+	}
+	byName := make(map[string]*Tenant)
+	for _, t := range configs {
+		byName[t.Name] = t
+	}
+	var changes []tenantChange
+	for _, t := range served.tenants {
+		c, ok := byName[t.Name]
+		delete(byName, t.Name)
+		if !ok {
+			result.Restart = append(result.Restart, "tenant "+t.Name)
+			continue
+		}
+		if want["tenants"] != "" && !sameTenant(t, c) {
+			result.Restart = append(result.Restart, "tenant "+t.Name)
+		}
+		mounts, err := newMounts(t, c.Mounts, t.newEngine)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, tenantChange{tenant: t, quota: c.Quota, config: c.Mounts, mounts: mounts})
+		result.Reloaded = append(result.Reloaded, "quota and mounts of "+tenantLabel(t))
+	}
+	for name := range byName {
+		result.Restart = append(result.Restart, "tenant "+name)
+	}
+	return changes, nil
+}
+
+// Whether t and c differ only in what a reload changes
+func sameTenant(t, c *Tenant) bool {
+	return t.Host == c.Host && t.Prefix == c.Prefix && t.Root == c.Root &&
+		t.Engine == c.Engine && t.ACL == c.ACL && t.Shares == c.Shares &&
+		t.Journal == c.Journal && t.Replica == c.Replica && t.Snapshots == c.Snapshots
+}
+
+func tenantLabel(t *Tenant) string {
+	if t.Name == "" {
+		return "the volume"
+	}
+	return t.Name
+}
+
+var ErrReloadTenant = errors.New("webdav: a server with tenants is reloaded with SIGHUP")
+
+/*
+  Reload the configuration, as SIGHUP does:
+
+    POST /.__api/reload
+
+  It answers with what was read again, and what waits for a restart,
+  or with why nothing changed.  A server with tenants is reloaded with
+  SIGHUP, rather than by the admin of any one of them.
+*/
+func reloadHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if tenantOf(ctx) != defaultTenant {
+			writeJsonError(w, http.StatusForbidden, ErrReloadTenant)
+			return
+		}
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		result, err := reload()
+		rec := AuditRecord{User: username, Action: "reload", Target: configFile}
+		rec.After, _ = json.Marshal(result)
+		if err != nil {
+			rec.Error = err.Error()
+			audit(ctx, rec)
+			writeJsonError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		audit(ctx, rec)
+		writeJson(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,678 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/client"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// The most conflicts kept, newest last
+const maxReplicaConflicts = 1000
+
+// How many changes are replayed between saves of the state
+const replicaBatch = 100
+
+/*
+  What was last written to the replica for a path: the ETag that
+  the replica gave the content, and the dead properties that were
+  set on it.
+*/
+type ReplicatedFile struct {
+	ETag  string   `json:"etag,omitempty"`
+	Props []string `json:"props,omitempty"`
+}
+
+/*
+  A change that was not made on the replica, because the replica
+  had changed what it would overwrite, or refused it.
+*/
+type ReplicaConflict struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Reason     string    `json:"reason"`
+	RemoteETag string    `json:"remote_etag,omitempty"`
+}
+
+type ReplicaState struct {
+	Cursor    uint64                    `json:"cursor"`
+	Paths     map[string]ReplicatedFile `json:"paths"`
+	Conflicts []ReplicaConflict         `json:"conflicts"`
+}
+
+/*
+  Mirrors the changes in a tenant's journal to a secondary server,
+  for an active/passive pair.  The cursor, and what was written,
+  are kept in a file beside the journal, so that it carries on
+  where it was after a restart.
+*/
+type replicator struct {
+	// held for a whole run, or repair
+	mu        sync.Mutex
+	fsys      fs.FS
+	journal   *changeJournal
+	remote    *client.Client
+	stateFile string
+	state     ReplicaState
+	lastRun   time.Time
+	lastError string
+}
+
+func newReplicator(replica, root string, j *changeJournal, stateFile string) (*replicator, error) {
+	remote, err := client.New(replica)
+	if err != nil {
+		return nil, err
+	}
+	if remote.Username != "" && remote.Password == "" {
+		remote.Password = os.Getenv("WEBDEV_REPLICA_PASSWORD")
+	}
+	r := &replicator{
+		fsys:      fs.FS{Root: root},
+		journal:   j,
+		remote:    remote,
+		stateFile: stateFile,
+		state:     ReplicaState{Paths: make(map[string]ReplicatedFile)},
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.state); err != nil {
+		return nil, fmt.Errorf("%s: %v", stateFile, err)
+	}
+	if r.state.Paths == nil {
+		r.state.Paths = make(map[string]ReplicatedFile)
+	}
+	return r, nil
+}
+
+func (r *replicator) save() error {
+	data, err := json.Marshal(r.state)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.stateFile+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(r.stateFile+".tmp", r.stateFile)
+}
+
+// What the replica has is not what was last written there
+var errReplicaChanged = errors.New("changed on the replica")
+
+/*
+  An error that may go away by itself, so the change is tried again
+  on the next run, rather than passed over.
+*/
+func retryable(err error) bool {
+	if err == errReplicaChanged {
+		return false
+	}
+	se, ok := err.(*client.StatusError)
+	return !ok || se.StatusCode >= 500 || se.StatusCode == http.StatusLocked || se.StatusCode == http.StatusTooManyRequests
+}
+
+func (r *replicator) conflict(c Change, name, reason, remoteETag string) {
+	webdav.Log().Warn("replication conflict", "seq", c.Seq, "method", c.Method, "path", name, "reason", reason)
+	r.state.Conflicts = append(r.state.Conflicts, ReplicaConflict{
+		Seq: c.Seq, Time: time.Now().UTC(), Method: c.Method, Path: name, Reason: reason, RemoteETag: remoteETag,
+	})
+	if len(r.state.Conflicts) > maxReplicaConflicts {
+		r.state.Conflicts = r.state.Conflicts[len(r.state.Conflicts)-maxReplicaConflicts:]
+	}
+}
+
+/*
+  Replay what is new in the journal, until the replica has caught
+  up.  A change that the replica refuses is noted as a conflict and
+  passed over.  If the replica cannot be reached, the run stops at
+  that change, to try it again next time.
+*/
+func (r *replicator) run(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastRun = time.Now().UTC()
+	err := r.catchUp(ctx)
+	r.lastError = ""
+	if err != nil {
+		r.lastError = err.Error()
+	}
+	return err
+}
+
+func (r *replicator) catchUp(ctx context.Context) error {
+	for {
+		var batch []Change
+		err := r.journal.since(r.state.Cursor, func(c Change) bool {
+			batch = append(batch, c)
+			return len(batch) < replicaBatch
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		for _, c := range batch {
+			if err := r.replay(ctx, c); err != nil {
+				if retryable(err) {
+					if saveErr := r.save(); saveErr != nil {
+						webdav.Log().Error("cannot save replication state", "file", r.stateFile, "err", saveErr)
+					}
+					return fmt.Errorf("change %d: %v", c.Seq, err)
+				}
+				r.conflict(c, c.Path, err.Error(), "")
+			}
+			r.state.Cursor = c.Seq
+		}
+		if err := r.save(); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *replicator) replay(ctx context.Context, c Change) error {
+	if isMetadataPath(c.Path) || (c.Destination != "" && isMetadataPath(c.Destination)) {
+		return nil
+	}
+	switch c.Method {
+	case "PUT", "POST", "MKCOL":
+		return r.mirror(ctx, c, c.Path, false, false)
+	case "PROPPATCH":
+		if _, err := os.Stat(r.fsys.Resolve(c.Path)); err != nil {
+			return nil
+		}
+		return r.pushProps(ctx, c.Path)
+	case "DELETE":
+		return r.remove(ctx, c, c.Path, false)
+	case "COPY":
+		return r.mirror(ctx, c, c.Destination, true, false)
+	case "MOVE":
+		if r.unchanged(ctx, c.Path) == nil {
+			if err := r.remote.Move(ctx, c.Path, c.Destination, true); err == nil {
+				r.renamed(c.Path, c.Destination)
+				return nil
+			} else if retryable(err) {
+				return err
+			}
+		}
+		// the replica could not move it, so send the result instead
+		if err := r.mirror(ctx, c, c.Destination, true, false); err != nil {
+			return err
+		}
+		return r.remove(ctx, c, c.Path, false)
+	}
+	return nil
+}
+
+/*
+  Nil if what the replica has at name is what was last written
+  there.  Directories are not checked, since what is in them is.
+*/
+func (r *replicator) unchanged(ctx context.Context, name string) error {
+	res, err := r.remote.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !res.Dir && r.state.Paths[name].ETag != res.ETag {
+		return errReplicaChanged
+	}
+	return nil
+}
+
+// Move what was written under one name to another.
+func (r *replicator) renamed(from, to string) {
+	for name, f := range r.state.Paths {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			delete(r.state.Paths, name)
+			r.state.Paths[to+strings.TrimPrefix(name, from)] = f
+		}
+	}
+}
+
+// Forget what was written under name.
+func (r *replicator) forget(name string) {
+	for n := range r.state.Paths {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			delete(r.state.Paths, n)
+		}
+	}
+}
+
+/*
+  Send what the primary has at name now, and with recursive, what
+  is under it.  A name that is gone by now is left for the change
+  that removed it.  With force, whatever the replica has is written
+  over.
+*/
+func (r *replicator) mirror(ctx context.Context, c Change, name string, recursive, force bool) error {
+	local := r.fsys.Resolve(name)
+	info, err := os.Stat(local)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return r.putFile(ctx, c, name, info, force)
+	}
+	if !recursive {
+		return r.makeDir(ctx, c, name)
+	}
+	return filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".__") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := r.fsys.VolumePath(p)
+		if info.IsDir() {
+			return r.makeDir(ctx, c, name)
+		}
+		return r.putFile(ctx, c, name, info, force)
+	})
+}
+
+// Make a directory on the replica, and any parents it lacks.
+func (r *replicator) makeDir(ctx context.Context, c Change, name string) error {
+	if name == "/" {
+		return nil
+	}
+	res, err := r.remote.Stat(ctx, name)
+	if err == nil {
+		if !res.Dir {
+			r.conflict(c, name, "a file on the replica", res.ETag)
+			return nil
+		}
+		return r.pushProps(ctx, name)
+	}
+	if !client.IsNotExist(err) {
+		return err
+	}
+	if err := r.ensureParent(ctx, name); err != nil {
+		return err
+	}
+	if err := r.remote.Mkdir(ctx, name); err != nil {
+		return err
+	}
+	return r.pushProps(ctx, name)
+}
+
+func (r *replicator) ensureParent(ctx context.Context, name string) error {
+	parent := path.Dir(name)
+	if parent == "/" {
+		return nil
+	}
+	_, err := r.remote.Stat(ctx, parent)
+	if !client.IsNotExist(err) {
+		return err
+	}
+	if err := r.ensureParent(ctx, parent); err != nil {
+		return err
+	}
+	return r.remote.Mkdir(ctx, parent)
+}
+
+func (r *replicator) putFile(ctx context.Context, c Change, name string, info os.FileInfo, force bool) error {
+	res, err := r.remote.Stat(ctx, name)
+	switch {
+	case client.IsNotExist(err):
+		if err := r.ensureParent(ctx, name); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case force:
+		if res.Dir {
+			if err := r.remote.Delete(ctx, name); err != nil {
+				return err
+			}
+			r.forget(name)
+		}
+	case res.Dir:
+		r.conflict(c, name, "a directory on the replica", "")
+		return nil
+	case r.state.Paths[name].ETag != res.ETag:
+		r.conflict(c, name, errReplicaChanged.Error(), res.ETag)
+		return nil
+	}
+	f, err := os.Open(r.fsys.Resolve(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	etag, err := r.remote.Put(ctx, name, f, info.Size())
+	if err != nil {
+		return err
+	}
+	rec := r.state.Paths[name]
+	rec.ETag = etag
+	r.state.Paths[name] = rec
+	return r.pushProps(ctx, name)
+}
+
+/*
+  Set the dead properties of name on the replica to its own on the
+  primary, removing any that were sent before and are gone now.
+  Inherited properties come from the defaults of directories, which
+  the replica has its own of.
+*/
+func (r *replicator) pushProps(ctx context.Context, name string) error {
+	own := fs.OwnProperties(r.fsys.Resolve(name))
+	rec := r.state.Paths[name]
+	if len(own) == 0 && len(rec.Props) == 0 {
+		return nil
+	}
+	set := make(map[xml.Name]string)
+	var keys []string
+	for k, v := range own {
+		set[fs.PropName(k)] = v
+		keys = append(keys, k)
+	}
+	var remove []xml.Name
+	for _, k := range rec.Props {
+		if _, ok := own[k]; !ok {
+			remove = append(remove, fs.PropName(k))
+		}
+	}
+	if err := r.remote.Proppatch(ctx, name, set, remove); err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	rec.Props = keys
+	r.state.Paths[name] = rec
+	return nil
+}
+
+func (r *replicator) remove(ctx context.Context, c Change, name string, force bool) error {
+	if !force {
+		if err := r.unchanged(ctx, name); client.IsNotExist(err) {
+			r.forget(name)
+			return nil
+		} else if err != nil {
+			if retryable(err) {
+				return err
+			}
+			r.conflict(c, name, err.Error(), "")
+			return nil
+		}
+	}
+	if err := r.remote.Delete(ctx, name); err != nil && !client.IsNotExist(err) {
+		return err
+	}
+	r.forget(name)
+	return nil
+}
+
+/*
+  How the replica differs from the primary under a path.  Missing
+  is on the primary and not the replica, extra is on the replica
+  and not the primary, and different is on both, but not the same
+  size or kind, or changed on the replica since it was written.
+*/
+type ReconcileReport struct {
+	Path      string    `json:"path"`
+	Time      time.Time `json:"time"`
+	Files     int       `json:"files"`
+	Missing   []string  `json:"missing"`
+	Extra     []string  `json:"extra"`
+	Different []string  `json:"different"`
+	Repaired  int       `json:"repaired"`
+	Errors    []string  `json:"errors,omitempty"`
+}
+
+type localEntry struct {
+	dir  bool
+	size int64
+}
+
+/*
+  Compare the primary and the replica under name, and with repair,
+  make the replica the same as the primary.  Repairs are made
+  between runs, so that they do not cross with the changes being
+  replayed.
+*/
+func (r *replicator) reconcile(ctx context.Context, name string, repair bool) (ReconcileReport, error) {
+	name = webdav.SlashClean(name)
+	report := ReconcileReport{Path: name, Time: time.Now().UTC(), Missing: []string{}, Extra: []string{}, Different: []string{}}
+	local := make(map[string]localEntry)
+	err := filepath.Walk(r.fsys.Resolve(name), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".__") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		local[r.fsys.VolumePath(p)] = localEntry{dir: info.IsDir(), size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	remote := make(map[string]client.Resource)
+	found, err := r.remote.ReadDir(ctx, name, true)
+	if err != nil && !client.IsNotExist(err) {
+		return report, err
+	}
+	for _, res := range found {
+		if !isMetadataPath(res.Name) {
+			remote[res.Name] = res
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, l := range local {
+		if n == name {
+			continue
+		}
+		report.Files++
+		res, ok := remote[n]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, n)
+		case l.dir != res.Dir:
+			report.Different = append(report.Different, n)
+		case !l.dir && (l.size != res.Size || r.state.Paths[n].ETag != res.ETag):
+			report.Different = append(report.Different, n)
+		}
+	}
+	for n := range remote {
+		if _, ok := local[n]; !ok {
+			report.Extra = append(report.Extra, n)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Different)
+	if !repair {
+		return report, nil
+	}
+
+	c := Change{Method: "REPAIR"}
+	fix := func(n string, err error) {
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", n, err))
+			return
+		}
+		report.Repaired++
+		kept := r.state.Conflicts[:0]
+		for _, conflict := range r.state.Conflicts {
+			if conflict.Path != n {
+				kept = append(kept, conflict)
+			}
+		}
+		r.state.Conflicts = kept
+	}
+	// parents sort before what is in them
+	for _, n := range append(append([]string{}, report.Missing...), report.Different...) {
+		if res, ok := remote[n]; ok && res.Dir != local[n].dir {
+			if err := r.remote.Delete(ctx, n); err != nil {
+				fix(n, err)
+				continue
+			}
+			r.forget(n)
+		}
+		fix(n, r.mirror(ctx, c, n, false, true))
+	}
+	removed := ""
+	for _, n := range report.Extra {
+		if removed != "" && strings.HasPrefix(n, removed+"/") {
+			continue
+		}
+		fix(n, r.remove(ctx, c, n, true))
+		removed = n
+	}
+	return report, r.save()
+}
+
+type ReplicationStatus struct {
+	Replica   string            `json:"replica"`
+	Cursor    uint64            `json:"cursor"`
+	Last      uint64            `json:"last"`
+	Lag       uint64            `json:"lag"`
+	LastRun   time.Time         `json:"last_run"`
+	LastError string            `json:"last_error,omitempty"`
+	Conflicts []ReplicaConflict `json:"conflicts"`
+}
+
+func (r *replicator) status() ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := ReplicationStatus{
+		Replica:   r.remote.Base.String(),
+		Cursor:    r.state.Cursor,
+		Last:      r.journal.last(),
+		LastRun:   r.lastRun,
+		LastError: r.lastError,
+		Conflicts: append([]ReplicaConflict{}, r.state.Conflicts...),
+	}
+	if s.Last > s.Cursor {
+		s.Lag = s.Last - s.Cursor
+	}
+	return s
+}
+
+func replicateJob(r *replicator, interval time.Duration) fs.Job {
+	return fs.Job{
+		Name:     "replicate",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run:      r.run,
+	}
+}
+
+/*
+  Watch and repair replication.  Admin only.
+
+    GET    /.__api/replication                            cursor, lag and conflicts
+    POST   /.__api/replication?reconcile=/rob             how the replica differs
+    POST   /.__api/replication?reconcile=/&repair=true    and make it the same
+    DELETE /.__api/replication                            forget the conflicts
+
+  A repair writes over whatever the replica has, so it is how a
+  replica is first filled, and how conflicts are settled in favour
+  of the primary.
+*/
+func replicationHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		r := tenantOf(ctx).replicator
+		if r == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no replica"))
+			return
+		}
+		actor, _ := ctx.Value("username").(string)
+		switch req.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, r.status())
+		case "POST":
+			name := req.URL.Query().Get("reconcile")
+			if name == "" {
+				name = "/"
+			}
+			repair := req.URL.Query().Get("repair") == "true"
+			report, err := r.reconcile(ctx, name, repair)
+			if repair {
+				rec := AuditRecord{User: actor, Action: "replication.repair", Target: report.Path, After: json.RawMessage(AsJson(report))}
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				audit(ctx, rec)
+			}
+			if err != nil {
+				writeJsonError(w, http.StatusBadGateway, err)
+				return
+			}
+			writeJson(w, http.StatusOK, report)
+		case "DELETE":
+			r.mu.Lock()
+			n := len(r.state.Conflicts)
+			r.state.Conflicts = nil
+			err := r.save()
+			r.mu.Unlock()
+			audit(ctx, AuditRecord{User: actor, Action: "replication.clear", Target: r.remote.Base.String()})
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]int{"cleared": n})
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+/*
+  Start mirroring a tenant's changes to its replica, from where it
+  was before.
+*/
+func setupReplica(t *Tenant) {
+	if t.Replica == "" {
+		return
+	}
+	if t.journal == nil {
+		log.Fatalf("WEBDAV: replicating %q needs a journal", t.Name)
+	}
+	r, err := newReplicator(t.Replica, t.Root, t.journal, t.Journal+".replica.json")
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot set up replica for %q: %v", t.Name, err)
+	}
+	t.replicator = r
+	webdav.Log().Info("replicating", "tenant", t.Name, "replica", r.remote.Base.String(), "cursor", r.state.Cursor)
+}
@@ -0,0 +1,164 @@
+package example1
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  WebDAV methods that change things, which are audited when
+  they succeed, named by their lower cased method.
+*/
+var auditedMethods = map[string]bool{
+	"PUT": true, "DELETE": true, "MKCOL": true, "COPY": true, "MOVE": true, "PROPPATCH": true,
+}
+
+/*
+  An audit sink that can be searched, for reports.
+*/
+type AuditReader interface {
+	Records(tenant, user string, from, to time.Time) ([]AuditRecord, error)
+}
+
+/*
+  Scan the audit file for the records of a user.  The file is
+  read from the start, so this suits files that are rotated.
+*/
+func (s *fileAuditSink) Records(tenant, user string, from, to time.Time) ([]AuditRecord, error) {
+	f, err := os.Open(s.f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	found := make([]AuditRecord, 0)
+	scanner := bufio.NewScanner(f)
+	// policies are in the records, and can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Tenant != tenant || rec.User != user {
+			continue
+		}
+		if rec.Time.Before(from) || !rec.Time.Before(to) {
+			continue
+		}
+		found = append(found, rec)
+	}
+	return found, scanner.Err()
+}
+
+func auditReader() AuditReader {
+	for _, sink := range auditSinks {
+		if r, ok := sink.(AuditReader); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+/*
+  What a user did, and what they were refused, over a while.
+*/
+type ActivityReport struct {
+	User       string        `json:"user"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Operations []AuditRecord `json:"operations"`
+	Denials    []AuditRecord `json:"denials"`
+	// More records matched than the limit
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+const defaultReportLimit = 1000
+
+/*
+  Report on a user, for compliance reviews.  Admins can report on
+  anyone, and users on themselves.  from and to are RFC 3339
+  times, and default to the last 30 days.
+
+    GET /.__api/report?user=jp&from=2026-10-01T00:00:00Z&to=2026-10-16T00:00:00Z&limit=100
+
+  It needs an audit file, given with -a.
+*/
+func reportHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		q := r.URL.Query()
+		user := q.Get("user")
+		if user == "" {
+			user = username
+		}
+		if user != username && !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		to := time.Now().UTC()
+		from := to.Add(-30 * 24 * time.Hour)
+		var err error
+		if s := q.Get("from"); s != "" {
+			if from, err = time.Parse(time.RFC3339, s); err != nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("from: %v", err))
+				return
+			}
+		}
+		if s := q.Get("to"); s != "" {
+			if to, err = time.Parse(time.RFC3339, s); err != nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("to: %v", err))
+				return
+			}
+		}
+		limit := defaultReportLimit
+		if s := q.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+				writeJsonError(w, http.StatusBadRequest, fmt.Errorf("limit must be a positive number"))
+				return
+			}
+		}
+		reader := auditReader()
+		if reader == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no audit file to report from"))
+			return
+		}
+		records, err := reader.Records(tenantOf(ctx).Name, user, from, to)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		report := ActivityReport{
+			User:       user,
+			From:       from,
+			To:         to,
+			Operations: make([]AuditRecord, 0),
+			Denials:    make([]AuditRecord, 0),
+		}
+		// newest last, as written, so keep the newest
+		if len(records) > limit {
+			records = records[len(records)-limit:]
+			report.Truncated = true
+		}
+		for _, rec := range records {
+			if rec.Action == "denied" || rec.Action == "auth failed" {
+				report.Denials = append(report.Denials, rec)
+			} else {
+				report.Operations = append(report.Operations, rec)
+			}
+		}
+		writeJson(w, http.StatusOK, report)
+	})
+}
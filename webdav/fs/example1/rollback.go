@@ -0,0 +1,350 @@
+package example1
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  What a rollback to a snapshot did, or would do with a dry run.
+  Paths are where they land.  ChangedSince are those that the journal
+  says changed after the snapshot was taken, up to the time asked
+  for, which come back as they were at the snapshot and not as they
+  were at that time, since nothing keeps what they were then.
+*/
+type RollbackReport struct {
+	Path         string    `json:"path"`
+	At           time.Time `json:"at"`
+	To           string    `json:"to"`
+	DryRun       bool      `json:"dry_run"`
+	Snapshot     string    `json:"snapshot"`
+	SnapshotTime time.Time `json:"snapshot_time"`
+	Added        []string  `json:"added"`
+	Changed      []string  `json:"changed"`
+	Removed      []string  `json:"removed"`
+	ChangedSince []string  `json:"changed_since"`
+}
+
+/*
+  The newest snapshot in the store that was taken by at, and holds
+  name.  Names say when they were taken, so only those that could
+  be are opened to read what they are of.
+*/
+func findSnapshot(ctx context.Context, store SnapshotStore, name string, at time.Time) (string, fs.SnapshotInfo, error) {
+	list, err := store.List(ctx)
+	if err != nil {
+		return "", fs.SnapshotInfo{}, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+	for _, s := range list {
+		taken, err := time.Parse("20060102T150405Z", strings.SplitN(strings.TrimSuffix(s.Name, ".tar"), "-", 2)[0])
+		if err != nil || taken.After(at) {
+			continue
+		}
+		rc, err := store.Open(ctx, s.Name)
+		if err != nil {
+			return "", fs.SnapshotInfo{}, err
+		}
+		info, err := fs.ReadSnapshotInfo(rc)
+		rc.Close()
+		if err != nil || info.Time.After(at) {
+			continue
+		}
+		if info.Path == "/" || info.Path == name || strings.HasPrefix(name, info.Path+"/") {
+			return s.Name, info, nil
+		}
+	}
+	return "", fs.SnapshotInfo{}, fmt.Errorf("no snapshot of %s from before %s: %w", name, at.Format(time.RFC3339), os.ErrNotExist)
+}
+
+type treeEntry struct {
+	dir  bool
+	size int64
+}
+
+/*
+  Everything under dir, by slash separated path, or nothing if it is
+  not there.  What a rollback left behind while it unpacked, before it
+  did that beside the volume, is not part of it.
+*/
+func listTree(dir string) (map[string]treeEntry, error) {
+	tree := make(map[string]treeEntry)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".__pitr-") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel, _ := filepath.Rel(dir, p); rel != "." {
+			tree["/"+filepath.ToSlash(rel)] = treeEntry{dir: info.IsDir(), size: info.Size()}
+		}
+		return nil
+	})
+	return tree, err
+}
+
+func sameContent(a, b string) bool {
+	sum := func(name string) []byte {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil
+		}
+		return h.Sum(nil)
+	}
+	sa, sb := sum(a), sum(b)
+	return sa != nil && bytes.Equal(sa, sb)
+}
+
+/*
+  Make to the same as name was in the newest snapshot taken by at.
+  What is in the way is written over, and what was not there then is
+  removed.  to may be name itself, to roll it back, or somewhere new,
+  to look before doing that.
+*/
+func rollbackToSnapshot(ctx context.Context, t *Tenant, name, to string, at time.Time, dryRun bool) (RollbackReport, error) {
+	report := RollbackReport{Path: name, At: at, To: to, DryRun: dryRun, Added: []string{}, Changed: []string{}, Removed: []string{}, ChangedSince: []string{}}
+	stored, info, err := findSnapshot(ctx, t.snapshots, name, at)
+	if err != nil {
+		return report, err
+	}
+	report.Snapshot, report.SnapshotTime = stored, info.Time
+
+	// unpack what is wanted beside the volume, on the same file system,
+	// so it can be moved in, and is not taken for part of what it replaces
+	root := filepath.Clean(t.fsys.Root)
+	staging, err := ioutil.TempDir(filepath.Dir(root), filepath.Base(root)+".rollback-")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(staging)
+	rc, err := t.snapshots.Open(ctx, stored)
+	if err != nil {
+		return report, err
+	}
+	sub := name
+	if info.Path != "/" {
+		sub = strings.TrimPrefix(name, info.Path)
+	}
+	from := filepath.Join(staging, "tree")
+	_, err = fs.RestoreSubtree(ctx, rc, sub, from)
+	rc.Close()
+	if err != nil {
+		return report, err
+	}
+
+	t.writes.RLock()
+	defer t.writes.RUnlock()
+	target := t.fsys.Resolve(to)
+	then, err := listTree(from)
+	if err != nil {
+		return report, err
+	}
+	now, err := listTree(target)
+	if err != nil {
+		return report, err
+	}
+	for p, e := range then {
+		n, ok := now[p]
+		switch {
+		case !ok:
+			report.Added = append(report.Added, p)
+		case e.dir != n.dir:
+			report.Changed = append(report.Changed, p)
+		case !e.dir && (e.size != n.size || !sameContent(filepath.Join(from, p), filepath.Join(target, p))):
+			report.Changed = append(report.Changed, p)
+		}
+	}
+	for p := range now {
+		if _, ok := then[p]; !ok {
+			report.Removed = append(report.Removed, p)
+		}
+	}
+	if t.journal != nil {
+		seen := make(map[string]bool)
+		err := t.journal.since(0, func(c Change) bool {
+			if c.Time.After(at) {
+				return false
+			}
+			if !c.Time.After(info.Time) {
+				return true
+			}
+			for _, p := range []string{c.Path, c.Destination} {
+				if p != "" && (p == name || strings.HasPrefix(p, name+"/")) && !seen[p] {
+					seen[p] = true
+					report.ChangedSince = append(report.ChangedSince, to+strings.TrimPrefix(p, name))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Removed)
+	sort.Strings(report.ChangedSince)
+	if !dryRun {
+		if err := applyRollback(ctx, t, &report, from, target); err != nil {
+			return report, err
+		}
+	}
+	for _, list := range [][]string{report.Added, report.Changed, report.Removed} {
+		for i, p := range list {
+			list[i] = path.Join(to, p)
+		}
+	}
+	return report, nil
+}
+
+func applyRollback(ctx context.Context, t *Tenant, report *RollbackReport, from, target string) error {
+	actor, _ := ctx.Value("username").(string)
+	note := func(method, p string) {
+		if t.journal == nil {
+			return
+		}
+		c := Change{Time: time.Now().UTC(), User: actor, Method: method, Path: path.Join(report.To, p)}
+		if _, err := t.journal.append(c); err != nil {
+			webdav.Log().Error("cannot journal change", "request_id", webdav.RequestID(ctx), "journal", t.journal.name, "err", err)
+		}
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	// the removed sort before what is in them, which go with them
+	removed := ""
+	for _, p := range report.Removed {
+		if removed != "" && strings.HasPrefix(p, removed+"/") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(target, p)); err != nil {
+			return err
+		}
+		note("DELETE", p)
+		removed = p
+	}
+	// parents sort before what is in them, so they are there first
+	put := append(append([]string{}, report.Added...), report.Changed...)
+	sort.Strings(put)
+	moved := ""
+	for _, p := range put {
+		if moved != "" && strings.HasPrefix(p, moved+"/") {
+			continue
+		}
+		dst := filepath.Join(target, p)
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		src := filepath.Join(from, p)
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// a new directory comes with all that is in it
+			if err := os.Rename(src, dst); err != nil {
+				return err
+			}
+			note("MKCOL", p)
+			moved = p
+			continue
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		note("PUT", p)
+	}
+	return nil
+}
+
+/*
+  Roll a directory back to the newest snapshot of it that was taken
+  by a time.  Admin only, and audited.
+
+    POST /.__api/rollback?path=/rob&at=2026-10-16T09:00:00Z&dry_run=true
+    POST /.__api/rollback?path=/rob&at=2026-10-16T09:00:00Z&to=/rob-then
+
+  Without to, the directory itself is rolled back.  A dry run lists
+  what would be added, changed and removed, without doing it.
+*/
+func rollbackHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		t := tenantOf(ctx)
+		if t.snapshots == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no snapshot store"))
+			return
+		}
+		q := r.URL.Query()
+		at, err := time.Parse(time.RFC3339, q.Get("at"))
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		name := webdav.SlashClean(q.Get("path"))
+		to := name
+		if q.Get("to") != "" {
+			to = webdav.SlashClean(q.Get("to"))
+		}
+		if fsys.Resolve(to) == "" || isMetadataPath(name) || isMetadataPath(to) {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("cannot restore to %s", to))
+			return
+		}
+		dryRun := q.Get("dry_run") == "true"
+		if !dryRun && !mayChange(w, t, to) {
+			return
+		}
+		report, err := rollbackToSnapshot(ctx, t, name, to, at, dryRun)
+		if !dryRun {
+			actor, _ := ctx.Value("username").(string)
+			rec := AuditRecord{User: actor, Action: "snapshot.rollback", Target: to, After: json.RawMessage(AsJson(report))}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+		}
+		if err != nil {
+			writeJsonError(w, statusOf(err), err)
+			return
+		}
+		writeJson(w, http.StatusOK, report)
+	})
+}
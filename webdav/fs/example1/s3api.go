@@ -0,0 +1,1386 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  An S3 api over the same volume and policy as WebDAV, on a port of
+  its own, for tools that only speak S3.  Buckets are the directories
+  at the top of the volume, and keys are paths in them, so that
+  s3://rob/notes/a.md is /rob/notes/a.md.  Requests are path style,
+  signed with AWS Signature Version 4 by an access key from
+  /.__api/s3keys, which stands in for its user.
+
+  Each operation needs what the same change through WebDAV would:
+
+    ListBuckets, ListObjects, HeadBucket       Stat, of what is listed
+    GetObject, HeadObject                      Read
+    PutObject, CompleteMultipartUpload         Create, or Write to replace
+    CreateMultipartUpload, UploadPart          the same, up front
+    CopyObject                                 Read, and Create or Write
+    DeleteObject, DeleteObjects, DeleteBucket  Delete
+    CreateBucket                               Create, at the top
+
+  Objects are read and written through the WebDAV handler, so that
+  quotas, locks, obligations, the journal and the audit trail all
+  apply as they would to WebDAV.
+*/
+type s3Handler struct {
+	Tenant *Tenant
+	// DAV serves WebDAV for the tenant, once the user is known
+	DAV http.Handler
+}
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// How long a multipart upload may be left before it is swept away
+const s3UploadTimeout = 24 * time.Hour
+
+type s3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errS3AccessDenied     = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errS3NoSuchKey        = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errS3NoSuchBucket     = &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."}
+	errS3NoSuchUpload     = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist."}
+	errS3BadDigest        = &s3Error{http.StatusBadRequest, "BadDigest", "The checksum that was sent does not match what was received."}
+	errS3Signature        = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match."}
+	errS3IncompleteBody   = &s3Error{http.StatusBadRequest, "IncompleteBody", "The body is not all there."}
+	errS3MethodNotAllowed = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The method is not allowed on this resource."}
+	errS3SlowDown         = &s3Error{http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate."}
+)
+
+func s3NotImplemented(what string) error {
+	return &s3Error{http.StatusNotImplemented, "NotImplemented", what + " is not implemented."}
+}
+
+func s3InvalidArgument(format string, args ...interface{}) error {
+	return &s3Error{http.StatusBadRequest, "InvalidArgument", fmt.Sprintf(format, args...)}
+}
+
+// The S3 error for a status that WebDAV gave.
+func s3ErrorFor(status int) *s3Error {
+	switch status {
+	case http.StatusNotFound:
+		return errS3NoSuchKey
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return errS3AccessDenied
+	case http.StatusPreconditionFailed:
+		return &s3Error{status, "PreconditionFailed", "At least one of the preconditions did not hold."}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return &s3Error{status, "InvalidRange", "The requested range is not satisfiable."}
+	}
+	return &s3Error{status, strings.ReplaceAll(http.StatusText(status), " ", ""), http.StatusText(status)}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	var e *s3Error
+	if !errors.As(err, &e) {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			e = errS3NoSuchKey
+		case errors.Is(err, os.ErrPermission), errors.Is(err, webdav.ErrNotAllowed):
+			e = errS3AccessDenied
+		default:
+			e = &s3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+			if status, ok := webdav.StatusOf(err); ok {
+				e = s3ErrorFor(status)
+			}
+		}
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	if r.Method == "HEAD" {
+		return
+	}
+	writeS3XML(w, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestId string
+	}{Code: e.Code, Message: e.Message, Resource: r.URL.Path, RequestId: webdav.RequestID(r.Context())})
+}
+
+func writeS3XML(w io.Writer, v interface{}) {
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Result(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	writeS3XML(w, v)
+}
+
+/*
+  s3Writer passes a WebDAV response on as S3 would give it, with
+  errors in its xml, and 201 Created as 200 OK.
+*/
+type s3Writer struct {
+	http.ResponseWriter
+	r      *http.Request
+	wrote  bool
+	failed bool
+}
+
+func (sw *s3Writer) WriteHeader(status int) {
+	if sw.wrote {
+		return
+	}
+	sw.wrote = true
+	if status >= 400 {
+		sw.failed = true
+		writeS3Error(sw.ResponseWriter, sw.r, s3ErrorFor(status))
+		return
+	}
+	if status == http.StatusCreated {
+		status = http.StatusOK
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *s3Writer) Write(b []byte) (int, error) {
+	if !sw.wrote {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.failed {
+		return len(b), nil
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Serve a WebDAV request, and fail unless it did.
+func (h s3Handler) dav(req *http.Request) (*statusRecorder, error) {
+	res := &statusRecorder{header: make(http.Header)}
+	h.DAV.ServeHTTP(res, req)
+	if res.status == 0 {
+		res.status = http.StatusOK
+	}
+	if res.status >= 300 {
+		return res, s3ErrorFor(res.status)
+	}
+	return res, nil
+}
+
+/*
+  What was signed, once the signature has been checked, for the
+  payload to be checked against as it is read.
+*/
+type s3Auth struct {
+	key        S3Key
+	signingKey []byte
+	amzDate    string
+	scope      string
+	signature  string
+	payload    string
+}
+
+/*
+  Check the AWS Signature Version 4 in the Authorization header,
+  by the secret of the access key it names.
+*/
+func verifyS3(r *http.Request) (*s3Auth, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		if r.URL.Query().Get("X-Amz-Signature") != "" {
+			return nil, s3NotImplemented("A presigned url")
+		}
+		return nil, errS3AccessDenied
+	}
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(part), "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	malformed := &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[3] != "s3" || credential[4] != "aws4_request" || fields["Signature"] == "" {
+		return nil, malformed
+	}
+	if s3Keys == nil {
+		return nil, errS3AccessDenied
+	}
+	key, ok := s3Keys.get(credential[0])
+	if !ok {
+		return nil, &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key id does not exist."}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	at, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, credential[1]) {
+		return nil, malformed
+	}
+	if skew := time.Since(at); skew > 15*time.Minute || skew < -15*time.Minute {
+		return nil, &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	}
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		return nil, s3InvalidArgument("x-amz-content-sha256 is required")
+	}
+	signed := fields["SignedHeaders"]
+	var canonical strings.Builder
+	for _, name := range strings.Split(signed, ";") {
+		value := strings.Join(r.Header.Values(name), ",")
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			if value == "" {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		case "transfer-encoding":
+			value = strings.Join(r.TransferEncoding, ",")
+		}
+		canonical.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		awsEscape(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		canonical.String(),
+		signed,
+		payload,
+	}, "\n")
+	auth := &s3Auth{
+		key:        key,
+		signingKey: signingKeyV4(key.Secret, credential[1], credential[2]),
+		amzDate:    amzDate,
+		scope:      strings.Join(credential[1:], "/"),
+		payload:    payload,
+	}
+	auth.signature = signatureV4(auth.signingKey, "AWS4-HMAC-SHA256", amzDate, auth.scope, sha256Hex([]byte(canonicalRequest)))
+	if !hmac.Equal([]byte(auth.signature), []byte(fields["Signature"])) {
+		return nil, errS3Signature
+	}
+	return auth, nil
+}
+
+/*
+  A body read into a temporary file, checked against what the client
+  said it would be, and ready to be read again.
+*/
+type spooled struct {
+	f    *os.File
+	size int64
+	md5  []byte
+}
+
+func (s *spooled) Close() error {
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+/*
+  Read the body of r into dir, undoing aws-chunked encoding, and
+  check the payload hash that was signed, any chunk signatures, and
+  any checksums in the headers or the trailer.
+*/
+func spoolBody(r *http.Request, auth *s3Auth, dir string) (*spooled, error) {
+	f, err := ioutil.TempFile(dir, ".spool-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &spooled{f: f}
+	sums := map[string]hash.Hash{
+		"md5":    md5.New(),
+		"sha256": sha256.New(),
+		"sha1":   sha1.New(),
+		"crc32":  crc32.NewIEEE(),
+		"crc32c": crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}
+	writers := []io.Writer{f}
+	for _, h := range sums {
+		writers = append(writers, h)
+	}
+	w := io.MultiWriter(writers...)
+	trailer := make(http.Header)
+	if strings.HasPrefix(auth.payload, "STREAMING-") {
+		var signer *chunkSigner
+		switch auth.payload {
+		case "STREAMING-AWS4-HMAC-SHA256-PAYLOAD", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER":
+			signer = &chunkSigner{key: auth.signingKey, amzDate: auth.amzDate, scope: auth.scope, prev: auth.signature}
+		case "STREAMING-UNSIGNED-PAYLOAD-TRAILER":
+		default:
+			s.Close()
+			return nil, s3NotImplemented(auth.payload)
+		}
+		s.size, trailer, err = readAWSChunked(r.Body, w, signer, strings.HasSuffix(auth.payload, "-TRAILER"))
+		if err == nil {
+			if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" && decoded != strconv.FormatInt(s.size, 10) {
+				err = errS3IncompleteBody
+			}
+		}
+	} else {
+		s.size, err = io.Copy(w, r.Body)
+		if err == nil && r.ContentLength >= 0 && s.size != r.ContentLength {
+			err = errS3IncompleteBody
+		}
+		if err == nil && auth.payload != "UNSIGNED-PAYLOAD" && auth.payload != hex.EncodeToString(sums["sha256"].Sum(nil)) {
+			err = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The payload does not match x-amz-content-sha256."}
+		}
+	}
+	if err == nil {
+		if want := r.Header.Get("Content-Md5"); want != "" && want != base64.StdEncoding.EncodeToString(sums["md5"].Sum(nil)) {
+			err = errS3BadDigest
+		}
+		for _, algorithm := range []string{"crc32", "crc32c", "sha1", "sha256"} {
+			want := r.Header.Get("X-Amz-Checksum-" + algorithm)
+			if want == "" {
+				want = trailer.Get("X-Amz-Checksum-" + algorithm)
+			}
+			if want != "" && want != base64.StdEncoding.EncodeToString(sums[algorithm].Sum(nil)) {
+				err = errS3BadDigest
+			}
+		}
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.md5 = sums["md5"].Sum(nil)
+	return s, nil
+}
+
+// Each chunk is signed over the signature of the one before it, starting with the request's.
+type chunkSigner struct {
+	key     []byte
+	amzDate string
+	scope   string
+	prev    string
+}
+
+func (c *chunkSigner) check(algorithm, signature string, hashed ...string) bool {
+	want := signatureV4(c.key, algorithm, c.amzDate, c.scope, append([]string{c.prev}, hashed...)...)
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return false
+	}
+	c.prev = signature
+	return true
+}
+
+func readChunkLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+/*
+  Undo aws-chunked encoding, as SDKs send streamed uploads:
+
+    <hex size>[;chunk-signature=<signature>]\r\n<data>\r\n ... 0...\r\n
+    [x-amz-checksum-crc32:<base64>\r\n ...]\r\n
+
+  With a signer, every chunk must be signed, and so must a trailer
+  when there is meant to be one.
+*/
+func readAWSChunked(body io.Reader, w io.Writer, signer *chunkSigner, trailing bool) (int64, http.Header, error) {
+	malformed := &s3Error{http.StatusBadRequest, "InvalidChunkSizeError", "The aws-chunked body is malformed."}
+	br := bufio.NewReader(body)
+	var n int64
+	for {
+		line, err := readChunkLine(br)
+		if err != nil {
+			return n, nil, errS3IncompleteBody
+		}
+		parts := strings.SplitN(line, ";", 2)
+		size, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 16, 64)
+		if err != nil || size < 0 {
+			return n, nil, malformed
+		}
+		h := sha256.New()
+		if _, err := io.CopyN(io.MultiWriter(w, h), br, size); err != nil {
+			return n, nil, errS3IncompleteBody
+		}
+		n += size
+		if signer != nil {
+			signature := ""
+			if len(parts) == 2 {
+				signature = strings.TrimPrefix(parts[1], "chunk-signature=")
+			}
+			if !signer.check("AWS4-HMAC-SHA256-PAYLOAD", signature, emptySHA256, hex.EncodeToString(h.Sum(nil))) {
+				return n, nil, errS3Signature
+			}
+		}
+		if size == 0 {
+			break
+		}
+		if line, err := readChunkLine(br); err != nil || line != "" {
+			return n, nil, malformed
+		}
+	}
+	trailer := make(http.Header)
+	var canonical strings.Builder
+	signature := ""
+	for {
+		line, err := readChunkLine(br)
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil {
+			return n, nil, malformed
+		}
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return n, nil, malformed
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if name == "x-amz-trailer-signature" {
+			signature = strings.TrimSpace(kv[1])
+			continue
+		}
+		trailer.Add(name, strings.TrimSpace(kv[1]))
+		canonical.WriteString(name + ":" + strings.TrimSpace(kv[1]) + "\n")
+	}
+	if signer != nil && trailing && !signer.check("AWS4-HMAC-SHA256-TRAILER", signature, sha256Hex([]byte(canonical.String()))) {
+		return n, nil, errS3Signature
+	}
+	return n, trailer, nil
+}
+
+/*
+  The S3 api of each tenant, on its own listener.  Tenants are told
+  apart by host alone, since S3 clients cannot put a prefix in
+  front of the bucket.
+*/
+type s3Router struct {
+	Tenants []*Tenant
+}
+
+func (sr s3Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	found := tenantRouter{Tenants: sr.Tenants}.findByHost(r)
+	if found == nil || found.s3 == nil {
+		writeS3Error(w, r, errS3NoSuchBucket)
+		return
+	}
+	found.s3.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "tenant", found)))
+}
+
+func (h s3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", webdav.RequestID(r.Context()))
+	auth, err := verifyS3(r)
+	if err != nil {
+		id := ""
+		if i := strings.Index(r.Header.Get("Authorization"), "Credential="); i >= 0 {
+			id = strings.SplitN(r.Header.Get("Authorization")[i+len("Credential="):], "/", 2)[0]
+		}
+		audit(r.Context(), AuditRecord{User: id, Action: "auth failed", Target: r.URL.Path, Error: err.Error()})
+		writeS3Error(w, r, err)
+		return
+	}
+	ctx := context.WithValue(r.Context(), "username", auth.key.User)
+	noteUser(ctx, auth.key.User)
+	r = r.WithContext(ctx)
+	leave, ok := requestLimit.enter(ctx)
+	if !ok {
+		w.Header().Set("Retry-After", webdav.RetryAfter)
+		writeS3Error(w, r, errS3SlowDown)
+		return
+	}
+	defer leave()
+	bucket, key := "", ""
+	if parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2); len(parts) == 2 {
+		bucket, key = parts[0], parts[1]
+	} else {
+		bucket = parts[0]
+	}
+	q := r.URL.Query()
+	switch {
+	case bucket == "":
+		if r.Method != "GET" {
+			err = errS3MethodNotAllowed
+			break
+		}
+		err = h.listBuckets(w, r)
+	case strings.HasPrefix(bucket, ".") || strings.Contains(bucket, "\\"):
+		err = &s3Error{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid."}
+	case key == "":
+		switch {
+		case r.Method == "GET" && q.Has("location"):
+			err = h.bucketLocation(w, r, bucket)
+		case r.Method == "GET" && q.Has("uploads"):
+			err = s3NotImplemented("ListMultipartUploads")
+		case r.Method == "GET":
+			err = h.listObjects(w, r, bucket)
+		case r.Method == "HEAD":
+			err = h.headBucket(w, r, bucket)
+		case r.Method == "PUT":
+			err = h.createBucket(w, r, bucket)
+		case r.Method == "DELETE":
+			err = h.deleteBucket(w, r, bucket)
+		case r.Method == "POST" && q.Has("delete"):
+			err = h.deleteObjects(w, r, auth, bucket)
+		default:
+			err = errS3MethodNotAllowed
+		}
+	default:
+		name := "/" + bucket + "/" + key
+		if isMetadataPath(name) {
+			err = errS3AccessDenied
+			break
+		}
+		if webdav.SlashClean(name) != strings.TrimSuffix(name, "/") {
+			err = s3InvalidArgument("%q is not a key that can be stored", key)
+			break
+		}
+		if _, err = h.bucket(ctx, bucket); err != nil {
+			break
+		}
+		switch {
+		case (r.Method == "GET" || r.Method == "HEAD") && q.Has("uploadId"):
+			err = h.listParts(w, r, bucket, key)
+		case r.Method == "GET" || r.Method == "HEAD":
+			err = h.getObject(w, r, name)
+		case r.Method == "PUT" && q.Has("uploadId"):
+			err = h.uploadPart(w, r, auth, bucket, key)
+		case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+			err = h.copyObject(w, r, name)
+		case r.Method == "PUT":
+			err = h.putObject(w, r, auth, name)
+		case r.Method == "POST" && q.Has("uploads"):
+			err = h.createMultipartUpload(w, r, bucket, key)
+		case r.Method == "POST" && q.Has("uploadId"):
+			err = h.completeMultipartUpload(w, r, auth, bucket, key)
+		case r.Method == "DELETE" && q.Has("uploadId"):
+			err = h.abortMultipartUpload(w, r, bucket, key)
+		case r.Method == "DELETE":
+			err = h.deleteObject(w, r, name)
+		default:
+			err = errS3MethodNotAllowed
+		}
+	}
+	if err != nil {
+		var e *s3Error
+		if !errors.As(err, &e) || e.Status >= 500 {
+			webdav.Log().Warn("s3 request failed", "request_id", webdav.RequestID(ctx), "user", auth.key.User, "method", r.Method, "url", r.URL, "err", err)
+		}
+		writeS3Error(w, r, err)
+	}
+}
+
+// The directory that is the bucket, if the user may see it.
+func (h s3Handler) bucket(ctx context.Context, bucket string) (os.FileInfo, error) {
+	fi, err := h.Tenant.fsys.Stat(ctx, "/"+bucket)
+	if err != nil || !fi.IsDir() {
+		return nil, errS3NoSuchBucket
+	}
+	return fi, nil
+}
+
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func (h s3Handler) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	infos, err := visibleChildren(ctx, h.Tenant.fsys, "/")
+	if err != nil {
+		return err
+	}
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	user := s3User(ctx)
+	result := struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string
+			DisplayName string
+		}
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{Xmlns: s3Namespace}
+	result.Owner.ID, result.Owner.DisplayName = user, user
+	for _, fi := range infos {
+		if fi.IsDir() {
+			result.Buckets = append(result.Buckets, bucket{Name: fi.Name(), CreationDate: s3Time(fi.ModTime())})
+		}
+	}
+	writeS3Result(w, result)
+	return nil
+}
+
+// The user that the access key stands in for.
+func s3User(ctx context.Context) string {
+	user, _ := ctx.Value("username").(string)
+	return user
+}
+
+func (h s3Handler) headBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if _, err := h.bucket(r.Context(), bucket); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h s3Handler) bucketLocation(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if _, err := h.bucket(r.Context(), bucket); err != nil {
+		return err
+	}
+	writeS3Result(w, struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		Xmlns   string   `xml:"xmlns,attr"`
+	}{Xmlns: s3Namespace})
+	return nil
+}
+
+func (h s3Handler) createBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if _, err := h.Tenant.fsys.Stat(r.Context(), "/"+bucket); err == nil {
+		return &s3Error{http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists."}
+	}
+	if _, err := h.dav(davRequest(r, h.Tenant, "MKCOL", "/"+bucket, nil, 0)); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Only an empty bucket can go, though what goes with it, such as its policy, goes too.
+func (h s3Handler) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	if _, err := h.bucket(ctx, bucket); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(h.Tenant.fsys.Resolve("/" + bucket))
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if !strings.HasPrefix(fi.Name(), ".__") {
+			return &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty."}
+		}
+	}
+	if _, err := h.dav(davRequest(r, h.Tenant, "DELETE", "/"+bucket, nil, 0)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type s3Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+/*
+  The objects under dir in the bucket.  Directories that hold nothing
+  are listed as keys that end with a slash, as folders made by S3
+  consoles are.  When not deep, so are those that hold something.
+*/
+func (h s3Handler) objectsUnder(ctx context.Context, bucket, dir string, deep bool, found *[]s3Object) error {
+	infos, err := visibleChildren(ctx, h.Tenant.fsys, path.Join("/", bucket, dir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		key := strings.TrimPrefix(path.Join(dir, fi.Name()), "/")
+		if !fi.IsDir() {
+			etag, _ := webdav.ETagOf(ctx, fi)
+			*found = append(*found, s3Object{Key: key, Size: fi.Size(), ModTime: fi.ModTime(), ETag: etag})
+			continue
+		}
+		before := len(*found)
+		if deep {
+			if err := h.objectsUnder(ctx, bucket, "/"+key, deep, found); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if len(*found) == before {
+			*found = append(*found, s3Object{Key: key + "/", ModTime: fi.ModTime(), ETag: `"d41d8cd98f00b204e9800998ecf8427e"`})
+		}
+	}
+	return nil
+}
+
+/*
+  ListObjectsV2, with list-type=2, and the ListObjects before it.
+  Continuation tokens are the last key that was listed.
+*/
+func (h s3Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx := r.Context()
+	if _, err := h.bucket(ctx, bucket); err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return s3InvalidArgument("max-keys must be a number that is not negative")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			last, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return s3InvalidArgument("the continuation token is not valid")
+			}
+			after = string(last)
+		}
+	}
+	// only the directory that the prefix is in needs to be looked in
+	dir := "/"
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = "/" + prefix[:i]
+	}
+	if webdav.SlashClean(dir) != dir || isMetadataPath(dir) {
+		dir = ""
+	}
+	var objects []s3Object
+	if dir != "" {
+		err := h.objectsUnder(ctx, bucket, dir, delimiter != "/", &objects)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encode = func(s string) string { return awsEscape(s, false) }
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Marker                *string `xml:",omitempty"`
+		NextMarker            string  `xml:",omitempty"`
+		StartAfter            string  `xml:",omitempty"`
+		ContinuationToken     string  `xml:",omitempty"`
+		NextContinuationToken string  `xml:",omitempty"`
+		KeyCount              *int    `xml:",omitempty"`
+		MaxKeys               int
+		Delimiter             string `xml:",omitempty"`
+		EncodingType          string `xml:",omitempty"`
+		IsTruncated           bool
+		Contents              []contents
+		CommonPrefixes        []commonPrefix
+	}{
+		Xmlns:        s3Namespace,
+		Name:         bucket,
+		Prefix:       encode(prefix),
+		MaxKeys:      maxKeys,
+		Delimiter:    encode(delimiter),
+		EncodingType: q.Get("encoding-type"),
+	}
+	last, count := "", 0
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Key, prefix) || o.Key <= after {
+			continue
+		}
+		common := ""
+		if delimiter != "" {
+			if i := strings.Index(o.Key[len(prefix):], delimiter); i >= 0 {
+				common = o.Key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if common != "" && (common == last || common <= after) {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		if common != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(common)})
+			last = common
+			continue
+		}
+		result.Contents = append(result.Contents, contents{
+			Key:          encode(o.Key),
+			LastModified: s3Time(o.ModTime),
+			ETag:         o.ETag,
+			Size:         o.Size,
+			StorageClass: "STANDARD",
+		})
+		last = o.Key
+	}
+	if v2 {
+		result.KeyCount = &count
+		result.StartAfter = encode(q.Get("start-after"))
+		result.ContinuationToken = q.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encode(q.Get("marker"))
+		result.Marker = &marker
+		if result.IsTruncated {
+			result.NextMarker = encode(last)
+		}
+	}
+	writeS3Result(w, result)
+	return nil
+}
+
+// A key that ends with a slash is a folder, which is a directory here.
+func isFolderKey(name string) bool {
+	return strings.HasSuffix(name, "/")
+}
+
+func (h s3Handler) getObject(w http.ResponseWriter, r *http.Request, name string) error {
+	fi, err := h.Tenant.fsys.Stat(r.Context(), name)
+	if err != nil || fi.IsDir() != isFolderKey(name) {
+		return errS3NoSuchKey
+	}
+	if fi.IsDir() {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	req := davRequest(r, h.Tenant, r.Method, name, nil, 0)
+	for _, k := range []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if v := r.Header.Get(k); v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	h.DAV.ServeHTTP(&s3Writer{ResponseWriter: w, r: r}, req)
+	return nil
+}
+
+// Whether the user may write name, as a new object or over an old one.
+func (h s3Handler) mayPut(ctx context.Context, name string) error {
+	c, err := h.Tenant.fsys.Capabilities(ctx, strings.TrimSuffix(name, "/"))
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Exists && c.Dir && !isFolderKey(name):
+		return &s3Error{http.StatusConflict, "InvalidRequest", "There are objects under this key, so it cannot be one."}
+	case c.Exists && !c.Dir && isFolderKey(name):
+		return &s3Error{http.StatusConflict, "InvalidRequest", "This key is an object, so there cannot be objects under it."}
+	case c.Exists && !c.Write && !c.Dir:
+		return errS3AccessDenied
+	case !c.Exists && !c.Create:
+		return errS3AccessDenied
+	}
+	return nil
+}
+
+// Make the directories down to dir that are not there, through WebDAV.
+func (h s3Handler) makeDirs(r *http.Request, dir string) error {
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	for i := range parts {
+		name := "/" + strings.Join(parts[:i+1], "/")
+		fi, err := h.Tenant.fsys.Stat(r.Context(), name)
+		if err == nil && !fi.IsDir() {
+			return &s3Error{http.StatusConflict, "InvalidRequest", fmt.Sprintf("%s is an object, so there cannot be objects under it.", strings.TrimPrefix(name, "/"))}
+		}
+		if err == nil {
+			continue
+		}
+		if _, err := h.dav(davRequest(r, h.Tenant, "MKCOL", name, nil, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write name through WebDAV, making what it is in first, and return its ETag.
+func (h s3Handler) put(r *http.Request, name string, body io.Reader, size int64, contentType string) (string, error) {
+	if err := h.makeDirs(r, path.Dir(name)); err != nil {
+		return "", err
+	}
+	req := davRequest(r, h.Tenant, "PUT", name, body, size)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := h.dav(req)
+	if err != nil {
+		return "", err
+	}
+	return res.header.Get("ETag"), nil
+}
+
+func (h s3Handler) putObject(w http.ResponseWriter, r *http.Request, auth *s3Auth, name string) error {
+	if err := h.mayPut(r.Context(), name); err != nil {
+		return err
+	}
+	body, err := spoolBody(r, auth, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if isFolderKey(name) {
+		if body.size != 0 {
+			return s3InvalidArgument("a folder cannot have content")
+		}
+		if err := h.makeDirs(r, name); err != nil {
+			return err
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, body.md5))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	etag, err := h.put(r, name, body.f, body.size, r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h s3Handler) copyObject(w http.ResponseWriter, r *http.Request, name string) error {
+	ctx := r.Context()
+	source := r.Header.Get("X-Amz-Copy-Source")
+	if i := strings.Index(source, "?"); i >= 0 {
+		source = source[:i]
+	}
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return s3InvalidArgument("x-amz-copy-source is not valid")
+	}
+	source = "/" + strings.TrimPrefix(source, "/")
+	if isMetadataPath(source) || webdav.SlashClean(source) != source {
+		return errS3AccessDenied
+	}
+	c, err := h.Tenant.fsys.Capabilities(ctx, source)
+	if err != nil {
+		return err
+	}
+	if !c.Exists || c.Dir {
+		return errS3NoSuchKey
+	}
+	if !c.Read {
+		return errS3AccessDenied
+	}
+	if isFolderKey(name) {
+		return s3InvalidArgument("an object cannot be copied to a folder")
+	}
+	if source != name {
+		if err := h.mayPut(ctx, name); err != nil {
+			return err
+		}
+		if err := h.makeDirs(r, path.Dir(name)); err != nil {
+			return err
+		}
+		req := davRequest(r, h.Tenant, "COPY", source, nil, 0)
+		req.Header.Set("Destination", h.Tenant.Prefix+name)
+		req.Header.Set("Overwrite", "T")
+		req.Header.Set("Depth", "0")
+		if _, err := h.dav(req); err != nil {
+			return err
+		}
+	}
+	fi, err := h.Tenant.fsys.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	etag, _ := webdav.ETagOf(ctx, fi)
+	writeS3Result(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		LastModified string
+		ETag         string
+	}{Xmlns: s3Namespace, LastModified: s3Time(fi.ModTime()), ETag: etag})
+	return nil
+}
+
+// Deleting what is not there succeeds, as it does in S3.
+func (h s3Handler) deleteKey(r *http.Request, name string) error {
+	fi, err := h.Tenant.fsys.Stat(r.Context(), name)
+	if err != nil || fi.IsDir() != isFolderKey(name) {
+		return nil
+	}
+	if fi.IsDir() {
+		// a folder goes only once there is nothing in it
+		if infos, err := ioutil.ReadDir(h.Tenant.fsys.Resolve(name)); err != nil || len(infos) > 0 {
+			return err
+		}
+	}
+	c, err := h.Tenant.fsys.Capabilities(r.Context(), strings.TrimSuffix(name, "/"))
+	if err != nil {
+		return err
+	}
+	if !c.Delete {
+		return errS3AccessDenied
+	}
+	_, err = h.dav(davRequest(r, h.Tenant, "DELETE", strings.TrimSuffix(name, "/"), nil, 0))
+	return err
+}
+
+func (h s3Handler) deleteObject(w http.ResponseWriter, r *http.Request, name string) error {
+	if err := h.deleteKey(r, name); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h s3Handler) deleteObjects(w http.ResponseWriter, r *http.Request, auth *s3Auth, bucket string) error {
+	if _, err := h.bucket(r.Context(), bucket); err != nil {
+		return err
+	}
+	body, err := spoolBody(r, auth, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var request struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(body.f, 4<<20)).Decode(&request); err != nil {
+		return &s3Error{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+	if len(request.Objects) > 1000 {
+		return &s3Error{http.StatusBadRequest, "MalformedXML", "at most 1000 keys can be deleted at once"}
+	}
+	type deleted struct {
+		Key string
+	}
+	type failed struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Deleted []deleted
+		Error   []failed
+	}{Xmlns: s3Namespace}
+	for _, o := range request.Objects {
+		name := "/" + bucket + "/" + o.Key
+		var err error = errS3AccessDenied
+		if !isMetadataPath(name) && webdav.SlashClean(name) == strings.TrimSuffix(name, "/") {
+			err = h.deleteKey(r, name)
+		}
+		var e *s3Error
+		switch {
+		case err == nil:
+			if !request.Quiet {
+				result.Deleted = append(result.Deleted, deleted{Key: o.Key})
+			}
+		case errors.As(err, &e):
+			result.Error = append(result.Error, failed{Key: o.Key, Code: e.Code, Message: e.Message})
+		default:
+			result.Error = append(result.Error, failed{Key: o.Key, Code: "InternalError", Message: err.Error()})
+		}
+	}
+	writeS3Result(w, result)
+	return nil
+}
+
+/*
+  A multipart upload is kept in a directory of its own, outside of
+  the volume so that its parts do not count against the quota, until
+  it is completed or aborted.
+*/
+type s3Upload struct {
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	User        string    `json:"user"`
+	ContentType string    `json:"content_type,omitempty"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+func (h s3Handler) uploadsDir() string {
+	name := h.Tenant.Name
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(os.TempDir(), "webdev-s3uploads", name)
+}
+
+// The upload that r is for, if it is one that its user started.
+func (h s3Handler) upload(r *http.Request, bucket, key string) (string, s3Upload, error) {
+	var upload s3Upload
+	id := r.URL.Query().Get("uploadId")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return "", upload, errS3NoSuchUpload
+	}
+	dir := filepath.Join(h.uploadsDir(), id)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", upload, errS3NoSuchUpload
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return "", upload, err
+	}
+	if upload.User != s3User(r.Context()) || upload.Bucket != bucket || upload.Key != key {
+		return "", upload, errS3NoSuchUpload
+	}
+	return dir, upload, nil
+}
+
+// Uploads that nobody finished are swept away when another starts.
+func (h s3Handler) sweepUploads() {
+	infos, _ := ioutil.ReadDir(h.uploadsDir())
+	for _, fi := range infos {
+		if fi.IsDir() && time.Since(fi.ModTime()) > s3UploadTimeout {
+			os.RemoveAll(filepath.Join(h.uploadsDir(), fi.Name()))
+		}
+	}
+}
+
+func (h s3Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	name := "/" + bucket + "/" + key
+	if isFolderKey(name) {
+		return s3InvalidArgument("a folder cannot be uploaded in parts")
+	}
+	if err := h.mayPut(r.Context(), name); err != nil {
+		return err
+	}
+	h.sweepUploads()
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(h.uploadsDir(), id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	upload := s3Upload{Bucket: bucket, Key: key, User: s3User(r.Context()), ContentType: r.Header.Get("Content-Type"), Initiated: time.Now().UTC()}
+	if err := ioutil.WriteFile(filepath.Join(dir, "upload.json"), []byte(AsJson(upload)), 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	writeS3Result(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+func partFile(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d", n))
+}
+
+func (h s3Handler) uploadPart(w http.ResponseWriter, r *http.Request, auth *s3Auth, bucket, key string) error {
+	dir, _, err := h.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > 10000 {
+		return s3InvalidArgument("partNumber must be from 1 to 10000")
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		return s3NotImplemented("UploadPartCopy")
+	}
+	body, err := spoolBody(r, auth, dir)
+	if err != nil {
+		return err
+	}
+	body.f.Close()
+	etag := fmt.Sprintf(`"%x"`, body.md5)
+	if err := ioutil.WriteFile(partFile(dir, n)+".etag", []byte(etag), 0600); err != nil {
+		os.Remove(body.f.Name())
+		return err
+	}
+	if err := os.Rename(body.f.Name(), partFile(dir, n)); err != nil {
+		os.Remove(body.f.Name())
+		return err
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type s3Part struct {
+	PartNumber   int
+	LastModified string `xml:",omitempty"`
+	ETag         string
+	Size         int64 `xml:",omitempty"`
+}
+
+func (h s3Handler) parts(dir string) ([]s3Part, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []s3Part
+	for _, fi := range infos {
+		n, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		etag, err := ioutil.ReadFile(partFile(dir, n) + ".etag")
+		if err != nil {
+			continue
+		}
+		parts = append(parts, s3Part{PartNumber: n, LastModified: s3Time(fi.ModTime()), ETag: string(etag), Size: fi.Size()})
+	}
+	return parts, nil
+}
+
+func (h s3Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	dir, upload, err := h.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+	parts, err := h.parts(dir)
+	if err != nil {
+		return err
+	}
+	writeS3Result(w, struct {
+		XMLName      xml.Name `xml:"ListPartsResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		Bucket       string
+		Key          string
+		UploadId     string
+		StorageClass string
+		IsTruncated  bool
+		Part         []s3Part
+	}{Xmlns: s3Namespace, Bucket: upload.Bucket, Key: upload.Key, UploadId: filepath.Base(dir), StorageClass: "STANDARD", Part: parts})
+	return nil
+}
+
+/*
+  Join the parts that were asked for, in order, into the object,
+  through WebDAV as any other write is.
+*/
+func (h s3Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, auth *s3Auth, bucket, key string) error {
+	dir, upload, err := h.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+	body, err := spoolBody(r, auth, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var request struct {
+		Parts []s3Part `xml:"Part"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(body.f, 4<<20)).Decode(&request); err != nil {
+		return &s3Error{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+	if len(request.Parts) == 0 {
+		return &s3Error{http.StatusBadRequest, "MalformedXML", "there are no parts"}
+	}
+	invalidPart := &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the parts was not uploaded, or its ETag does not match."}
+	var readers []io.Reader
+	var size int64
+	for i, p := range request.Parts {
+		if i > 0 && p.PartNumber <= request.Parts[i-1].PartNumber {
+			return &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The parts are not in order."}
+		}
+		etag, err := ioutil.ReadFile(partFile(dir, p.PartNumber) + ".etag")
+		if err != nil || strings.Trim(string(etag), `"`) != strings.Trim(p.ETag, `"`) {
+			return invalidPart
+		}
+		f, err := os.Open(partFile(dir, p.PartNumber))
+		if err != nil {
+			return invalidPart
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		readers = append(readers, f)
+	}
+	name := "/" + bucket + "/" + key
+	if err := h.mayPut(r.Context(), name); err != nil {
+		return err
+	}
+	etag, err := h.put(r, name, io.MultiReader(readers...), size, upload.ContentType)
+	if err != nil {
+		return err
+	}
+	os.RemoveAll(dir)
+	writeS3Result(w, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Xmlns: s3Namespace, Location: name, Bucket: bucket, Key: key, ETag: etag})
+	return nil
+}
+
+func (h s3Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	dir, _, err := h.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
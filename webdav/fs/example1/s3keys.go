@@ -0,0 +1,201 @@
+package example1
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  An access key for the S3 api, which stands in for the user it
+  belongs to.  S3 clients sign requests with the secret, so it is
+  kept as it is, and only shown when the key is made.
+*/
+type S3Key struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type s3KeyStore struct {
+	file string
+	mu   sync.Mutex
+	keys map[string]S3Key
+}
+
+// The access keys of the S3 api, if it is served
+var s3Keys *s3KeyStore
+
+func newS3KeyStore(file string) (*s3KeyStore, error) {
+	s := &s3KeyStore{file: file, keys: make(map[string]S3Key)}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, fmt.Errorf("reading s3 keys from %s: %v", file, err)
+	}
+	return s, nil
+}
+
+// save must be called with mu held.
+func (s *s3KeyStore) save() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *s3KeyStore) get(id string) (S3Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	return key, ok
+}
+
+// Keys look like AWS keys, so that tools that check them are happy.
+func (s *s3KeyStore) create(user string) (S3Key, error) {
+	id := make([]byte, 10)
+	secret := make([]byte, 30)
+	if _, err := rand.Read(id); err != nil {
+		return S3Key{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return S3Key{}, err
+	}
+	key := S3Key{
+		ID:      "WD" + base32.StdEncoding.EncodeToString(id),
+		User:    user,
+		Secret:  base64.StdEncoding.EncodeToString(secret),
+		Created: time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	return key, s.save()
+}
+
+// The keys of user, or of everybody, without their secrets.
+func (s *s3KeyStore) list(user string) []S3Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]S3Key, 0)
+	for _, key := range s.keys {
+		if user == "" || key.User == user {
+			key.Secret = ""
+			list = append(list, key)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func (s *s3KeyStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.keys, id)
+	return s.save()
+}
+
+/*
+  Access keys for the S3 api.  Users manage their own, and admins
+  anybody's, with user.  A new key is the only time its secret is
+  shown.  Audited.
+
+    GET    /.__api/s3keys
+    POST   /.__api/s3keys
+    POST   /.__api/s3keys?user=jp
+    DELETE /.__api/s3keys?id=WDXXXXXXXXXXXXXXXX
+*/
+func s3KeysHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if s3Keys == nil {
+			writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("the s3 api is not served"))
+			return
+		}
+		actor, _ := ctx.Value("username").(string)
+		user := r.URL.Query().Get("user")
+		admin := isAdmin(ctx, fsys)
+		if user != "" && user != actor && !admin {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if user == "" && !(admin && r.Method == "GET") {
+			user = actor
+		}
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, s3Keys.list(user))
+		case "POST":
+			if err := validUsername(user); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			key, err := s3Keys.create(user)
+			rec := AuditRecord{User: actor, Action: "s3key.create", Target: user, After: json.RawMessage(AsJson(map[string]string{"id": key.ID}))}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusCreated, key)
+		case "DELETE":
+			id := r.URL.Query().Get("id")
+			key, ok := s3Keys.get(id)
+			if !ok || (key.User != actor && !admin) {
+				writeJsonError(w, http.StatusNotFound, fmt.Errorf("no key %q", id))
+				return
+			}
+			err := s3Keys.remove(id)
+			rec := AuditRecord{User: actor, Action: "s3key.delete", Target: key.User, Before: json.RawMessage(AsJson(map[string]string{"id": id}))}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+			if err != nil {
+				writeJsonError(w, statusOf(err), err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+// Open the access keys of the S3 api, when it is served.
+func setupS3Keys(file string) {
+	keys, err := newS3KeyStore(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot load s3 keys: %v", err)
+	}
+	s3Keys = keys
+}
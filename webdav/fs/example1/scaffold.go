@@ -0,0 +1,108 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+  A volume from a server without policies has none of the files that
+  this one needs to decide anything, and so would deny everything.
+  ScaffoldPolicies writes a starting point, for an admin to edit
+  before serving: a policy at the root that lets everyone read, and
+  makes admins of the users given, and for each top level directory,
+  claims for a user of the same name, and a policy that lets only
+  them write there.
+
+    package policy
+
+    Stat = true
+    Read = true
+
+    Write {
+        input.claims.groups.username[_] == "rob"
+    }
+    ...
+
+  Files that are there already are left alone, so it can be run
+  again once more directories are added.  It returns the files that
+  it wrote, or would write with dryRun.
+*/
+func ScaffoldPolicies(root string, admins []string, dryRun bool) ([]string, error) {
+	written := make([]string, 0)
+	write := func(name, content string) error {
+		if _, err := os.Stat(name); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		written = append(written, name)
+		if dryRun {
+			return nil
+		}
+		return ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	if err := write(filepath.Join(root, ".__security.rego"), rootPolicy(admins)); err != nil {
+		return written, err
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return written, err
+	}
+	for _, e := range entries {
+		username := e.Name()
+		if !e.IsDir() || validUsername(username) != nil || strings.HasPrefix(username, ".") {
+			continue
+		}
+		if err := write(filepath.Join(root, username, ".__claims.json"), homeClaims(username)); err != nil {
+			return written, err
+		}
+		if err := write(filepath.Join(root, username, ".__security.rego"), homePolicy(username)); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// rego takes json strings, so this quotes anything that a name can have in it.
+func regoString(s string) string {
+	j, _ := json.Marshal(s)
+	return string(j)
+}
+
+func rootPolicy(admins []string) string {
+	var b strings.Builder
+	b.WriteString("package policy\n\nStat = true\nRead = true\n")
+	for _, admin := range admins {
+		fmt.Fprintf(&b, "\nAdmin {\n    input.claims.groups.username[_] == %s\n}\n", regoString(admin))
+	}
+	return b.String()
+}
+
+func homeClaims(username string) string {
+	claims := Claims{Groups: map[string][]string{"username": {username}}}
+	j, _ := json.MarshalIndent(claims, "", "\t")
+	return string(j) + "\n"
+}
+
+func homePolicy(username string) string {
+	return fmt.Sprintf(`package policy
+
+Stat = true
+Read = true
+
+Write {
+    input.claims.groups.username[_] == %s
+}
+Create {
+    Write
+}
+Delete {
+    Write
+}
+`, regoString(username))
+}
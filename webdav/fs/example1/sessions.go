@@ -0,0 +1,573 @@
+package example1
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+var ErrSessionNotFound = errors.New("webdav: no such session")
+
+// The cookie that a browser keeps its session in
+const sessionCookie = "webdev_session"
+
+/*
+  A logged in browser.  It is kept under the hash of its id, so
+  that whoever can read the store cannot use what is in it.
+*/
+type Session struct {
+	Key      string    `json:"key"`
+	User     string    `json:"user"`
+	Tenant   string    `json:"tenant,omitempty"`
+	MFA      bool      `json:"mfa,omitempty"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+/*
+  How long a session lasts: Idle after it was last used, and
+  never more than Absolute after it was made.
+*/
+type SessionTimeouts struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+func (t SessionTimeouts) expired(s Session, now time.Time) bool {
+	return now.Sub(s.LastSeen) > t.Idle || now.Sub(s.Created) > t.Absolute
+}
+
+// How long until s expires
+func (t SessionTimeouts) remaining(s Session, now time.Time) time.Duration {
+	idle := s.LastSeen.Add(t.Idle).Sub(now)
+	absolute := s.Created.Add(t.Absolute).Sub(now)
+	if absolute < idle {
+		return absolute
+	}
+	return idle
+}
+
+/*
+  Where sessions are kept.  Get does not return expired sessions,
+  and Touch marks a session as used now.
+*/
+type SessionStore interface {
+	Create(s Session) error
+	Get(key string) (Session, error)
+	Touch(key string, now time.Time) error
+	Delete(key string) error
+}
+
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionID() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+/*
+  Keep sessions in memory, and in a json file if there is one, so
+  that they outlive a restart.  Expired sessions are dropped as new
+  ones are made.
+*/
+type localSessionStore struct {
+	mu       sync.Mutex
+	file     string
+	timeouts SessionTimeouts
+	sessions map[string]Session
+}
+
+func newLocalSessionStore(file string, timeouts SessionTimeouts) (*localSessionStore, error) {
+	s := &localSessionStore{file: file, timeouts: timeouts, sessions: make(map[string]Session)}
+	if file == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return nil, fmt.Errorf("reading sessions from %s: %v", file, err)
+	}
+	return s, nil
+}
+
+// save must be called with mu held.
+func (s *localSessionStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *localSessionStore) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, old := range s.sessions {
+		if s.timeouts.expired(old, now) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[session.Key] = session
+	return s.save()
+}
+
+func (s *localSessionStore) Get(key string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[key]
+	if !ok || s.timeouts.expired(session, time.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+/*
+  Touching is not saved to the file, which would mean a write for
+  every request.  After a restart, sessions are idle from when
+  they were last saved.
+*/
+func (s *localSessionStore) Touch(key string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[key]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.LastSeen = now
+	s.sessions[key] = session
+	return nil
+}
+
+func (s *localSessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+	return s.save()
+}
+
+/*
+  Keep sessions in Redis, for servers that share them.  Each is
+  a json value that Redis expires by itself.  This speaks just
+  enough of the Redis protocol for that:
+
+    redis://:password@host:6379/2
+*/
+type redisSessionStore struct {
+	mu       sync.Mutex
+	addr     string
+	password string
+	db       int
+	timeouts SessionTimeouts
+	conn     net.Conn
+	rd       *bufio.Reader
+}
+
+const redisSessionPrefix = "webdev:session:"
+
+func newRedisSessionStore(where string, timeouts SessionTimeouts) (*redisSessionStore, error) {
+	u, err := url.Parse(where)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisSessionStore{addr: u.Host, timeouts: timeouts}
+	if u.User != nil {
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("bad redis database: %s", db)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.do("PING"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// do sends one command, dialing again once if the connection was lost.
+// It must be called with mu held.
+func (s *redisSessionStore) do(args ...string) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			if err := s.dial(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := s.send(args)
+		if err == nil {
+			return reply, nil
+		}
+		if _, isReply := err.(redisError); isReply || attempt > 0 {
+			return nil, err
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *redisSessionStore) dial() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn, s.rd = conn, bufio.NewReader(conn)
+	if s.password != "" {
+		if _, err := s.send([]string{"AUTH", s.password}); err != nil {
+			conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.send([]string{"SELECT", strconv.Itoa(s.db)}); err != nil {
+			conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// An error that Redis replied with, rather than one talking to it
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (s *redisSessionStore) send(args []string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return s.reply()
+}
+
+// reply reads simple strings, errors, integers and bulk strings.
+func (s *redisSessionStore) reply() (interface{}, error) {
+	line, err := s.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (s *redisSessionStore) put(session Session, now time.Time) error {
+	ttl := s.timeouts.remaining(session, now)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	_, err = s.do("SET", redisSessionPrefix+session.Key, string(data), "PX", strconv.FormatInt(int64(ttl/time.Millisecond)+1, 10))
+	return err
+}
+
+func (s *redisSessionStore) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(session, time.Now())
+}
+
+func (s *redisSessionStore) Get(key string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply, err := s.do("GET", redisSessionPrefix+key)
+	if err != nil {
+		return Session{}, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return Session{}, err
+	}
+	if s.timeouts.expired(session, time.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *redisSessionStore) Touch(key string, now time.Time) error {
+	session, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	session.LastSeen = now
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(session, now)
+}
+
+func (s *redisSessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.do("DEL", redisSessionPrefix+key)
+	return err
+}
+
+var (
+	sessions        SessionStore
+	sessionTimeouts SessionTimeouts
+)
+
+/*
+  Sessions are kept in memory, a json file, or Redis:
+
+    memory
+    ./sessions.json
+    redis://localhost:6379
+*/
+func setupSessions(where string, timeouts SessionTimeouts) {
+	sessionTimeouts = timeouts
+	var err error
+	switch {
+	case where == "memory":
+		sessions, err = newLocalSessionStore("", timeouts)
+	case strings.HasPrefix(where, "redis://"):
+		sessions, err = newRedisSessionStore(where, timeouts)
+	default:
+		sessions, err = newLocalSessionStore(where, timeouts)
+	}
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot set up sessions: %v", err)
+	}
+}
+
+/*
+  The session of a request, if it has a cookie for one in this
+  tenant.  Using a session keeps it alive.
+*/
+func sessionOf(r *http.Request) (Session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || sessions == nil {
+		return Session{}, false
+	}
+	key := sessionKey(c.Value)
+	session, err := sessions.Get(key)
+	if err != nil {
+		if err != ErrSessionNotFound {
+			webdav.Log().Warn("cannot read session", "request_id", webdav.RequestID(r.Context()), "err", err)
+		}
+		return Session{}, false
+	}
+	if session.Tenant != tenantOf(r.Context()).Name {
+		return Session{}, false
+	}
+	if err := sessions.Touch(key, time.Now()); err != nil {
+		webdav.Log().Warn("cannot touch session", "request_id", webdav.RequestID(r.Context()), "err", err)
+	}
+	return session, true
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	cookiePath := tenantOf(r.Context()).Prefix
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     cookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   schemeOf(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in</title></head>
+<body>
+<form method="POST">
+<input type="hidden" name="next" value="{{.}}">
+<p><input name="username" placeholder="username" autocomplete="username"></p>
+<p><input name="password" type="password" placeholder="password" autocomplete="current-password"></p>
+<p><input name="otp" placeholder="one time code, if you have one" autocomplete="one-time-code"></p>
+<p><input type="submit" value="Log in"></p>
+</form>
+</body>
+</html>
+`))
+
+/*
+  Log in, for a session cookie, so that a browser need not send a
+  password with every request.
+
+    GET  /.__api/login              a form, which posts back here
+    POST /.__api/login              with basic auth, or the form's fields
+
+  A one time code, in X-OTP or the otp field, makes a session that
+  has passed step-up authentication.  Form posts are redirected to
+  next, and others get the session as json.
+*/
+func loginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		next := r.FormValue("next")
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+			next = "/"
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			loginTemplate.Execute(w, next)
+			return
+		case "POST":
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		username, password, basic := r.BasicAuth()
+		if !basic {
+			username, password = r.PostFormValue("username"), r.PostFormValue("password")
+		}
+		if err := validUsername(username); err != nil {
+			writeJsonError(w, http.StatusUnauthorized, err)
+			return
+		}
+		claims, err := authenticator.Verify(ctx, username, password)
+		if err != nil {
+			audit(ctx, AuditRecord{User: username, Action: "auth failed", Target: r.URL.Path, Error: err.Error()})
+			writeJsonError(w, http.StatusUnauthorized, err)
+			return
+		}
+		onboard(ctx, username, claims)
+		code := r.Header.Get(OTPHeader)
+		if code == "" {
+			code = r.PostFormValue("otp")
+		}
+		hasMFA := false
+		if code != "" {
+			if mfa == nil || !mfa.verify(mfaKey(ctx, username), code, time.Now()) {
+				audit(ctx, AuditRecord{User: username, Action: "auth failed", Target: r.URL.Path, Error: mfaBadCode})
+				writeJsonError(w, http.StatusUnauthorized, errors.New(mfaBadCode))
+				return
+			}
+			hasMFA = true
+		}
+		id := newSessionID()
+		now := time.Now().UTC()
+		session := Session{
+			Key:      sessionKey(id),
+			User:     username,
+			Tenant:   tenantOf(ctx).Name,
+			MFA:      hasMFA,
+			Created:  now,
+			LastSeen: now,
+		}
+		if err := sessions.Create(session); err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err)
+			return
+		}
+		noteUser(ctx, username)
+		audit(ctx, AuditRecord{User: username, Action: "login"})
+		setSessionCookie(w, r, id, int(sessionTimeouts.Absolute/time.Second))
+		if !basic {
+			http.Redirect(w, r, tenantOf(ctx).Prefix+next, http.StatusSeeOther)
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"user":    username,
+			"mfa":     hasMFA,
+			"expires": now.Add(sessionTimeouts.Absolute),
+		})
+	})
+}
+
+/*
+  End the session of the request.
+
+    POST /.__api/logout
+*/
+func logoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			key := sessionKey(c.Value)
+			if session, err := sessions.Get(key); err == nil {
+				audit(r.Context(), AuditRecord{User: session.User, Action: "logout"})
+			}
+			if err := sessions.Delete(key); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		setSessionCookie(w, r, "", -1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
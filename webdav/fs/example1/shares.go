@@ -0,0 +1,450 @@
+package example1
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+  Shared links are served under this prefix, without logging in.
+*/
+const sharePrefix = "/.__share/"
+
+var ErrShareNotFound = fmt.Errorf("share not found or expired")
+var ErrShareUsedUp = fmt.Errorf("share has no downloads left")
+
+/*
+  A Share lets anyone with its token get at one path,
+  as the user who made it.  The password hash is never
+  handed back out.
+*/
+type Share struct {
+	Token        string     `json:"token"`
+	Owner        string     `json:"owner"`
+	Path         string     `json:"path"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	Protected    bool       `json:"protected"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	MaxDownloads int        `json:"max_downloads,omitempty"`
+	Downloads    int        `json:"downloads"`
+	AllowUpload  bool       `json:"allow_upload,omitempty"`
+	Created      time.Time  `json:"created"`
+}
+
+func (s Share) expired(now time.Time) bool {
+	return s.Expires != nil && now.After(*s.Expires)
+}
+
+func (s Share) public() Share {
+	s.PasswordHash = ""
+	return s
+}
+
+/*
+  Where shares are kept.
+*/
+type ShareStore interface {
+	Create(s Share) error
+	Get(token string) (Share, error)
+	List() []Share
+	Revoke(token string) error
+	// Use counts a download, refusing once the share is used up.
+	Use(token string) (Share, error)
+	// Prune drops expired shares, saying how many.
+	Prune(now time.Time) (int, error)
+}
+
+/*
+  Keep shares in a json file, outside of the served volume.
+*/
+type fileShareStore struct {
+	file   string
+	mu     sync.Mutex
+	shares map[string]Share
+}
+
+func newFileShareStore(file string) (*fileShareStore, error) {
+	s := &fileShareStore{file: file, shares: make(map[string]Share)}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.shares); err != nil {
+		return nil, fmt.Errorf("reading shares from %s: %v", file, err)
+	}
+	return s, nil
+}
+
+// save must be called with mu held.
+func (s *fileShareStore) save() error {
+	data, err := json.MarshalIndent(s.shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *fileShareStore) Create(share Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[share.Token] = share
+	return s.save()
+}
+
+func (s *fileShareStore) Get(token string) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[token]
+	if !ok || share.expired(time.Now()) {
+		return Share{}, ErrShareNotFound
+	}
+	return share, nil
+}
+
+func (s *fileShareStore) List() []Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Share, 0, len(s.shares))
+	for _, share := range s.shares {
+		list = append(list, share)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func (s *fileShareStore) Revoke(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shares[token]; !ok {
+		return ErrShareNotFound
+	}
+	delete(s.shares, token)
+	return s.save()
+}
+
+func (s *fileShareStore) Use(token string) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[token]
+	if !ok || share.expired(time.Now()) {
+		return Share{}, ErrShareNotFound
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return Share{}, ErrShareUsedUp
+	}
+	share.Downloads++
+	s.shares[token] = share
+	return share, s.save()
+}
+
+func (s *fileShareStore) Prune(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for token, share := range s.shares {
+		if share.expired(now) {
+			delete(s.shares, token)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.save()
+}
+
+/*
+  A job to drop expired shares, named "shares".
+*/
+func shareGCJob(store ShareStore, interval time.Duration) fs.Job {
+	return fs.Job{
+		Name:     "shares",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			n, err := store.Prune(time.Now())
+			if n > 0 {
+				webdav.Log().Info("dropped expired shares", "count", n)
+			}
+			return err
+		},
+	}
+}
+
+func newShareToken() string {
+	var b [18]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// Nothing under .__ can be reached through a share, so that
+// nobody can read or upload a policy with one.
+func isMetadataPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".__") {
+			return true
+		}
+	}
+	return false
+}
+
+type ShareRequest struct {
+	Path         string `json:"path"`
+	Password     string `json:"password,omitempty"`
+	Expires      int64  `json:"expires,omitempty"`
+	MaxDownloads int    `json:"max_downloads,omitempty"`
+	AllowUpload  bool   `json:"allow_upload,omitempty"`
+}
+
+/*
+  Manage your shares.  Admins see everyone's with all=true.
+
+    GET    /.__api/shares
+    POST   /.__api/shares  {"path": "/rob/pics", "password": "...", "expires": 86400, "max_downloads": 10, "allow_upload": false}
+    DELETE /.__api/shares?token=...
+*/
+func sharesHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		t := tenantOf(ctx)
+		shares := t.shares
+		if shares == nil {
+			writeJsonError(w, http.StatusNotFound, fmt.Errorf("sharing is not set up"))
+			return
+		}
+		switch r.Method {
+		case "GET":
+			all := r.URL.Query().Get("all") == "true" && isAdmin(ctx, fsys)
+			list := make([]Share, 0)
+			for _, share := range shares.List() {
+				if all || share.Owner == username {
+					list = append(list, share.public())
+				}
+			}
+			writeJson(w, http.StatusOK, list)
+		case "POST":
+			var req ShareRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			share, status, err := newShare(r, fsys, username, req)
+			if err != nil {
+				writeJsonError(w, status, err)
+				return
+			}
+			if err := shares.Create(share); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			req.Password = ""
+			after, _ := json.Marshal(req)
+			audit(ctx, AuditRecord{User: username, Action: "share", Target: share.Path, After: after})
+			writeJson(w, http.StatusCreated, map[string]interface{}{
+				"share": share.public(),
+				"url":   t.Prefix + sharePrefix + share.Token,
+			})
+		case "DELETE":
+			token := r.URL.Query().Get("token")
+			share, err := shares.Get(token)
+			if err != nil || (share.Owner != username && !isAdmin(ctx, fsys)) {
+				writeJsonError(w, http.StatusNotFound, ErrShareNotFound)
+				return
+			}
+			rec := AuditRecord{User: username, Action: "unshare", Target: share.Path}
+			if err := shares.Revoke(token); err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			audit(ctx, rec)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+/*
+  You can share what you can read, and allow uploads
+  into a directory where you can create files.
+*/
+func newShare(r *http.Request, fsys fs.FS, username string, req ShareRequest) (Share, int, error) {
+	ctx := r.Context()
+	name := webdav.SlashClean(req.Path)
+	if name == "/" || isMetadataPath(name) {
+		return Share{}, http.StatusBadRequest, fmt.Errorf("cannot share %s", name)
+	}
+	info, err := fsys.Stat(ctx, name)
+	if err != nil {
+		return Share{}, http.StatusNotFound, os.ErrNotExist
+	}
+	if !canSign(r, fsys, "GET", name) {
+		return Share{}, http.StatusForbidden, os.ErrPermission
+	}
+	if req.AllowUpload {
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowCreate})
+		if !info.IsDir() || !fsys.Allow(ctx, permission, fs.AllowCreate) {
+			return Share{}, http.StatusForbidden, fmt.Errorf("uploads can only be shared into a directory you can create files in")
+		}
+	}
+	if req.Expires < 0 || req.MaxDownloads < 0 {
+		return Share{}, http.StatusBadRequest, fmt.Errorf("expires and max_downloads cannot be negative")
+	}
+	now := time.Now().UTC()
+	share := Share{
+		Token:        newShareToken(),
+		Owner:        username,
+		Path:         name,
+		MaxDownloads: req.MaxDownloads,
+		AllowUpload:  req.AllowUpload,
+		Created:      now,
+	}
+	if req.Expires > 0 {
+		expires := now.Add(time.Duration(req.Expires) * time.Second)
+		share.Expires = &expires
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return Share{}, http.StatusInternalServerError, err
+		}
+		share.PasswordHash = string(hash)
+		share.Protected = true
+	}
+	return share, 0, nil
+}
+
+type SharedEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+/*
+  Serve /.__share/<token>/rest as the owner of the share would see
+  path/rest, through the same handler (and so the same policy) as
+  everything else.  A directory comes back as a json listing.
+*/
+func shareHandler(fsys fs.FS, srv http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, sharePrefix)
+		token := rest
+		if i := strings.Index(rest, "/"); i >= 0 {
+			token, rest = rest[:i], rest[i:]
+		} else {
+			rest = "/"
+		}
+		t := tenantOf(r.Context())
+		shares := t.shares
+		share, err := shares.Get(token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if share.PasswordHash != "" {
+			_, password, ok := r.BasicAuth()
+			if !ok || bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+				if ok {
+					audit(r.Context(), AuditRecord{Action: "auth failed", Target: share.Path, Error: "wrong share password"})
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="Share"`)
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		rest = webdav.SlashClean(rest)
+		if isMetadataPath(rest) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		target := path.Join(share.Path, rest)
+		ctx := context.WithValue(r.Context(), "username", share.Owner)
+		info, statErr := fsys.Stat(ctx, target)
+		switch r.Method {
+		case "GET", "HEAD":
+			if statErr != nil {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+			if info.IsDir() {
+				listShared(w, ctx, fsys, target)
+				return
+			}
+			if r.Method == "GET" {
+				if _, err := shares.Use(token); err != nil {
+					http.Error(w, err.Error(), http.StatusGone)
+					return
+				}
+			}
+		case "PUT":
+			if !share.AllowUpload {
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			// Uploads add files, they never replace them
+			if statErr == nil {
+				http.Error(w, "already exists", http.StatusConflict)
+				return
+			}
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r = r.WithContext(ctx)
+		r.URL.Path = path.Join(t.Prefix, target)
+		srv.ServeHTTP(w, r)
+	})
+}
+
+func listShared(w http.ResponseWriter, ctx context.Context, fsys fs.FS, name string) {
+	f, err := fsys.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+		return
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	entries := make([]SharedEntry, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".__") {
+			continue
+		}
+		entries = append(entries, SharedEntry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			IsDir:   info.IsDir(),
+			ModTime: info.ModTime(),
+		})
+	}
+	writeJson(w, http.StatusOK, entries)
+}
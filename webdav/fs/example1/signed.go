@@ -0,0 +1,197 @@
+package example1
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Signed urls are good for at most a week.
+const maxSignedExpiry = 7 * 24 * time.Hour
+
+var ErrBadSignature = fmt.Errorf("signed url is invalid or expired")
+
+/*
+  Sign urls so that whoever has one can GET (or PUT) one path,
+  until it expires, without logging in.  The request acts as the
+  user who signed it, so the policy still decides, and a link
+  stops working if the user loses access.
+*/
+type urlSigner struct {
+	key []byte
+}
+
+/*
+  Without a key file, links stop working when the server restarts.
+*/
+var signer = &urlSigner{key: randomKey()}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+/*
+  Use the key in keyFile, making one if it does not exist yet,
+  so that links survive a restart.
+*/
+func setupSigner(keyFile string) error {
+	if keyFile == "" {
+		return nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key = randomKey()
+		err = ioutil.WriteFile(keyFile, key, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	if len(key) < 16 {
+		return fmt.Errorf("signing key in %s is too short", keyFile)
+	}
+	signer = &urlSigner{key: key}
+	return nil
+}
+
+func (s *urlSigner) signature(method, name, username string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", method, name, username, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sign returns the path and query of a signed url.
+func (s *urlSigner) sign(method, name, username string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("method", method)
+	q.Set("user", username)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", s.signature(method, name, username, expires.Unix()))
+	return (&url.URL{Path: name, RawQuery: q.Encode()}).String()
+}
+
+/*
+  Say who signed the url of this request.  A GET link also
+  works for HEAD.
+*/
+func (s *urlSigner) verify(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	method := q.Get("method")
+	if method != r.Method && !(method == "GET" && r.Method == "HEAD") {
+		return "", ErrBadSignature
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", ErrBadSignature
+	}
+	username := q.Get("user")
+	expected := s.signature(method, r.URL.Path, username, expires)
+	if !hmac.Equal([]byte(expected), []byte(q.Get("signature"))) {
+		return "", ErrBadSignature
+	}
+	return username, nil
+}
+
+func isSigned(r *http.Request) bool {
+	return r.URL.Query().Get("signature") != ""
+}
+
+type SignRequest struct {
+	Path    string `json:"path"`
+	Method  string `json:"method"`
+	Expires int64  `json:"expires"`
+}
+
+type SignResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+/*
+  Mint a signed url for a path that you can read (or write, for PUT).
+  Expires is in seconds, and defaults to an hour.
+
+    POST /.__api/sign  {"path": "/rob/cat.jpg", "method": "GET", "expires": 3600}
+*/
+func signHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		var req SignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Method == "" {
+			req.Method = "GET"
+		}
+		if req.Method != "GET" && req.Method != "PUT" {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("only GET and PUT urls can be signed"))
+			return
+		}
+		lifetime := time.Duration(req.Expires) * time.Second
+		if req.Expires == 0 {
+			lifetime = time.Hour
+		}
+		if lifetime <= 0 || lifetime > maxSignedExpiry {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("expires must be between 1 and %d seconds", int64(maxSignedExpiry/time.Second)))
+			return
+		}
+		name := webdav.SlashClean(req.Path)
+		if strings.HasPrefix(name, apiPrefix) || name == "/" {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("cannot sign %s", name))
+			return
+		}
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !canSign(r, fsys, req.Method, name) {
+			writeJsonError(w, http.StatusForbidden, os.ErrPermission)
+			return
+		}
+		expires := time.Now().Add(lifetime)
+		t := tenantOf(ctx)
+		resp := SignResponse{
+			URL:     t.signer.sign(req.Method, path.Join(t.Prefix, name), username, expires),
+			Expires: expires.UTC(),
+		}
+		after, _ := json.Marshal(req)
+		audit(ctx, AuditRecord{User: username, Action: "sign", Target: name, After: after})
+		writeJson(w, http.StatusOK, resp)
+	})
+}
+
+/*
+  Only hand out links for what the user could do right now.
+  As with any PUT, an existing file needs Write, and a new one
+  needs Create on its directory.
+*/
+func canSign(r *http.Request, fsys fs.FS, method, name string) bool {
+	ctx := r.Context()
+	if method == "GET" {
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowRead})
+		return fsys.Allow(ctx, permission, fs.AllowRead)
+	}
+	if _, err := os.Stat(fsys.Resolve(name)); err == nil {
+		permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(name), Action: fs.AllowWrite})
+		return fsys.Allow(ctx, permission, fs.AllowWrite)
+	}
+	permission := fsys.PermissionHandler(ctx, fs.Action{Name: fsys.Resolve(path.Dir(name)), Action: fs.AllowCreate})
+	return fsys.Allow(ctx, permission, fs.AllowCreate)
+}
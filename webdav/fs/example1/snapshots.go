@@ -0,0 +1,325 @@
+package example1
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// How often the snapshot job snapshots the whole volume, and how many of those it keeps
+var snapshotEvery = 24 * time.Hour
+var snapshotKeep = 7
+
+/*
+  Changes through WebDAV wait while a snapshot is being taken, so
+  that it is of the volume at one moment.
+*/
+type snapshotGate struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+func (g snapshotGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if journaledMethods[r.Method] || r.Method == "POST" {
+		g.Tenant.writes.RLock()
+		defer g.Tenant.writes.RUnlock()
+	}
+	g.Handler.ServeHTTP(w, r)
+}
+
+// A snapshot of the whole volume is named for when it was taken, and of a directory, for it too.
+func snapshotName(info fs.SnapshotInfo) string {
+	name := info.Time.Format("20060102T150405Z")
+	if p := strings.Trim(info.Path, "/"); p != "" {
+		name += "-" + strings.NewReplacer("/", "-", "\\", "-").Replace(p)
+	}
+	return name + ".tar"
+}
+
+/*
+  Take a snapshot of a directory into a temporary file, holding off
+  changes only for as long as that takes, and not for as long as it
+  takes to send it on.  The caller removes the file.
+*/
+func takeSnapshot(ctx context.Context, t *Tenant, name string) (fs.SnapshotInfo, *os.File, error) {
+	f, err := ioutil.TempFile("", "webdev-snapshot-*.tar")
+	if err != nil {
+		return fs.SnapshotInfo{}, nil, err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	t.writes.Lock()
+	info, err := t.fsys.Snapshot(ctx, name, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	t.writes.Unlock()
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return info, nil, err
+	}
+	return info, f, nil
+}
+
+// Take a snapshot of a directory, and keep it in the tenant's store.
+func storeSnapshot(ctx context.Context, t *Tenant, name string) (fs.SnapshotInfo, string, error) {
+	info, f, err := takeSnapshot(ctx, t, name)
+	if err != nil {
+		return info, "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	stored := snapshotName(info)
+	return info, stored, t.snapshots.Put(ctx, stored, f)
+}
+
+/*
+  Snapshot the whole volume, and drop the oldest of those beyond
+  the ones to keep.  Snapshots of directories are left alone.
+*/
+func snapshotJob(t *Tenant) fs.Job {
+	return fs.Job{
+		Name:     "snapshot",
+		Interval: snapshotEvery,
+		Jitter:   snapshotEvery / 100,
+		Run: func(ctx context.Context) error {
+			info, stored, err := storeSnapshot(ctx, t, "/")
+			if err != nil {
+				return err
+			}
+			webdav.Log().Info("took snapshot", "tenant", t.Name, "name", stored, "files", info.Files, "bytes", info.Bytes)
+			audit(ctx, AuditRecord{User: "system", Action: "snapshot", Target: stored, After: json.RawMessage(AsJson(info))})
+			list, err := t.snapshots.List(ctx)
+			if err != nil {
+				return err
+			}
+			var whole []string
+			for _, s := range list {
+				if !strings.Contains(s.Name, "-") {
+					whole = append(whole, s.Name)
+				}
+			}
+			sort.Strings(whole)
+			for i := 0; i < len(whole)-snapshotKeep; i++ {
+				if err := t.snapshots.Delete(ctx, whole[i]); err != nil {
+					return err
+				}
+				webdav.Log().Info("dropped snapshot", "tenant", t.Name, "name", whole[i])
+			}
+			return nil
+		},
+	}
+}
+
+/*
+  Snapshots of the volume, with its metadata, as tar.  Admin only,
+  and audited.
+
+    GET    /.__api/snapshots?path=/rob     download a snapshot of /rob now
+    POST   /.__api/snapshots?path=/rob     keep a snapshot of /rob in the store
+    GET    /.__api/snapshots               list the snapshots in the store
+    GET    /.__api/snapshots?name=N        download one from the store
+    DELETE /.__api/snapshots?name=N        remove one from the store
+*/
+func snapshotsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		t := tenantOf(ctx)
+		actor, _ := ctx.Value("username").(string)
+		q := r.URL.Query()
+		name := webdav.SlashClean(q.Get("path"))
+		if q.Get("path") == "" && (r.Method == "GET" || r.Method == "DELETE") {
+			if t.snapshots == nil {
+				writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no snapshot store"))
+				return
+			}
+			stored := q.Get("name")
+			if stored != "" {
+				if err := validSnapshotName(stored); err != nil {
+					writeJsonError(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+			switch {
+			case r.Method == "GET" && stored == "":
+				list, err := t.snapshots.List(ctx)
+				if err != nil {
+					writeJsonError(w, http.StatusBadGateway, err)
+					return
+				}
+				writeJson(w, http.StatusOK, list)
+			case r.Method == "GET":
+				rc, err := t.snapshots.Open(ctx, stored)
+				if err != nil {
+					writeJsonError(w, statusOf(err), err)
+					return
+				}
+				defer rc.Close()
+				audit(ctx, AuditRecord{User: actor, Action: "snapshot.download", Target: stored})
+				w.Header().Set("Content-Type", "application/x-tar")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", stored))
+				io.Copy(w, rc)
+			default:
+				err := t.snapshots.Delete(ctx, stored)
+				rec := AuditRecord{User: actor, Action: "snapshot.delete", Target: stored}
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				audit(ctx, rec)
+				if err != nil {
+					writeJsonError(w, statusOf(err), err)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+		switch r.Method {
+		case "GET":
+			info, f, err := takeSnapshot(ctx, t, name)
+			if err != nil {
+				writeJsonError(w, statusOf(err), err)
+				return
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			audit(ctx, AuditRecord{User: actor, Action: "snapshot", Target: name, After: json.RawMessage(AsJson(info))})
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snapshotName(info)))
+			io.Copy(w, f)
+		case "POST":
+			if t.snapshots == nil {
+				writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no snapshot store"))
+				return
+			}
+			info, stored, err := storeSnapshot(ctx, t, name)
+			rec := AuditRecord{User: actor, Action: "snapshot", Target: stored, After: json.RawMessage(AsJson(info))}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			audit(ctx, rec)
+			if err != nil {
+				writeJsonError(w, statusOf(err), err)
+				return
+			}
+			writeJson(w, http.StatusCreated, map[string]interface{}{"name": stored, "snapshot": info})
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+/*
+  Unpack a snapshot into a directory that does not exist yet, to
+  get back what was in it without writing over what is there now.
+  Admin only, and audited.
+
+    POST /.__api/restore?to=/restored&name=N      from the store
+    POST /.__api/restore?to=/restored             the tar in the body
+
+  To restore into a new volume instead, use webdavctl restore.
+*/
+func restoreHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		t := tenantOf(ctx)
+		actor, _ := ctx.Value("username").(string)
+		to := webdav.SlashClean(r.URL.Query().Get("to"))
+		dir := fsys.Resolve(to)
+		if r.URL.Query().Get("to") == "" || to == "/" || dir == "" || isMetadataPath(to) {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("restore to a new directory"))
+			return
+		}
+		var src io.Reader = r.Body
+		from := "upload"
+		if stored := r.URL.Query().Get("name"); stored != "" {
+			if err := validSnapshotName(stored); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if t.snapshots == nil {
+				writeJsonError(w, http.StatusNotImplemented, fmt.Errorf("there is no snapshot store"))
+				return
+			}
+			rc, err := t.snapshots.Open(ctx, stored)
+			if err != nil {
+				writeJsonError(w, statusOf(err), err)
+				return
+			}
+			defer rc.Close()
+			src, from = rc, stored
+		}
+		t.writes.RLock()
+		info, err := fs.Restore(ctx, src, dir)
+		t.writes.RUnlock()
+		rec := AuditRecord{User: actor, Action: "snapshot.restore", Target: to, Before: json.RawMessage(AsJson(from)), After: json.RawMessage(AsJson(info))}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		audit(ctx, rec)
+		if err != nil {
+			if os.IsExist(err) {
+				writeJsonError(w, http.StatusConflict, fmt.Errorf("%s already exists", to))
+				return
+			}
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, map[string]interface{}{"to": to, "snapshot": info})
+	})
+}
+
+func statusOf(err error) int {
+	var de *davError
+	switch {
+	case errors.As(err, &de):
+		return de.Status
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, webdav.ErrTooManyOpenFiles):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Open the snapshot store of a tenant, if it has one.
+func setupSnapshots(t *Tenant) {
+	if t.Snapshots == "" {
+		return
+	}
+	store, err := newSnapshotStore(t.Snapshots, t.Name)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot set up snapshots for %q: %v", t.Name, err)
+	}
+	t.snapshots = store
+}
@@ -0,0 +1,293 @@
+package example1
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Where the stored snapshots of a tenant can be browsed, under its prefix
+const snapshotTreePrefix = "/.snapshots/"
+
+/*
+  The stored snapshots of a tenant, as a tree of directories that are
+  named for them, without the .tar, each with what was in it:
+
+    /.snapshots/20261016T090000Z/rob/notes.md
+    /.snapshots/20261016T120000Z-rob/notes.md
+
+  The second is of /rob alone.  What anyone may see in a snapshot is
+  what the policy lets them see at the same place now.
+*/
+type snapshotTree struct {
+	Tenant *Tenant
+	mu     sync.Mutex
+	open   map[string]*openSnapshot
+}
+
+type openSnapshot struct {
+	f    *os.File
+	fsys *fs.SnapshotFS
+}
+
+var _ webdav.FileSystem = &snapshotTree{}
+
+// The snapshot that name is in, and where name is in it.
+func (st *snapshotTree) split(ctx context.Context, name string) (*fs.SnapshotFS, string, error) {
+	name = webdav.SlashClean(name)
+	parts := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 2)
+	stored := parts[0] + ".tar"
+	rest := "/"
+	if len(parts) == 2 {
+		rest = "/" + parts[1]
+	}
+	if validSnapshotName(stored) != nil {
+		return nil, "", os.ErrNotExist
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if o, ok := st.open[stored]; ok {
+		return o.fsys, rest, nil
+	}
+	f, err := st.fetch(ctx, stored)
+	if err != nil {
+		return nil, "", err
+	}
+	sfs, err := fs.OpenSnapshot(f, st.Tenant.fsys)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	st.open[stored] = &openSnapshot{f: f, fsys: sfs}
+	return sfs, rest, nil
+}
+
+/*
+  A snapshot as a file that can be read anywhere in.  One from a
+  directory store is that file, and one from elsewhere is copied to
+  a temporary file first, which is gone once it is closed.
+*/
+func (st *snapshotTree) fetch(ctx context.Context, stored string) (*os.File, error) {
+	rc, err := st.Tenant.snapshots.Open(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := rc.(*os.File); ok {
+		return f, nil
+	}
+	defer rc.Close()
+	f, err := ioutil.TempFile("", "webdev-snapshot-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+  What is in the store now, as directories.  Snapshots that have
+  left it since they were opened are closed.
+*/
+func (st *snapshotTree) list(ctx context.Context) ([]os.FileInfo, error) {
+	list, err := st.Tenant.snapshots.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	infos := make([]os.FileInfo, 0, len(list))
+	for _, s := range list {
+		found[s.Name] = true
+		infos = append(infos, snapshotDirInfo{name: strings.TrimSuffix(s.Name, ".tar"), modTime: s.Time})
+	}
+	st.mu.Lock()
+	for name, o := range st.open {
+		if !found[name] {
+			o.f.Close()
+			delete(st.open, name)
+		}
+	}
+	st.mu.Unlock()
+	return infos, nil
+}
+
+func (st *snapshotTree) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return webdav.ErrNotAllowed
+}
+
+func (st *snapshotTree) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if webdav.SlashClean(name) == "/" {
+		list, err := st.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &snapshotListing{list: list}, nil
+	}
+	sfs, rest, err := st.split(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := sfs.OpenFile(ctx, rest, flag, perm)
+	if err != nil || rest != "/" {
+		return f, err
+	}
+	fi, err := st.Stat(ctx, name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return snapshotTop{File: f, info: fi}, nil
+}
+
+func (st *snapshotTree) RemoveAll(ctx context.Context, name string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (st *snapshotTree) Rename(ctx context.Context, oldName, newName string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (st *snapshotTree) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if webdav.SlashClean(name) == "/" {
+		return snapshotDirInfo{name: "/", modTime: time.Now()}, nil
+	}
+	sfs, rest, err := st.split(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := sfs.Stat(ctx, rest)
+	if err == nil && rest == "/" {
+		// named for the snapshot, not for what it is of
+		fi = snapshotDirInfo{name: strings.TrimPrefix(webdav.SlashClean(name), "/"), modTime: fi.ModTime()}
+	}
+	return fi, err
+}
+
+func (st *snapshotTree) Capabilities(ctx context.Context, name string) (webdav.Capabilities, error) {
+	if webdav.SlashClean(name) == "/" {
+		return webdav.Capabilities{Exists: true, Dir: true, Read: true}, nil
+	}
+	sfs, rest, err := st.split(ctx, name)
+	if err != nil {
+		return webdav.Capabilities{}, err
+	}
+	return sfs.Capabilities(ctx, rest)
+}
+
+type snapshotDirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi snapshotDirInfo) Name() string       { return fi.name }
+func (fi snapshotDirInfo) Size() int64        { return 0 }
+func (fi snapshotDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (fi snapshotDirInfo) ModTime() time.Time { return fi.modTime }
+func (fi snapshotDirInfo) IsDir() bool        { return true }
+func (fi snapshotDirInfo) Sys() interface{}   { return nil }
+
+// The top of a snapshot, which is named for it.
+type snapshotTop struct {
+	webdav.File
+	info os.FileInfo
+}
+
+func (f snapshotTop) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// The top of the tree, which lists the snapshots.
+type snapshotListing struct {
+	list []os.FileInfo
+	pos  int
+}
+
+func (l *snapshotListing) Read(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (l *snapshotListing) Seek(offset int64, whence int) (int64, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (l *snapshotListing) Write(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (l *snapshotListing) Close() error {
+	return nil
+}
+
+func (l *snapshotListing) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		rest := l.list[l.pos:]
+		l.pos = len(l.list)
+		return rest, nil
+	}
+	if l.pos >= len(l.list) {
+		return nil, io.EOF
+	}
+	end := l.pos + count
+	if end > len(l.list) {
+		end = len(l.list)
+	}
+	rest := l.list[l.pos:end]
+	l.pos = end
+	return rest, nil
+}
+
+func (l *snapshotListing) Stat() (os.FileInfo, error) {
+	return snapshotDirInfo{name: "/", modTime: time.Now()}, nil
+}
+
+func (l *snapshotListing) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return map[xml.Name]webdav.Property{}, nil
+}
+
+func (l *snapshotListing) Patch(p []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return nil, webdav.ErrNotAllowed
+}
+
+/*
+  Browse the stored snapshots, read only, to get back an older
+  version of a file without asking an admin.  Copy it out with
+  GET, and PUT it back where it belongs.
+*/
+func snapshotTreeHandler(t *Tenant) http.Handler {
+	tree := t.tree
+	filter := propertyFilter(t.fsys)
+	return &webdav.Handler{
+		Prefix:      t.Prefix + strings.TrimSuffix(snapshotTreePrefix, "/"),
+		FileSystem:  webdav.ReadOnlyFS{FileSystem: tree},
+		LockSystem:  fs.NewMemLS(),
+		Obligations: obligationProcessors,
+		Filters:     contentFilters,
+		// properties are hidden as they would be where they are now
+		PropertyFilter: func(ctx context.Context, name string) func(xml.Name) bool {
+			sfs, rest, err := tree.split(ctx, name)
+			if err != nil {
+				return nil
+			}
+			return filter(ctx, sfs.LiveName(rest))
+		},
+		// nothing here changes, so there is nothing to lock
+		LockModes: map[string]webdav.LockMode{"/": webdav.LockNone},
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				webdav.Log().Warn("request failed", "request_id", webdav.RequestID(r.Context()), "user", r.Context().Value("username"), "method", r.Method, "url", r.URL, "err", err)
+			}
+		},
+	}
+}
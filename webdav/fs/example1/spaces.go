@@ -0,0 +1,268 @@
+package example1
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Where shared spaces are made, under the root of a volume
+const spacesDir = "spaces"
+
+// The template that spaces are made with, and known by
+const spaceTemplate = "group-space"
+
+/*
+  The most that may be kept under a directory, in bytes, as a dead
+  property of the directory that only admins may change:
+
+    <w:quota xmlns:w="https://github.com/rfielding/webdev/">10737418240</w:quota>
+*/
+var quotaProperty = xml.Name{Space: "https://github.com/rfielding/webdev/", Local: "quota"}
+
+type SpaceRequest struct {
+	Name string `json:"name"`
+	// The group whose members may use it, which is Name if not given
+	Group string `json:"group,omitempty"`
+	// The most it may hold, in bytes, or 0 for as much as the volume
+	Quota            int64  `json:"quota,omitempty"`
+	Banner           string `json:"banner,omitempty"`
+	BannerForeground string `json:"bannerForeground,omitempty"`
+	BannerBackground string `json:"bannerBackground,omitempty"`
+}
+
+type Space struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Group string `json:"group"`
+	Quota int64  `json:"quota,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
+
+/*
+  Provision a shared space for a group, at /spaces/name: the
+  directory, a group-space policy that lets the members of the group
+  do anything in it, the banner that the policy gives, and its quota.
+  The group is a principal from then on, even before it has members.
+  It is all made in a hidden directory and renamed into place, so a
+  space is there whole, or not at all.
+*/
+func provisionSpace(fsys fs.FS, owner string, req SpaceRequest) (Space, error) {
+	if req.Group == "" {
+		req.Group = req.Name
+	}
+	if err := validUsername(req.Name); err != nil {
+		return Space{}, fmt.Errorf("name: %v", err)
+	}
+	if err := validUsername(req.Group); err != nil {
+		return Space{}, fmt.Errorf("group: %v", err)
+	}
+	if req.Quota < 0 {
+		return Space{}, fmt.Errorf("quota must not be negative")
+	}
+	space := Space{Name: req.Name, Path: path.Join("/", spacesDir, req.Name), Group: req.Group, Quota: req.Quota, Owner: owner}
+	dir := filepath.Join(fsys.Root, spacesDir)
+	final := filepath.Join(dir, req.Name)
+	if _, err := os.Lstat(final); err == nil {
+		return Space{}, os.ErrExist
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Space{}, err
+	}
+	tmp, err := ioutil.TempDir(dir, ".__provisioning.")
+	if err != nil {
+		return Space{}, err
+	}
+	err = os.Chmod(tmp, 0755)
+	if err == nil {
+		err = writeSpace(tmp, space, req)
+	}
+	if err == nil {
+		if _, err = os.Lstat(final); err == nil {
+			err = os.ErrExist
+		} else if os.IsNotExist(err) {
+			err = os.Rename(tmp, final)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return Space{}, err
+	}
+	fsys.Stats.InvalidateTree(final)
+	return space, nil
+}
+
+// Write what is in a space into dir, where it is being made.
+func writeSpace(dir string, space Space, req SpaceRequest) error {
+	params := map[string]interface{}{
+		"attribute": ldapDefaultGroupsClaim,
+		"values":    []string{space.Group},
+	}
+	for k, v := range map[string]string{"banner": req.Banner, "bannerForeground": req.BannerForeground, "bannerBackground": req.BannerBackground} {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	manifest, err := json.MarshalIndent(PolicyManifest{Template: spaceTemplate, Params: params}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".__security.json"), manifest, 0644); err != nil {
+		return err
+	}
+	if space.Quota > 0 {
+		props, err := json.MarshalIndent(map[string]string{fs.PropKey(quotaProperty): strconv.FormatInt(space.Quota, 10)}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, ".__deadproperties.json"), props, 0644); err != nil {
+			return err
+		}
+	}
+	if space.Owner != "" {
+		return fs.SetOwner(dir, space.Owner)
+	}
+	return nil
+}
+
+// The spaces on the volume at root, by name.
+func spacesOf(root string) []Space {
+	infos, err := ioutil.ReadDir(filepath.Join(root, spacesDir))
+	if err != nil {
+		return nil
+	}
+	spaces := make([]Space, 0)
+	for _, fi := range infos {
+		full := filepath.Join(root, spacesDir, fi.Name())
+		if !fi.IsDir() || validUsername(fi.Name()) != nil {
+			continue
+		}
+		manifest, err := manifestOf(full)
+		if err != nil || manifest == nil || manifest.Template != spaceTemplate {
+			continue
+		}
+		values, _ := manifest.Params["values"].([]interface{})
+		if len(values) == 0 {
+			continue
+		}
+		group, _ := values[0].(string)
+		quota, _ := quotaOf(full)
+		spaces = append(spaces, Space{
+			Name:  fi.Name(),
+			Path:  path.Join("/", spacesDir, fi.Name()),
+			Group: group,
+			Quota: quota,
+			Owner: fs.OwnerOf(full),
+		})
+	}
+	sort.Slice(spaces, func(i, j int) bool { return spaces[i].Name < spaces[j].Name })
+	return spaces
+}
+
+// The quota set on the directory full, a path on the volume, if any.
+func quotaOf(full string) (int64, bool) {
+	v, ok := fs.OwnProperties(full)[fs.PropKey(quotaProperty)]
+	if !ok {
+		return 0, false
+	}
+	var text struct {
+		Text string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte("<t>"+v+"</t>"), &text); err != nil {
+		return 0, false
+	}
+	quota, err := strconv.ParseInt(text.Text, 10, 64)
+	if err != nil || quota <= 0 {
+		return 0, false
+	}
+	return quota, true
+}
+
+// The nearest directory at or above name, a slash separated path, that
+// has a quota, with the quota.
+func nearestQuota(fsys fs.FS, name string) (dir string, quota int64, ok bool) {
+	for dir = webdav.SlashClean(name); ; dir = path.Dir(dir) {
+		if quota, ok = quotaOf(fsys.Resolve(dir)); ok {
+			return dir, quota, true
+		}
+		if dir == "/" {
+			return "", 0, false
+		}
+	}
+}
+
+/*
+  What is left under the directory with the nearest quota above
+  name, if there is one.  Only files count against it, as
+  quota-used-bytes does, and not the policy and properties that a
+  space is provisioned with.
+*/
+func spaceLeft(ctx context.Context, fsys fs.FS, name string) (left int64, ok bool, err error) {
+	dir, quota, ok := nearestQuota(fsys, path.Dir(webdav.SlashClean(name)))
+	if !ok {
+		return 0, false, nil
+	}
+	usage, err := fsys.Usage(ctx, dir)
+	if err != nil {
+		return 0, false, err
+	}
+	return quota - usage.Bytes, true, nil
+}
+
+/*
+  Provision shared spaces, and list them, as an admin:
+
+    GET  /.__api/spaces
+    POST /.__api/spaces  {"name": "apollo", "group": "apollo", "quota": 10737418240, "banner": "APOLLO"}
+*/
+func spacesHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		username, _ := ctx.Value("username").(string)
+		if !isAdmin(ctx, fsys) {
+			writeJsonError(w, http.StatusForbidden, ErrNotAdmin)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, spacesOf(fsys.Root))
+		case "POST":
+			var req SpaceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			if !mayChange(w, tenantOf(ctx), path.Join("/", spacesDir, req.Name)) {
+				return
+			}
+			rec := AuditRecord{User: username, Action: "provision space", Target: path.Join("/", spacesDir, req.Name)}
+			rec.After, _ = json.Marshal(req)
+			space, err := provisionSpace(fsys, username, req)
+			if err != nil {
+				rec.Error = err.Error()
+				audit(ctx, rec)
+				status := http.StatusBadRequest
+				if os.IsExist(err) {
+					status = http.StatusConflict
+				}
+				writeJsonError(w, status, err)
+				return
+			}
+			audit(ctx, rec)
+			writeJson(w, http.StatusCreated, space)
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package example1
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  The apis that are not WebDAV make their changes as WebDAV requests
+  to the tenant's handler, on behalf of the request they are serving,
+  so that they are allowed, locked, journaled and audited the same
+  way as any other.
+*/
+
+// A WebDAV request for name in t, on behalf of r, for apis that are served through WebDAV.
+func davRequest(r *http.Request, t *Tenant, method, name string, body io.Reader, size int64) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = method
+	req.URL.Path = t.Prefix + name
+	req.URL.RawPath = ""
+	req.URL.RawQuery = ""
+	req.Header = make(http.Header)
+	if body == nil {
+		body = http.NoBody
+	}
+	req.Body = ioutil.NopCloser(body)
+	req.ContentLength = size
+	return req
+}
+
+// davError is how a change through WebDAV failed, as its status.
+type davError struct {
+	Status  int
+	Message string
+}
+
+func (e *davError) Error() string {
+	return e.Message
+}
+
+func davErrorf(status int, format string, args ...interface{}) error {
+	return &davError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+func davErrorFor(status int) error {
+	return &davError{Status: status, Message: http.StatusText(status)}
+}
+
+/*
+  Whether the user may write the file name, new or not, before what
+  is to be written is read.
+*/
+func mayWrite(ctx context.Context, fsys fs.FS, name string) error {
+	c, err := fsys.Capabilities(ctx, name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Exists && c.Dir:
+		return davErrorf(http.StatusConflict, "%s is a directory", name)
+	case c.Exists && !c.Write, !c.Exists && !c.Create:
+		if fsys.Disclosure(ctx, name) == webdav.DiscloseHidden {
+			return davErrorf(http.StatusNotFound, "%s not found", name)
+		}
+		return davErrorf(http.StatusForbidden, "cannot write %s", name)
+	}
+	return nil
+}
+
+// Whether the user may delete name.
+func mayDelete(ctx context.Context, fsys fs.FS, name string) error {
+	c, err := fsys.Capabilities(ctx, name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !c.Exists && fsys.Disclosure(ctx, name) != webdav.DiscloseForbidden:
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	case !c.Delete && fsys.Disclosure(ctx, name) == webdav.DiscloseHidden:
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	case !c.Delete:
+		return davErrorf(http.StatusForbidden, "cannot delete %s", name)
+	}
+	return nil
+}
+
+// The dead properties of name that the user may see, by fs.PropKey.
+func deadProps(ctx context.Context, fsys fs.FS, name string) (map[string]string, error) {
+	f, err := fsys.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, davErrorf(http.StatusNotFound, "%s not found", name)
+	}
+	defer f.Close()
+	dead, err := f.(webdav.DeadPropsHolder).DeadProps()
+	if err != nil {
+		return nil, err
+	}
+	visible := propertyFilter(fsys)(ctx, name)
+	props := make(map[string]string)
+	for pname, p := range dead {
+		if visible == nil || visible(pname) {
+			props[fs.PropKey(pname)] = string(p.InnerXML)
+		}
+	}
+	return props, nil
+}
+
+// A property as PROPPATCH would name it, in a namespace of its own.
+func propXML(key, value string, i int) (string, error) {
+	pname := fs.PropName(key)
+	if pname.Local == "" || strings.ContainsAny(pname.Local, " \t\r\n<>&\"'/=:") {
+		return "", davErrorf(http.StatusBadRequest, "bad property name %q", key)
+	}
+	var ns bytes.Buffer
+	xml.EscapeText(&ns, []byte(pname.Space))
+	return fmt.Sprintf(`<p%d:%s xmlns:p%d="%s">%s</p%d:%s>`, i, pname.Local, i, ns.String(), value, i, pname.Local), nil
+}
+
+/*
+  Set and remove properties of name with a PROPPATCH, so that they
+  are validated, locked and journaled as any other.  Values are the
+  inner xml of the properties, as WebDAV keeps them.
+*/
+func propPatch(r *http.Request, t *Tenant, dav http.Handler, name string, set map[string]string, remove []string) error {
+	if len(set) == 0 && len(remove) == 0 {
+		return davErrorf(http.StatusBadRequest, "nothing to set or remove")
+	}
+	// WebDAV fails, rather than refuses, a PROPPATCH that may not be done
+	if c, err := t.fsys.Capabilities(r.Context(), name); err != nil {
+		return err
+	} else if !c.Exists {
+		return davErrorf(http.StatusNotFound, "%s not found", name)
+	} else if !c.Write {
+		return davErrorf(http.StatusForbidden, "cannot change the properties of %s", name)
+	}
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><D:propertyupdate xmlns:D="DAV:">`)
+	i := 0
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p, err := propXML(k, set[k], i)
+		if err != nil {
+			return err
+		}
+		body.WriteString("<D:set><D:prop>" + p + "</D:prop></D:set>")
+		i++
+	}
+	for _, k := range remove {
+		p, err := propXML(k, "", i)
+		if err != nil {
+			return err
+		}
+		body.WriteString("<D:remove><D:prop>" + p + "</D:prop></D:remove>")
+		i++
+	}
+	body.WriteString("</D:propertyupdate>")
+	patch := davRequest(r, t, "PROPPATCH", name, strings.NewReader(body.String()), int64(body.Len()))
+	patch.Header.Set("Content-Type", "application/xml")
+	res := &multistatusRecorder{header: make(http.Header)}
+	dav.ServeHTTP(res, patch)
+	return res.failure()
+}
+
+// multistatusRecorder keeps the multistatus that a PROPPATCH answers with.
+type multistatusRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (m *multistatusRecorder) Header() http.Header {
+	return m.header
+}
+
+func (m *multistatusRecorder) WriteHeader(status int) {
+	if m.status == 0 {
+		m.status = status
+	}
+}
+
+func (m *multistatusRecorder) Write(b []byte) (int, error) {
+	m.WriteHeader(http.StatusOK)
+	return m.body.Write(b)
+}
+
+/*
+  The first property that could not be changed, as its status.  The
+  others fail with 424 Failed Dependency when one does, so those
+  are passed over.
+*/
+func (m *multistatusRecorder) failure() error {
+	if m.status != http.StatusMultiStatus {
+		return davErrorFor(m.status)
+	}
+	var ms struct {
+		Propstat []struct {
+			Prop struct {
+				Names []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: response>propstat"`
+	}
+	if err := xml.Unmarshal(m.body.Bytes(), &ms); err != nil {
+		return err
+	}
+	for _, ps := range ms.Propstat {
+		fields := strings.Fields(ps.Status)
+		if len(fields) < 2 {
+			continue
+		}
+		status, _ := strconv.Atoi(fields[1])
+		if status == http.StatusOK || status == http.StatusFailedDependency {
+			continue
+		}
+		for _, n := range ps.Prop.Names {
+			return davErrorf(status, "cannot change %s: %s", fs.PropKey(n.XMLName), http.StatusText(status))
+		}
+		return davErrorFor(status)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package example1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+/*
+  Read and set the tags of a path.  Setting them is the same as a
+  PROPPATCH of the tags property, so it takes Write permission,
+  and policies can protect the tags the same way.
+  Without a path, GET lists the tags on what you can see, with
+  how many of those files have each.
+
+    GET /.__api/tags
+    GET /.__api/tags?path=/rob/cat.jpg
+    PUT /.__api/tags?path=/rob/cat.jpg  {"tags": ["cats", "pets"]}
+*/
+func tagsHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		q := r.URL.Query()
+		if r.Method == "GET" && q.Get("path") == "" {
+			counts := make(map[string]int)
+			for tag := range fsys.Tags.Tags() {
+				if n := len(visibleTagged(r, fsys, tag)); n > 0 {
+					counts[tag] = n
+				}
+			}
+			writeJson(w, http.StatusOK, counts)
+			return
+		}
+		name := webdav.SlashClean(q.Get("path"))
+		if _, err := fsys.Stat(ctx, name); err != nil || isMetadataPath(name) {
+			writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			f, err := fsys.OpenFile(ctx, name, os.O_RDONLY, 0)
+			if err != nil {
+				writeJsonError(w, http.StatusNotFound, os.ErrNotExist)
+				return
+			}
+			defer f.Close()
+			props, err := f.(webdav.DeadPropsHolder).DeadProps()
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusOK, fs.TagsOf(string(props[fs.TagsProperty].InnerXML)))
+		case "PUT":
+			var req TagsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJsonError(w, http.StatusBadRequest, err)
+				return
+			}
+			for _, t := range req.Tags {
+				if strings.Contains(t, ",") {
+					writeJsonError(w, http.StatusBadRequest, fmt.Errorf("tags cannot contain commas: %q", t))
+					return
+				}
+			}
+			f, err := fsys.OpenFile(ctx, name, os.O_RDWR, 0)
+			if err != nil {
+				writeJsonError(w, http.StatusForbidden, os.ErrPermission)
+				return
+			}
+			defer f.Close()
+			_, err = f.(webdav.DeadPropsHolder).Patch([]webdav.Proppatch{{
+				Remove: len(req.Tags) == 0,
+				Props: []webdav.Property{{
+					XMLName:  fs.TagsProperty,
+					InnerXML: []byte(fs.FormatTags(req.Tags)),
+				}},
+			}})
+			if err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJson(w, http.StatusOK, fs.TagsOf(fs.FormatTags(req.Tags)))
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+		}
+	})
+}
+
+/*
+  Find the files with a tag, out of those that you can see.
+
+    GET /.__api/tags/search?tag=cats
+*/
+func tagSearchHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, webdav.ErrUnsupportedMethod)
+			return
+		}
+		tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+		if tag == "" {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("tag is required"))
+			return
+		}
+		writeJson(w, http.StatusOK, visibleTagged(r, fsys, tag))
+	})
+}
+
+// The index can be stale, so check each file is still there, and visible.
+func visibleTagged(r *http.Request, fsys fs.FS, tag string) []string {
+	names := make([]string, 0)
+	for _, found := range fsys.Tags.Lookup(tag) {
+		name := fsys.VolumePath(found)
+		if name == "" {
+			continue
+		}
+		if _, err := fsys.Stat(r.Context(), name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,105 @@
+package example1
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Most admins are not rego authors.  Instead of a .__security.rego,
+  a directory can have a .__security.json that names one of these
+  templates, with parameters that the template sees as data.params:
+
+    {
+      "template": "group-read",
+      "params": {"owner": "rob", "attribute": "team", "values": ["eng"]}
+    }
+*/
+//go:embed templates/*.rego
+var policyTemplates embed.FS
+
+type PolicyManifest struct {
+	Template string                 `json:"template"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+}
+
+// PolicyTemplates lists the names of the built in templates.
+func PolicyTemplates() []string {
+	entries, _ := policyTemplates.ReadDir("templates")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".rego"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func policyTemplate(template string) (string, error) {
+	if template == "" || strings.ContainsAny(template, "/.") {
+		return "", fmt.Errorf("invalid policy template: %q", template)
+	}
+	data, err := policyTemplates.ReadFile(path.Join("templates", template+".rego"))
+	if err != nil {
+		return "", fmt.Errorf("no such policy template: %s", template)
+	}
+	return string(data), nil
+}
+
+// manifestOf reads the manifest attached to name, if there is one.
+func manifestOf(name string) (*PolicyManifest, error) {
+	manifestFile := fs.NameFor(name, "security.json")
+	if manifestFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(manifestFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest PolicyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", manifestFile, err)
+	}
+	return &manifest, nil
+}
+
+/*
+  The rego for a manifest attached to name.  ok is false when there is no
+  manifest.  A broken manifest gets the policy that allows nothing.
+*/
+func templateOf(name string) (opaObj string, ok bool) {
+	manifest, err := manifestOf(name)
+	if err != nil {
+		webdav.Log().Warn("cannot read policy manifest", "err", err)
+		return emptyPolicy, true
+	}
+	if manifest == nil {
+		return "", false
+	}
+	opaObj, err = policyTemplate(manifest.Template)
+	if err != nil {
+		webdav.Log().Warn("cannot use policy template", "name", name, "err", err)
+		return emptyPolicy, true
+	}
+	return opaObj, true
+}
+
+// paramsOf makes the parameters of the manifest on name into a data document.
+func paramsOf(name string) map[string]interface{} {
+	manifest, err := manifestOf(name)
+	if err != nil || manifest == nil || manifest.Params == nil {
+		return nil
+	}
+	return map[string]interface{}{"params": manifest.Params}
+}
@@ -0,0 +1,27 @@
+package policy
+
+# Users can read when their clearance claim is at or above
+# data.params.classification, in the order of data.params.levels.
+# Cleared users that are also in data.params.writers can write.
+
+level(c) = i {
+    data.params.levels[i] == c
+}
+
+cleared {
+    level(input.claims.groups.clearance[_]) >= level(data.params.classification)
+}
+
+writer {
+    input.claims.groups.username[_] == data.params.writers[_]
+}
+
+Stat { cleared }
+Read { cleared }
+Create { cleared; writer }
+Write { cleared; writer }
+Delete { cleared; writer }
+
+Banner = data.params.classification
+BannerForeground = "white"
+BannerBackground = "red"
@@ -0,0 +1,24 @@
+package policy
+
+# Members of a group can read, and the owner can do anything.
+# A member has one of data.params.values in the claim data.params.attribute
+
+owner {
+    input.claims.groups.username[_] == data.params.owner
+}
+
+member {
+    input.claims.groups[data.params.attribute][_] == data.params.values[_]
+}
+
+Stat { owner }
+Stat { member }
+Read { owner }
+Read { member }
+Create { owner }
+Write { owner }
+Delete { owner }
+
+Banner = "GROUP"
+BannerForeground = "black"
+BannerBackground = "yellow"
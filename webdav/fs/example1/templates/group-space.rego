@@ -0,0 +1,18 @@
+package policy
+
+# A shared space: members of a group can do anything here.
+# A member has one of data.params.values in the claim data.params.attribute
+
+member {
+    input.claims.groups[data.params.attribute][_] == data.params.values[_]
+}
+
+Stat { member }
+Read { member }
+Create { member }
+Write { member }
+Delete { member }
+
+Banner = data.params.banner
+BannerForeground = data.params.bannerForeground
+BannerBackground = data.params.bannerBackground
@@ -0,0 +1,17 @@
+package policy
+
+# Only data.params.owner may see or touch anything here.
+
+owner {
+    input.claims.groups.username[_] == data.params.owner
+}
+
+Stat { owner }
+Read { owner }
+Create { owner }
+Write { owner }
+Delete { owner }
+
+Banner = "PRIVATE"
+BannerForeground = "white"
+BannerBackground = "red"
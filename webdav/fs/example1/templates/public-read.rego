@@ -0,0 +1,17 @@
+package policy
+
+# Everyone can read, and the owner can do anything.
+
+owner {
+    input.claims.groups.username[_] == data.params.owner
+}
+
+Stat = true
+Read = true
+Create { owner }
+Write { owner }
+Delete { owner }
+
+Banner = "PUBLIC"
+BannerForeground = "white"
+BannerBackground = "green"
@@ -0,0 +1,393 @@
+package example1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  A tenant is one customer of a server that serves many.  It is
+  picked by the Host of a request, or by a path prefix, and has a
+  root, locks, policies, share links, activity feed and jobs of
+  its own.  Tenants are listed in a json file:
+
+    [
+      {"name": "acme", "host": "acme.example.com", "root": "./tenants/acme", "quota": 1073741824, "journal": "./acme.journal"},
+      {"name": "initech", "prefix": "/initech", "root": "./tenants/initech", "engine": "acl", "acl": "./initech.acl.json", "shares": "./initech.shares.json"},
+      {"name": "hooli", "host": "hooli.example.com", "root": "./tenants/hooli", "mounts": {"/archive": "./archive/hooli"}}
+    ]
+
+  Without a tenants file, the server is a single tenant with
+  neither a host nor a prefix.  Behind a proxy that serves it under
+  -basepath, every tenant's prefix is under that.
+*/
+type Tenant struct {
+	Name   string `json:"name"`
+	Host   string `json:"host,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Root   string `json:"root"`
+	// The policy engine and acl file, if not the ones given by flags
+	Engine string `json:"engine,omitempty"`
+	ACL    string `json:"acl,omitempty"`
+	// A file to keep share links in.  Without one, the tenant cannot share.
+	Shares string `json:"shares,omitempty"`
+	// Bytes that the tenant may store, if more than zero.  A reload
+	// changes it as it is served, so it is read with atomic.
+	Quota int64 `json:"quota,omitempty"`
+	// A file to journal changes in.  Without one, there is no journal.
+	Journal string `json:"journal,omitempty"`
+	// The url of a server to mirror changes to.  It needs a journal.
+	Replica string `json:"replica,omitempty"`
+	// A directory, or s3:// url, to keep snapshots in
+	Snapshots string `json:"snapshots,omitempty"`
+	// Other directories to serve under paths of the volume, by path
+	Mounts map[string]string `json:"mounts,omitempty"`
+
+	fsys   fs.FS
+	mux    *http.ServeMux
+	signer *urlSigner
+	shares ShareStore
+	recent     *activityLog
+	journal    *changeJournal
+	replicator *replicator
+	snapshots  SnapshotStore
+	tree       *snapshotTree
+	s3         http.Handler
+	grpc       http.Handler
+	// WebDAV, for the frontends that are not http
+	dav        http.Handler
+	// changes hold this for reading, and snapshots for writing
+	writes sync.RWMutex
+	// subtrees that are read only for maintenance
+	readOnly readOnlySubtrees
+	// what it uses of its quota, as the quota job counts it
+	used usageCount
+	// its maintenance jobs, and how they are doing
+	scheduler *fs.Scheduler
+	// what a reload needs, to mount volumes again
+	srv       *webdav.Handler
+	mounts    map[string]webdav.Mount
+	newEngine func(root string) (PolicyEngine, error)
+}
+
+/*
+  The tenant when there is no tenants file.
+*/
+var defaultTenant = &Tenant{}
+
+// tenantOf is the tenant that a request is for.
+func tenantOf(ctx context.Context) *Tenant {
+	if t, ok := ctx.Value("tenant").(*Tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+func loadTenants(file string) ([]*Tenant, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, t := range tenants {
+		if t.Name == "" || t.Root == "" {
+			return nil, fmt.Errorf("every tenant needs a name and a root")
+		}
+		if (t.Host == "") == (t.Prefix == "") {
+			return nil, fmt.Errorf("tenant %s needs exactly one of host or prefix", t.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("tenant %s is listed twice", t.Name)
+		}
+		names[t.Name] = true
+		t.Host = strings.ToLower(t.Host)
+		if t.Prefix != "" {
+			t.Prefix = webdav.SlashClean(t.Prefix)
+		}
+		t.Prefix = underBasePath(t.Prefix)
+	}
+	return tenants, nil
+}
+
+// Mounts, as -mounts gives them: /archive=./archive,/shared=/srv/shared
+func parseMounts(s string) (map[string]string, error) {
+	mounts := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("bad mount %q", entry)
+		}
+		mounts[parts[0]] = parts[1]
+	}
+	return mounts, nil
+}
+
+/*
+  The mounts of a tenant, each a volume with its own locks, and
+  policies from newEngine.  Their paths are in the volume, so that
+  "/archive" is at /initech/archive for a tenant with the prefix
+  /initech.
+*/
+func newMounts(t *Tenant, config map[string]string, newEngine func(root string) (PolicyEngine, error)) (map[string]webdav.Mount, error) {
+	mounts := make(map[string]webdav.Mount)
+	for at, root := range config {
+		at = webdav.SlashClean(at)
+		if at == "/" {
+			return nil, fmt.Errorf("tenant %q cannot mount over its root", t.Name)
+		}
+		// what is mounted already is kept, with its locks
+		if m, ok := t.mounts[at]; ok {
+			if fsys, ok := m.FileSystem.(fs.FS); ok && fsys.Root == root {
+				mounts[at] = m
+				continue
+			}
+		}
+		engine, err := newEngine(root)
+		if err != nil {
+			return nil, err
+		}
+		fsys := newVolume(root, engine)
+		mounts[at] = webdav.Mount{FileSystem: fsys, LockSystem: fsys.Locks}
+	}
+	return mounts, nil
+}
+
+/*
+  Each tenant signs urls with its own key, made from the server's,
+  so that a link made for one tenant does nothing for another.
+*/
+func (s *urlSigner) forTenant(name string) *urlSigner {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("tenant\n" + name))
+	return &urlSigner{key: mac.Sum(nil)}
+}
+
+/*
+  Send each request to its tenant.  A request that matches no
+  tenant is refused, rather than sent to a default, so that
+  nothing leaks between them.
+*/
+type tenantRouter struct {
+	Tenants []*Tenant
+}
+
+func (tr tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := tr.find(r)
+	if t == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), "tenant", t))
+	if t.Prefix != "" {
+		// The apis are served as if the prefix were not there.  WebDAV
+		// keeps it, so that hrefs and Destination headers carry it.
+		rest := strings.TrimPrefix(r.URL.Path, t.Prefix)
+		if strings.HasPrefix(rest, apiPrefix) || strings.HasPrefix(rest, sharePrefix) {
+			http.StripPrefix(t.Prefix, t.mux).ServeHTTP(w, r)
+			return
+		}
+	}
+	t.mux.ServeHTTP(w, r)
+}
+
+func hostOf(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (tr tenantRouter) find(r *http.Request) *Tenant {
+	host := hostOf(r)
+	var found *Tenant
+	for _, t := range tr.Tenants {
+		if t.Host != "" && t.Host == host {
+			return t
+		}
+		if t.Host == "" && t.Prefix != "" && (r.URL.Path == t.Prefix || strings.HasPrefix(r.URL.Path, t.Prefix+"/")) {
+			if found == nil || len(t.Prefix) > len(found.Prefix) {
+				found = t
+			}
+		}
+	}
+	return found
+}
+
+/*
+  The tenant for r by host alone, for apis whose clients cannot put
+  a prefix in front of the path.  Without a tenants file, it is the
+  one tenant there is.
+*/
+func (tr tenantRouter) findByHost(r *http.Request) *Tenant {
+	if len(tr.Tenants) == 1 && tr.Tenants[0] == defaultTenant {
+		return defaultTenant
+	}
+	host := hostOf(r)
+	for _, t := range tr.Tenants {
+		if t.Host != "" && t.Host == host {
+			return t
+		}
+	}
+	return nil
+}
+
+/*
+  Refuse uploads once a tenant has used up its quota, or the nearest
+  directory above where it goes with a quota, as a space has, has
+  used up its own, with 507 Insufficient Storage.  Usage is totalled
+  by walking the root, by the quota job when it runs, and otherwise
+  on every upload, which only suits small tenants.  What an upload
+  brings is added to the count once it has succeeded.
+*/
+type quotaHandler struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+func (q quotaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" && r.Method != "COPY" {
+		q.Handler.ServeHTTP(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, q.Tenant.Prefix)
+	if dst := r.Header.Get("Destination"); dst != "" && r.Method == "COPY" {
+		if u, err := url.Parse(dst); err == nil {
+			name = strings.TrimPrefix(u.Path, q.Tenant.Prefix)
+		}
+	}
+	quota := atomic.LoadInt64(&q.Tenant.Quota)
+	left, limited, err := spaceLeft(r.Context(), q.Tenant.fsys, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if quota <= 0 && !limited {
+		q.Handler.ServeHTTP(w, r)
+		return
+	}
+	in, err := incomingUsage(r.Context(), q.Tenant, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if quota > 0 {
+		incoming := in.Bytes + in.MetadataBytes
+		used, counted := q.Tenant.used.get()
+		if !counted {
+			usage, err := q.Tenant.fsys.Usage(context.Background(), "/")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			used = usage.Bytes + usage.MetadataBytes
+		}
+		if used+incoming > quota {
+			webdav.ServeError(w, r, webdav.ErrQuotaExceeded)
+			return
+		}
+		holdBody(r, quota-used)
+	}
+	if limited {
+		// only files count against a space
+		if in.Bytes > left {
+			webdav.ServeError(w, r, webdav.ErrQuotaExceeded)
+			return
+		}
+		holdBody(r, left)
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	q.Handler.ServeHTTP(cw, r)
+	if quota > 0 && cw.status/100 == 2 {
+		// what came, now that it is on the volume
+		written := in.Bytes + in.MetadataBytes
+		if b, ok := r.Body.(*quotaReader); ok {
+			written = b.read
+		}
+		q.Tenant.used.add(written)
+	}
+}
+
+/*
+  What a PUT or COPY brings in: the body, or for a COPY, what its
+  source uses on the volume.  A body that is sent chunked, with no
+  length, counts as nothing here, and is held to the quota as it is
+  read instead.
+*/
+func incomingUsage(ctx context.Context, t *Tenant, r *http.Request) (fs.Usage, error) {
+	if r.Method == "COPY" {
+		usage, err := t.fsys.Usage(ctx, strings.TrimPrefix(r.URL.Path, t.Prefix))
+		if os.IsNotExist(err) {
+			// the handler answers that
+			return fs.Usage{}, nil
+		}
+		return usage, err
+	}
+	if r.ContentLength < 0 {
+		return fs.Usage{}, nil
+	}
+	return fs.Usage{Bytes: r.ContentLength}, nil
+}
+
+// Hold the body of a PUT of unknown length to left bytes, or to less
+// if it is held to less already
+func holdBody(r *http.Request, left int64) {
+	if r.Method != "PUT" || r.ContentLength >= 0 {
+		return
+	}
+	if b, ok := r.Body.(*quotaReader); ok {
+		if left < b.left {
+			b.left = left
+		}
+		return
+	}
+	r.Body = &quotaReader{ReadCloser: r.Body, left: left}
+}
+
+// A body of unknown length, that fails with ErrQuotaExceeded rather
+// than give more than left bytes
+type quotaReader struct {
+	io.ReadCloser
+	left int64
+	read int64
+}
+
+func (b *quotaReader) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// it may have given all it had
+		var one [1]byte
+		if n, err := b.ReadCloser.Read(one[:]); n == 0 {
+			return 0, err
+		}
+		return 0, webdav.ErrQuotaExceeded
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	b.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,221 @@
+package example1
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/rfielding/webdev/webdav"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+  Users, their password hashes, and their claims, in a yaml file.
+
+    users:
+      rob:
+        password: $2a$10$N9qo8uLOickgx2ZMRZoMye...
+        groups: [admins, staff]
+        claims:
+          age: [adult]
+          citizen: [US]
+
+  Hashes are bcrypt, as htpasswd -B makes them, or webdavctl hash.  The groups go into the claim
+  groups, beside username and the rest of claims.
+*/
+type UserFile struct {
+	Users map[string]UserEntry `json:"users"`
+}
+
+type UserEntry struct {
+	Password string              `json:"password"`
+	Groups   []string            `json:"groups,omitempty"`
+	Claims   map[string][]string `json:"claims,omitempty"`
+}
+
+type userDB struct {
+	file     string
+	mu       sync.Mutex
+	modTime  time.Time
+	users    map[string]UserEntry
+	verified *passwordCache
+}
+
+// How long a password that worked is remembered, so that it is not checked again
+const passwordCacheTimeout = 5 * time.Minute
+
+/*
+  Salted hashes of passwords that worked, for checks that are slow
+  on purpose, since a WebDAV client sends its password with every
+  request.
+*/
+type passwordCache struct {
+	mu      sync.Mutex
+	salt    [16]byte
+	entries map[string]verifiedPassword
+}
+
+type verifiedPassword struct {
+	hash    []byte
+	expires time.Time
+}
+
+func newPasswordCache() (*passwordCache, error) {
+	c := &passwordCache{entries: make(map[string]verifiedPassword)}
+	_, err := rand.Read(c.salt[:])
+	return c, err
+}
+
+func (c *passwordCache) sum(username, password string) []byte {
+	sum := sha256.Sum256(append(append(c.salt[:], username+"\x00"...), password...))
+	return sum[:]
+}
+
+func (c *passwordCache) ok(username, password string) bool {
+	c.mu.Lock()
+	v, ok := c.entries[username]
+	c.mu.Unlock()
+	return ok && time.Now().Before(v.expires) && subtle.ConstantTimeCompare(v.hash, c.sum(username, password)) == 1
+}
+
+func (c *passwordCache) add(username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, v := range c.entries {
+		if now.After(v.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[username] = verifiedPassword{hash: c.sum(username, password), expires: now.Add(passwordCacheTimeout)}
+}
+
+func (c *passwordCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]verifiedPassword)
+}
+
+// Compared against for users that do not exist, so that they take as long
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+func newUserDB(file string) (*userDB, error) {
+	verified, err := newPasswordCache()
+	if err != nil {
+		return nil, err
+	}
+	db := &userDB{file: file, verified: verified}
+	if err := db.reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+/*
+  Read the file again if it changed, so that users can be added
+  without a restart.
+*/
+func (db *userDB) reload() error {
+	info, err := os.Stat(db.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(db.modTime) && db.users != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(db.file)
+	if err != nil {
+		return err
+	}
+	var f UserFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%s: %v", db.file, err)
+	}
+	for name, u := range f.Users {
+		if err := validUsername(name); err != nil {
+			return fmt.Errorf("%s: %v", db.file, err)
+		}
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			return fmt.Errorf("%s: %s must have a bcrypt password hash: %v", db.file, name, err)
+		}
+	}
+	db.users = f.Users
+	db.modTime = info.ModTime()
+	db.verified.clear()
+	return nil
+}
+
+func (db *userDB) user(username string) (UserEntry, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.reload(); err != nil {
+		// keep the users that were last read
+		webdav.Log().Warn("cannot read users", "file", db.file, "err", err)
+	}
+	u, ok := db.users[username]
+	return u, ok
+}
+
+func (db *userDB) Claims(ctx context.Context, root, username string) (Claims, error) {
+	u, ok := db.user(username)
+	if !ok {
+		return Claims{}, fmt.Errorf("no such user %s", username)
+	}
+	claims := Claims{Groups: make(map[string][]string)}
+	for k, v := range u.Claims {
+		claims.Groups[k] = v
+	}
+	claims.Groups["username"] = []string{username}
+	if len(u.Groups) > 0 {
+		claims.Groups[ldapDefaultGroupsClaim] = u.Groups
+	}
+	return claims, nil
+}
+
+func (db *userDB) Verify(ctx context.Context, username, password string) (Claims, error) {
+	u, ok := db.user(username)
+	if !ok {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return Claims{}, ErrBadPassword
+	}
+	if db.verified.ok(username, password) {
+		return db.Claims(ctx, "", username)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		return Claims{}, ErrBadPassword
+	}
+	db.verified.add(username, password)
+	return db.Claims(ctx, "", username)
+}
+
+/*
+  HashPassword makes a bcrypt hash for a users file.
+*/
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+/*
+  Check passwords against, and take claims from, a users file.
+*/
+func setupUsers(file string) {
+	if file == "" {
+		return
+	}
+	db, err := newUserDB(file)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot load users: %v", err)
+	}
+	claimsProvider = db
+	authenticator = db
+	webdav.Log().Info("using users file", "file", file, "users", len(db.users))
+}
@@ -0,0 +1,157 @@
+package example1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+/*
+  Virtual files are made from templates, and are in every directory
+  that the requester may Stat, as a README.html that lists what is
+  there, or a summary of what the policy lets them do.  They are
+  rendered for each GET, and are never written to the volume.  A real
+  file of the same name is served instead, and they are not listed,
+  nor can they be changed.
+*/
+type virtualTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// The virtual files, by name
+var virtualFiles = make(map[string]virtualTemplate)
+
+// What a virtual file's template sees
+type virtualData struct {
+	// Path is the directory, as the client sees it
+	Path string
+	// Name is the name of the virtual file
+	Name     string
+	Username string
+	// Permission is what the policy of the directory gives the requester,
+	// as .Permission.Write and .Permission.Banner
+	Permission map[string]interface{}
+	// Properties are the dead properties of the directory, with the ones it inherits
+	Properties map[string]string
+	Owner      string
+	// Entries are what the requester may Stat in the directory
+	Entries []browseEntry
+}
+
+/*
+  Serve virtual files, in front of the WebDAV handler, which sees
+  everything else.
+*/
+type virtualHandler struct {
+	Tenant  *Tenant
+	Handler http.Handler
+}
+
+func (v virtualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fsys := v.Tenant.fsys
+	name := webdav.SlashClean(strings.TrimPrefix(r.URL.Path, v.Tenant.Prefix))
+	dir, base := path.Split(name)
+	t, ok := virtualFiles[base]
+	if !ok || name == "/" {
+		v.Handler.ServeHTTP(w, r)
+		return
+	}
+	if _, err := os.Lstat(fsys.Resolve(name)); !os.IsNotExist(err) {
+		v.Handler.ServeHTTP(w, r)
+		return
+	}
+	dir = webdav.SlashClean(dir)
+	info, err := fsys.Stat(ctx, dir)
+	if err != nil || !info.IsDir() {
+		v.Handler.ServeHTTP(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+	case "OPTIONS", "PROPFIND":
+		v.Handler.ServeHTTP(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "virtual files cannot be changed", http.StatusMethodNotAllowed)
+		return
+	}
+	username, _ := ctx.Value("username").(string)
+	full := fsys.Resolve(dir)
+	data := virtualData{
+		Path:       path.Join("/", v.Tenant.Prefix, dir),
+		Name:       base,
+		Username:   username,
+		Permission: fsys.PermissionHandler(ctx, fs.Action{Name: full, Action: fs.AllowStat}),
+		Properties: fs.EffectiveProperties(fsys.Root, full),
+		Owner:      fs.OwnerOf(full),
+	}
+	data.Entries, _ = visibleEntries(ctx, v.Tenant, dir)
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		webdav.Log().Warn("cannot render virtual file", "request_id", webdav.RequestID(ctx), "name", name, "err", err)
+		http.Error(w, "cannot render "+base, http.StatusInternalServerError)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(base))
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	sum := sha256.Sum256(b.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	// it is what this user may see, now
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, base, info.ModTime(), bytes.NewReader(b.Bytes()))
+}
+
+/*
+  Load the virtual files from the templates in dir, each named for the
+  file it makes with .tmpl after it, as README.html.tmpl.  Templates
+  of html are escaped as html.
+*/
+func setupVirtualFiles(dir string) {
+	if dir == "" {
+		return
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("WEBDAV: cannot load virtual files: %v", err)
+	}
+	for _, fi := range infos {
+		name := strings.TrimSuffix(fi.Name(), ".tmpl")
+		if fi.IsDir() || name == fi.Name() {
+			continue
+		}
+		if validUsername(name) != nil {
+			log.Fatalf("WEBDAV: cannot serve a virtual file named %q", name)
+		}
+		file := filepath.Join(dir, fi.Name())
+		var t virtualTemplate
+		if ext := path.Ext(name); ext == ".html" || ext == ".htm" {
+			t, err = htmltemplate.New(fi.Name()).ParseFiles(file)
+		} else {
+			t, err = template.New(fi.Name()).ParseFiles(file)
+		}
+		if err != nil {
+			log.Fatalf("WEBDAV: cannot load virtual file: %v", err)
+		}
+		virtualFiles[name] = t
+	}
+	webdav.Log().Info("serving virtual files", "dir", dir, "files", len(virtualFiles))
+}
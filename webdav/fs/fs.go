@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/xml"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/internal/norm"
+	"io"
 	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	//ixml "github.com/rfielding/webdev/webdav/internal/xml"
 
 )
@@ -21,7 +25,10 @@ import (
    These are the expected types
 */
 var _ webdav.File = &DPFile{}
+var _ webdav.ObligatedFile = &DPFile{}
+var _ webdav.ReadCheckedFile = &DPFile{}
 var _ webdav.FileSystem = &FS{}
+var _ webdav.DisclosingFileSystem = FS{}
 
 /*
   There are a few actions that we need permission for
@@ -33,6 +40,14 @@ const AllowRead = Allow("Read")
 const AllowWrite = Allow("Write")
 const AllowDelete = Allow("Delete")
 const AllowStat = Allow("Stat")
+const AllowAdmin = Allow("Admin")
+
+/*
+  PolicyFailed is what a PermissionHandler gives, with why, when it
+  could not decide, so that what it does not allow is
+  webdav.ErrPolicyUnavailable rather than denied, or not there.
+*/
+const PolicyFailed = "PolicyFailed"
 
 /*
   At a minimum, we need to know what kind of change we are making to which file
@@ -49,34 +64,106 @@ type DPFile struct {
 	F   *os.File
 	FS  FS
 	Ctx context.Context
+	// Permission is the decision that let this file be opened, if any
+	Permission map[string]interface{}
+	readable   *bool
+	// what is left to list of a directory, by name
+	names []string
+	// whether the stat of the file has to be dropped from the cache on close
+	written bool
+	// gives the file back to the FS's budget
+	release func()
+}
+
+/*
+  A policy can attach obligations to a decision
+  as an object named Obligations.
+*/
+func (f *DPFile) Obligations() webdav.Obligations {
+	obligations, _ := f.Permission["Obligations"].(map[string]interface{})
+	return obligations
+}
+
+/*
+  Being able to see a file is not the same as being allowed to read
+  what is in it.  A file that this open just created is readable.
+*/
+func (f *DPFile) CanRead() bool {
+	if f.readable == nil {
+		readable := true
+		if f.Permission != nil {
+			permission := f.FS.PermissionHandler(f.Ctx, Action{Name: f.F.Name(), Action: AllowRead})
+			readable = f.FS.Allow(f.Ctx, permission, AllowRead)
+		}
+		f.readable = &readable
+	}
+	return *f.readable
 }
 
 func (f *DPFile) Read(b []byte) (int, error) {
+	if !f.CanRead() {
+		return 0, webdav.ErrNotAllowed
+	}
 	return f.F.Read(b)
 }
 
 func (f *DPFile) Close() error {
-	return f.F.Close()
+	if f.release != nil {
+		f.release()
+	}
+	err := f.F.Close()
+	if f.written {
+		f.FS.Stats.Invalidate(f.F.Name())
+	}
+	return err
 }
 
 func (f *DPFile) Seek(offset int64, whence int) (int64, error) {
 	return f.F.Seek(offset, whence)
 }
 
+/*
+  Readdir lists the directory in order of name, so that a listing
+  is the same from one open to the next, and can be paged through
+  with an offset.  With n > 0, it returns the next n entries at
+  most, and io.EOF when there are no more, as os.File does.  Only
+  the names are read up front, so a page of a huge directory costs
+  a page of stats.
+*/
 func (f *DPFile) Readdir(n int) ([]fs.FileInfo, error) {
-	result, err := f.F.Readdir(n)
-	if err != nil {
-		return nil, err
-	}
-	// filter out what we are not allowed to see
-	filteredResult := make([]fs.FileInfo, 0)
-	for i := range result {
-		permissions := f.FS.PermissionHandler(f.Ctx, Action{Name: f.F.Name(), Action: AllowStat})
-		if f.FS.Allow(f.Ctx, permissions, AllowStat) {
-			filteredResult = append(filteredResult, result[i])
+	if f.names == nil {
+		names, err := f.F.Readdirnames(-1)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		f.names = names
+	}
+	result := make([]fs.FileInfo, 0)
+	for len(f.names) > 0 && (n <= 0 || len(result) < n) {
+		child := filepath.Join(f.F.Name(), f.names[0])
+		f.names = f.names[1:]
+		// filter out what we are not allowed to see
+		permission := f.FS.PermissionHandler(f.Ctx, Action{Name: child, Action: AllowStat})
+		if !f.FS.Allow(f.Ctx, permission, AllowStat) {
+			continue
+		}
+		fi, err := os.Lstat(child)
+		if err != nil {
+			// removed since it was listed
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi = f.FS.listedLink(child, fi); fi == nil {
+				continue
+			}
 		}
+		result = append(result, fi)
 	}
-	return filteredResult, err
+	if n > 0 && len(result) == 0 {
+		return result, io.EOF
+	}
+	return result, nil
 }
 
 func (f *DPFile) Stat() (fs.FileInfo, error) {
@@ -84,7 +171,13 @@ func (f *DPFile) Stat() (fs.FileInfo, error) {
 }
 
 func (f *DPFile) Write(b []byte) (int, error) {
-	return f.F.Write(b)
+	f.written = true
+	n, err := f.F.Write(b)
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		// the volume, or the user's share of it, is full
+		return n, webdav.ErrQuotaExceeded
+	}
+	return n, err
 }
 
 // Encapsulate naming conventions for files that are attachments to real files
@@ -100,7 +193,7 @@ func NameFor(name, ftype string) string {
 		} else {
 			s, err := os.Stat(name)
 			if err != nil {
-				log.Printf("WEBDAV: stat on %s file %v", ftype, err)
+				webdav.Log().Debug("no file to attach to", "type", ftype, "err", err)
 				return ""
 			} else {
 				if s.IsDir() {
@@ -123,57 +216,84 @@ func (f *DPFile) DeadProps() (map[xml.Name]webdav.Property, error) {
 		return map[xml.Name]webdav.Property{}, nil	
 	}
 
-	// If the file doesn't exist, then return empty properties
 	retval := make(map[xml.Name]webdav.Property)
-	propertiesFile := NameFor(name, "deadproperties.json")
-	if _,err := os.Stat(propertiesFile); os.IsNotExist(err) {
-		return retval,nil
+	for k, v := range EffectiveProperties(f.FS.Root, name) {
+		if k == PropKey(OwnerProperty) {
+			continue
+		}
+		pname := PropName(k)
+		retval[pname] = webdav.Property{
+			XMLName:  pname,
+			InnerXML: []byte(v),
+		}
 	}
+	return retval, nil
+}
+
+// Properties in the DAV: namespace are stored by their local name,
+// and anything else as {namespace}local
+func PropKey(name xml.Name) string {
+	if name.Space == "" || name.Space == "DAV:" {
+		return name.Local
+	}
+	return fmt.Sprintf("{%s}%s", name.Space, name.Local)
+}
+
+// PropName undoes PropKey
+func PropName(key string) xml.Name {
+	if strings.HasPrefix(key, "{") {
+		if i := strings.Index(key, "}"); i > 0 {
+			return xml.Name{Space: key[1:i], Local: key[i+1:]}
+		}
+	}
+	return xml.Name{Space: "DAV:", Local: key}
+}
+
+// If the file doesn't exist, then there are no properties
+func readProperties(propertiesFile string) map[string]string {
+	propertiesMap := make(map[string]string)
 	bytes, err := ioutil.ReadFile(propertiesFile)
+	if os.IsNotExist(err) {
+		return propertiesMap
+	}
 	if err != nil {
-		log.Printf("error opening properties file %s: %v", propertiesFile, err)
-		return retval, nil
+		webdav.Log().Warn("cannot read properties", "file", propertiesFile, "err", err)
+		return propertiesMap
 	}
-	var propertiesMap map[string]string 
-	err = json.Unmarshal(bytes,&propertiesMap)
+	err = json.Unmarshal(bytes, &propertiesMap)
 	if err != nil {
-		log.Printf("error unmarshalling json %s: %v", propertiesFile, err)
-		return retval, nil
-	}
-	for k := range propertiesMap {
-		log.Printf("set: %s -> %s", k, propertiesMap[k])
-		retval[xml.Name{Space: "DAV:", Local: k}] = webdav.Property{
-            XMLName:  xml.Name{Space: "DAV:", Local: k},
-            InnerXML: []byte(propertiesMap[k]),
-		}
+		webdav.Log().Warn("cannot parse properties", "file", propertiesFile, "err", err)
 	}
-	return retval, nil
+	return propertiesMap
 }
 
-// TODO: figure out what needs to be serialized.  I don't think there
-// is any standard.
+// All of the patches are applied, and then persisted in one write,
+// so they either all succeed or all fail.
 func (f *DPFile) Patch(p []webdav.Proppatch) ([]webdav.Propstat, error) {
-	// Update the properties struct and return val
-	retval := make([]webdav.Propstat, 0)
-	current, err := f.DeadProps()
-	if err != nil {
-		return retval, nil
+	name := f.F.Name()
+	if strings.HasPrefix(path.Base(name), ".__") {
+		return nil, webdav.ErrNotAllowed
 	}
-	var writeVal map[string]string
-	for k := range current {
-		writeVal[k.Local] = string(current[k].InnerXML)
+	for i := range p {
+		for _, v := range p[i].Props {
+			if v.XMLName == OwnerProperty {
+				// it is changed with Chown
+				return ownerProtected(p), nil
+			}
+		}
 	}
+	propertiesFile := NameFor(name, "deadproperties.json")
+	writeVal := readProperties(propertiesFile)
+	pstat := webdav.Propstat{Status: 200}
 	for i := range p {
-		for j := range p[i].Props {
-			v := p[i].Props[j]
-			k := v.XMLName.Local
-			s := string(v.InnerXML)
-			retval[0].Props = append(retval[0].Props, webdav.Property{
-				XMLName:  xml.Name{Space: "DAV:", Local: k},
-				InnerXML: []byte(s),	
-			})
-			writeVal[k] = s
-			retval[0].Status = 200
+		for _, v := range p[i].Props {
+			k := PropKey(v.XMLName)
+			if p[i].Remove {
+				delete(writeVal, k)
+			} else {
+				writeVal[k] = string(v.InnerXML)
+			}
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: v.XMLName})
 		}
 	}
 	// Persist it back to disk as json
@@ -181,12 +301,14 @@ func (f *DPFile) Patch(p []webdav.Proppatch) ([]webdav.Propstat, error) {
 	if err != nil {
 		return nil, err
 	}
-	propertiesFile := NameFor(f.F.Name(), "deadproperties.json")
-	err = ioutil.WriteFile(propertiesFile, data, 0744)
+	err = ioutil.WriteFile(propertiesFile, data, 0644)
 	if err != nil {
 		return nil, err
 	}
-	return retval, nil
+	if f.FS.Tags != nil {
+		f.FS.Tags.Set(name, TagsOf(writeVal[PropKey(TagsProperty)]))
+	}
+	return []webdav.Propstat{pstat}, nil
 }
 
 // A FS implements FileSystem using the native file system restricted to a
@@ -195,6 +317,24 @@ type FS struct {
 	Root              string
 	Locks webdav.LockSystem
 	PermissionHandler func(ctx context.Context, action Action) map[string]interface{}
+	// Tags, if set, is kept up to date as tags are patched.
+	Tags *TagIndex
+	// Budget, if set, caps the files that are open at once.
+	Budget *webdav.FileBudget
+	// Stats, if set, caches what is stat'd on the volume.
+	Stats *StatCache
+	// Symlinks says what to do with symbolic links.  The default follows
+	// them, wherever they go.
+	Symlinks SymlinkPolicy
+	// Names says which names are the same name.  The default takes names
+	// as they are spelled, byte for byte.
+	Names Normalization
+	// Owner, if set, says who is making a request, to record as the
+	// owner of what it makes.
+	Owner func(ctx context.Context) string
+	// Trash, if set, is a directory outside of the volume, on the same
+	// file system, that what is deleted is moved into, as trash.go has it.
+	Trash string
 }
 
 //
@@ -205,16 +345,53 @@ type FS struct {
 //
 
 func (d FS) resolve(name string) string {
+	p, _ := d.lookup(name)
+	return p
+}
+
+// lookup is resolve, also saying whether name is a link that is exposed
+// as one.  A name that the Symlinks policy does not reach resolves to "".
+func (d FS) lookup(name string) (string, bool) {
 	// This implementation is based on FS.Open's code in the standard net/http package.
 	if filepath.Separator != '/' && strings.IndexRune(name, filepath.Separator) >= 0 ||
 		strings.Contains(name, "\x00") {
-		return ""
+		return "", false
 	}
 	dir := d.Root
 	if dir == "" {
 		dir = "."
 	}
-	return filepath.Join(dir, filepath.FromSlash(webdav.SlashClean(name)))
+	name = webdav.SlashClean(name)
+	if d.Names&NormalizeNFC != 0 {
+		name = norm.NFC(name)
+	}
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if d.Names&(NormalizeNFC|NormalizeCaseless) != 0 {
+		p = d.match(p)
+	}
+	link, err := d.checkLinks(p)
+	if err != nil {
+		return "", false
+	}
+	return p, link
+}
+
+// Resolve maps a slash separated name onto the volume, or "" if it cannot.
+func (d FS) Resolve(name string) string {
+	return d.resolve(name)
+}
+
+// VolumePath undoes Resolve, or returns "" if name is not on the volume.
+func (d FS) VolumePath(name string) string {
+	dir := d.Root
+	if dir == "" {
+		dir = "."
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return filepath.ToSlash(filepath.Clean("/" + rel))
 }
 
 // Convenience function for extracting a boolean permission once the calculation is done for the file in context
@@ -226,73 +403,204 @@ func (d FS) Allow(ctx context.Context, permissions map[string]interface{}, allow
 	return false
 }
 
+// The Banner that permission gives, if the requester may Stat what it is of
+func (d FS) banner(ctx context.Context, permission map[string]interface{}) string {
+	banner, _ := permission["Banner"].(string)
+	if !d.Allow(ctx, permission, AllowStat) {
+		return ""
+	}
+	return banner
+}
+
+// ErrNotAllowed, with the Banner of permission, so that the client is told what it ran into
+func (d FS) denied(ctx context.Context, permission map[string]interface{}) error {
+	if _, failed := permission[PolicyFailed]; failed {
+		return webdav.ErrPolicyUnavailable
+	}
+	if banner := d.banner(ctx, permission); banner != "" {
+		return &webdav.PolicyError{Banner: banner}
+	}
+	return webdav.ErrNotAllowed
+}
+
+// os.ErrNotExist, for what permission does not let the requester Stat, unless it could not be decided
+func (d FS) hidden(permission map[string]interface{}) error {
+	if _, failed := permission[PolicyFailed]; failed {
+		return webdav.ErrPolicyUnavailable
+	}
+	return os.ErrNotExist
+}
+
+// The flags of OpenFile that change a file
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
 func (d FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	// metadata, as .__security.rego is, is only written by the server, or
+	// anyone who can create a file could write the policy that guards it
+	if isMetadata(name) {
+		return webdav.ErrNotAllowed
+	}
 	if name = d.resolve(name); name == "" {
 		return os.ErrNotExist
 	}
-	permission := d.PermissionHandler(ctx, Action{Name: path.Base(name), Action: AllowCreate})
+	// as with a new file, ask the parent
+	permission := d.PermissionHandler(ctx, Action{Name: path.Dir(name), Action: AllowCreate})
 	if !d.Allow(ctx, permission, AllowCreate) {
-		return webdav.ErrNotAllowed
+		return d.denied(ctx, permission)
+	}
+	if _, err := os.Lstat(name); os.IsNotExist(err) && d.collides(name) {
+		return webdav.ErrNameCollision
+	}
+	defer d.Stats.Invalidate(name)
+	if err := os.Mkdir(name, perm); err != nil {
+		return err
 	}
-	return os.Mkdir(name, perm)
+	d.recordOwner(ctx, name)
+	return nil
 }
 
 func (d FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	if name = d.resolve(name); name == "" {
+	if flag&writeFlags != 0 && isMetadata(name) {
+		return nil, webdav.ErrNotAllowed
+	}
+	name, link := d.lookup(name)
+	if name == "" {
 		return nil, os.ErrNotExist
 	}
-	_, err := os.Stat(name)
+	if link {
+		return d.openLink(ctx, name, flag)
+	}
+	info, err := d.Stats.stat(name)
+	var decision map[string]interface{}
 	// on create, ask parent if we can modify it
 	if os.IsNotExist(err) {
 		permission := d.PermissionHandler(ctx, Action{Name: path.Dir(name), Action: AllowCreate})
 		if (flag&os.O_RDWR) != 0 && !d.Allow(ctx, permission, AllowCreate) {
-			return nil, webdav.ErrNotAllowed
+			return nil, d.denied(ctx, permission)
+		}
+		if flag&os.O_CREATE != 0 && d.collides(name) {
+			return nil, webdav.ErrNameCollision
 		}
 	} else {
 		// on update, ask file if it can be modified
 		permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowWrite})
 		if !d.Allow(ctx, permission, AllowStat) {
-			return nil, os.ErrNotExist
+			return nil, d.hidden(permission)
 		}
 		if (flag&os.O_RDWR) != 0 && !d.Allow(ctx, permission, AllowWrite) {
-			return nil, webdav.ErrNotAllowed
+			return nil, d.denied(ctx, permission)
 		}
+		decision = permission
+	}
+	release, err := d.Budget.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		defer d.Stats.Invalidate(name)
+	}
+	if info != nil && info.IsDir() {
+		// a directory is only written by patching its properties
+		flag &^= os.O_RDWR | os.O_WRONLY | os.O_TRUNC
 	}
 	f, err := os.OpenFile(name, flag, perm)
 	if err != nil {
+		release()
+		if errors.Is(err, syscall.EMFILE) {
+			return nil, webdav.ErrTooManyOpenFiles
+		}
 		return nil, err
 	}
-	return &DPFile{F: f, FS: d, Ctx: ctx}, nil
+	if info == nil && flag&os.O_CREATE != 0 {
+		d.recordOwner(ctx, name)
+	}
+	return &DPFile{F: f, FS: d, Ctx: ctx, Permission: decision, release: release}, nil
 }
 
 func (d FS) RemoveAll(ctx context.Context, name string) error {
+	if isMetadata(name) {
+		return webdav.ErrNotAllowed
+	}
 	if name = d.resolve(name); name == "" {
 		return os.ErrNotExist
 	}
 	permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowDelete})
 	if !d.Allow(ctx, permission, AllowStat) {
-		return os.ErrNotExist
+		return d.hidden(permission)
 	}
 	if !d.Allow(ctx, permission, AllowDelete) {
-		return webdav.ErrNotAllowed
+		return d.denied(ctx, permission)
 	}
 	if name == filepath.Clean(d.Root) {
 		// Prohibit removing the virtual root directory.
 		return os.ErrInvalid
 	}
+	defer d.Stats.InvalidateTree(name)
+	if info, err := os.Lstat(name); err == nil && info.Mode().IsRegular() {
+		d.unbind(name)
+	}
+	if d.Trash != "" {
+		return d.trash(ctx, name)
+	}
+	if p := webdav.ProgressOf(ctx); p != nil {
+		return d.removeAll(ctx, name, p)
+	}
 	return os.RemoveAll(name)
 }
 
+// removeAll is os.RemoveAll, for a job, counting what it removes as it goes.
+func (d FS) removeAll(ctx context.Context, name string, p *webdav.Progress) error {
+	info, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		dir, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			child := filepath.Join(name, n)
+			if err := d.removeAll(ctx, child, p); err != nil {
+				p.Fail(d.VolumePath(child), err)
+				return err
+			}
+		}
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !strings.HasPrefix(info.Name(), ".__") {
+		// metadata is not listed, so was not counted
+		p.Add(1)
+	}
+	return nil
+}
+
 func (d FS) Rename(ctx context.Context, oldName, newName string) error {
+	if isMetadata(oldName) || isMetadata(newName) {
+		return webdav.ErrNotAllowed
+	}
 	if oldName = d.resolve(oldName); oldName == "" {
 		return os.ErrNotExist
 	}
 	permission := d.PermissionHandler(ctx, Action{Name: oldName, Action: AllowRead})
 	if !d.Allow(ctx, permission, AllowStat) {
-		return os.ErrNotExist
+		return d.hidden(permission)
 	}
 	if !d.Allow(ctx, permission, AllowRead) {
-		return webdav.ErrNotAllowed
+		return d.denied(ctx, permission)
 	}
 
 	// if the name DOES exist, then rename is not allowed
@@ -302,24 +610,114 @@ func (d FS) Rename(ctx context.Context, oldName, newName string) error {
 
 	permission = d.PermissionHandler(ctx, Action{Name: newName, Action: AllowCreate})
 	if !d.Allow(ctx, permission, AllowWrite) {
-		return webdav.ErrNotAllowed
+		return d.denied(ctx, permission)
 	}
 
 	if root := filepath.Clean(d.Root); root == oldName || root == newName {
 		// Prohibit renaming from or to the virtual root directory.
 		return os.ErrInvalid
 	}
+	defer d.Stats.InvalidateTree(newName)
+	defer d.Stats.InvalidateTree(oldName)
 	return os.Rename(oldName, newName)
 }
 
+// Capabilities asks the policy once for what may be done with name, for OPTIONS.
+func (d FS) Capabilities(ctx context.Context, name string) (webdav.Capabilities, error) {
+	name, link := d.lookup(name)
+	if name == "" {
+		return webdav.Capabilities{}, os.ErrNotExist
+	}
+	if link {
+		// a link is only read and removed through WebDAV
+		permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowStat})
+		if !d.Allow(ctx, permission, AllowStat) {
+			return webdav.Capabilities{}, nil
+		}
+		return webdav.Capabilities{
+			Exists: true,
+			Read:   d.Allow(ctx, permission, AllowRead),
+			Delete: d.Allow(ctx, permission, AllowDelete),
+		}, nil
+	}
+	info, err := d.Stats.stat(name)
+	if os.IsNotExist(err) {
+		// on create, ask parent, as OpenFile does
+		permission := d.PermissionHandler(ctx, Action{Name: path.Dir(name), Action: AllowCreate})
+		if _, failed := permission[PolicyFailed]; failed {
+			return webdav.Capabilities{}, webdav.ErrPolicyUnavailable
+		}
+		return webdav.Capabilities{Create: d.Allow(ctx, permission, AllowCreate), Banner: d.banner(ctx, permission)}, nil
+	}
+	if err != nil {
+		return webdav.Capabilities{}, err
+	}
+	permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowStat})
+	if !d.Allow(ctx, permission, AllowStat) {
+		// it may as well not be there, but it cannot be made either
+		if err := d.hidden(permission); err != os.ErrNotExist {
+			return webdav.Capabilities{}, err
+		}
+		return webdav.Capabilities{}, nil
+	}
+	return webdav.Capabilities{
+		Exists: true,
+		Dir:    info.IsDir(),
+		Read:   d.Allow(ctx, permission, AllowRead),
+		Write:  d.Allow(ctx, permission, AllowWrite),
+		Create: info.IsDir() && d.Allow(ctx, permission, AllowCreate),
+		Delete: d.Allow(ctx, permission, AllowDelete),
+		Banner: d.banner(ctx, permission),
+	}, nil
+}
+
+/*
+  How a refusal of name is answered, as the Disclosure that its policy
+  gives says, "hide" or "forbid":
+
+    Disclosure = "hide"
+
+  What is not there goes by its directory, and is never forbidden.
+*/
+func (d FS) Disclosure(ctx context.Context, name string) webdav.Disclosure {
+	name, _ = d.lookup(name)
+	if name == "" {
+		return webdav.DiscloseDefault
+	}
+	_, err := os.Lstat(name)
+	exists := err == nil
+	of := name
+	if !exists {
+		of = path.Dir(name)
+	}
+	permission := d.PermissionHandler(ctx, Action{Name: of, Action: AllowStat})
+	switch permission["Disclosure"] {
+	case "hide":
+		return webdav.DiscloseHidden
+	case "forbid":
+		if exists {
+			return webdav.DiscloseForbidden
+		}
+	}
+	return webdav.DiscloseDefault
+}
+
 // Note that if we can't stat a file, we should tell the user that it does not exist.
 func (d FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	if name = d.resolve(name); name == "" {
+	name, link := d.lookup(name)
+	if name == "" {
 		return nil, os.ErrNotExist
 	}
 	permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowStat})
 	if !d.Allow(ctx, permission, AllowStat) {
-		return nil, os.ErrNotExist
+		return nil, d.hidden(permission)
+	}
+	if link {
+		fi, err := os.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+		return linkInfo{name: fi.Name(), modTime: fi.ModTime()}, nil
 	}
-	return os.Stat(name)
+	return d.Stats.stat(name)
 }
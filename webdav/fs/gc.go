@@ -0,0 +1,118 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  The kinds of attachments that NameFor produces.  A file f gets
+  the attachment .__f.<type>, and a directory gets .__<type> inside of it.
+*/
+var SidecarTypes = []string{
+	"deadproperties.json",
+	"security.rego",
+	"policy_tests.json",
+	"security_data.json",
+	"security.json",
+	"comments.json",
+	"defaultproperties.json",
+	"bindings.json",
+}
+
+/*
+  An Orphan is an attachment whose primary file is gone, usually
+  because somebody moved or deleted files directly on the volume.
+*/
+type Orphan struct {
+	Sidecar string `json:"sidecar"`
+	Primary string `json:"primary"`
+}
+
+// primaryOf returns the file that a sidecar is attached to, or "" if
+// name is not a sidecar of a file (directory attachments never orphan).
+func primaryOf(name string) string {
+	b := filepath.Base(name)
+	if !strings.HasPrefix(b, ".__") {
+		return ""
+	}
+	b = strings.TrimPrefix(b, ".__")
+	// Previous versions are kept as .__f.<type>.<n>
+	if i := strings.LastIndex(b, "."); i >= 0 {
+		if _, err := strconv.Atoi(b[i+1:]); err == nil {
+			b = b[:i]
+		}
+	}
+	for _, ftype := range SidecarTypes {
+		if strings.HasSuffix(b, "."+ftype) {
+			return filepath.Join(filepath.Dir(name), strings.TrimSuffix(b, "."+ftype))
+		}
+	}
+	return ""
+}
+
+// FindOrphans walks the whole volume looking for sidecars without a primary file.
+func (d FS) FindOrphans(ctx context.Context) ([]Orphan, error) {
+	root := d.Root
+	if root == "" {
+		root = "."
+	}
+	orphans := make([]Orphan, 0)
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+		primary := primaryOf(name)
+		if primary == "" {
+			return nil
+		}
+		if _, err := os.Lstat(primary); os.IsNotExist(err) {
+			orphans = append(orphans, Orphan{Sidecar: name, Primary: primary})
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// CollectOrphans finds orphans, and removes them unless this is a dry run.
+func (d FS) CollectOrphans(ctx context.Context, dryRun bool) ([]Orphan, error) {
+	orphans, err := d.FindOrphans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return orphans, nil
+	}
+	for _, o := range orphans {
+		if err := os.Remove(o.Sidecar); err != nil && !os.IsNotExist(err) {
+			return orphans, err
+		}
+		webdav.Log().Info("removed orphaned sidecar", "file", o.Sidecar)
+	}
+	return orphans, nil
+}
+
+// OrphanGCJob removes orphaned sidecars in the background.
+func OrphanGCJob(d FS, interval time.Duration) Job {
+	return Job{
+		Name:     "sidecars",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			_, err := d.CollectOrphans(ctx, false)
+			return err
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A Job is a periodic maintenance task, such as cleaning up
+  orphaned sidecar files or expiring locks.
+*/
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Jitter is the maximum random delay added to each interval, so that
+	// many instances over the same volume don't all scan at once.
+	Jitter  time.Duration
+	Enabled bool
+	Run     func(ctx context.Context) error
+}
+
+/*
+  JobStats are the metrics kept for each job.
+*/
+type JobStats struct {
+	Runs         int64         `json:"runs"`
+	Failures     int64         `json:"failures"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError,omitempty"`
+}
+
+/*
+  A Scheduler runs the enabled jobs in the background
+  until its context is cancelled.
+*/
+type Scheduler struct {
+	mu    sync.Mutex
+	jobs  map[string]Job
+	stats map[string]*JobStats
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		jobs:  make(map[string]Job),
+		stats: make(map[string]*JobStats),
+	}
+}
+
+// Add registers a job, replacing any previous job of the same name.
+func (s *Scheduler) Add(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.Name] = job
+	if s.stats[job.Name] == nil {
+		s.stats[job.Name] = &JobStats{}
+	}
+}
+
+// Enable turns a registered job on or off. It must be called before Start.
+func (s *Scheduler) Enable(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("no such job: %s", name)
+	}
+	job.Enabled = enabled
+	s.jobs[name] = job
+	return nil
+}
+
+// Names lists the registered jobs in sorted order.
+func (s *Scheduler) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start launches a goroutine for every enabled job.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if !job.Enabled || job.Interval <= 0 || job.Run == nil {
+			continue
+		}
+		webdav.Log().Info("scheduling job", "job", job.Name, "every", job.Interval)
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	for {
+		delay := job.Interval
+		if job.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(job.Jitter)))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		s.run(ctx, job)
+	}
+}
+
+// RunNow runs a registered job once, whether or not it is enabled.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such job: %s", name)
+	}
+	return s.run(ctx, job)
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) error {
+	start := time.Now()
+	err := job.Run(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats[job.Name]
+	stats.Runs++
+	stats.LastRun = start
+	stats.LastDuration = time.Since(start)
+	stats.LastError = ""
+	if err != nil {
+		stats.Failures++
+		stats.LastError = err.Error()
+		webdav.Log().Warn("job failed", "job", job.Name, "err", err)
+	}
+	return err
+}
+
+// Stats returns a snapshot of the metrics for every registered job.
+func (s *Scheduler) Stats() map[string]JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retval := make(map[string]JobStats, len(s.stats))
+	for name, stats := range s.stats {
+		retval[name] = *stats
+	}
+	return retval
+}
+
+/*
+  A LockSystem that can drop its expired locks without
+  waiting for the next lock request to come in.
+*/
+type LockCollector interface {
+	CollectExpired(now time.Time)
+}
+
+// LockGCJob expires stale locks in the background.
+func LockGCJob(ls LockCollector, interval time.Duration) Job {
+	return Job{
+		Name:     "locks",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			ls.CollectExpired(time.Now())
+			return nil
+		},
+	}
+}
@@ -6,6 +6,7 @@ package fs
 
 import (
 	"container/heap"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -47,6 +48,14 @@ func (m *memLS) collectExpiredNodes(now time.Time) {
 	}
 }
 
+// CollectExpired removes expired locks, so that an idle server does not
+// hold onto them until the next lock request.
+func (m *memLS) CollectExpired(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectExpiredNodes(now)
+}
+
 func (m *memLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -191,6 +200,29 @@ func (m *memLS) Unlock(now time.Time, token string) error {
 	return nil
 }
 
+// ListLocks lists the locks with a root at or under prefix.
+func (m *memLS) ListLocks(now time.Time, prefix string) ([]webdav.ActiveLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectExpiredNodes(now)
+
+	prefix = webdav.SlashClean(prefix)
+	locks := make([]webdav.ActiveLock, 0)
+	for token, n := range m.byToken {
+		root := n.details.Root
+		if prefix != "/" && root != prefix && !strings.HasPrefix(root, prefix+"/") {
+			continue
+		}
+		l := webdav.ActiveLock{LockDetails: n.details, Token: token}
+		if n.details.Duration >= 0 {
+			l.Expiry = n.expiry
+		}
+		locks = append(locks, l)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Root < locks[j].Root })
+	return locks, nil
+}
+
 func (m *memLS) canCreate(name string, zeroDepth bool) bool {
 	return walkToRoot(name, func(name0 string, first bool) bool {
 		n := m.byName[name0]
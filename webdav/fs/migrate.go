@@ -0,0 +1,246 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A volume that was served by another WebDAV server, such as one
+  built on x/net/webdav, can be served by this one as it is, except
+  for the properties that the other server kept its own way.  These
+  are imported from sidecar files or extended attributes into the
+  .__deadproperties.json that this package keeps them in.
+
+  A sidecar is a file named after the one it has properties for,
+  with a suffix, as report.pdf.props beside report.pdf.  It is either
+  the json of a map from property names to text
+
+    { "{urn:x}color": "red", "author": "rob" }
+
+  or xml that has the properties as children of the root, as the
+  prop of a PROPPATCH would
+
+    <D:prop xmlns:D="DAV:"><x:color xmlns:x="urn:x">red</x:color></D:prop>
+
+  Extended attributes in the user namespace are imported as
+  properties too, by their name without "user.", in a namespace of
+  their own unless they are named as {namespace}local already.
+*/
+type MigrateOptions struct {
+	// The suffix of sidecar files, or "" for none
+	Sidecar string
+	// Whether to import extended attributes, and the namespace to put them in
+	Xattrs         bool
+	XattrNamespace string
+	// Whether properties that are set already are replaced
+	Overwrite bool
+	// Whether to only report what would be imported
+	DryRun bool
+}
+
+// MigratedFile is what was imported for one file, by property name.
+type MigratedFile struct {
+	Path     string   `json:"path"`
+	Imported []string `json:"imported,omitempty"`
+	Skipped  []string `json:"skipped,omitempty"`
+}
+
+// ImportProperties walks the whole volume, importing the properties that
+// opts says where to find.  Sidecars are left where they are, to be removed
+// once the import has been checked.
+func (d FS) ImportProperties(ctx context.Context, opts MigrateOptions) ([]MigratedFile, error) {
+	root := d.Root
+	if root == "" {
+		root = "."
+	}
+	root = filepath.Clean(root)
+	migrated := make([]MigratedFile, 0)
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".__") && name != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if opts.Sidecar != "" && strings.HasSuffix(name, opts.Sidecar) {
+			if _, err := os.Lstat(strings.TrimSuffix(name, opts.Sidecar)); err == nil {
+				return nil
+			}
+		}
+		props := make(map[xml.Name]string)
+		var skipped []string
+		if opts.Sidecar != "" && name != root {
+			found, err := readSidecar(name + opts.Sidecar)
+			if err != nil {
+				return err
+			}
+			for k, v := range found {
+				props[k] = v
+			}
+		}
+		if opts.Xattrs {
+			found, bad, err := readXattrs(name, opts.XattrNamespace)
+			if err != nil {
+				return err
+			}
+			for k, v := range found {
+				props[k] = v
+			}
+			skipped = append(skipped, bad...)
+		}
+		if len(props) == 0 && len(skipped) == 0 {
+			return nil
+		}
+		m, err := importProperties(name, props, opts)
+		if err != nil {
+			return err
+		}
+		m.Skipped = append(m.Skipped, skipped...)
+		m.Path = "/" + filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(name, root), string(filepath.Separator)))
+		migrated = append(migrated, m)
+		return nil
+	})
+	return migrated, err
+}
+
+// Merge props into the dead properties of name.
+func importProperties(name string, props map[xml.Name]string, opts MigrateOptions) (MigratedFile, error) {
+	var m MigratedFile
+	propertiesFile := NameFor(name, "deadproperties.json")
+	current := readProperties(propertiesFile)
+	keys := make([]string, 0, len(props))
+	values := make(map[string]string)
+	for pname, v := range props {
+		k := PropKey(pname)
+		keys = append(keys, k)
+		values[k] = v
+	}
+	sort.Strings(keys)
+	changed := false
+	for _, k := range keys {
+		old, set := current[k]
+		switch {
+		case !validLocalName(PropName(k).Local):
+			m.Skipped = append(m.Skipped, k+": not a property name")
+		case webdav.IsLiveProperty(PropName(k)):
+			// computed by the server, so there is nothing to keep
+			m.Skipped = append(m.Skipped, k+": live property")
+		case set && old == values[k]:
+			// imported already
+		case set && !opts.Overwrite:
+			m.Skipped = append(m.Skipped, k+": already set")
+		default:
+			current[k] = values[k]
+			m.Imported = append(m.Imported, k)
+			changed = true
+		}
+	}
+	if !changed || opts.DryRun {
+		return m, nil
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	return m, ioutil.WriteFile(propertiesFile, data, 0644)
+}
+
+// The properties in a sidecar, as inner xml, or none if there is no sidecar.
+func readSidecar(sidecar string) (map[xml.Name]string, error) {
+	data, err := ioutil.ReadFile(sidecar)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[xml.Name]string)
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		var root struct {
+			Props []struct {
+				XMLName  xml.Name
+				InnerXML string `xml:",innerxml"`
+			} `xml:",any"`
+		}
+		if err := xml.Unmarshal(data, &root); err != nil {
+			return nil, &os.PathError{Op: "parse", Path: sidecar, Err: err}
+		}
+		for _, p := range root.Props {
+			props[p.XMLName] = p.InnerXML
+		}
+		return props, nil
+	}
+	var text map[string]string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return nil, &os.PathError{Op: "parse", Path: sidecar, Err: err}
+	}
+	for k, v := range text {
+		props[PropName(k)] = escapeText(v)
+	}
+	return props, nil
+}
+
+/*
+  The extended attributes of name in the user namespace, as
+  properties.  Those that are not text are skipped.
+*/
+func readXattrs(name, namespace string) (map[xml.Name]string, []string, error) {
+	attrs, err := listXattrs(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	props := make(map[xml.Name]string)
+	var skipped []string
+	for attr, value := range attrs {
+		if !strings.HasPrefix(attr, "user.") {
+			continue
+		}
+		key := strings.TrimPrefix(attr, "user.")
+		pname := xml.Name{Space: namespace, Local: key}
+		if strings.HasPrefix(key, "{") {
+			pname = PropName(key)
+		}
+		if !utf8.Valid(value) {
+			skipped = append(skipped, attr+": not text")
+			continue
+		}
+		props[pname] = escapeText(string(value))
+	}
+	sort.Strings(skipped)
+	return props, skipped, nil
+}
+
+func escapeText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Whether s can be the local name of an xml element.
+func validLocalName(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n<>&\"'/=:{}") {
+		return false
+	}
+	return !strings.ContainsAny(s[:1], "-.0123456789")
+}
@@ -0,0 +1,212 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav/internal/norm"
+)
+
+/*
+  A Normalization says which names an FS takes to be the same name, so
+  that one file is not made twice under names that look alike: macOS
+  clients send names decomposed (NFD), where Windows and Linux ones send
+  them composed (NFC), and Windows clients take names that differ only
+  in case to be one name.
+*/
+type Normalization int
+
+const (
+	// NormalizeNFC makes new names in NFC, and finds names that are on
+	// the volume in another form by their NFC
+	NormalizeNFC Normalization = 1 << iota
+	// NormalizeFold does not make a name beside one that differs from it
+	// only in case
+	NormalizeFold
+	// NormalizeCaseless finds names on the volume in whatever case they
+	// are asked for, as a Windows share does
+	NormalizeCaseless
+)
+
+func (n Normalization) String() string {
+	var names []string
+	if n&NormalizeNFC != 0 {
+		names = append(names, "nfc")
+	}
+	if n&NormalizeFold != 0 {
+		names = append(names, "fold")
+	}
+	if n&NormalizeCaseless != 0 {
+		names = append(names, "caseless")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseNormalization reads none, or nfc, fold and caseless separated by
+// commas.  caseless is fold too, as a name found in any case can only
+// be made once.
+func ParseNormalization(s string) (Normalization, error) {
+	var n Normalization
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "none":
+		case "nfc":
+			n |= NormalizeNFC
+		case "fold":
+			n |= NormalizeFold
+		case "caseless":
+			n |= NormalizeCaseless | NormalizeFold
+		default:
+			return 0, fmt.Errorf("unknown normalization: %s", name)
+		}
+	}
+	return n, nil
+}
+
+// What a name is compared by: names with the same key are the same name.
+func (n Normalization) key(name string) string {
+	if n&NormalizeNFC != 0 {
+		name = norm.NFC(name)
+	}
+	if n&(NormalizeFold|NormalizeCaseless) != 0 {
+		name = norm.Fold(name)
+	}
+	return name
+}
+
+// What a name is looked up by, when it is not there as it is spelled
+func (n Normalization) lookupKey(name string) string {
+	if n&NormalizeNFC != 0 {
+		name = norm.NFC(name)
+	}
+	if n&NormalizeCaseless != 0 {
+		name = norm.Fold(name)
+	}
+	return name
+}
+
+/*
+  Where p, a path on the volume that is not there as it is spelled,
+  is there in another form, or in another case.  Each part of it that
+  is not there is matched to the one entry of its directory with the
+  same lookup key.  The parts after one with no match are kept as they
+  are, for a name that is being made.
+*/
+func (d FS) match(p string) string {
+	if _, err := os.Lstat(p); err == nil {
+		return p
+	}
+	root := d.rootDir()
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." {
+		return p
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	dir := root
+	for i, part := range parts {
+		if _, err := os.Lstat(filepath.Join(dir, part)); err == nil {
+			dir = filepath.Join(dir, part)
+			continue
+		}
+		match := ""
+		key := d.Names.lookupKey(part)
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, e := range entries {
+				if e.Name() != part && d.Names.lookupKey(e.Name()) == key {
+					if match != "" {
+						// more than one is as good as none
+						match = ""
+						break
+					}
+					match = e.Name()
+				}
+			}
+		}
+		if match == "" {
+			return filepath.Join(append([]string{dir}, parts[i:]...)...)
+		}
+		dir = filepath.Join(dir, match)
+	}
+	return dir
+}
+
+/*
+  Whether p, a path on the volume that is about to be made, would be
+  the same name under the Names normalization as another entry in its
+  directory.
+*/
+func (d FS) collides(p string) bool {
+	if d.Names == 0 {
+		return false
+	}
+	base := filepath.Base(p)
+	key := d.Names.key(base)
+	entries, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Name() != base && d.Names.key(e.Name()) == key {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  Collisions finds names under name that are already on the volume and
+  that the Names normalization takes to be the same, such as files
+  copied on with another client, or before it was set.  Each set of them
+  is listed as slash separated names, and only one of each set can be
+  reached by its normalized name until the others are renamed.
+*/
+func (d FS) Collisions(ctx context.Context, name string) ([][]string, error) {
+	full := d.resolve(name)
+	if full == "" {
+		return nil, os.ErrNotExist
+	}
+	var collisions [][]string
+	err := filepath.Walk(full, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		byKey := make(map[string][]string)
+		var keys []string
+		for _, e := range entries {
+			// metadata goes by the name of its file, so collides with it
+			if strings.HasPrefix(e.Name(), ".__") {
+				continue
+			}
+			k := d.Names.key(e.Name())
+			if byKey[k] == nil {
+				keys = append(keys, k)
+			}
+			byKey[k] = append(byKey[k], d.VolumePath(filepath.Join(p, e.Name())))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if len(byKey[k]) > 1 {
+				collisions = append(collisions, byKey[k])
+			}
+		}
+		return nil
+	})
+	return collisions, err
+}
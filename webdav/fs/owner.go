@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  The owner of a resource is the user who made it, kept as a dead
+  property that PROPPATCH may not change, and that is not copied with
+  the rest:
+
+    <w:owner xmlns:w="https://github.com/rfielding/webdev/">rob</w:owner>
+
+  It is not listed with the other dead properties, as a server shows it
+  as DAV:owner, in whatever form its principals take.
+*/
+var OwnerProperty = xml.Name{Space: "https://github.com/rfielding/webdev/", Local: "owner"}
+
+// OwnerOf is who owns name, a path on the volume, or "" if nobody is
+// recorded as owning it.
+func OwnerOf(name string) string {
+	v, ok := OwnProperties(name)[PropKey(OwnerProperty)]
+	if !ok {
+		return ""
+	}
+	var text struct {
+		Text string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte("<t>"+v+"</t>"), &text); err != nil {
+		return ""
+	}
+	return text.Text
+}
+
+// SetOwner records owner as the owner of name, a path on the volume, or
+// that nobody owns it if owner is "".
+func SetOwner(name, owner string) error {
+	propertiesFile := NameFor(name, "deadproperties.json")
+	if propertiesFile == "" {
+		return os.ErrNotExist
+	}
+	props := readProperties(propertiesFile)
+	if owner == "" {
+		if _, ok := props[PropKey(OwnerProperty)]; !ok {
+			return nil
+		}
+		delete(props, PropKey(OwnerProperty))
+	} else {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(owner))
+		props[PropKey(OwnerProperty)] = b.String()
+	}
+	data, err := json.MarshalIndent(props, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(propertiesFile, data, 0644)
+}
+
+// Record the user of ctx as the owner of name, a path on the volume that
+// they just made.
+func (d FS) recordOwner(ctx context.Context, name string) {
+	if d.Owner == nil || strings.HasPrefix(filepath.Base(name), ".__") {
+		return
+	}
+	owner := d.Owner(ctx)
+	if owner == "" {
+		return
+	}
+	if err := SetOwner(name, owner); err != nil {
+		// it is still made, but by nobody in particular
+		webdav.Log().Warn("cannot record owner", "name", name, "owner", owner, "err", err)
+	}
+}
+
+/*
+  Chown gives what is at name, and with recursive, everything under it,
+  to owner.  With from, only what from owns is given away, as when a
+  user leaves and someone else takes over their files.  It returns how
+  many it changed, and stops at the first one it cannot.
+*/
+func (d FS) Chown(ctx context.Context, name, from, owner string, recursive bool) (int, error) {
+	full := d.resolve(name)
+	if full == "" {
+		return 0, os.ErrNotExist
+	}
+	if _, err := os.Stat(full); err != nil {
+		return 0, err
+	}
+	changed := 0
+	chown := func(p string) error {
+		if from != "" && OwnerOf(p) != from {
+			return nil
+		}
+		if err := SetOwner(p, owner); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	}
+	if !recursive {
+		return changed, chown(full)
+	}
+	err := filepath.Walk(full, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if strings.HasPrefix(info.Name(), ".__") {
+			return nil
+		}
+		return chown(p)
+	})
+	return changed, err
+}
+
+// What a PROPPATCH of the owner is answered with: it is protected, and
+// the rest fail with it.
+func ownerProtected(p []webdav.Proppatch) []webdav.Propstat {
+	forbidden := webdav.Propstat{Status: 403, XMLError: `<D:cannot-modify-protected-property xmlns:D="DAV:"/>`}
+	failed := webdav.Propstat{Status: webdav.StatusFailedDependency}
+	for _, patch := range p {
+		for _, v := range patch.Props {
+			if v.XMLName == OwnerProperty {
+				forbidden.Props = append(forbidden.Props, webdav.Property{XMLName: v.XMLName})
+			} else {
+				failed.Props = append(failed.Props, webdav.Property{XMLName: v.XMLName})
+			}
+		}
+	}
+	if len(failed.Props) == 0 {
+		return []webdav.Propstat{forbidden}
+	}
+	return []webdav.Propstat{forbidden, failed}
+}
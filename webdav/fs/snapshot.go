@@ -0,0 +1,230 @@
+package fs
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The pax records at the start of a snapshot that say what it is of
+const (
+	SnapshotPathRecord = "WEBDEV.path"
+	SnapshotTimeRecord = "WEBDEV.time"
+)
+
+/*
+  SnapshotInfo describes a snapshot: the directory it was taken of,
+  when, and how much went into it.
+*/
+type SnapshotInfo struct {
+	Path  string    `json:"path"`
+	Time  time.Time `json:"time"`
+	Files int64     `json:"files"`
+	Dirs  int64     `json:"dirs"`
+	Bytes int64     `json:"bytes"`
+}
+
+// Snapshot writes the directory name to w as a tar, with the metadata that
+// goes with it, such as dead properties, claims and policies.  Names in the
+// tar are relative to name.  Locks are not part of the volume, so are left
+// out, as are symlinks and anything else that is not a file or directory.
+// Nothing stops the volume changing meanwhile, so a caller that needs the
+// snapshot to be consistent must hold off writers.
+func (d FS) Snapshot(ctx context.Context, name string, w io.Writer) (SnapshotInfo, error) {
+	info := SnapshotInfo{Path: name, Time: time.Now().UTC()}
+	full := d.resolve(name)
+	if full == "" {
+		return info, os.ErrNotExist
+	}
+	fi, err := os.Stat(full)
+	if err != nil {
+		return info, err
+	}
+	if !fi.IsDir() {
+		return info, fmt.Errorf("%s is not a directory", name)
+	}
+	tw := tar.NewWriter(w)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{
+			SnapshotPathRecord: name,
+			SnapshotTimeRecord: info.Time.Format(time.RFC3339Nano),
+		},
+		Format: tar.FormatPAX,
+	})
+	if err != nil {
+		return info, err
+	}
+	err = filepath.Walk(full, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// it went away while we were walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(full, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.Format = tar.FormatPAX
+		if fi.IsDir() {
+			hdr.Name += "/"
+			info.Dirs++
+			return tw.WriteHeader(hdr)
+		}
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		n, err := io.CopyN(tw, f, hdr.Size)
+		info.Files++
+		info.Bytes += n
+		if err == io.EOF {
+			return fmt.Errorf("%s shrank while it was being read", hdr.Name)
+		}
+		return err
+	})
+	if err != nil {
+		return info, err
+	}
+	return info, tw.Close()
+}
+
+// ReadSnapshotInfo reads what a snapshot is of from the start of its tar.
+func ReadSnapshotInfo(r io.Reader) (SnapshotInfo, error) {
+	hdr, err := tar.NewReader(r).Next()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return snapshotInfoOf(hdr)
+}
+
+func snapshotInfoOf(hdr *tar.Header) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	if hdr.Typeflag != tar.TypeXGlobalHeader {
+		return info, fmt.Errorf("not a snapshot")
+	}
+	info.Path = hdr.PAXRecords[SnapshotPathRecord]
+	info.Time, _ = time.Parse(time.RFC3339Nano, hdr.PAXRecords[SnapshotTimeRecord])
+	return info, nil
+}
+
+// Restore unpacks a snapshot into the directory dir, which must not exist
+// yet, so that nothing is written over.  dir may be on the volume, or the
+// root of a new one.  Names that would land outside of dir are refused.
+func Restore(ctx context.Context, r io.Reader, dir string) (SnapshotInfo, error) {
+	return RestoreSubtree(ctx, r, "/", dir)
+}
+
+// RestoreSubtree is Restore of only what is under sub, a slash separated
+// path within the snapshot.  The counts are of what was unpacked.
+func RestoreSubtree(ctx context.Context, r io.Reader, sub, dir string) (SnapshotInfo, error) {
+	sub = path.Clean("/" + sub)
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	info, err := snapshotInfoOf(hdr)
+	if err != nil {
+		return info, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return info, err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return info, err
+	}
+	type dirTime struct {
+		name string
+		t    time.Time
+	}
+	var dirs []dirTime
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+		if err := ctx.Err(); err != nil {
+			return info, err
+		}
+		clean := path.Clean("/" + hdr.Name)
+		if clean == "/" || clean != "/"+strings.TrimSuffix(hdr.Name, "/") {
+			return info, fmt.Errorf("bad name in snapshot: %q", hdr.Name)
+		}
+		if sub != "/" {
+			if !strings.HasPrefix(clean, sub+"/") {
+				continue
+			}
+			clean = strings.TrimPrefix(clean, sub)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(clean))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return info, err
+			}
+			dirs = append(dirs, dirTime{target, hdr.ModTime})
+			info.Dirs++
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return info, err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm()|0600)
+			if err != nil {
+				return info, err
+			}
+			n, err := io.Copy(f, tr)
+			closeErr := f.Close()
+			if err != nil {
+				return info, err
+			}
+			if closeErr != nil {
+				return info, closeErr
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			info.Files++
+			info.Bytes += n
+		default:
+			return info, fmt.Errorf("%s in snapshot is of type %s", hdr.Name, strconv.QuoteRune(rune(hdr.Typeflag)))
+		}
+	}
+	// last, since making what is in them changes their times
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i].name, dirs[i].t, dirs[i].t)
+	}
+	return info, nil
+}
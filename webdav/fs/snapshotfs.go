@@ -0,0 +1,344 @@
+package fs
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+var _ webdav.FileSystem = &SnapshotFS{}
+var _ webdav.ReadCheckedFile = &snapshotFile{}
+
+/*
+  SnapshotFS serves what is in a snapshot, as FS.Snapshot wrote it,
+  as a FileSystem that cannot be changed.  The tar is indexed once,
+  and files are read from where they are in it.
+
+  Permission is asked of Live about where each name is on it now,
+  so that the snapshot shows nobody what the policy would not show
+  them today, even of what has since been removed.  The metadata
+  that goes with files is not listed, but serves as their dead
+  properties.
+*/
+type SnapshotFS struct {
+	Info    SnapshotInfo
+	Live    FS
+	r       io.ReaderAt
+	entries map[string]snapshotEntry
+	// what is in each directory, by name
+	children map[string][]string
+}
+
+type snapshotEntry struct {
+	hdr    *tar.Header
+	offset int64
+}
+
+// countingReader lets tar skip over what it does not read, while keeping
+// track of where it is.
+type countingReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.r.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+// OpenSnapshot indexes the snapshot in f, which must stay open for as long
+// as the SnapshotFS is used.
+func OpenSnapshot(f *os.File, live FS) (*SnapshotFS, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	info, err := snapshotInfoOf(hdr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SnapshotFS{
+		Info:     info,
+		Live:     live,
+		r:        f,
+		entries:  make(map[string]snapshotEntry),
+		children: make(map[string][]string),
+	}
+	s.entries["/"] = snapshotEntry{hdr: &tar.Header{
+		Name:     "./",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  info.Time,
+	}}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		clean := path.Clean("/" + hdr.Name)
+		if clean == "/" || clean != "/"+strings.TrimSuffix(hdr.Name, "/") {
+			return nil, fmt.Errorf("bad name in snapshot: %q", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		s.entries[clean] = snapshotEntry{hdr: hdr, offset: cr.pos}
+		s.children[path.Dir(clean)] = append(s.children[path.Dir(clean)], path.Base(clean))
+	}
+	for _, names := range s.children {
+		sort.Strings(names)
+	}
+	return s, nil
+}
+
+func isMetadata(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".__") {
+			return true
+		}
+	}
+	return false
+}
+
+// The entry for name, if it is there and the user of ctx may see it.
+func (s *SnapshotFS) lookup(ctx context.Context, name string) (snapshotEntry, map[string]interface{}, error) {
+	name = webdav.SlashClean(name)
+	e, ok := s.entries[name]
+	if !ok || isMetadata(name) {
+		return e, nil, os.ErrNotExist
+	}
+	permission := s.Live.PermissionHandler(ctx, Action{Name: s.live(name), Action: AllowStat})
+	if !s.Live.Allow(ctx, permission, AllowStat) {
+		return e, nil, s.Live.hidden(permission)
+	}
+	return e, permission, nil
+}
+
+// LiveName is where name in the snapshot would be on the live volume.
+func (s *SnapshotFS) LiveName(name string) string {
+	return path.Join(s.Info.Path, webdav.SlashClean(name))
+}
+
+func (s *SnapshotFS) live(name string) string {
+	return s.Live.resolve(s.LiveName(name))
+}
+
+func (s *SnapshotFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return webdav.ErrNotAllowed
+}
+
+func (s *SnapshotFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, webdav.ErrNotAllowed
+	}
+	e, permission, err := s.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f := &snapshotFile{
+		fs:         s,
+		ctx:        ctx,
+		name:       webdav.SlashClean(name),
+		entry:      e,
+		permission: permission,
+	}
+	if e.hdr.Typeflag == tar.TypeReg {
+		f.content = io.NewSectionReader(s.r, e.offset, e.hdr.Size)
+	}
+	return f, nil
+}
+
+func (s *SnapshotFS) RemoveAll(ctx context.Context, name string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (s *SnapshotFS) Rename(ctx context.Context, oldName, newName string) error {
+	return webdav.ErrNotAllowed
+}
+
+func (s *SnapshotFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, _, err := s.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.hdr.FileInfo(), nil
+}
+
+// Capabilities are only ever to read, or to see.
+func (s *SnapshotFS) Capabilities(ctx context.Context, name string) (webdav.Capabilities, error) {
+	e, permission, err := s.lookup(ctx, name)
+	if err != nil {
+		return webdav.Capabilities{}, nil
+	}
+	return webdav.Capabilities{
+		Exists: true,
+		Dir:    e.hdr.Typeflag == tar.TypeDir,
+		Read:   s.Live.Allow(ctx, permission, AllowRead),
+	}, nil
+}
+
+// The content of a metadata file in the snapshot, if it is there.
+func (s *SnapshotFS) readProperties(name string) map[string]string {
+	props := make(map[string]string)
+	e, ok := s.entries[name]
+	if !ok || e.hdr.Typeflag != tar.TypeReg {
+		return props
+	}
+	data := make([]byte, e.hdr.Size)
+	if _, err := s.r.ReadAt(data, e.offset); err != nil && err != io.EOF {
+		webdav.Log().Warn("cannot read properties", "snapshot", s.Info.Path, "file", name, "err", err)
+		return props
+	}
+	if err := json.Unmarshal(data, &props); err != nil {
+		webdav.Log().Warn("cannot parse properties", "snapshot", s.Info.Path, "file", name, "err", err)
+	}
+	return props
+}
+
+// The dead properties that name had, over what it inherited, as with EffectiveProperties.
+func (s *SnapshotFS) properties(name string) map[string]string {
+	props := make(map[string]string)
+	for d := name; d != "/"; {
+		d = path.Dir(d)
+		for k, v := range s.readProperties(path.Join(d, ".__defaultproperties.json")) {
+			if _, ok := props[k]; !ok {
+				props[k] = v
+			}
+		}
+	}
+	own := path.Join(path.Dir(name), ".__"+path.Base(name)+".deadproperties.json")
+	if e := s.entries[name]; e.hdr.Typeflag == tar.TypeDir {
+		own = path.Join(name, ".__deadproperties.json")
+	}
+	for k, v := range s.readProperties(own) {
+		props[k] = v
+	}
+	return props
+}
+
+/*
+  A file or directory in a snapshot, which can be read but
+  not written.
+*/
+type snapshotFile struct {
+	fs         *SnapshotFS
+	ctx        context.Context
+	name       string
+	entry      snapshotEntry
+	permission map[string]interface{}
+	content    *io.SectionReader
+	dirPos     int
+	readable   *bool
+}
+
+func (f *snapshotFile) CanRead() bool {
+	if f.readable == nil {
+		permission := f.fs.Live.PermissionHandler(f.ctx, Action{Name: f.fs.live(f.name), Action: AllowRead})
+		readable := f.fs.Live.Allow(f.ctx, permission, AllowRead)
+		f.readable = &readable
+	}
+	return *f.readable
+}
+
+func (f *snapshotFile) Obligations() webdav.Obligations {
+	obligations, _ := f.permission["Obligations"].(map[string]interface{})
+	return obligations
+}
+
+func (f *snapshotFile) Read(b []byte) (int, error) {
+	if f.content == nil {
+		return 0, fmt.Errorf("%s is a directory", f.name)
+	}
+	if !f.CanRead() {
+		return 0, webdav.ErrNotAllowed
+	}
+	return f.content.Read(b)
+}
+
+func (f *snapshotFile) Seek(offset int64, whence int) (int64, error) {
+	if f.content == nil {
+		return 0, fmt.Errorf("%s is a directory", f.name)
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *snapshotFile) Close() error {
+	return nil
+}
+
+func (f *snapshotFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.content != nil {
+		return nil, fmt.Errorf("%s is not a directory", f.name)
+	}
+	var result []fs.FileInfo
+	names := f.fs.children[f.name]
+	for f.dirPos < len(names) && (count <= 0 || len(result) < count) {
+		fi, err := f.fs.Stat(f.ctx, path.Join(f.name, names[f.dirPos]))
+		f.dirPos++
+		if err == nil {
+			result = append(result, fi)
+		}
+	}
+	if count > 0 && len(result) == 0 {
+		return nil, io.EOF
+	}
+	return result, nil
+}
+
+func (f *snapshotFile) Stat() (fs.FileInfo, error) {
+	return f.entry.hdr.FileInfo(), nil
+}
+
+func (f *snapshotFile) Write(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (f *snapshotFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	retval := make(map[xml.Name]webdav.Property)
+	for k, v := range f.fs.properties(f.name) {
+		pname := PropName(k)
+		retval[pname] = webdav.Property{
+			XMLName:  pname,
+			InnerXML: []byte(v),
+		}
+	}
+	return retval, nil
+}
+
+func (f *snapshotFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
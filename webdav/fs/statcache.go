@@ -0,0 +1,132 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  StatCache keeps what os.Stat said about paths on the volume for a
+  while, since a sync client's PROPFIND and the GETs after it stat
+  the same paths many times a second.  Only the stat is cached; the
+  policy is still asked on every Stat, since it differs by user.
+
+  What an FS changes is dropped from the cache as it changes it, and
+  Watch drops what other processes change.  TTL bounds how stale an
+  entry can be when neither sees a change, such as on a network file
+  system, or when there are more directories than the kernel will
+  watch.
+*/
+type StatCache struct {
+	// How long an entry is used for.  Default is a second.
+	TTL time.Duration
+	// The most entries to keep.  Default is 100000.
+	Max int
+
+	mu      sync.Mutex
+	entries map[string]statEntry
+	// counts invalidations, so that a stat that raced one is not kept
+	gen uint64
+}
+
+type statEntry struct {
+	info os.FileInfo
+	at   time.Time
+}
+
+// NewStatCache makes a StatCache with entries that last ttl.
+func NewStatCache(ttl time.Duration, max int) *StatCache {
+	return &StatCache{TTL: ttl, Max: max, entries: make(map[string]statEntry)}
+}
+
+// stat is os.Stat of name, which is a path on the volume, from the cache if
+// it is there.  A nil cache stats every time.
+func (c *StatCache) stat(name string) (os.FileInfo, error) {
+	if c == nil {
+		return os.Stat(name)
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && time.Since(e.at) < ttl {
+		return e.info, nil
+	}
+	at := time.Now()
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return info, nil
+	}
+	max := c.Max
+	if max <= 0 {
+		max = 100000
+	}
+	if len(c.entries) >= max {
+		// any will do; the next stat of it puts it back
+		for k := range c.entries {
+			delete(c.entries, k)
+			if len(c.entries) < max*9/10 {
+				break
+			}
+		}
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]statEntry)
+	}
+	c.entries[name] = statEntry{info: info, at: at}
+	return info, nil
+}
+
+// Invalidate drops name, and its parent, whose time changes with it.
+func (c *StatCache) Invalidate(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.entries, name)
+	delete(c.entries, filepath.Dir(name))
+}
+
+// InvalidateTree drops name, its parent, and everything under it, for
+// directories that are removed or renamed.
+func (c *StatCache) InvalidateTree(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.entries, name)
+	delete(c.entries, filepath.Dir(name))
+	prefix := name + string(filepath.Separator)
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Clear drops everything.
+func (c *StatCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.gen++
+	c.entries = make(map[string]statEntry)
+	c.mu.Unlock()
+}
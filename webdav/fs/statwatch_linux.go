@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+const watchEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE
+
+/*
+  Watch drops what changes under root, which should be the Root of
+  the FS, as inotify reports it, until it is closed.  Every directory
+  is watched, so a volume with more directories than
+  fs.inotify.max_user_watches is only watched in part, and the rest
+  is left to the TTL.
+*/
+func (c *StatCache) Watch(root string) (io.Closer, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// non-blocking, so that reads wait in the poller, and Close ends them
+	w := &statWatcher{cache: c, file: os.NewFile(uintptr(fd), "inotify"), fd: fd, dirs: make(map[int]string)}
+	w.addTree(filepath.Clean(root))
+	go w.run()
+	return w, nil
+}
+
+type statWatcher struct {
+	cache *StatCache
+	file  *os.File
+	fd    int
+
+	mu   sync.Mutex
+	dirs map[int]string
+	full bool
+}
+
+func (w *statWatcher) Close() error {
+	return w.file.Close()
+}
+
+// Watch dir, and the directories under it.
+func (w *statWatcher) addTree(dir string) {
+	filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, name, watchEvents)
+		if err != nil {
+			w.mu.Lock()
+			full := w.full
+			w.full = true
+			w.mu.Unlock()
+			if !full {
+				webdav.Log().Warn("cannot watch all of the volume, so stats of the rest are cached for their TTL", "dir", name, "err", err)
+			}
+			return filepath.SkipDir
+		}
+		w.mu.Lock()
+		w.dirs[wd] = name
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *statWatcher) run() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			w.event(ev, string(trimNul(nameBytes)))
+		}
+	}
+}
+
+func (w *statWatcher) event(ev *syscall.InotifyEvent, base string) {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		// changes were lost, so nothing can be trusted
+		w.cache.Clear()
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.dirs[int(ev.Wd)]
+	if ev.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, int(ev.Wd))
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	name := dir
+	if base != "" {
+		name = filepath.Join(dir, base)
+	}
+	isDir := ev.Mask&syscall.IN_ISDIR != 0
+	switch {
+	case isDir && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		w.cache.InvalidateTree(name)
+		w.addTree(name)
+	case isDir && ev.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.cache.InvalidateTree(name)
+	default:
+		w.cache.Invalidate(name)
+	}
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+import (
+	"errors"
+	"io"
+)
+
+// Watch is only done on Linux.  Elsewhere, what other processes change is
+// cached for the TTL.
+func (c *StatCache) Watch(root string) (io.Closer, error) {
+	return nil, errors.New("stats are only watched on Linux")
+}
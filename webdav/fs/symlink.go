@@ -0,0 +1,230 @@
+package fs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  A SymlinkPolicy says what an FS does with the symbolic links that
+  were made on its volume by something other than WebDAV, which could
+  otherwise lead out of the Root.
+*/
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow follows links wherever they go, as os.Open does
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkDeny treats any path through a link as not found
+	SymlinkDeny
+	// SymlinkWithinRoot follows links that end up under the Root, and
+	// treats the rest as not found
+	SymlinkWithinRoot
+	// SymlinkExpose does not follow links, but serves those that point
+	// under the Root as redirect references (RFC 4437) to where they
+	// point, and treats the rest as not found
+	SymlinkExpose
+)
+
+var symlinkPolicyNames = []string{"follow", "deny", "within", "expose"}
+
+func (p SymlinkPolicy) String() string {
+	if p < 0 || int(p) >= len(symlinkPolicyNames) {
+		return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+	}
+	return symlinkPolicyNames[p]
+}
+
+// ParseSymlinkPolicy reads follow, deny, within or expose.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	for i, name := range symlinkPolicyNames {
+		if strings.EqualFold(s, name) {
+			return SymlinkPolicy(i), nil
+		}
+	}
+	return SymlinkFollow, fmt.Errorf("unknown symlink policy: %s", s)
+}
+
+/*
+  Whether name, a path on the volume, may be reached under the policy.
+  It is os.ErrNotExist when it may not, and link is true when name is
+  itself a link that SymlinkExpose serves as a redirect reference.
+  What is not there yet has no links in it.
+*/
+func (d FS) checkLinks(name string) (link bool, err error) {
+	if d.Symlinks == SymlinkFollow {
+		return false, nil
+	}
+	root := d.rootDir()
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == "." {
+		return false, nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	p := root
+	for i, part := range parts {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return false, nil
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		switch {
+		case d.Symlinks == SymlinkDeny || !d.withinRoot(p):
+			return false, os.ErrNotExist
+		case d.Symlinks == SymlinkExpose:
+			if i < len(parts)-1 {
+				// only the link itself is there, not what is under it
+				return false, os.ErrNotExist
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d FS) rootDir() string {
+	if d.Root == "" {
+		return "."
+	}
+	return filepath.Clean(d.Root)
+}
+
+// Whether the link at p ends up under the Root.  Dangling links do not.
+func (d FS) withinRoot(p string) bool {
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	root, err := filepath.EvalSymlinks(d.rootDir())
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Open the link at name, which SymlinkExpose serves as a redirect reference.
+func (d FS) openLink(ctx context.Context, name string, flag int) (webdav.File, error) {
+	permission := d.PermissionHandler(ctx, Action{Name: name, Action: AllowStat})
+	if !d.Allow(ctx, permission, AllowStat) {
+		return nil, d.hidden(permission)
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		// it is changed by changing the link, out of band
+		return nil, webdav.ErrNotAllowed
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	target, err := os.Readlink(name)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.IsAbs(target) {
+		if target, err = filepath.Rel(filepath.Dir(name), target); err != nil {
+			return nil, err
+		}
+	}
+	href := (&url.URL{Path: filepath.ToSlash(target)}).EscapedPath()
+	if tfi, err := os.Stat(name); err == nil && tfi.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return &linkFile{info: linkInfo{name: fi.Name(), modTime: fi.ModTime()}, href: href}, nil
+}
+
+// A link, as a redirect reference: an empty file with a DAV:reftarget.
+type linkFile struct {
+	info linkInfo
+	href string
+}
+
+func (f *linkFile) Close() error {
+	return nil
+}
+
+func (f *linkFile) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *linkFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (f *linkFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *linkFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *linkFile) Write(b []byte) (int, error) {
+	return 0, webdav.ErrNotAllowed
+}
+
+func (f *linkFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	target := xml.Name{Space: "DAV:", Local: "reftarget"}
+	var href strings.Builder
+	href.WriteString(`<D:href xmlns:D="DAV:">`)
+	xml.EscapeText(&href, []byte(f.href))
+	href.WriteString(`</D:href>`)
+	return map[xml.Name]webdav.Property{
+		target: {XMLName: target, InnerXML: []byte(href.String())},
+	}, nil
+}
+
+func (f *linkFile) Patch(p []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return nil, webdav.ErrNotAllowed
+}
+
+// A link, as the empty file that stands for it.
+type linkInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi linkInfo) Name() string       { return fi.name }
+func (fi linkInfo) Size() int64        { return 0 }
+func (fi linkInfo) Mode() os.FileMode  { return 0444 }
+func (fi linkInfo) ModTime() time.Time { return fi.modTime }
+func (fi linkInfo) IsDir() bool        { return false }
+func (fi linkInfo) Sys() interface{}   { return nil }
+
+// How the link at p, which lstat says is fi, is listed under the policy,
+// or nil for not at all.
+func (d FS) listedLink(p string, fi os.FileInfo) os.FileInfo {
+	switch {
+	case d.Symlinks == SymlinkFollow:
+		return fi
+	case d.Symlinks == SymlinkDeny || !d.withinRoot(p):
+		return nil
+	case d.Symlinks == SymlinkExpose:
+		return linkInfo{name: fi.Name(), modTime: fi.ModTime()}
+	}
+	target, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+	return renamedInfo{FileInfo: target, name: fi.Name()}
+}
+
+// What a link leads to, listed under the name of the link.
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedInfo) Name() string { return fi.name }
@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  Tags are a dead property holding a comma separated list:
+
+    <w:tags xmlns:w="https://github.com/rfielding/webdev/">red, blue</w:tags>
+*/
+var TagsProperty = xml.Name{Space: "https://github.com/rfielding/webdev/", Local: "tags"}
+
+// TagsOf parses the inner xml of the tags property.
+func TagsOf(innerXML string) []string {
+	var text struct {
+		Text string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte("<t>"+innerXML+"</t>"), &text); err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, t := range strings.Split(text.Text, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// FormatTags is the inner xml of the tags property for tags.
+func FormatTags(tags []string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(strings.Join(tags, ", ")))
+	return buf.String()
+}
+
+/*
+  A TagIndex finds the files with a tag without walking the volume.
+  Names are paths on disk.  It can go stale if files are moved
+  or removed, so callers should check that what it finds is still
+  there, and still visible to the user asking.
+*/
+type TagIndex struct {
+	mu     sync.RWMutex
+	byTag  map[string]map[string]bool
+	byFile map[string][]string
+}
+
+func NewTagIndex() *TagIndex {
+	return &TagIndex{
+		byTag:  make(map[string]map[string]bool),
+		byFile: make(map[string][]string),
+	}
+}
+
+// Set replaces the tags of a file.
+func (t *TagIndex) Set(name string, tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tag := range t.byFile[name] {
+		delete(t.byTag[tag], name)
+		if len(t.byTag[tag]) == 0 {
+			delete(t.byTag, tag)
+		}
+	}
+	if len(tags) == 0 {
+		delete(t.byFile, name)
+		return
+	}
+	t.byFile[name] = tags
+	for _, tag := range tags {
+		if t.byTag[tag] == nil {
+			t.byTag[tag] = make(map[string]bool)
+		}
+		t.byTag[tag][name] = true
+	}
+}
+
+// Lookup returns the files with a tag, sorted.
+func (t *TagIndex) Lookup(tag string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	names := make([]string, 0, len(t.byTag[tag]))
+	for name := range t.byTag[tag] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tags returns every tag in use, with how many files have it.
+func (t *TagIndex) Tags() map[string]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[string]int)
+	for tag, names := range t.byTag {
+		counts[tag] = len(names)
+	}
+	return counts
+}
+
+// BuildTagIndex reads the tags of everything on the volume.
+func (d FS) BuildTagIndex(ctx context.Context) (*TagIndex, error) {
+	t := NewTagIndex()
+	root := d.Root
+	if root == "" {
+		root = "."
+	}
+	key := PropKey(TagsProperty)
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() || !strings.HasSuffix(name, "deadproperties.json") {
+			return nil
+		}
+		primary := primaryOf(name)
+		if filepath.Base(name) == ".__deadproperties.json" {
+			primary = filepath.Dir(name)
+		}
+		if primary == "" {
+			return nil
+		}
+		if tags := TagsOf(readProperties(name)[key]); len(tags) > 0 {
+			t.Set(primary, tags)
+		}
+		return nil
+	})
+	return t, err
+}
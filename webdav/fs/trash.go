@@ -0,0 +1,164 @@
+package fs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+/*
+  With a Trash, what is deleted is moved there rather than removed,
+  each into a directory of its own, with a record of where it was:
+
+    <Trash>/20261016T093000.123456789Z-3f2a9c01/report.pdf
+    <Trash>/20261016T093000.123456789Z-3f2a9c01/.__report.pdf.deadproperties.json
+    <Trash>/20261016T093000.123456789Z-3f2a9c01.json
+
+  A file takes its sidecars with it, so that it can be put back with
+  its properties and its policy.  The trash is outside of the volume,
+  so that nothing that walks the volume finds it there, nor counts it
+  against a quota, but it has to be on the same file system, so that
+  a delete is a rename.  An admin puts something back by moving it
+  back onto the volume, and TrashExpiryJob removes what has been in
+  the trash longer than it is kept.
+*/
+type Trashed struct {
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+	By      string    `json:"by,omitempty"`
+}
+
+// Move name, resolved, into the trash, as RemoveAll does when there is one.
+func (d FS) trash(ctx context.Context, name string) error {
+	now := time.Now().UTC()
+	var b [4]byte
+	rand.Read(b[:])
+	entry := filepath.Join(d.Trash, now.Format("20060102T150405.000000000Z")+"-"+hex.EncodeToString(b[:]))
+	if err := os.MkdirAll(entry, 0700); err != nil {
+		return err
+	}
+	record := Trashed{Path: d.VolumePath(name), Deleted: now}
+	if d.Owner != nil {
+		record.By = d.Owner(ctx)
+	}
+	var sidecars []string
+	if info, err := os.Lstat(name); err == nil && !info.IsDir() {
+		for _, ftype := range SidecarTypes {
+			if sidecar := NameFor(name, ftype); sidecar != "" {
+				if _, err := os.Lstat(sidecar); err == nil {
+					sidecars = append(sidecars, sidecar)
+				}
+			}
+		}
+	}
+	if err := os.Rename(name, filepath.Join(entry, filepath.Base(name))); err != nil {
+		os.Remove(entry)
+		return err
+	}
+	for _, sidecar := range sidecars {
+		if err := os.Rename(sidecar, filepath.Join(entry, filepath.Base(sidecar))); err != nil {
+			webdav.Log().Warn("cannot trash sidecar", "sidecar", sidecar, "err", err)
+		}
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(entry+".json", data, 0600)
+}
+
+// What is in the trash, by the directory that each is in.
+func (d FS) trashed() (map[string]Trashed, error) {
+	infos, err := ioutil.ReadDir(d.Trash)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]Trashed)
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		file := filepath.Join(d.Trash, fi.Name())
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var t Trashed
+		if err := json.Unmarshal(data, &t); err != nil {
+			webdav.Log().Warn("cannot parse trash record", "file", file, "err", err)
+			continue
+		}
+		entries[strings.TrimSuffix(file, ".json")] = t
+	}
+	return entries, nil
+}
+
+// Remove what is in the trash that remove says to, along with its record.
+func (d FS) emptyTrash(ctx context.Context, remove func(t Trashed) bool) (int, error) {
+	if d.Trash == "" {
+		return 0, nil
+	}
+	entries, err := d.trashed()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for entry, t := range entries {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if !remove(t) {
+			continue
+		}
+		if err := os.RemoveAll(entry); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(entry + ".json"); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// ExpireTrash removes what was deleted before, and returns how many it removed.
+func (d FS) ExpireTrash(ctx context.Context, before time.Time) (int, error) {
+	return d.emptyTrash(ctx, func(t Trashed) bool {
+		return t.Deleted.Before(before)
+	})
+}
+
+// PurgeTrash removes what was deleted from name, a path on the volume, or under it.
+func (d FS) PurgeTrash(ctx context.Context, name string) (int, error) {
+	name = webdav.SlashClean(name)
+	return d.emptyTrash(ctx, func(t Trashed) bool {
+		return t.Path == name || strings.HasPrefix(t.Path, strings.TrimSuffix(name, "/")+"/")
+	})
+}
+
+// TrashExpiryJob removes what has been in the trash for longer than keep.
+func TrashExpiryJob(d FS, keep, interval time.Duration) Job {
+	return Job{
+		Name:     "trash",
+		Interval: interval,
+		Jitter:   interval / 10,
+		Run: func(ctx context.Context) error {
+			n, err := d.ExpireTrash(ctx, time.Now().Add(-keep))
+			if n > 0 {
+				webdav.Log().Info("expired trash", "root", d.Root, "removed", n)
+			}
+			return err
+		},
+	}
+}
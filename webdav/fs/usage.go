@@ -0,0 +1,121 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+  Usage is a du style summary of a part of the volume.
+  Metadata (the .__ attachments) is counted separately,
+  because users don't think of it as their data.
+*/
+type Usage struct {
+	Name          string  `json:"name"`
+	Files         int64   `json:"files"`
+	Dirs          int64   `json:"dirs"`
+	Bytes         int64   `json:"bytes"`
+	MetadataFiles int64   `json:"metadataFiles"`
+	MetadataBytes int64   `json:"metadataBytes"`
+	Children      []Usage `json:"children,omitempty"`
+}
+
+func (u *Usage) add(c Usage) {
+	u.Files += c.Files
+	u.Dirs += c.Dirs
+	u.Bytes += c.Bytes
+	u.MetadataFiles += c.MetadataFiles
+	u.MetadataBytes += c.MetadataBytes
+}
+
+// Usage totals up the named directory, with a breakdown for each of its
+// immediate children.  At the top of the volume, the children are the
+// home directories, so this doubles as a per-user report.
+func (d FS) Usage(ctx context.Context, name string) (Usage, error) {
+	return d.usage(ctx, name, func(string) bool { return true })
+}
+
+// VisibleUsage is Usage, of only what the user of ctx may Stat, so that
+// it names and totals what a listing would show them, and no more.
+// Quotas are of everything, and are taken from Usage.
+func (d FS) VisibleUsage(ctx context.Context, name string) (Usage, error) {
+	return d.usage(ctx, name, func(p string) bool {
+		if strings.HasPrefix(filepath.Base(p), ".__") {
+			// counted with what it belongs to
+			return true
+		}
+		permission := d.PermissionHandler(ctx, Action{Name: p, Action: AllowStat})
+		return d.Allow(ctx, permission, AllowStat)
+	})
+}
+
+// Usage, counting only the files, resolved, that visible says to.
+func (d FS) usage(ctx context.Context, name string, visible func(p string) bool) (Usage, error) {
+	full := d.resolve(name)
+	if full == "" {
+		return Usage{}, os.ErrNotExist
+	}
+	total := Usage{Name: name}
+	info, err := os.Stat(full)
+	if err != nil {
+		return total, err
+	}
+	if !info.IsDir() {
+		total.count(info)
+		return total, nil
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return total, err
+	}
+	for _, e := range entries {
+		if !visible(filepath.Join(full, e.Name())) {
+			continue
+		}
+		c := Usage{Name: filepath.ToSlash(filepath.Join(name, e.Name()))}
+		err := filepath.Walk(filepath.Join(full, e.Name()), func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !visible(p) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			c.count(info)
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total.add(c)
+		// Attachments of this directory are not worth a line of their own
+		if !strings.HasPrefix(e.Name(), ".__") {
+			total.Children = append(total.Children, c)
+		}
+	}
+	sort.Slice(total.Children, func(i, j int) bool {
+		return total.Children[i].Bytes > total.Children[j].Bytes
+	})
+	return total, nil
+}
+
+func (u *Usage) count(info os.FileInfo) {
+	switch {
+	case info.IsDir():
+		u.Dirs++
+	case strings.HasPrefix(info.Name(), ".__"):
+		u.MetadataFiles++
+		u.MetadataBytes += info.Size()
+	default:
+		u.Files++
+		u.Bytes += info.Size()
+	}
+}
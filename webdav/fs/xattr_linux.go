@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// The extended attributes of name, by their full names.
+func listXattrs(name string) (map[string][]byte, error) {
+	attrs := make(map[string][]byte)
+	size, err := syscall.Listxattr(name, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return attrs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(name, buf)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+		if len(attr) == 0 {
+			continue
+		}
+		n, err := syscall.Getxattr(name, string(attr), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n > 0 {
+			if n, err = syscall.Getxattr(name, string(attr), value); err != nil {
+				return nil, err
+			}
+		}
+		attrs[string(attr)] = value[:n]
+	}
+	return attrs, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+// Extended attributes are only read on Linux.
+func listXattrs(name string) (map[string][]byte, error) {
+	return map[string][]byte{}, nil
+}
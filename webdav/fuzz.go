@@ -0,0 +1,33 @@
+//go:build gofuzz
+// +build gofuzz
+
+package webdav
+
+import (
+	"bytes"
+)
+
+/*
+  Fuzz is the entry point for go-fuzz, which feeds it the bodies in
+  testdata/fuzz/corpus to start from:
+
+    go-fuzz-build -o webdav-fuzz.zip ./webdav
+    go-fuzz -bin webdav-fuzz.zip -workdir webdav/testdata/fuzz
+
+  Every body is parsed as each of PROPFIND, PROPPATCH and LOCK, as
+  the handler would, with the default limits.  Bodies that any of
+  them take are the interesting ones.
+*/
+func Fuzz(data []byte) int {
+	interesting := 0
+	if _, _, err := readPropfind(bytes.NewReader(data), DefaultXMLLimits); err == nil {
+		interesting = 1
+	}
+	if _, _, err := readProppatch(bytes.NewReader(data), DefaultXMLLimits); err == nil {
+		interesting = 1
+	}
+	if _, _, err := readLockInfo(bytes.NewReader(data), DefaultXMLLimits); err == nil {
+		interesting = 1
+	}
+	return interesting
+}
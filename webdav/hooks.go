@@ -0,0 +1,149 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/*
+  Hooks run custom rules around every request that a Handler serves,
+  without replacing its methods.  Each Before hook, in order, can
+  veto the request by returning a status to answer with, or change
+  the Path or Destination that it acts on:
+
+    h.Hooks = &webdav.Hooks{
+      Before: []webdav.BeforeHook{func(w http.ResponseWriter, op *webdav.Operation) (int, error) {
+        if op.Method == "DELETE" && strings.HasPrefix(op.Path, "/contracts/") {
+          return http.StatusForbidden, errors.New("contracts are kept")
+        }
+        return 0, nil
+      }},
+    }
+
+  Each After hook runs as the response starts, with the status that
+  it has, so it can still set headers.  A vetoed request runs them
+  too.
+*/
+type Hooks struct {
+	// Who is making a request, for Operation.User
+	UserOf func(ctx context.Context) string
+	Before []BeforeHook
+	After  []AfterHook
+}
+
+// A BeforeHook vetoes op by returning a status other than 0, which is
+// answered, with the error for the Logger.
+type BeforeHook func(w http.ResponseWriter, op *Operation) (status int, err error)
+
+// An AfterHook sees how op was answered.  err is only known when the
+// method left the response to the Handler, rather than writing it itself.
+type AfterHook func(w http.ResponseWriter, op *Operation, status int, err error)
+
+// An Operation is a request, as hooks see it.
+type Operation struct {
+	Method string
+	// The resource, in the FileSystem, without the Prefix.  A Before
+	// hook may change it, to act on another.
+	Path string
+	// Where a COPY or MOVE goes, in the FileSystem, when it is on this
+	// server.  A Before hook may change it too.
+	Destination string
+	User        string
+	Request     *http.Request
+}
+
+/*
+  Run the Before hooks of r, returning r as they have changed it, or
+  the status that one of them vetoed it with.  After hooks run as w
+  is first written.
+*/
+func (h *Handler) before(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, int, error) {
+	if h.Hooks == nil {
+		return w, r, 0, nil
+	}
+	name, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return w, r, status, err
+	}
+	op := &Operation{Method: r.Method, Path: name, Request: r}
+	var dst *url.URL
+	var dstName string
+	if hdr := r.Header.Get("Destination"); hdr != "" {
+		if u, local, err := h.destination(r, hdr); err == nil && local {
+			if d, _, err := h.stripPrefix(u.Path); err == nil {
+				dst, dstName, op.Destination = u, d, d
+			}
+		}
+	}
+	if h.Hooks.UserOf != nil {
+		op.User = h.Hooks.UserOf(r.Context())
+	}
+	hw := &hookWriter{ResponseWriter: w, hooks: h.Hooks, op: op}
+	for _, before := range h.Hooks.Before {
+		if status, err := before(hw, op); status != 0 {
+			hw.err = err
+			return hw, r, status, err
+		}
+	}
+	if op.Path != name || (dst != nil && op.Destination != dstName) {
+		r = r.Clone(r.Context())
+		r.URL.Path = h.Prefix + rewritten(name, op.Path)
+		r.URL.RawPath = ""
+		if dst != nil {
+			d := *dst
+			d.Path, d.RawPath = h.Prefix+rewritten(dst.Path, op.Destination), ""
+			r.Header.Set("Destination", d.String())
+		}
+		op.Request = r
+	}
+	return hw, r, 0, nil
+}
+
+// to, with the trailing slash that was on from
+func rewritten(from, to string) string {
+	to = path.Clean("/" + to)
+	if strings.HasSuffix(from, "/") && to != "/" {
+		to += "/"
+	}
+	return to
+}
+
+// Runs the After hooks as the response starts.
+type hookWriter struct {
+	http.ResponseWriter
+	hooks *Hooks
+	op    *Operation
+	// what the request failed with, when it is known in time
+	err  error
+	done bool
+}
+
+func (w *hookWriter) WriteHeader(status int) {
+	w.after(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	w.after(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *hookWriter) Flush() {
+	w.after(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hookWriter) after(status int) {
+	if w.done {
+		return
+	}
+	w.done = true
+	for _, after := range w.hooks.After {
+		after(w.ResponseWriter, w.op, status, w.err)
+	}
+}
@@ -0,0 +1,166 @@
+//go:build ignore
+// +build ignore
+
+// Maketables writes tables.go from the Unicode Character Database:
+//
+//	go run maketables.go -ucd https://www.unicode.org/Public/14.0.0/ucd
+//
+// The url may also be a local directory with UnicodeData.txt and
+// CompositionExclusions.txt in it.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ucd = flag.String("ucd", "https://www.unicode.org/Public/14.0.0/ucd", "where the Unicode Character Database is")
+
+func open(name string) io.ReadCloser {
+	if strings.HasPrefix(*ucd, "http://") || strings.HasPrefix(*ucd, "https://") {
+		resp, err := http.Get(*ucd + "/" + name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("%s: %s", name, resp.Status)
+		}
+		return resp.Body
+	}
+	f, err := os.Open(filepath.Join(*ucd, name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+// The fields of each line of name, without comments and blank lines.
+func lines(name string, fn func(fields []string)) {
+	r := open(name)
+	defer r.Close()
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		fn(fields)
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseRune(s string) rune {
+	r, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rune(r)
+}
+
+func main() {
+	flag.Parse()
+	ccc := make(map[rune]uint8)
+	decomp := make(map[rune][]rune)
+	lines("UnicodeData.txt", func(fields []string) {
+		r := parseRune(fields[0])
+		if strings.HasSuffix(fields[1], "First>") || strings.HasSuffix(fields[1], "Last>") {
+			// ranges have no decompositions, and only starters
+			return
+		}
+		c, err := strconv.Atoi(fields[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if c != 0 {
+			ccc[r] = uint8(c)
+		}
+		if d := fields[5]; d != "" && !strings.HasPrefix(d, "<") {
+			for _, s := range strings.Fields(d) {
+				decomp[r] = append(decomp[r], parseRune(s))
+			}
+		}
+	})
+	excluded := make(map[rune]bool)
+	lines("CompositionExclusions.txt", func(fields []string) {
+		excluded[parseRune(fields[0])] = true
+	})
+
+	var runes []rune
+	for r := range decomp {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by go run maketables.go -ucd %s; DO NOT EDIT.\n\n", *ucd)
+	b.WriteString("package norm\n\n")
+	b.WriteString("// The canonical decompositions, one level deep\n")
+	b.WriteString("var decompositions = map[rune]string{\n")
+	for _, r := range runes {
+		fmt.Fprintf(&b, "\t0x%04X: %s,\n", r, quote(decomp[r]))
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("// The primary composites, by what they decompose to\n")
+	b.WriteString("var compositions = map[[2]rune]rune{\n")
+	for _, r := range runes {
+		d := decomp[r]
+		// singletons, non-starters and exclusions are never composed
+		if len(d) != 2 || excluded[r] || ccc[r] != 0 || ccc[d[0]] != 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t{0x%04X, 0x%04X}: 0x%04X,\n", d[0], d[1], r)
+	}
+	b.WriteString("}\n\n")
+	runes = runes[:0]
+	for r := range ccc {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	b.WriteString("// The canonical combining classes that are not 0\n")
+	b.WriteString("var combiningClasses = map[rune]uint8{\n")
+	for _, r := range runes {
+		fmt.Fprintf(&b, "\t0x%04X: %d,\n", r, ccc[r])
+	}
+	b.WriteString("}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("tables.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func quote(rs []rune) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range rs {
+		if r > 0xFFFF {
+			fmt.Fprintf(&b, `\U%08X`, r)
+		} else {
+			fmt.Fprintf(&b, `\u%04X`, r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
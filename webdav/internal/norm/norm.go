@@ -0,0 +1,187 @@
+/*
+  Package norm puts names into Unicode Normalization Form C, and folds
+  their case, so that names that look the same compare the same.  macOS
+  clients send names decomposed (NFD), where Windows and Linux ones
+  send them composed, and Windows clients treat names that differ only
+  in case as one name.
+
+  The tables are generated from the Unicode Character Database by
+  maketables.go.
+*/
+package norm
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:generate go run maketables.go
+
+// Hangul syllables are composed and decomposed by arithmetic, not tables.
+// http://www.unicode.org/versions/Unicode14.0.0/ch03.pdf#G56669
+const (
+	hangulBase  = 0xAC00
+	hangulL     = 0x1100
+	hangulV     = 0x1161
+	hangulT     = 0x11A7
+	hangulLen   = 19
+	hangulVLen  = 21
+	hangulTLen  = 28
+	hangulNLen  = hangulVLen * hangulTLen
+	hangulCount = hangulLen * hangulNLen
+)
+
+// NFC is s in Normalization Form C.
+func NFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	runes := decompose(s)
+	reorder(runes)
+	return string(compose(runes))
+}
+
+// NFD is s in Normalization Form D, with every character decomposed.
+func NFD(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	runes := decompose(s)
+	reorder(runes)
+	return string(runes)
+}
+
+// IsNFC says whether s is already in Normalization Form C.
+func IsNFC(s string) bool {
+	return isASCII(s) || NFC(s) == s
+}
+
+/*
+  Fold is s with every letter in one case, as Windows compares names.
+  It is only for comparing: two names fold the same when they differ
+  only in case, but what they fold to need not be either of them.
+*/
+func Fold(s string) string {
+	if isASCII(s) {
+		return strings.ToLower(s)
+	}
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteRune(foldRune(r))
+	}
+	return b.String()
+}
+
+// The same letter as r, in the case that every case of it folds to.
+func foldRune(r rune) rune {
+	least := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < least {
+			least = f
+		}
+	}
+	return unicode.ToLower(least)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// The full canonical decomposition of s.
+func decompose(s string) []rune {
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		runes = decomposeRune(runes, r)
+	}
+	return runes
+}
+
+func decomposeRune(runes []rune, r rune) []rune {
+	if r >= hangulBase && r < hangulBase+hangulCount {
+		i := r - hangulBase
+		runes = append(runes, hangulL+i/hangulNLen, hangulV+(i%hangulNLen)/hangulTLen)
+		if t := i % hangulTLen; t != 0 {
+			runes = append(runes, hangulT+t)
+		}
+		return runes
+	}
+	d, ok := decompositions[r]
+	if !ok {
+		return append(runes, r)
+	}
+	for _, r := range d {
+		runes = decomposeRune(runes, r)
+	}
+	return runes
+}
+
+// Put each run of combining marks in canonical order.
+func reorder(runes []rune) {
+	for i := 0; i < len(runes); {
+		if combiningClasses[runes[i]] == 0 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && combiningClasses[runes[j]] != 0 {
+			j++
+		}
+		run := runes[i:j]
+		sort.SliceStable(run, func(a, b int) bool {
+			return combiningClasses[run[a]] < combiningClasses[run[b]]
+		})
+		i = j
+	}
+}
+
+// The canonical composition of runes, which are decomposed and in order.
+func compose(runes []rune) []rune {
+	if len(runes) == 0 {
+		return runes
+	}
+	out := runes[:1]
+	starter := 0
+	if combiningClasses[runes[0]] != 0 {
+		starter = -1
+	}
+	var last uint8
+	for _, r := range runes[1:] {
+		ccc := combiningClasses[r]
+		if starter >= 0 {
+			// r is blocked from the starter by a mark of its class or higher,
+			// or by a starter after it
+			blocked := len(out)-1 > starter && (last == 0 || last >= ccc)
+			if !blocked {
+				if c, ok := composePair(out[starter], r); ok {
+					out[starter] = c
+					continue
+				}
+			}
+		}
+		if ccc == 0 {
+			starter = len(out)
+		}
+		last = ccc
+		out = append(out, r)
+	}
+	return out
+}
+
+func composePair(a, b rune) (rune, bool) {
+	switch {
+	case a >= hangulL && a < hangulL+hangulLen && b >= hangulV && b < hangulV+hangulVLen:
+		return hangulBase + ((a-hangulL)*hangulVLen+(b-hangulV))*hangulTLen, true
+	case a >= hangulBase && a < hangulBase+hangulCount && (a-hangulBase)%hangulTLen == 0 &&
+		b > hangulT && b < hangulT+hangulTLen:
+		return a + (b - hangulT), true
+	}
+	c, ok := compositions[[2]rune{a, b}]
+	return c, ok
+}
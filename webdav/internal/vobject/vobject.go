@@ -0,0 +1,159 @@
+// Package vobject parses the content lines that iCalendar (RFC 5545) and
+// vCard (RFC 6350) data are made of, into components and their properties.
+package vobject
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Component, such as a VEVENT or a VCARD, with its properties and the
+// components inside of it.
+type Component struct {
+	Name     string
+	Props    []Property
+	Children []*Component
+}
+
+// A Property is one content line, unfolded.  Names are upper case.
+type Property struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+// Prop is the first property of c named name, if it has one.
+func (c *Component) Prop(name string) (Property, bool) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Parse the one component named root, which must be all that data holds.
+func Parse(data []byte, root string) (*Component, error) {
+	var stack []*Component
+	var top *Component
+	lines, err := unfold(data)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		switch p.Name {
+		case "BEGIN":
+			if top != nil {
+				return nil, fmt.Errorf("data after END:%s", root)
+			}
+			c := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, c)
+			} else if c.Name != root {
+				return nil, fmt.Errorf("not a %s", root)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("line %d: END:%s does not match", i+1, p.Value)
+			}
+			if len(stack) == 1 {
+				top = stack[0]
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: %s is outside of any component", i+1, p.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, p)
+		}
+	}
+	if top == nil {
+		return nil, fmt.Errorf("no complete %s", root)
+	}
+	return top, nil
+}
+
+// The content lines of data, with folded lines joined back up.
+func unfold(data []byte) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	for s.Scan() {
+		line := strings.TrimSuffix(s.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, s.Err()
+}
+
+// name *(";" param) ":" value, where quoted parameter values may hold
+// the separators.
+func parseLine(line string) (Property, error) {
+	p := Property{}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, errors.New("no property name")
+	}
+	p.Name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return p, errors.New("bad parameter")
+		}
+		key := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var values []string
+		for {
+			var v string
+			if strings.HasPrefix(line, `"`) {
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					return p, errors.New("unterminated quote")
+				}
+				v, line = line[1:end+1], line[end+2:]
+			} else {
+				end := strings.IndexAny(line, ",;:")
+				if end < 0 {
+					return p, errors.New("no value")
+				}
+				v, line = line[:end], line[end:]
+			}
+			values = append(values, v)
+			if !strings.HasPrefix(line, ",") {
+				break
+			}
+			line = line[1:]
+		}
+		if p.Params == nil {
+			p.Params = make(map[string][]string)
+		}
+		p.Params[key] = append(p.Params[key], values...)
+		if line == "" {
+			return p, errors.New("no value")
+		}
+		i = 0
+	}
+	if line[i] != ':' {
+		return p, errors.New("no value")
+	}
+	p.Value = line[i+1:]
+	return p, nil
+}
+
@@ -0,0 +1,387 @@
+package webdav
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+  Jobs run COPY, MOVE and DELETE of whole collections in the
+  background, for clients that ask with Prefer: respond-async (RFC
+  7240), so that a big tree is not cut off by a proxy's timeout.
+
+    DELETE /big/ HTTP/1.1
+    Prefer: respond-async
+
+    HTTP/1.1 202 Accepted
+    Location: /jobs/5f0c...
+    Preference-Applied: respond-async
+
+  A GET of the Location says how far the job has got, as a Job, and a
+  DELETE of it cancels the job where it is.  The request is checked,
+  locks included, when the job starts, so a request that would have
+  failed at once fails the job instead.
+*/
+type Jobs struct {
+	// Where jobs are kept.  Default is in memory.
+	Store JobStore
+	// The url path that the status of a job is at, with its id after it.
+	StatusPrefix string
+	// Who is running a request, so that only they can see their jobs
+	OwnerOf func(ctx context.Context) string
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// The states of a Job
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// A Job is a request that is running in the background, or that has.
+type Job struct {
+	ID          string `json:"id"`
+	Owner       string `json:"owner,omitempty"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+	State       string `json:"state"`
+	// Files and directories, of those the user can see
+	Total int64 `json:"total"`
+	Done  int64 `json:"done"`
+	// Where it went wrong, if it did
+	Failures []JobFailure `json:"failures,omitempty"`
+	// What the request would have answered, when it is over
+	Status   int        `json:"status,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// A JobFailure is a file that a job could not do.
+type JobFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ErrNoSuchJob is returned by a JobStore for a job that it does not have.
+var ErrNoSuchJob = errors.New("webdav: no such job")
+
+// A JobStore keeps jobs as they go, for their status to be asked for.
+type JobStore interface {
+	Save(job Job) error
+	Load(id string) (Job, error)
+}
+
+// NewMemJobStore keeps jobs in memory, until they have been over for keep.
+func NewMemJobStore(keep time.Duration) JobStore {
+	return &memJobStore{keep: keep, jobs: make(map[string]Job)}
+}
+
+type memJobStore struct {
+	keep time.Duration
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func (s *memJobStore) Save(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	for id, j := range s.jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > s.keep {
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
+
+func (s *memJobStore) Load(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNoSuchJob
+	}
+	return job, nil
+}
+
+/*
+  Progress counts what a job has done.  A FileSystem that does a
+  whole tree in one call, as RemoveAll does, can Add to it as it
+  goes.  It is nil when the request is not a job.
+*/
+type Progress struct {
+	total int64
+	done  int64
+
+	mu       sync.Mutex
+	failures []JobFailure
+}
+
+type progressKey struct{}
+
+// ProgressOf is the Progress of the job that ctx is running, if it is one.
+func ProgressOf(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// Add counts n more files as done.
+func (p *Progress) Add(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.done, n)
+	}
+}
+
+// Fail records that name could not be done, unless something under it
+// already was, which is then the reason.
+func (p *Progress) Fail(name string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range p.failures {
+		if f.Path == name || strings.HasPrefix(f.Path, strings.TrimSuffix(name, "/")+"/") {
+			return
+		}
+	}
+	p.failures = append(p.failures, JobFailure{Path: name, Error: jobError(err)})
+}
+
+// What went wrong, without where on the server's disk
+func jobError(err error) string {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return pe.Op + ": " + pe.Err.Error()
+	}
+	var le *os.LinkError
+	if errors.As(err, &le) {
+		return le.Op + ": " + le.Err.Error()
+	}
+	return err.Error()
+}
+
+// The progress so far, into job.
+func (p *Progress) fill(job *Job) {
+	job.Total = atomic.LoadInt64(&p.total)
+	job.Done = atomic.LoadInt64(&p.done)
+	if job.Done > job.Total {
+		job.Total = job.Done
+	}
+	p.mu.Lock()
+	job.Failures = append([]JobFailure(nil), p.failures...)
+	p.mu.Unlock()
+}
+
+func (j *Jobs) store() JobStore {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Store == nil {
+		j.Store = NewMemJobStore(24 * time.Hour)
+	}
+	if j.cancels == nil {
+		j.cancels = make(map[string]context.CancelFunc)
+	}
+	return j.Store
+}
+
+// Whether r asks to be run in the background, and can be.
+func (h *Handler) wantsJob(r *http.Request) bool {
+	if h.Jobs == nil || (r.Method != "COPY" && r.Method != "MOVE" && r.Method != "DELETE") {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(strings.Join(r.Header["Prefer"], ",")), "respond-async") {
+		return false
+	}
+	// a COPY of Depth 0 is only the collection itself
+	return r.Method != "COPY" || r.Header.Get("Depth") == "" || parseDepth(r.Header.Get("Depth")) == InfiniteDepth
+}
+
+/*
+  Start r as a job, if it is of a collection, answering 202 with
+  where to see how it goes.  A request of a file is quick enough to
+  answer as it is, so it is not started, and nor is one whose path
+  is not there, which is answered as it is too.
+*/
+func (h *Handler) startJob(w http.ResponseWriter, r *http.Request) bool {
+	src, _, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return false
+	}
+	info, err := h.FileSystem.Stat(r.Context(), src)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	store := h.Jobs.store()
+	var b [16]byte
+	rand.Read(b[:])
+	job := Job{
+		ID:          hex.EncodeToString(b[:]),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Destination: r.Header.Get("Destination"),
+		State:       JobRunning,
+		Started:     time.Now(),
+	}
+	if h.Jobs.OwnerOf != nil {
+		job.Owner = h.Jobs.OwnerOf(r.Context())
+	}
+	if err := store.Save(job); err != nil {
+		return false
+	}
+	// the job outlives the request, but is still for its user
+	p := &Progress{}
+	ctx, cancel := context.WithCancel(context.WithValue(detached{r.Context()}, progressKey{}, p))
+	h.Jobs.mu.Lock()
+	h.Jobs.cancels[job.ID] = cancel
+	h.Jobs.mu.Unlock()
+	go h.runJob(ctx, cancel, r.Clone(ctx), job, p, src, info)
+
+	w.Header().Set("Location", h.Jobs.StatusPrefix+job.ID)
+	w.Header().Set("Preference-Applied", "respond-async")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+	return true
+}
+
+func (h *Handler) runJob(ctx context.Context, cancel context.CancelFunc, r *http.Request, job Job, p *Progress, src string, info os.FileInfo) {
+	defer cancel()
+	store := h.Jobs.store()
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.fill(&job)
+				store.Save(job)
+			}
+		}
+	}()
+	// count first, so that how far it has got means something
+	atomic.AddInt64(&p.total, 1)
+	h.countTree(ctx, src, p)
+	w := &discardWriter{header: make(http.Header)}
+	status, err := h.method(r.Method)(w, r)
+	close(done)
+	<-stopped
+	if err == nil {
+		// whatever was not counted as it went is done now
+		atomic.StoreInt64(&p.done, atomic.LoadInt64(&p.total))
+	}
+	p.fill(&job)
+	finished := time.Now()
+	job.Status, job.Finished = status, &finished
+	switch {
+	case err == nil:
+		job.State = JobDone
+	case ctx.Err() == context.Canceled:
+		// what failed after it was cancelled failed because it was
+		err = ctx.Err()
+		job.State, job.Error, job.Failures, job.Status = JobCancelled, "cancelled", nil, 0
+	default:
+		job.State, job.Error = JobFailed, jobError(err)
+	}
+	if err := store.Save(job); err != nil {
+		Log().Warn("cannot save job", "request_id", RequestID(ctx), "job", job.ID, "err", err)
+	}
+	h.Jobs.mu.Lock()
+	delete(h.Jobs.cancels, job.ID)
+	h.Jobs.mu.Unlock()
+	if h.Logger != nil {
+		h.Logger(r, err)
+	}
+}
+
+// Count what is under the directory name, listing each directory once.
+func (h *Handler) countTree(ctx context.Context, name string, p *Progress) {
+	f, err := h.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	children, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return
+	}
+	atomic.AddInt64(&p.total, int64(len(children)))
+	for _, c := range children {
+		if ctx.Err() != nil {
+			return
+		}
+		if c.IsDir() {
+			h.countTree(ctx, path.Join(name, c.Name()), p)
+		}
+	}
+}
+
+// ServeHTTP serves the status of jobs, at StatusPrefix, to their owners.
+// A DELETE cancels a job.
+func (j *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, j.StatusPrefix)
+	job, err := j.store().Load(id)
+	if err == nil && j.OwnerOf != nil && job.Owner != j.OwnerOf(r.Context()) {
+		err = ErrNoSuchJob
+	}
+	if err != nil {
+		http.Error(w, "No such job", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		j.mu.Lock()
+		if cancel, ok := j.cancels[id]; ok {
+			cancel()
+		}
+		j.mu.Unlock()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if job.State == JobRunning {
+		w.Header().Set("Retry-After", "1")
+	}
+	json.NewEncoder(w).Encode(job)
+}
+
+// detached has the values of a context, but not its deadline or cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// What a job's request would have written to its client
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,73 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// A LivePropertyFunc computes the value of a live property of the resource
+// at name.  The value is text, and is escaped before it is sent.  Returning
+// an error that is os.ErrNotExist reports the property as missing for this
+// resource, and any other error fails the whole PROPFIND.
+type LivePropertyFunc func(ctx context.Context, name string, fi os.FileInfo) (string, error)
+
+// RegisterLiveProperty adds a computed, protected property, so that it shows
+// up in PROPFIND and cannot be changed with PROPPATCH.  If dir is false, it
+// is only given for files.  As RFC 4918 asks of live properties that it does
+// not define, allprop only returns it if it is named in include.
+//
+// It must be called before serving, and panics if pname is already a live
+// property.
+func RegisterLiveProperty(pname xml.Name, dir bool, fn LivePropertyFunc) {
+	if _, ok := liveProps[pname]; ok {
+		panic(fmt.Sprintf("webdav: live property {%s}%s is already registered", pname.Space, pname.Local))
+	}
+	liveProps[pname] = liveProp{
+		findFn: func(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+			value, err := fn(ctx, name, fi)
+			if err != nil {
+				return "", err
+			}
+			return escapeXML(value), nil
+		},
+		dir:    dir,
+		custom: true,
+	}
+}
+
+// RegisterLivePropertyXML is RegisterLiveProperty for properties whose value
+// is XML, such as a DAV:href, which fn must give already escaped.
+func RegisterLivePropertyXML(pname xml.Name, dir bool, fn LivePropertyFunc) {
+	if _, ok := liveProps[pname]; ok {
+		panic(fmt.Sprintf("webdav: live property {%s}%s is already registered", pname.Space, pname.Local))
+	}
+	liveProps[pname] = liveProp{
+		findFn: func(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+			return fn(ctx, name, fi)
+		},
+		dir:    dir,
+		custom: true,
+	}
+}
+
+// A ResourceTypeFunc gives what DAV:resourcetype has for the resource at
+// name besides DAV:collection, as XML, or "" for nothing more.
+type ResourceTypeFunc func(ctx context.Context, fs FileSystem, name string, fi os.FileInfo) (string, error)
+
+var resourceTypes []ResourceTypeFunc
+
+// RegisterResourceType adds the types that fn gives to DAV:resourcetype,
+// as extensions do for the collections that they make, such as calendars.
+// It must be called before serving.
+func RegisterResourceType(fn ResourceTypeFunc) {
+	resourceTypes = append(resourceTypes, fn)
+}
+
+// IsLiveProperty reports whether pname is a live property, which is computed
+// rather than stored, so cannot be given a value.
+func IsLiveProperty(pname xml.Name) bool {
+	_, ok := liveProps[pname]
+	return ok
+}
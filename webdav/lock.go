@@ -114,3 +114,20 @@ type LockDetails struct {
 	// depth, it has infinite depth.
 	ZeroDepth bool
 }
+
+// ActiveLock is a lock that a LockSystem holds, as listed by a LockLister.
+type ActiveLock struct {
+	LockDetails
+	// Token identifies the lock, as returned by Create.
+	Token string
+	// Expiry is when the lock times out. It is zero if it never does.
+	Expiry time.Time
+}
+
+// LockLister is an optional interface for a LockSystem that can say which
+// locks it holds.  Without it, DAV:lockdiscovery is always empty.
+type LockLister interface {
+	// ListLocks returns the active locks whose root is prefix, or is under
+	// it.  A prefix of "/" lists every lock.
+	ListLocks(now time.Time, prefix string) ([]ActiveLock, error)
+}
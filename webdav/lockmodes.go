@@ -0,0 +1,62 @@
+package webdav
+
+import (
+	"errors"
+	"strings"
+)
+
+// A LockMode says what kind of LOCK a subtree allows.
+type LockMode int
+
+const (
+	// LockAny allows zero and infinite depth locks.  It is the default.
+	LockAny LockMode = iota
+	// LockZeroDepth only allows locks on single resources.
+	LockZeroDepth
+	// LockNone turns locking off, for trees backed by stores where it
+	// means nothing.  OPTIONS then only advertises DAV class 1.
+	LockNone
+)
+
+var (
+	// ErrLockingDisabled is returned when a LOCK is refused by a LockMode.
+	ErrLockingDisabled = errors.New("webdav: locking is disabled here")
+)
+
+// lockMode is the mode of the longest prefix in h.LockModes that covers name.
+func (h *Handler) lockMode(name string) LockMode {
+	name = SlashClean(name)
+	mode, longest := LockAny, -1
+	for prefix, m := range h.LockModes {
+		prefix = SlashClean(prefix)
+		if covers(prefix, name) && len(prefix) > longest {
+			mode, longest = m, len(prefix)
+		}
+	}
+	return mode
+}
+
+// canLock says whether a LOCK of root, at the given depth, is allowed.  An
+// infinite depth lock covers everything under root, so it is refused if any
+// subtree under root restricts locking.
+func (h *Handler) canLock(root string, zeroDepth bool) bool {
+	mode := h.lockMode(root)
+	if mode == LockNone || (mode == LockZeroDepth && !zeroDepth) {
+		return false
+	}
+	if zeroDepth {
+		return true
+	}
+	root = SlashClean(root)
+	for prefix, m := range h.LockModes {
+		if m != LockAny && covers(root, SlashClean(prefix)) {
+			return false
+		}
+	}
+	return true
+}
+
+// covers is true if name is root, or is under it.
+func covers(root, name string) bool {
+	return root == "/" || name == root || strings.HasPrefix(name, root+"/")
+}
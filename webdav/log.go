@@ -0,0 +1,103 @@
+package webdav
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// A Logger writes leveled, structured log lines.  The args after the message
+// are alternating keys and values.  A *slog.Logger is one, so deployments on
+// newer Go can use SetLogger(slog.Default()).
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// A Level is how important a log line is.  The values match slog's.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "DEBUG"
+	case l <= LevelInfo:
+		return "INFO"
+	case l <= LevelWarn:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// ParseLevel reads debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", s)
+}
+
+// NewLogger writes lines at level or above to l, as key=value pairs, in the
+// same form as slog's text handler.
+func NewLogger(l *log.Logger, level Level) Logger {
+	return stdLogger{out: l, level: level}
+}
+
+type stdLogger struct {
+	out   *log.Logger
+	level Level
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) { s.log(LevelDebug, msg, args) }
+func (s stdLogger) Info(msg string, args ...interface{})  { s.log(LevelInfo, msg, args) }
+func (s stdLogger) Warn(msg string, args ...interface{})  { s.log(LevelWarn, msg, args) }
+func (s stdLogger) Error(msg string, args ...interface{}) { s.log(LevelError, msg, args) }
+
+func (s stdLogger) log(level Level, msg string, args []interface{}) {
+	if level < s.level {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "level=%s msg=%s", level, quoteIfNeeded(msg))
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			// a value without a key, as slog does
+			fmt.Fprintf(&b, " !BADKEY=%s", quoteIfNeeded(fmt.Sprint(args[i])))
+			break
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], quoteIfNeeded(fmt.Sprint(args[i+1])))
+	}
+	s.out.Print(b.String())
+}
+
+func quoteIfNeeded(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+var logger = NewLogger(log.Default(), LevelInfo)
+
+// SetLogger replaces the logger that this package, and the fs package, log
+// to.  It should be called before serving.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// Log is the logger set by SetLogger.  By default, it writes lines at Info
+// and above to the standard logger.
+func Log() Logger {
+	return logger
+}
@@ -0,0 +1,366 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  NewMemFS is a FileSystem that holds its files, and their dead
+  properties, in memory, and forgets them when it is dropped.  It is
+  for tests, and for what runs the Handler with nothing on disk, as
+  the litmus harness in testsuite does:
+
+    h := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: fs.NewMemLS()}
+
+  It has no policy of its own.  Everything in it may be read and
+  written by anyone who reaches it.
+*/
+func NewMemFS() FileSystem {
+	return &memFS{root: newMemDir(0777)}
+}
+
+type memFS struct {
+	// Held for anything that looks at or changes a node
+	mu   sync.Mutex
+	root *memNode
+}
+
+// A file, or a directory when it has children
+type memNode struct {
+	children map[string]*memNode
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	props    map[xml.Name]Property
+}
+
+func newMemDir(perm os.FileMode) *memNode {
+	return &memNode{
+		children: make(map[string]*memNode),
+		mode:     os.ModeDir | perm&os.ModePerm,
+		modTime:  time.Now(),
+	}
+}
+
+/*
+  The directory that name is in, and its last segment, or no
+  directory when name is the root.  Every directory above it has to
+  be there.
+*/
+func (fs *memFS) walk(name string) (*memNode, string, error) {
+	name = SlashClean(name)
+	if name == "/" {
+		return nil, "/", nil
+	}
+	dir := fs.root
+	segments := strings.Split(name[1:], "/")
+	for _, s := range segments[:len(segments)-1] {
+		next, ok := dir.children[s]
+		if !ok || !next.mode.IsDir() {
+			return nil, "", os.ErrNotExist
+		}
+		dir = next
+	}
+	return dir, segments[len(segments)-1], nil
+}
+
+// The node that name is, or nil if there is none
+func (fs *memFS) lookup(name string) (*memNode, error) {
+	dir, base, err := fs.walk(name)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		return fs.root, nil
+	}
+	return dir.children[base], nil
+}
+
+func (fs *memFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, base, err := fs.walk(name)
+	if err != nil {
+		return err
+	}
+	if dir == nil || dir.children[base] != nil {
+		return os.ErrExist
+	}
+	dir.children[base] = newMemDir(perm)
+	dir.modTime = time.Now()
+	return nil
+}
+
+func (fs *memFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, base, err := fs.walk(name)
+	if err != nil {
+		return nil, err
+	}
+	n := fs.root
+	if dir != nil {
+		n = dir.children[base]
+	}
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case n == nil:
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		n = &memNode{mode: perm & os.ModePerm, modTime: time.Now()}
+		dir.children[base] = n
+		dir.modTime = n.modTime
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, os.ErrExist
+	case n.mode.IsDir():
+		// it may be opened to write its properties, but not truncated
+		if flag&os.O_TRUNC != 0 {
+			return nil, os.ErrInvalid
+		}
+	case writing && flag&os.O_TRUNC != 0:
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{fs: fs, n: n, name: path.Base(SlashClean(name)), writing: writing}, nil
+}
+
+func (fs *memFS) RemoveAll(ctx context.Context, name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, base, err := fs.walk(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dir == nil {
+		return os.ErrInvalid
+	}
+	if _, ok := dir.children[base]; ok {
+		delete(dir.children, base)
+		dir.modTime = time.Now()
+	}
+	return nil
+}
+
+func (fs *memFS) Rename(ctx context.Context, oldName, newName string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldName, newName = SlashClean(oldName), SlashClean(newName)
+	if oldName == newName {
+		return nil
+	}
+	if strings.HasPrefix(newName, oldName+"/") {
+		// a directory cannot go into itself
+		return os.ErrInvalid
+	}
+	oldDir, oldBase, err := fs.walk(oldName)
+	if err != nil {
+		return err
+	}
+	newDir, newBase, err := fs.walk(newName)
+	if err != nil {
+		return err
+	}
+	if oldDir == nil || newDir == nil {
+		return os.ErrInvalid
+	}
+	n, ok := oldDir.children[oldBase]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if existing, ok := newDir.children[newBase]; ok {
+		if existing.mode.IsDir() != n.mode.IsDir() || len(existing.children) > 0 {
+			return os.ErrExist
+		}
+	}
+	delete(oldDir.children, oldBase)
+	newDir.children[newBase] = n
+	now := time.Now()
+	oldDir.modTime, newDir.modTime = now, now
+	return nil
+}
+
+func (fs *memFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, os.ErrNotExist
+	}
+	return n.info(path.Base(SlashClean(name))), nil
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return memFileInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+/*
+  A memFile is a node, opened.  A directory's entries are read once,
+  in order of their names, at the first Readdir, and paged from there,
+  so that what a PROPFIND lists does not move under it.
+*/
+type memFile struct {
+	fs      *memFS
+	n       *memNode
+	name    string
+	writing bool
+	pos     int64
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.n.mode.IsDir() {
+		return 0, os.ErrInvalid
+	}
+	if f.pos >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.n.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += f.pos
+	case io.SeekEnd:
+		pos += int64(len(f.n.data))
+	default:
+		return 0, os.ErrInvalid
+	}
+	if pos < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if !f.writing {
+		return 0, os.ErrPermission
+	}
+	if f.n.mode.IsDir() {
+		return 0, os.ErrInvalid
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.n.data)) {
+		data := make([]byte, end)
+		copy(data, f.n.data)
+		f.n.data = data
+	}
+	copy(f.n.data[f.pos:], p)
+	f.pos = end
+	f.n.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if !f.n.mode.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if !f.listed {
+		names := make([]string, 0, len(f.n.children))
+		for name := range f.n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f.entries = append(f.entries, f.n.children[name].info(name))
+		}
+		f.listed = true
+	}
+	if count <= 0 {
+		rest := f.entries
+		f.entries = nil
+		return rest, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	page := f.entries[:count]
+	f.entries = f.entries[count:]
+	return page, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.n.info(f.name), nil
+}
+
+func (f *memFile) DeadProps() (map[xml.Name]Property, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	props := make(map[xml.Name]Property, len(f.n.props))
+	for k, v := range f.n.props {
+		props[k] = v
+	}
+	return props, nil
+}
+
+func (f *memFile) Patch(patches []Proppatch) ([]Propstat, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	pstat := Propstat{Status: 200}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(f.n.props, p.XMLName)
+				continue
+			}
+			if f.n.props == nil {
+				f.n.props = make(map[xml.Name]Property)
+			}
+			f.n.props[p.XMLName] = p
+		}
+	}
+	return []Propstat{pstat}, nil
+}
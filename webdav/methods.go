@@ -0,0 +1,83 @@
+package webdav
+
+import (
+	"net/http"
+	"sort"
+)
+
+/*
+  A MethodHandler serves one method of a Handler.  It returns the
+  status for the Handler to answer with, or 0 when it has written
+  the response itself, and the error, if any, for the Logger.
+
+  A Handler's Methods replace the built in handling of some methods,
+  and can fall back to it with DefaultMethod, as a GET that sends
+  big files to a CDN does:
+
+    h.Methods = map[string]webdav.MethodHandler{
+      "GET": func(w http.ResponseWriter, r *http.Request) (int, error) {
+        if fi, err := h.FileSystem.Stat(r.Context(), r.URL.Path); err == nil && fi.Size() > 1<<30 {
+          http.Redirect(w, r, cdn+r.URL.Path, http.StatusFound)
+          return 0, nil
+        }
+        return h.DefaultMethod("GET")(w, r)
+      },
+    }
+*/
+type MethodHandler func(w http.ResponseWriter, r *http.Request) (status int, err error)
+
+// DefaultMethod is the built in MethodHandler of method, or nil if there
+// is none.
+func (h *Handler) DefaultMethod(method string) MethodHandler {
+	switch method {
+	case "OPTIONS":
+		return h.handleOptions
+	case "GET", "HEAD", "POST":
+		return h.handleGetHeadPost
+	case "DELETE":
+		return h.handleDelete
+	case "PUT":
+		return h.handlePut
+	case "MKCOL":
+		return h.handleMkcol
+	case "COPY", "MOVE":
+		return h.handleCopyMove
+	case "LOCK":
+		return h.handleLock
+	case "UNLOCK":
+		return h.handleUnlock
+	case "PROPFIND":
+		return h.handlePropfind
+	case "PROPPATCH":
+		return h.handleProppatch
+	case "MKREDIRECTREF":
+		return h.handleMkredirectref
+	case "UPDATEREDIRECTREF":
+		return h.handleUpdateredirectref
+	case "BIND":
+		return h.handleBind
+	case "UNBIND":
+		return h.handleUnbind
+	}
+	return nil
+}
+
+// The MethodHandler that serves method, or nil if none does.
+func (h *Handler) method(method string) MethodHandler {
+	if m, ok := h.Methods[method]; ok && m != nil {
+		return m
+	}
+	return h.DefaultMethod(method)
+}
+
+// The methods that Methods serves and that are not built in, for Allow.
+func (h *Handler) addedMethods() []string {
+	var added []string
+	for method, m := range h.Methods {
+		if m != nil && h.DefaultMethod(method) == nil {
+			added = append(added, method)
+		}
+	}
+	sort.Strings(added)
+	return added
+}
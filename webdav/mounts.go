@@ -0,0 +1,100 @@
+package webdav
+
+import (
+	"strings"
+	"sync"
+)
+
+/*
+  A Mount serves another FileSystem, with its own LockSystem, under
+  a path of a Handler, as its Mounts have them:
+
+    h.Mounts = map[string]webdav.Mount{
+      "/home":    {FileSystem: homes, LockSystem: homeLocks},
+      "/shared":  {FileSystem: shared, LockSystem: sharedLocks},
+      "/archive": {FileSystem: archive, LockSystem: archiveLocks},
+    }
+
+  The longest path that covers a request picks its mount, and the
+  Handler's own FileSystem serves the rest.  The mount sees names
+  under its path, as though it were the Prefix, and each FileSystem
+  brings its own policy.  Everything else of the Handler is shared.
+
+  A COPY or MOVE from one mount to another, or to the Handler's own
+  FileSystem, is refused with 502, as it would be to another server
+  that is not a Remote.  Nor are mounts listed in a PROPFIND of their
+  parent, unless its FileSystem has a directory where they are.
+*/
+type Mount struct {
+	FileSystem FileSystem
+	LockSystem LockSystem
+	// LockModes, by path in the mount, as for a Handler
+	LockModes map[string]LockMode
+}
+
+/*
+  SetMounts replaces the Mounts of a Handler that is serving, as when
+  its configuration is reloaded.  Requests that have begun keep the
+  mount that they began with, so an upload to a mount that is taken
+  away is not cut off.
+*/
+func (h *Handler) SetMounts(mounts map[string]Mount) {
+	mountTables.Store(h, mounts)
+}
+
+/*
+  The Mounts that SetMounts gave, by Handler.  They are kept apart from
+  the Handler, which is copied for each mount that serves a request.
+*/
+var mountTables sync.Map
+
+// The mounts of h, as SetMounts last gave them, or as Mounts has them.
+func (h *Handler) mounts() map[string]Mount {
+	if m, ok := mountTables.Load(h); ok {
+		return m.(map[string]Mount)
+	}
+	return h.Mounts
+}
+
+// The path of the mount that covers the url path p, or "" if none does.
+func (h *Handler) mountAt(p string) string {
+	name, _, err := h.stripPrefix(p)
+	if err != nil {
+		return ""
+	}
+	at := ""
+	for m := range h.mounts() {
+		m = strings.TrimSuffix(m, "/")
+		if len(m) > len(at) && (name == m || strings.HasPrefix(name, m+"/")) {
+			at = m
+		}
+	}
+	return at
+}
+
+// The Handler of the mount that covers the url path p, if one does.
+func (h *Handler) mounted(p string) (*Handler, bool) {
+	at := h.mountAt(p)
+	if at == "" {
+		return nil, false
+	}
+	mounts := h.mounts()
+	m, ok := mounts[at]
+	if !ok {
+		m = mounts[at+"/"]
+	}
+	mh := *h
+	mh.Prefix = h.Prefix + at
+	mh.FileSystem, mh.LockSystem, mh.LockModes = m.FileSystem, m.LockSystem, m.LockModes
+	mh.Mounts, mh.mountOf, mh.mountedAt = nil, h, at
+	return &mh, true
+}
+
+// Whether the url path dst is in another mount than h serves.
+func (h *Handler) crossesMount(dst string) bool {
+	root := h
+	if h.mountOf != nil {
+		root = h.mountOf
+	}
+	return root.mountAt(dst) != h.mountedAt
+}
@@ -0,0 +1,325 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/rfielding/webdev/webdav/internal/norm"
+)
+
+/*
+  A FileNamePolicy decides whether a resource may be made at name, a
+  slash separated path without the Prefix, by a PUT, MKCOL, LOCK, COPY
+  or MOVE.  What is there already is not checked again, so that files
+  that were made before a policy was set can still be changed.  A
+  non-nil error rejects the name: a *NameError is answered with its
+  Status and a DAV:error body that says what is wrong with it, and any
+  other error with 403 Forbidden.
+*/
+type FileNamePolicy interface {
+	ValidateName(ctx context.Context, name string) error
+}
+
+// The codes of a NameError, for clients to act on
+const (
+	NameReserved           = "reserved-name"
+	NameTrailingDotOrSpace = "trailing-dot-or-space"
+	NameControlCharacter   = "control-character"
+	NameInvalidCharacter   = "invalid-character"
+	NameTooLong            = "name-too-long"
+	NamePathTooLong        = "path-too-long"
+	NameNotASCII           = "not-ascii"
+)
+
+// A NameError is why a FileNamePolicy rejected a name.
+type NameError struct {
+	// Name is the path that was rejected
+	Name string
+	// Segment is the part of Name that is wrong, or "" for all of it
+	Segment string
+	// Code is one of the Name codes, or one of a policy's own
+	Code string
+	// Status is what to answer with: 400 or 403, usually
+	Status int
+}
+
+func (e *NameError) Error() string {
+	if e.Segment != "" {
+		return fmt.Sprintf("webdav: %s: %q", e.Code, e.Segment)
+	}
+	return fmt.Sprintf("webdav: %s: %q", e.Code, e.Name)
+}
+
+// The namespace of the elements that this server defines
+const webdevNamespace = "https://github.com/rfielding/webdev/"
+
+/*
+  A FileNameSanitizer is a FileNamePolicy that can make a name that it
+  rejects into one that it accepts, so that what was uploaded is kept
+  under that name instead, as for files from scanners and other
+  programs that make ugly names.  ok is false when it does not, and
+  the name is rejected after all.  Only the last part of name should
+  be changed, as the rest is there already.
+*/
+type FileNameSanitizer interface {
+	FileNamePolicy
+	SanitizeName(ctx context.Context, name string) (sanitized string, ok bool)
+}
+
+/*
+  Check a name that is about to be made against the FileNamePolicy,
+  returning the name to make it with.  With sanitize, a name that a
+  FileNameSanitizer can fix is fixed, numbered as "name (1).txt" if
+  that is taken, and the Location of what is made is set.  When it is
+  rejected with a NameError, the body is written here, and the status
+  is 0, as the method has answered.
+*/
+func (h *Handler) checkName(w http.ResponseWriter, r *http.Request, name string, sanitize bool) (string, int, error) {
+	if h.FileNamePolicy == nil {
+		return name, 0, nil
+	}
+	ctx := r.Context()
+	if _, err := h.FileSystem.Stat(ctx, name); !os.IsNotExist(err) {
+		return name, 0, nil
+	}
+	err := h.FileNamePolicy.ValidateName(ctx, name)
+	if err == nil {
+		return name, 0, nil
+	}
+	if s, ok := h.FileNamePolicy.(FileNameSanitizer); ok && sanitize {
+		if clean, ok := s.SanitizeName(ctx, name); ok {
+			if clean = h.unusedName(ctx, clean); clean != "" && s.ValidateName(ctx, clean) == nil {
+				w.Header().Set("Location", h.href(clean, nil))
+				return clean, 0, nil
+			}
+		}
+	}
+	ne, ok := err.(*NameError)
+	if !ok {
+		return "", http.StatusForbidden, err
+	}
+	status := ne.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<D:error xmlns:D="DAV:" xmlns:W="` + webdevNamespace + `"><W:invalid-name>`)
+	writeElement(&b, "W:code", ne.Code)
+	writeElement(&b, "W:name", ne.Name)
+	if ne.Segment != "" {
+		writeElement(&b, "W:segment", ne.Segment)
+	}
+	b.WriteString(`</W:invalid-name></D:error>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+	return "", 0, err
+}
+
+// The most that unusedName numbers a name before giving up
+const maxNameNumber = 1000
+
+// name, or "name (1).txt" and so on if it is taken, or "" if they all are.
+func (h *Handler) unusedName(ctx context.Context, name string) string {
+	ext := path.Ext(name)
+	if ext == name[strings.LastIndexByte(name, '/')+1:] {
+		// a dot file is all name
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i <= maxNameNumber; i++ {
+		n := name
+		if i > 0 {
+			n = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		if _, err := h.FileSystem.Stat(ctx, n); os.IsNotExist(err) {
+			return n
+		}
+	}
+	return ""
+}
+
+func writeElement(b *bytes.Buffer, name, text string) {
+	b.WriteString("<" + name + ">")
+	xml.EscapeText(b, []byte(text))
+	b.WriteString("</" + name + ">")
+}
+
+/*
+  PortableNames is a FileNamePolicy for names that Windows, macOS and
+  Linux clients can all keep.  It rejects, with 403 Forbidden:
+
+    CON, PRN, AUX, NUL, COM1-9 and LPT1-9, with or without an extension
+    names that end in a dot or a space
+    < > : " | ? * and \, which Windows does not allow in names
+    names and paths that are too long
+
+  and control characters, with 400 Bad Request.  Lengths are counted in
+  UTF-16 code units, as Windows counts them.
+
+  With Sanitize, it is a FileNameSanitizer, and makes such names into
+  ones it accepts instead: control characters are dropped, reserved
+  characters are replaced, trailing dots and spaces are trimmed,
+  reserved names get a leading underscore, and long names are cut
+  short, keeping their extension.
+*/
+type PortableNames struct {
+	// MaxName bounds each part of a path.  0 is 255.
+	MaxName int
+	// MaxPath bounds the whole path, which is under wherever a client
+	// mounts the server, so it is best well under Windows' 260.  0 does
+	// not bound it.
+	MaxPath int
+	// ASCII rejects names that are not all ASCII, which Sanitize
+	// transliterates, as Café to Cafe
+	ASCII bool
+	// Sanitize fixes names rather than rejecting them
+	Sanitize bool
+}
+
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func (p PortableNames) ValidateName(ctx context.Context, name string) error {
+	maxName := p.MaxName
+	if maxName == 0 {
+		maxName = 255
+	}
+	name = SlashClean(name)
+	if p.MaxPath > 0 && utf16Len(name) > p.MaxPath {
+		return &NameError{Name: name, Code: NamePathTooLong, Status: http.StatusForbidden}
+	}
+	for _, segment := range strings.Split(strings.Trim(name, "/"), "/") {
+		fail := func(code string, status int) error {
+			return &NameError{Name: name, Segment: segment, Code: code, Status: status}
+		}
+		for _, c := range segment {
+			if c < 0x20 || c == 0x7F {
+				return fail(NameControlCharacter, http.StatusBadRequest)
+			}
+			if p.ASCII && c > unicode.MaxASCII {
+				return fail(NameNotASCII, http.StatusForbidden)
+			}
+		}
+		if strings.ContainsAny(segment, `<>:"|?*\`) {
+			return fail(NameInvalidCharacter, http.StatusForbidden)
+		}
+		if strings.HasSuffix(segment, ".") || strings.HasSuffix(segment, " ") {
+			return fail(NameTrailingDotOrSpace, http.StatusForbidden)
+		}
+		if isReserved(segment) {
+			return fail(NameReserved, http.StatusForbidden)
+		}
+		if utf16Len(segment) > maxName {
+			return fail(NameTooLong, http.StatusForbidden)
+		}
+	}
+	return nil
+}
+
+// CON.txt is as reserved as CON
+func isReserved(segment string) bool {
+	base := segment
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	return windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))]
+}
+
+// What Sanitize puts in place of characters that Windows does not allow
+var invalidReplacements = map[rune]string{
+	'<': "(", '>': ")", ':': "-", '"': "'", '|': "-", '?': "", '*': "-", '\\': "-",
+}
+
+// Letters that are not a letter and accents, for ASCII
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "Th",
+	'ı': "i", '‘': "'", '’': "'", '“': "'", '”': "'", '–': "-", '—': "-", '…': "...",
+	'\u00A0': " ",
+}
+
+// The room that unusedName needs to number a name, as " (1000)"
+var numberingRoom = len(fmt.Sprintf(" (%d)", maxNameNumber))
+
+func (p PortableNames) SanitizeName(ctx context.Context, name string) (string, bool) {
+	if !p.Sanitize {
+		return "", false
+	}
+	dir, segment := path.Split(SlashClean(name))
+	if p.ASCII {
+		segment = norm.NFD(segment)
+	}
+	var b strings.Builder
+	for _, c := range segment {
+		if r, ok := invalidReplacements[c]; ok {
+			b.WriteString(r)
+			continue
+		}
+		switch {
+		case c < 0x20 || c == 0x7F:
+		case p.ASCII && unicode.Is(unicode.Mn, c):
+			// the accents of what was decomposed
+		case p.ASCII && c > unicode.MaxASCII:
+			if t, ok := transliterations[c]; ok {
+				b.WriteString(t)
+			} else {
+				b.WriteByte('_')
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+	clean := strings.TrimRight(b.String(), ". ")
+	if clean == "" {
+		clean = "_"
+	}
+	if isReserved(clean) {
+		clean = "_" + clean
+	}
+	maxName := p.MaxName
+	if maxName == 0 {
+		maxName = 255
+	}
+	if p.MaxPath > 0 && p.MaxPath-utf16Len(dir) < maxName {
+		maxName = p.MaxPath - utf16Len(dir)
+	}
+	if utf16Len(clean) > maxName-numberingRoom {
+		clean = shorten(clean, maxName-numberingRoom)
+	}
+	if clean == "" {
+		return "", false
+	}
+	return dir + clean, true
+}
+
+// name cut to n UTF-16 code units, keeping its extension if there is room.
+func shorten(name string, n int) string {
+	ext := path.Ext(name)
+	if utf16Len(ext) >= n/2 {
+		ext = ""
+	}
+	runes := []rune(strings.TrimSuffix(name, ext))
+	for len(runes) > 0 && utf16Len(string(runes))+utf16Len(ext) > n {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), ". ") + ext
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,66 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+  Obligations are things that a policy requires to happen when it
+  allows access, such as a banner on the response or a watermark in
+  the content.  They are keyed by name, with whatever value the
+  policy gave.
+*/
+type Obligations map[string]interface{}
+
+// ObligatedFile is an optional interface for the File objects returned
+// by a FileSystem, for files whose access came with obligations.
+type ObligatedFile interface {
+	Obligations() Obligations
+}
+
+// An ObligationProcessor carries out one kind of obligation on a response
+// before it is served.  It may set headers on w, and may return replacement
+// content.  If it returns an error, the request is refused.
+type ObligationProcessor interface {
+	Process(ctx context.Context, w http.ResponseWriter, r *http.Request, value interface{}, content io.ReadSeeker) (io.ReadSeeker, error)
+}
+
+// fulfill runs the processor for every obligation, in order of name.  An
+// obligation that nobody knows how to carry out refuses the request, since
+// the policy only allowed access on that condition.  Obligations carried out
+// by a content filter for ctype are left to the filter.
+func (h *Handler) fulfill(w http.ResponseWriter, r *http.Request, obligations Obligations, ctype string, content io.ReadSeeker) (io.ReadSeeker, int, error) {
+	names := make([]string, 0, len(obligations))
+	for name := range obligations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := h.Obligations[name]
+		if !ok && h.filtersObligation(name, ctype) {
+			continue
+		}
+		if !ok {
+			return nil, http.StatusForbidden, ErrObligationUnfulfilled
+		}
+		var err error
+		content, err = p.Process(r.Context(), w, r, obligations[name], content)
+		if err != nil {
+			return nil, http.StatusForbidden, err
+		}
+	}
+	return content, 0, nil
+}
+
+func (h *Handler) filtersObligation(name string, ctype string) bool {
+	for _, fr := range h.Filters {
+		if fr.Get && fr.Obligation == name && strings.HasPrefix(ctype, fr.ContentType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+/*
+  A PROPFIND of Depth 1 can ask for a page of a directory, rather than
+  one multistatus of all of it, with Limit and Offset headers:
+
+    PROPFIND /big/ HTTP/1.1
+    Depth: 1
+    Limit: 1000
+    Offset: 3000
+
+  The response has the directory itself, then the Limit entries at
+  most that come after the first Offset, in the order of the File's
+  Readdir.  When there are more, it has a Next-Offset header for the
+  request of the next page, which is left out on the last one, and by
+  servers that do not page, so a client that follows it gets the
+  whole listing from either.
+*/
+const (
+	LimitHeader      = "Limit"
+	OffsetHeader     = "Offset"
+	NextOffsetHeader = "Next-Offset"
+)
+
+// The page that a request asks for, if any.
+func parsePage(h http.Header) (offset, limit int, paged bool, err error) {
+	l, o := h.Get(LimitHeader), h.Get(OffsetHeader)
+	if l == "" && o == "" {
+		return 0, 0, false, nil
+	}
+	if l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			return 0, 0, false, ErrInvalidPage
+		}
+	}
+	if o != "" {
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			return 0, 0, false, ErrInvalidPage
+		}
+	}
+	return offset, limit, true, nil
+}
+
+/*
+  Read the entries of a directory from offset, limit of them at most,
+  or all of the rest if limit is 0, saying whether there are more.
+  It relies on Readdir(n) giving the next n entries at most, and
+  io.EOF when there are none, in the same order on every open.
+*/
+func readPage(f File, offset, limit int) ([]os.FileInfo, bool, error) {
+	for offset > 0 {
+		n := offset
+		if n > 1024 {
+			n = 1024
+		}
+		skipped, err := f.Readdir(n)
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		offset -= len(skipped)
+	}
+	if limit == 0 {
+		page, err := f.Readdir(0)
+		return page, false, err
+	}
+	page := make([]os.FileInfo, 0, limit)
+	for len(page) < limit {
+		fis, err := f.Readdir(limit - len(page))
+		if err == io.EOF {
+			return page, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		page = append(page, fis...)
+	}
+	next, err := f.Readdir(1)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	return page, len(next) > 0, nil
+}
+
+// walkPage is WalkFS of Depth 1, over a page of the directory name.
+func walkPage(ctx context.Context, fs FileSystem, w http.ResponseWriter, name string, info os.FileInfo, offset, limit int, walkFn filepath.WalkFunc) error {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return walkFn(name, info, err)
+	}
+	page, more, err := readPage(f, offset, limit)
+	f.Close()
+	if err != nil {
+		return walkFn(name, info, err)
+	}
+	// before the multistatus starts
+	if more {
+		w.Header().Set(NextOffsetHeader, strconv.Itoa(offset+limit))
+	}
+	if err := walkFn(name, info, nil); err != nil && err != filepath.SkipDir {
+		return err
+	}
+	for _, fileInfo := range page {
+		filename := path.Join(name, fileInfo.Name())
+		fileInfo, err := fs.Stat(ctx, filename)
+		if err != nil {
+			err = walkFn(filename, fileInfo, err)
+		} else {
+			err = WalkFS(ctx, fs, 0, filename, fileInfo, walkFn)
+		}
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// PathMode says how strictly the Handler decodes request paths.
+type PathMode int
+
+const (
+	// PathsCompatible takes paths as net/http decodes them, and leaves
+	// it to the FileSystem to refuse what it cannot resolve.
+	PathsCompatible PathMode = iota
+	// PathsAudit serves paths as PathsCompatible does, but reports those
+	// that PathsStrict would refuse, to see what clients send before
+	// refusing it.
+	PathsAudit
+	// PathsStrict refuses paths that break any of the rules of DecodePath.
+	PathsStrict
+)
+
+func (m PathMode) String() string {
+	switch m {
+	case PathsAudit:
+		return "audit"
+	case PathsStrict:
+		return "strict"
+	}
+	return "compatible"
+}
+
+// ParsePathMode reads a PathMode by name: compatible, audit or strict.
+func ParsePathMode(s string) (PathMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "compatible":
+		return PathsCompatible, nil
+	case "audit":
+		return PathsAudit, nil
+	case "strict":
+		return PathsStrict, nil
+	}
+	return 0, fmt.Errorf("unknown path mode: %s", s)
+}
+
+// PathPolicy says how the Handler decodes request paths, and Destinations.
+type PathPolicy struct {
+	Mode PathMode
+	// Rejected, if non-nil, is told of every path that the rules refuse,
+	// in PathsAudit mode as well as PathsStrict.
+	Rejected func(r *http.Request, err *PathError)
+}
+
+// The rules of DecodePath, as a PathError names them
+const (
+	PathBadEscape    = "bad-escape"
+	PathEncodedSlash = "encoded-slash"
+	PathNUL          = "nul"
+	PathOverlong     = "overlong"
+	PathNotUTF8      = "not-utf8"
+	PathDotSegment   = "dot-segment"
+)
+
+/*
+  A PathError is ErrInvalidPath, with the rule that the path broke.
+  errors.Is(err, ErrInvalidPath) is true of it.
+*/
+type PathError struct {
+	Rule string
+	Path string
+}
+
+func (e *PathError) Error() string {
+	return ErrInvalidPath.Error() + ": " + e.Rule
+}
+
+func (e *PathError) Is(target error) bool {
+	return target == ErrInvalidPath
+}
+
+/*
+  DecodePath decodes a path as it is sent, with its escapes, by these
+  rules, each of which is refused with a PathError that names it:
+
+    bad-escape     a % that is not followed by two hex digits
+    encoded-slash  %2F, which no name can have, and which decoded would
+                   move where the segments of the path fall
+    nul            a NUL, escaped or not
+    overlong       UTF-8 that takes more bytes than it needs, as %C0%AF
+                   does for /, which some decoders take as the shorter
+    not-utf8       any other bytes that are not UTF-8
+    dot-segment    a segment that is . or .., escaped or not, which would
+                   reach outside the collection it is in
+
+  Other escapes are decoded as url.PathUnescape decodes them, and + is
+  left as it is.
+*/
+func DecodePath(escaped string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c == '%' {
+			if i+2 >= len(escaped) || !isHex(escaped[i+1]) || !isHex(escaped[i+2]) {
+				return "", &PathError{Rule: PathBadEscape, Path: escaped}
+			}
+			c = unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+			i += 2
+			if c == '/' {
+				return "", &PathError{Rule: PathEncodedSlash, Path: escaped}
+			}
+		}
+		if c == 0 {
+			return "", &PathError{Rule: PathNUL, Path: escaped}
+		}
+		b.WriteByte(c)
+	}
+	name := b.String()
+	if !utf8.ValidString(name) {
+		if overlong(name) {
+			return "", &PathError{Rule: PathOverlong, Path: escaped}
+		}
+		return "", &PathError{Rule: PathNotUTF8, Path: escaped}
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." {
+			return "", &PathError{Rule: PathDotSegment, Path: escaped}
+		}
+	}
+	return name, nil
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// Whether s has a character encoded in more bytes than UTF-8 needs for it.
+func overlong(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 0xC0 || c == 0xC1:
+			return true
+		case c == 0xE0 && i+1 < len(s) && 0x80 <= s[i+1] && s[i+1] < 0xA0:
+			return true
+		case c == 0xF0 && i+1 < len(s) && 0x80 <= s[i+1] && s[i+1] < 0x90:
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  Check escaped, a path of r or its Destination, against the rules of
+  DecodePath, as the PathPolicy says to.  Only PathsStrict refuses it,
+  but PathsAudit reports it.
+*/
+func (h *Handler) checkPath(r *http.Request, escaped string) error {
+	p := h.Paths
+	if p == nil || p.Mode == PathsCompatible {
+		return nil
+	}
+	_, err := DecodePath(escaped)
+	if err == nil {
+		return nil
+	}
+	if p.Rejected != nil {
+		p.Rejected(r, err.(*PathError))
+	}
+	if p.Mode == PathsStrict {
+		return err
+	}
+	return nil
+}
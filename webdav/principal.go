@@ -0,0 +1,67 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+type principalKey struct{}
+
+// CurrentPrincipal is the href of the principal of the user of ctx, as
+// Handler.Principal gave it, or "" when there is none.
+func CurrentPrincipal(ctx context.Context) string {
+	href, _ := ctx.Value(principalKey{}).(string)
+	return href
+}
+
+// withPrincipal keeps the principal of the user of r in its context, for
+// the properties that need it.
+func (h *Handler) withPrincipal(r *http.Request) *http.Request {
+	if h.Principal == nil || CurrentPrincipal(r.Context()) != "" {
+		return r
+	}
+	href := h.Principal(r.Context())
+	if href == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, href))
+}
+
+// http://www.webdav.org/specs/rfc5397.html#PROPERTY_current-user-principal
+func findCurrentUserPrincipal(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	href := CurrentPrincipal(ctx)
+	if href == "" {
+		return `<D:unauthenticated xmlns:D="DAV:"/>`, nil
+	}
+	var b bytes.Buffer
+	b.WriteString(`<D:href xmlns:D="DAV:">`)
+	xml.EscapeText(&b, []byte(href))
+	b.WriteString(`</D:href>`)
+	return b.String(), nil
+}
+
+/*
+  WellKnown redirects the well-known URIs of WebDAV, CalDAV and CardDAV
+  (RFC 6764) to target, where clients that were only given a host name
+  can find the current user's principal, and from it their files,
+  calendars and address books:
+
+    mux.Handle("/.well-known/", webdav.WellKnown("/dav/"))
+
+  Other well-known URIs are not found.
+*/
+func WellKnown(target string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimSuffix(path.Clean(r.URL.Path), "/") {
+		case "/.well-known/webdav", "/.well-known/caldav", "/.well-known/carddav":
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 // Proppatch describes a property update instruction as defined in RFC 4918.
@@ -99,14 +100,20 @@ type DeadPropsHolder interface {
 	Patch([]Proppatch) ([]Propstat, error)
 }
 
-// liveProps contains all supported, protected DAV: properties.
-var liveProps = map[xml.Name]struct {
+type liveProp struct {
 	// findFn implements the propfind function of this property. If nil,
 	// it indicates a hidden property.
 	findFn func(context.Context, FileSystem, LockSystem, string, os.FileInfo) (string, error)
 	// dir is true if the property applies to directories.
 	dir bool
-}{
+	// custom is true if the property is only given when it is named, as
+	// those added with RegisterLiveProperty are.
+	custom bool
+}
+
+// liveProps contains all supported, protected DAV: properties, and any that
+// were registered.
+var liveProps = map[xml.Name]liveProp{
 	{Space: "DAV:", Local: "resourcetype"}: {
 		findFn: findResourceType,
 		dir:    true,
@@ -151,13 +158,43 @@ var liveProps = map[xml.Name]struct {
 		dir: false,
 	},
 
-	// TODO: The lockdiscovery property requires LockSystem to list the
-	// active locks on a resource.
-	{Space: "DAV:", Local: "lockdiscovery"}: {},
+	{Space: "DAV:", Local: "lockdiscovery"}: {
+		findFn: findLockDiscovery,
+		dir:    true,
+	},
 	{Space: "DAV:", Local: "supportedlock"}: {
 		findFn: findSupportedLock,
 		dir:    true,
 	},
+
+	// Redirect references keep these as dead properties, which only
+	// MKREDIRECTREF and UPDATEREDIRECTREF may change.
+	refTargetName: {
+		findFn: nil,
+		dir:    false,
+	},
+	redirectLifetimeName: {
+		findFn: nil,
+		dir:    false,
+	},
+
+	// Of a BindingFileSystem, given only when named, as they cost a
+	// lookup of the bindings.
+	{Space: "DAV:", Local: "resource-id"}: {
+		findFn: findResourceID,
+		dir:    true,
+		custom: true,
+	},
+	{Space: "DAV:", Local: "parent-set"}: {
+		findFn: findParentSet,
+		dir:    true,
+		custom: true,
+	},
+	{Space: "DAV:", Local: "current-user-principal"}: {
+		findFn: findCurrentUserPrincipal,
+		dir:    true,
+		custom: true,
+	},
 }
 
 // TODO(nigeltao) merge props and allprop?
@@ -195,6 +232,12 @@ func props(ctx context.Context, fs FileSystem, ls LockSystem, name string, pname
 		// Otherwise, it must either be a live property or we don't know it.
 		if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) {
 			innerXML, err := prop.findFn(ctx, fs, ls, name, fi)
+			if prop.custom && errors.Is(err, os.ErrNotExist) {
+				pstatNotFound.Props = append(pstatNotFound.Props, Property{
+					XMLName: pn,
+				})
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -255,6 +298,16 @@ func allprop(ctx context.Context, fs FileSystem, ls LockSystem, name string, inc
 	if err != nil {
 		return nil, err
 	}
+	// Registered live properties can be costly, so they are only included
+	// when asked for.
+	n := 0
+	for _, pn := range pnames {
+		if !liveProps[pn].custom {
+			pnames[n] = pn
+			n++
+		}
+	}
+	pnames = pnames[:n]
 	// Add names from include if they are not already covered in pnames.
 	nameset := make(map[xml.Name]bool)
 	for _, pn := range pnames {
@@ -276,7 +329,7 @@ func patch(ctx context.Context, fs FileSystem, ls LockSystem, name string, patch
 loop:
 	for _, patch := range patches {
 		for _, p := range patch.Props {
-			if _, ok := liveProps[p.XMLName]; ok {
+			if _, ok := liveProps[p.XMLName]; ok || p.XMLName == ContentHashProperty {
 				conflict = true
 				break loop
 			}
@@ -292,7 +345,7 @@ loop:
 		}
 		for _, patch := range patches {
 			for _, p := range patch.Props {
-				if _, ok := liveProps[p.XMLName]; ok {
+				if _, ok := liveProps[p.XMLName]; ok || p.XMLName == ContentHashProperty {
 					pstatForbidden.Props = append(pstatForbidden.Props, Property{XMLName: p.XMLName})
 				} else {
 					pstatFailedDep.Props = append(pstatFailedDep.Props, Property{XMLName: p.XMLName})
@@ -355,10 +408,20 @@ func escapeXML(s string) string {
 }
 
 func findResourceType(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	rtype := ""
 	if fi.IsDir() {
-		return `<D:collection xmlns:D="DAV:"/>`, nil
+		rtype = `<D:collection xmlns:D="DAV:"/>`
+	} else if _, _, ok := redirectRef(ctx, fs, name, fi); ok {
+		return `<D:redirectref xmlns:D="DAV:"/>`, nil
 	}
-	return "", nil
+	for _, fn := range resourceTypes {
+		more, err := fn(ctx, fs, name, fi)
+		if err != nil {
+			return "", err
+		}
+		rtype += more
+	}
+	return rtype, nil
 }
 
 func findDisplayName(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
@@ -458,7 +521,44 @@ func findETag(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi
 	return fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size()), nil
 }
 
+// ETagOf is the ETag that a Handler gives fi, for those that serve files
+// some other way, and want them to agree.
+func ETagOf(ctx context.Context, fi os.FileInfo) (string, error) {
+	return findETag(ctx, nil, nil, "", fi)
+}
+
+// findLockDiscovery lists the locks that cover name: its own, and infinite
+// depth locks on its parents.
+func findLockDiscovery(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	ll, ok := ls.(LockLister)
+	if !ok {
+		return "", nil
+	}
+	now := time.Now()
+	locks, err := ll.ListLocks(now, "/")
+	if err != nil {
+		return "", err
+	}
+	name = SlashClean(name)
+	var buf bytes.Buffer
+	for _, l := range locks {
+		if l.Root != name && (l.ZeroDepth || !covers(l.Root, name)) {
+			continue
+		}
+		timeout := time.Duration(-1)
+		if !l.Expiry.IsZero() {
+			timeout = l.Expiry.Sub(now)
+		}
+		buf.WriteString(activeLockXML(l.Token, l.LockDetails, timeout))
+	}
+	return buf.String(), nil
+}
+
+// We only support exclusive write locks, and none at all without a LockSystem.
 func findSupportedLock(ctx context.Context, fs FileSystem, ls LockSystem, name string, fi os.FileInfo) (string, error) {
+	if ls == nil {
+		return "", nil
+	}
 	return `` +
 		`<D:lockentry xmlns:D="DAV:">` +
 		`<D:lockscope><D:exclusive/></D:lockscope>` +
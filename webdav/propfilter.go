@@ -0,0 +1,63 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+)
+
+// A PropertyFilter says which properties of the resource at name may be
+// shown to the user making the request.  A nil func shows them all.
+type PropertyFilter func(ctx context.Context, name string) func(pname xml.Name) bool
+
+// filterPropstats takes hidden properties out of a PROPFIND response before
+// it is written.  Properties that were asked for by name are reported as
+// missing, as if they did not exist, rather than as forbidden, so that
+// their existence does not leak.  Otherwise they are just left out.
+func (h *Handler) filterPropstats(ctx context.Context, name string, pstats []Propstat, named bool) []Propstat {
+	if h.PropertyFilter == nil {
+		return pstats
+	}
+	visible := h.PropertyFilter(ctx, name)
+	if visible == nil {
+		return pstats
+	}
+	filtered := make([]Propstat, 0, len(pstats))
+	missing := -1
+	var hidden []Property
+	for _, pstat := range pstats {
+		props := make([]Property, 0, len(pstat.Props))
+		for _, p := range pstat.Props {
+			if visible(p.XMLName) {
+				props = append(props, p)
+			} else if named {
+				hidden = append(hidden, Property{XMLName: p.XMLName})
+			}
+		}
+		if pstat.Status == http.StatusNotFound {
+			missing = len(filtered)
+		}
+		if len(props) > 0 || len(pstat.Props) == 0 || pstat.Status == http.StatusNotFound {
+			pstat.Props = props
+			filtered = append(filtered, pstat)
+		}
+	}
+	if len(hidden) > 0 {
+		if missing < 0 {
+			filtered = append(filtered, Propstat{Status: http.StatusNotFound})
+			missing = len(filtered) - 1
+		}
+		filtered[missing].Props = append(filtered[missing].Props, hidden...)
+	}
+	// An empty 404 propstat says nothing
+	kept := filtered[:0]
+	for _, pstat := range filtered {
+		if pstat.Status != http.StatusNotFound || len(pstat.Props) > 0 {
+			kept = append(kept, pstat)
+		}
+	}
+	if len(kept) == 0 {
+		kept = append(kept, Propstat{Status: http.StatusOK})
+	}
+	return kept
+}
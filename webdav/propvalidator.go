@@ -0,0 +1,52 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+)
+
+// A PropertyValidator decides whether a PROPPATCH may set, or remove, the
+// property p of the resource at name.  A non-nil error rejects it, and its
+// message is sent back to the client as the reason.
+type PropertyValidator interface {
+	ValidateProperty(ctx context.Context, name string, p Property, remove bool) error
+}
+
+// validatePatches checks every property in patches before any of them are
+// applied.  It returns nil if they are all allowed.  A PROPPATCH is all or
+// nothing, so if any are rejected, they are reported as 403 Forbidden and
+// the others as 424 Failed Dependency, the same as a protected property.
+func (h *Handler) validatePatches(ctx context.Context, name string, patches []Proppatch) []Propstat {
+	if h.PropertyValidator == nil {
+		return nil
+	}
+	var rejected []Propstat
+	byReason := make(map[string]int)
+	pstatFailedDep := Propstat{Status: StatusFailedDependency}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			err := h.PropertyValidator.ValidateProperty(ctx, name, p, patch.Remove)
+			if err == nil {
+				pstatFailedDep.Props = append(pstatFailedDep.Props, Property{XMLName: p.XMLName})
+				continue
+			}
+			i, ok := byReason[err.Error()]
+			if !ok {
+				i = len(rejected)
+				byReason[err.Error()] = i
+				rejected = append(rejected, Propstat{
+					Status:              http.StatusForbidden,
+					ResponseDescription: err.Error(),
+				})
+			}
+			rejected[i].Props = append(rejected[i].Props, Property{XMLName: p.XMLName})
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	if len(pstatFailedDep.Props) > 0 {
+		rejected = append(rejected, pstatFailedDep)
+	}
+	return rejected
+}
@@ -0,0 +1,24 @@
+package webdav
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+  The most byte ranges that a GET may ask for at once.  A request for
+  more is answered with the whole file, as RFC 7233 allows, rather
+  than a multipart/byteranges with a part for every few bytes.
+*/
+const maxRanges = 100
+
+// Drop the Range of r if it asks for more ranges than are served.
+func limitRanges(r *http.Request) {
+	spec := r.Header.Get("Range")
+	if spec == "" {
+		return
+	}
+	if strings.Count(spec, ",")+1 > maxRanges {
+		r.Header.Del("Range")
+	}
+}
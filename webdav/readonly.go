@@ -0,0 +1,93 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"os"
+)
+
+// ReadOnlyFS serves a FileSystem so that nothing can be changed through
+// it.  Files are only opened for reading, and their dead properties can be
+// read but not patched.
+type ReadOnlyFS struct {
+	FileSystem FileSystem
+}
+
+func (ro ReadOnlyFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return ErrNotAllowed
+}
+
+// OpenFile refuses to make or change files.  PROPPATCH opens for reading and
+// writing, so a file opened that way is opened to read, and it is the file
+// that refuses the patch.
+func (ro ReadOnlyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, ErrNotAllowed
+	}
+	f, err := ro.FileSystem.OpenFile(ctx, name, flag&^os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{f}, nil
+}
+
+func (ro ReadOnlyFS) RemoveAll(ctx context.Context, name string) error {
+	return ErrNotAllowed
+}
+
+func (ro ReadOnlyFS) Rename(ctx context.Context, oldName, newName string) error {
+	return ErrNotAllowed
+}
+
+func (ro ReadOnlyFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return ro.FileSystem.Stat(ctx, name)
+}
+
+// Capabilities are those of the FileSystem, less any that change it.
+func (ro ReadOnlyFS) Capabilities(ctx context.Context, name string) (Capabilities, error) {
+	var c Capabilities
+	if cfs, ok := ro.FileSystem.(CapableFileSystem); ok {
+		var err error
+		if c, err = cfs.Capabilities(ctx, name); err != nil {
+			return c, err
+		}
+	} else if fi, err := ro.FileSystem.Stat(ctx, name); err == nil {
+		c = Capabilities{Exists: true, Dir: fi.IsDir(), Read: true}
+	}
+	c.Write, c.Create, c.Delete = false, false, false
+	return c, nil
+}
+
+// readOnlyFile keeps what the File it wraps says about reading it.
+type readOnlyFile struct {
+	File
+}
+
+func (f readOnlyFile) Write(p []byte) (int, error) {
+	return 0, ErrNotAllowed
+}
+
+// Patch refuses every patch, rather than failing as if something broke.
+func (f readOnlyFile) Patch(patches []Proppatch) ([]Propstat, error) {
+	pstat := Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, Property{XMLName: p.XMLName})
+		}
+	}
+	return []Propstat{pstat}, nil
+}
+
+func (f readOnlyFile) CanRead() bool {
+	if rf, ok := f.File.(ReadCheckedFile); ok {
+		return rf.CanRead()
+	}
+	return true
+}
+
+func (f readOnlyFile) Obligations() Obligations {
+	if of, ok := f.File.(ObligatedFile); ok {
+		return of.Obligations()
+	}
+	return nil
+}
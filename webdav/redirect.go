@@ -0,0 +1,238 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	ixml "github.com/rfielding/webdev/webdav/internal/xml"
+)
+
+/*
+  Redirect references (RFC 4437) are resources that redirect to
+  another url, so that paths can be retired gracefully when a tree is
+  reorganized.  MKREDIRECTREF makes one, and UPDATEREDIRECTREF
+  changes where it goes, or whether for good:
+
+    MKREDIRECTREF /old/report.pdf HTTP/1.1
+
+    <D:mkredirectref xmlns:D="DAV:">
+      <D:reftarget><D:href>/new/report.pdf</D:href></D:reftarget>
+      <D:redirect-lifetime><D:permanent/></D:redirect-lifetime>
+    </D:mkredirectref>
+
+  Any other request of it is answered with a 302, or a 301 when it is
+  permanent, with the target in Location and Redirect-Ref, unless it
+  has Apply-To-Redirect-Ref: T, which acts on the reference itself, so
+  that it can be found, copied, moved and deleted.
+
+  A reference is an empty file with the DAV:reftarget and
+  DAV:redirect-lifetime dead properties, which a PROPPATCH cannot
+  change, so any FileSystem that keeps dead properties can have them.
+*/
+var (
+	refTargetName        = xml.Name{Space: "DAV:", Local: "reftarget"}
+	redirectLifetimeName = xml.Name{Space: "DAV:", Local: "redirect-lifetime"}
+)
+
+// ErrNotRedirectRef is an UPDATEREDIRECTREF of what is not a redirect reference.
+var ErrNotRedirectRef = errors.New("webdav: not a redirect reference")
+
+// http://greenbytes.de/tech/webdav/rfc4437.html#METHOD_MKREDIRECTREF
+type redirectRefInfo struct {
+	XMLName  ixml.Name
+	Target   *string   `xml:"reftarget>href"`
+	Lifetime *lifetime `xml:"redirect-lifetime"`
+}
+
+type lifetime struct {
+	Permanent *struct{} `xml:"permanent"`
+	Temporary *struct{} `xml:"temporary"`
+}
+
+func readRedirectRefInfo(r io.Reader, limits XMLLimits, root string) (ri redirectRefInfo, status int, err error) {
+	body, status, err := limits.read(r)
+	if err != nil {
+		return ri, status, err
+	}
+	if err := ixml.NewDecoder(bytes.NewReader(body)).Decode(&ri); err != nil {
+		return ri, http.StatusBadRequest, err
+	}
+	if ri.XMLName.Space != "DAV:" || ri.XMLName.Local != root {
+		return ri, http.StatusBadRequest, ErrInvalidRedirectRef
+	}
+	if ri.Target != nil && strings.TrimSpace(*ri.Target) == "" {
+		return ri, http.StatusBadRequest, ErrInvalidRedirectRef
+	}
+	return ri, 0, nil
+}
+
+// The dead properties that ri sets.
+func (ri redirectRefInfo) props() []Property {
+	var props []Property
+	if ri.Target != nil {
+		var b bytes.Buffer
+		b.WriteString(`<D:href xmlns:D="DAV:">`)
+		xml.EscapeText(&b, []byte(strings.TrimSpace(*ri.Target)))
+		b.WriteString(`</D:href>`)
+		props = append(props, Property{XMLName: refTargetName, InnerXML: b.Bytes()})
+	}
+	if ri.Lifetime != nil {
+		l := `<D:temporary xmlns:D="DAV:"/>`
+		if ri.Lifetime.Permanent != nil {
+			l = `<D:permanent xmlns:D="DAV:"/>`
+		}
+		props = append(props, Property{XMLName: redirectLifetimeName, InnerXML: []byte(l)})
+	}
+	return props
+}
+
+/*
+  Where name redirects to, and whether for good, if it is a redirect
+  reference.  Only empty files are looked at, so that the rest are
+  not opened for it.
+*/
+func redirectRef(ctx context.Context, fs FileSystem, name string, fi os.FileInfo) (target string, permanent, ok bool) {
+	if fi == nil {
+		var err error
+		if fi, err = fs.Stat(ctx, name); err != nil {
+			return "", false, false
+		}
+	}
+	if fi.IsDir() || fi.Size() != 0 {
+		return "", false, false
+	}
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", false, false
+	}
+	defer f.Close()
+	props, err := f.DeadProps()
+	if err != nil {
+		return "", false, false
+	}
+	t, ok := props[refTargetName]
+	if !ok {
+		return "", false, false
+	}
+	var href struct {
+		Href string `xml:"href"`
+	}
+	if err := ixml.NewDecoder(bytes.NewReader(append(append([]byte("<t>"), t.InnerXML...), "</t>"...))).Decode(&href); err != nil || href.Href == "" {
+		return "", false, false
+	}
+	l := props[redirectLifetimeName]
+	return strings.TrimSpace(href.Href), bytes.Contains(l.InnerXML, []byte("permanent")), true
+}
+
+// Answer r with where it redirects to, if it is of a redirect reference.
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	if r.Method == "MKREDIRECTREF" || r.Method == "UPDATEREDIRECTREF" || r.Header.Get("Apply-To-Redirect-Ref") == "T" {
+		return 0, nil
+	}
+	name, _, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return 0, nil
+	}
+	target, permanent, ok := redirectRef(r.Context(), h.FileSystem, name, nil)
+	if !ok {
+		return 0, nil
+	}
+	location := target
+	if u, err := url.Parse(target); err == nil {
+		location = r.URL.ResolveReference(u).String()
+	}
+	w.Header().Set("Location", location)
+	w.Header().Set("Redirect-Ref", target)
+	if permanent {
+		return http.StatusMovedPermanently, nil
+	}
+	return http.StatusFound, nil
+}
+
+func (h *Handler) handleMkredirectref(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	ri, status, err := readRedirectRefInfo(r.Body, h.xmlLimits(), "mkredirectref")
+	if err != nil {
+		return status, err
+	}
+	if ri.Target == nil {
+		return http.StatusBadRequest, ErrInvalidRedirectRef
+	}
+	ctx := r.Context()
+	if _, err := h.FileSystem.Stat(ctx, reqPath); err == nil {
+		return http.StatusMethodNotAllowed, os.ErrExist
+	}
+	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusConflict, err
+		}
+		return http.StatusForbidden, err
+	}
+	if ri.Lifetime == nil {
+		ri.Lifetime = &lifetime{Temporary: &struct{}{}}
+	}
+	_, patchErr := f.Patch([]Proppatch{{Props: ri.props()}})
+	closeErr := f.Close()
+	if patchErr == nil {
+		patchErr = closeErr
+	}
+	if patchErr != nil {
+		// a file that does not redirect is not what was asked for
+		h.FileSystem.RemoveAll(ctx, reqPath)
+		return http.StatusForbidden, patchErr
+	}
+	return http.StatusCreated, nil
+}
+
+func (h *Handler) handleUpdateredirectref(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	ri, status, err := readRedirectRefInfo(r.Body, h.xmlLimits(), "updateredirectref")
+	if err != nil {
+		return status, err
+	}
+	ctx := r.Context()
+	fi, err := h.FileSystem.Stat(ctx, reqPath)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if _, _, ok := redirectRef(ctx, h.FileSystem, reqPath, fi); !ok {
+		return http.StatusForbidden, ErrNotRedirectRef
+	}
+	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDWR, 0)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+	_, patchErr := f.Patch([]Proppatch{{Props: ri.props()}})
+	closeErr := f.Close()
+	if patchErr != nil {
+		return http.StatusForbidden, patchErr
+	}
+	if closeErr != nil {
+		return http.StatusForbidden, closeErr
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,243 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/rfielding/webdev/webdav/client"
+)
+
+/*
+  A COPY or MOVE whose Destination is on another server is sent there
+  by the Handler's Remote, as the client package would: each
+  directory made with MKCOL, each file streamed with PUT, and dead
+  properties set with PROPPATCH.  A MOVE removes the source once all
+  of it is copied.
+
+    COPY /rob/pics/ HTTP/1.1
+    Destination: https://dr.example.com:8000/rob/pics/
+
+  Remote decides which servers may be sent to, and with whose
+  credentials, refusing the rest with ErrInvalidDestination, which is
+  a 502.  When the destination itself cannot be written the answer is
+  a 502 too, or a 507 if the other server is full.  When only some of
+  the tree could be, the answer is a 207 that names each resource that
+  failed, by its source when it could not be read here, or by its
+  destination when the other server refused it.
+*/
+func (h *Handler) handleRemoteCopyMove(w http.ResponseWriter, r *http.Request, u *url.URL) (status int, err error) {
+	c, err := h.Remote(r, u)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	src, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	dst := u.Path
+	if dst == "" {
+		dst = "/"
+	}
+
+	depth := InfiniteDepth
+	if hdr := r.Header.Get("Depth"); hdr != "" {
+		depth = parseDepth(hdr)
+		if depth != 0 && depth != InfiniteDepth || r.Method == "MOVE" && depth != InfiniteDepth {
+			return http.StatusBadRequest, ErrInvalidDepth
+		}
+	}
+	// a MOVE takes the source away, so it must not be locked by another
+	if r.Method == "MOVE" {
+		release, status, err := h.confirmLocks(r, src, "")
+		if err != nil {
+			return status, err
+		}
+		defer release()
+	}
+
+	ctx := r.Context()
+	info, err := h.FileSystem.Stat(ctx, src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusForbidden, err
+	}
+	// as copyFiles does, the source has to be readable before anything
+	// on the other server is written over
+	if status, err := h.readable(ctx, src, info); err != nil {
+		return status, err
+	}
+
+	created := false
+	if _, err := c.Stat(ctx, dst); err != nil {
+		if !client.IsNotExist(err) {
+			return remoteStatus(err), err
+		}
+		created = true
+	} else {
+		// as Overwrite is for a COPY on this server
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, os.ErrExist
+		}
+		if err := c.Delete(ctx, dst); err != nil && !client.IsNotExist(err) {
+			return remoteStatus(err), err
+		}
+	}
+
+	var failed []remoteFailure
+	if f := h.copyRemote(ctx, c, src, dst, info, depth, &failed); f != nil {
+		// nothing was copied
+		return f.status, f.err
+	}
+	if len(failed) == 0 && r.Method == "MOVE" {
+		if err := h.FileSystem.RemoveAll(ctx, src); err != nil {
+			failed = append(failed, remoteFailure{href: h.href(src, info), status: http.StatusForbidden, err: err})
+		}
+	}
+	if len(failed) > 0 {
+		mw := multistatusWriter{w: w}
+		for _, f := range failed {
+			resp := &response{
+				Href:   []string{f.href},
+				Status: statusLine(f.status),
+			}
+			if err := mw.write(resp); err != nil {
+				return 0, err
+			}
+		}
+		if err := mw.close(); err != nil {
+			return 0, err
+		}
+		return 0, failed[0].err
+	}
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusNoContent, nil
+}
+
+// A resource that a COPY or MOVE to another server could not do
+type remoteFailure struct {
+	href   string
+	status int
+	err    error
+}
+
+/*
+  Copy src, and what is under it to depth, to dst on the other
+  server, noting what fails under it in failed, and going on with the
+  rest.  If src itself fails, that is returned, and nothing under it
+  is tried.
+*/
+func (h *Handler) copyRemote(ctx context.Context, c *client.Client, src, dst string, info os.FileInfo, depth int, failed *[]remoteFailure) *remoteFailure {
+	if err := ctx.Err(); err != nil {
+		// the client, or the job, has given up
+		return &remoteFailure{href: h.href(src, info), status: http.StatusInternalServerError, err: err}
+	}
+	f, err := h.FileSystem.OpenFile(ctx, src, os.O_RDONLY, 0)
+	if err != nil {
+		status := http.StatusForbidden
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		return h.remoteFailed(ctx, src, &remoteFailure{href: h.href(src, info), status: status, err: err})
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil {
+		info = fi
+	}
+	if rf, ok := f.(ReadCheckedFile); ok && !info.IsDir() && !rf.CanRead() {
+		return h.remoteFailed(ctx, src, &remoteFailure{href: h.href(src, info), status: http.StatusForbidden, err: ErrNotAllowed})
+	}
+	if info.IsDir() {
+		err = c.Mkdir(ctx, dst)
+	} else {
+		_, err = c.Put(ctx, dst, f, info.Size())
+	}
+	if err == nil {
+		err = pushProps(ctx, c, dst, f)
+	}
+	if err != nil {
+		return h.remoteFailed(ctx, src, &remoteFailure{href: c.URL(dst), status: remoteStatus(err), err: err})
+	}
+	ProgressOf(ctx).Add(1)
+	if !info.IsDir() || depth != InfiniteDepth {
+		return nil
+	}
+	children, err := f.Readdir(-1)
+	if err != nil {
+		*failed = append(*failed, remoteFailure{href: h.href(src, info), status: http.StatusForbidden, err: err})
+		ProgressOf(ctx).Fail(src, err)
+		return nil
+	}
+	for _, child := range children {
+		if fail := h.copyRemote(ctx, c, path.Join(src, child.Name()), path.Join(dst, child.Name()), child, depth, failed); fail != nil {
+			*failed = append(*failed, *fail)
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Whether src may be read, as copyRemote finds when it opens it.
+func (h *Handler) readable(ctx context.Context, src string, info os.FileInfo) (status int, err error) {
+	f, err := h.FileSystem.OpenFile(ctx, src, os.O_RDONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusForbidden, err
+	}
+	defer f.Close()
+	if rf, ok := f.(ReadCheckedFile); ok && !info.IsDir() && !rf.CanRead() {
+		return http.StatusForbidden, ErrNotAllowed
+	}
+	return 0, nil
+}
+
+func (h *Handler) remoteFailed(ctx context.Context, src string, f *remoteFailure) *remoteFailure {
+	ProgressOf(ctx).Fail(src, f.err)
+	return f
+}
+
+// Set the dead properties of f on dst.
+func pushProps(ctx context.Context, c *client.Client, dst string, f File) error {
+	props, err := f.DeadProps()
+	if err != nil || len(props) == 0 {
+		return err
+	}
+	set := make(map[xml.Name]string, len(props))
+	for n, p := range props {
+		set[n] = string(p.InnerXML)
+	}
+	return c.Proppatch(ctx, dst, set, nil)
+}
+
+// The href of name, as a multistatus has it.
+func (h *Handler) href(name string, info os.FileInfo) string {
+	href := path.Join(h.Prefix, name)
+	if href != "/" && info != nil && info.IsDir() {
+		href += "/"
+	}
+	return (&url.URL{Path: href}).EscapedPath()
+}
+
+// What to answer when the other server failed with err.
+func remoteStatus(err error) int {
+	if se, ok := err.(*client.StatusError); ok && se.StatusCode == http.StatusInsufficientStorage {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusBadGateway
+}
+
+func statusLine(status int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", status, StatusText(status))
+}
@@ -0,0 +1,188 @@
+package webdav_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/client"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// A file system in memory, in which what is named secret may be
+// opened but not read, as a policy would have it
+type unreadableFS struct {
+	webdav.FileSystem
+}
+
+func (u unreadableFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := u.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || path.Base(name) != "secret" {
+		return f, err
+	}
+	return unreadableFile{f}, nil
+}
+
+type unreadableFile struct {
+	webdav.File
+}
+
+func (unreadableFile) CanRead() bool { return false }
+
+// What a COPY is sent from and to
+type remotePair struct {
+	local  *httptest.Server
+	remote *httptest.Server
+	src    webdav.FileSystem
+	dst    webdav.FileSystem
+}
+
+/*
+  A server to copy from, and the other server that its Remote sends
+  to, each over a file system in memory.  refuse, if it gives a
+  status, answers a request to the other server with it.
+*/
+func newRemotePair(t *testing.T, refuse func(r *http.Request) int) *remotePair {
+	p := &remotePair{src: unreadableFS{webdav.NewMemFS()}, dst: webdav.NewMemFS()}
+	other := &webdav.Handler{FileSystem: p.dst, LockSystem: fs.NewMemLS()}
+	p.remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := refuse(r); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		other.ServeHTTP(w, r)
+	}))
+	t.Cleanup(p.remote.Close)
+	p.local = httptest.NewServer(&webdav.Handler{
+		FileSystem: p.src,
+		LockSystem: fs.NewMemLS(),
+		Remote: func(r *http.Request, u *url.URL) (*client.Client, error) {
+			return client.New(p.remote.URL)
+		},
+	})
+	t.Cleanup(p.local.Close)
+	return p
+}
+
+// COPY src, on the server to copy from, to dst on the other
+func (p *remotePair) copy(t *testing.T, src, dst string) (int, string) {
+	req, err := http.NewRequest("COPY", p.local.URL+src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Destination", p.remote.URL+dst)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func writeFile(t *testing.T, fsys webdav.FileSystem, name, content string) {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fsys webdav.FileSystem, name string) string {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// A tree of two files, to copy
+func writePics(t *testing.T, fsys webdav.FileSystem) {
+	if err := fsys.Mkdir(context.Background(), "/pics", 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fsys, "/pics/a", "aaa")
+	writeFile(t, fsys, "/pics/b", "bbb")
+}
+
+func TestRemoteCopy(t *testing.T) {
+	p := newRemotePair(t, func(*http.Request) int { return 0 })
+	writePics(t, p.src)
+	if status, body := p.copy(t, "/pics/", "/pics/"); status != http.StatusCreated {
+		t.Fatalf("COPY: got %d, want 201: %s", status, body)
+	}
+	for _, name := range []string{"/pics/a", "/pics/b"} {
+		if got, want := readFile(t, p.dst, name), readFile(t, p.src, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// What the other server refuses is named in a 207, and the rest is copied
+func TestRemoteCopyPartial(t *testing.T) {
+	p := newRemotePair(t, func(r *http.Request) int {
+		if r.Method == "PUT" && r.URL.Path == "/pics/b" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	writePics(t, p.src)
+	status, body := p.copy(t, "/pics/", "/pics/")
+	if status != http.StatusMultiStatus {
+		t.Fatalf("COPY: got %d, want 207: %s", status, body)
+	}
+	if !strings.Contains(body, p.remote.URL+"/pics/b") || !strings.Contains(body, "502") {
+		t.Errorf("COPY: the 207 does not name /pics/b as a 502: %s", body)
+	}
+	if strings.Contains(body, "/pics/a") {
+		t.Errorf("COPY: the 207 names /pics/a, which was copied: %s", body)
+	}
+	if got := readFile(t, p.dst, "/pics/a"); got != "aaa" {
+		t.Errorf("/pics/a: got %q, want %q", got, "aaa")
+	}
+}
+
+// A full server is a 507, rather than a 502
+func TestRemoteCopyFull(t *testing.T) {
+	p := newRemotePair(t, func(r *http.Request) int {
+		if r.Method == "PUT" {
+			return http.StatusInsufficientStorage
+		}
+		return 0
+	})
+	writeFile(t, p.src, "/a", "aaa")
+	if status, body := p.copy(t, "/a", "/a"); status != http.StatusInsufficientStorage {
+		t.Fatalf("COPY: got %d, want 507: %s", status, body)
+	}
+}
+
+// What may not be read is refused before what it would replace is deleted
+func TestRemoteCopyUnreadable(t *testing.T) {
+	p := newRemotePair(t, func(*http.Request) int { return 0 })
+	writeFile(t, p.src, "/secret", "new")
+	writeFile(t, p.dst, "/secret", "old")
+	if status, body := p.copy(t, "/secret", "/secret"); status != http.StatusForbidden {
+		t.Fatalf("COPY: got %d, want 403: %s", status, body)
+	}
+	if got := readFile(t, p.dst, "/secret"); got != "old" {
+		t.Errorf("/secret: got %q, want %q", got, "old")
+	}
+}
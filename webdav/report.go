@@ -0,0 +1,139 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+)
+
+/*
+  A Report is how an extension of a Handler, such as CalDAV, answers a
+  REPORT: with the properties of the resources that it picked, as a
+  PROPFIND gives them, and any that only the report has:
+
+    return h.ServeReport(w, r, webdav.Report{
+      Names: matching,
+      Props: asked,
+      Computed: func(ctx context.Context, name string, fi os.FileInfo, pname xml.Name) (webdav.Property, bool, error) {
+        if pname != calendarData {
+          return webdav.Property{}, false, nil
+        }
+        ...
+      },
+    })
+*/
+type Report struct {
+	// The resources, in the FileSystem.  Those that are gone, or that
+	// the user may not see, are answered with 404.
+	Names []string
+	// The properties asked for
+	Props []xml.Name
+	// Computed gives a property that only the report has, or ok false to
+	// look it up as usual.  An error that is os.ErrNotExist reports it as
+	// missing, and any other fails the report.
+	Computed func(ctx context.Context, name string, fi os.FileInfo, pname xml.Name) (prop Property, ok bool, err error)
+	// Truncated says that Names were cut short, such as by a limit that
+	// the client asked for, which is answered with 507 for the request.
+	Truncated bool
+}
+
+// ServeReport answers r with rep, as a MethodHandler would.
+func (h *Handler) ServeReport(w http.ResponseWriter, r *http.Request, rep Report) (status int, err error) {
+	ctx := r.Context()
+	mw := multistatusWriter{w: w}
+	if err := mw.writeHeader(); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, name := range rep.Names {
+		fi, err := h.FileSystem.Stat(ctx, name)
+		if err != nil {
+			resp := &response{Href: []string{h.href(name, nil)}, Status: statusLine(http.StatusNotFound)}
+			if err := mw.write(resp); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		pstats, err := h.reportProps(ctx, name, fi, rep)
+		if err != nil {
+			// the multistatus has been started, so it can only be cut short
+			mw.close()
+			return 0, err
+		}
+		href := path.Join(h.Prefix, name)
+		if href != "/" && fi.IsDir() {
+			href += "/"
+		}
+		if err := mw.write(makePropstatResponse(href, pstats)); err != nil {
+			return 0, err
+		}
+	}
+	if rep.Truncated {
+		resp := &response{Href: []string{(&url.URL{Path: r.URL.Path}).EscapedPath()}, Status: statusLine(StatusInsufficientStorage)}
+		if err := mw.write(resp); err != nil {
+			return 0, err
+		}
+	}
+	return 0, mw.close()
+}
+
+// The propstats of name that rep asks for.
+func (h *Handler) reportProps(ctx context.Context, name string, fi os.FileInfo, rep Report) ([]Propstat, error) {
+	var found, missing []Property
+	var rest []xml.Name
+	for _, pn := range rep.Props {
+		if rep.Computed != nil {
+			prop, ok, err := rep.Computed(ctx, name, fi, pn)
+			if ok {
+				if errors.Is(err, os.ErrNotExist) {
+					missing = append(missing, Property{XMLName: pn})
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				prop.XMLName = pn
+				found = append(found, prop)
+				continue
+			}
+		}
+		rest = append(rest, pn)
+	}
+	ls := h.LockSystem
+	if h.lockMode(name) == LockNone {
+		ls = nil
+	}
+	pstats, err := props(ctx, h.FileSystem, ls, name, rest)
+	if err != nil {
+		return nil, err
+	}
+	pstats = h.filterPropstats(ctx, name, pstats, true)
+	pstats = withProps(pstats, http.StatusOK, found)
+	pstats = withProps(pstats, http.StatusNotFound, missing)
+	// props gives an empty 200 when it was asked for nothing
+	n := 0
+	for _, p := range pstats {
+		if len(p.Props) > 0 || len(pstats) == 1 {
+			pstats[n] = p
+			n++
+		}
+	}
+	return pstats[:n], nil
+}
+
+// pstats, with props added to the propstat of status.
+func withProps(pstats []Propstat, status int, props []Property) []Propstat {
+	if len(props) == 0 {
+		return pstats
+	}
+	for i := range pstats {
+		if pstats[i].Status == status {
+			pstats[i].Props = append(pstats[i].Props, props...)
+			return pstats
+		}
+	}
+	return append(pstats, Propstat{Status: status, Props: props})
+}
@@ -0,0 +1,58 @@
+package webdav
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the id of a request, both ways.  A client can set
+// it to have its own id used, and every response says which id it got.
+const RequestIDHeader = "X-Request-ID"
+
+// Ids longer than this, or with anything but printable ASCII, are replaced.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is the id of the request that ctx belongs to, if it has one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID gives every request an id before h sees it, so that what is
+// logged about a request can be tied back to what the client saw.
+func WithRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+// withRequestID does nothing if r already has an id.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if RequestID(r.Context()) != "" {
+		return r
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		var b [16]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package testsuite
+
+import (
+	"context"
+	"os"
+)
+
+// TB is the part of testing.TB that AssertLitmus needs, so that this package is not a test.
+type TB interface {
+	Helper()
+	Logf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Skipf(format string, args ...interface{})
+}
+
+/*
+  AssertLitmus runs litmus against a new server, and fails t for
+  every test in the pass list that did not pass.  Tests that pass
+  and are not in the list are logged, to be added to it.  It skips
+  when litmus is not installed, so that it can be in a test that
+  runs everywhere:
+
+    func TestLitmus(t *testing.T) {
+        testsuite.AssertLitmus(t, testsuite.Options{}, testsuite.Litmus{}, "testdata/litmus.pass")
+    }
+*/
+func AssertLitmus(t TB, opts Options, l Litmus, passListFile string) {
+	t.Helper()
+	passList, err := ReadPassList(passListFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("cannot read pass list: %v", err)
+	}
+	if opts.Username == "" {
+		opts.Username, opts.Password = "litmus", "litmus"
+	}
+	srv, err := Start(opts)
+	if err != nil {
+		t.Fatalf("cannot start server: %v", err)
+	}
+	defer srv.Close()
+	report, err := l.Run(context.Background(), srv.URL, opts.Username, opts.Password)
+	if err == ErrNoLitmus {
+		t.Skipf("%v", err)
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, res := range report.Regressions(passList) {
+		t.Errorf("%s: %s %s", res.ID, res.Status, res.Reason)
+	}
+	expected := make(map[string]bool)
+	for _, id := range passList {
+		expected[id] = true
+	}
+	for _, id := range report.Passed() {
+		if !expected[id] {
+			t.Logf("%s passes, and is not in %s", id, passListFile)
+		}
+	}
+}
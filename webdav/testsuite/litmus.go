@@ -0,0 +1,231 @@
+package testsuite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The suites that litmus runs unless told otherwise.
+var DefaultSuites = []string{"basic", "copymove", "props", "locks", "http"}
+
+// Litmus says how to run litmus.
+type Litmus struct {
+	// The litmus script.  Default is $LITMUS, or litmus on the PATH.
+	Binary string
+	// The suites to run.  Default is DefaultSuites.
+	Suites []string
+	// How long all of the suites may take.  Default is five minutes.
+	Timeout time.Duration
+	// Where to copy what litmus prints, such as os.Stdout, or nil for nowhere
+	Output io.Writer
+}
+
+// Status is how a litmus test went.
+type Status string
+
+const (
+	Pass    = Status("pass")
+	Fail    = Status("FAIL")
+	Skipped = Status("SKIPPED")
+)
+
+/*
+  Result is one litmus test.  Its ID is the suite, number and name,
+  as basic/3.put_get, since some names are used more than once in
+  a suite.
+*/
+type Result struct {
+	ID       string
+	Suite    string
+	Number   int
+	Name     string
+	Status   Status
+	Reason   string
+	Warnings []string
+}
+
+// Report is the results of a run of litmus, in the order they were run.
+type Report struct {
+	Results []Result
+}
+
+// ErrNoLitmus is returned by Run when there is no litmus to run.
+var ErrNoLitmus = fmt.Errorf("litmus is not installed")
+
+// Run runs litmus against url, as username and password if username is not "".
+func (l Litmus) Run(ctx context.Context, url, username, password string) (*Report, error) {
+	binary := l.Binary
+	if binary == "" {
+		binary = os.Getenv("LITMUS")
+	}
+	if binary == "" {
+		binary = "litmus"
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, ErrNoLitmus
+	}
+	suites := l.Suites
+	if len(suites) == 0 {
+		suites = DefaultSuites
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	args := []string{url}
+	if username != "" {
+		args = append(args, username, password)
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), "TESTS="+strings.Join(suites, " "))
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if l.Output != nil {
+		cmd.Stdout = io.MultiWriter(&out, l.Output)
+	}
+	cmd.Stderr = cmd.Stdout
+	// litmus exits non-zero when a test fails, which the report says better
+	runErr := cmd.Run()
+	report, err := ParseLitmus(&out)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Results) == 0 {
+		if runErr != nil {
+			return nil, fmt.Errorf("litmus: %v", runErr)
+		}
+		return nil, fmt.Errorf("litmus ran no tests")
+	}
+	return report, nil
+}
+
+var (
+	litmusSuite  = regexp.MustCompile("^-> running `([^']+)'")
+	litmusTest   = regexp.MustCompile(`^\s*(\d+)\.\s+(\S+?)\.*\s+(pass|FAIL|SKIPPED|WARNING:)\s*(.*)$`)
+	litmusResult = regexp.MustCompile(`^\s+\.+\s+(pass|FAIL|SKIPPED)\s*(.*)$`)
+)
+
+/*
+  ParseLitmus reads what litmus prints, which is a line for each
+  test, or for a test that warns, the warning and then a line with
+  how it went:
+
+    -> running `basic':
+     0. init.................. pass
+     9. delete_fragment....... WARNING: DELETE removed collection resource with Request-URI including fragment; unsafe
+        ...................... pass (with 1 warning)
+*/
+func ParseLitmus(r io.Reader) (*Report, error) {
+	report := &Report{}
+	suite := ""
+	var open *Result
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := litmusSuite.FindStringSubmatch(line); m != nil {
+			suite, open = m[1], nil
+			continue
+		}
+		if m := litmusTest.FindStringSubmatch(line); m != nil && suite != "" {
+			n, _ := strconv.Atoi(m[1])
+			report.Results = append(report.Results, Result{
+				ID:     fmt.Sprintf("%s/%d.%s", suite, n, m[2]),
+				Suite:  suite,
+				Number: n,
+				Name:   m[2],
+			})
+			open = &report.Results[len(report.Results)-1]
+			if m[3] == "WARNING:" {
+				open.Warnings = append(open.Warnings, m[4])
+				continue
+			}
+			open.Status, open.Reason = Status(m[3]), trimParens(m[4])
+			open = nil
+			continue
+		}
+		if m := litmusResult.FindStringSubmatch(line); m != nil && open != nil {
+			open.Status, open.Reason = Status(m[1]), trimParens(m[2])
+			open = nil
+			continue
+		}
+		if open != nil && strings.HasPrefix(strings.TrimSpace(line), "WARNING:") {
+			open.Warnings = append(open.Warnings, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "WARNING:")))
+		}
+	}
+	return report, scanner.Err()
+}
+
+func trimParens(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "("), ")")
+}
+
+// Passed is the tests that passed, as IDs, in order.
+func (r *Report) Passed() []string {
+	passed := make([]string, 0)
+	for _, res := range r.Results {
+		if res.Status == Pass {
+			passed = append(passed, res.ID)
+		}
+	}
+	return passed
+}
+
+// Regressions is the tests in the pass list that did not pass, with how they went.
+func (r *Report) Regressions(passList []string) []Result {
+	byID := make(map[string]Result)
+	for _, res := range r.Results {
+		byID[res.ID] = res
+	}
+	regressions := make([]Result, 0)
+	for _, id := range passList {
+		res, ok := byID[id]
+		if !ok {
+			res = Result{ID: id, Status: Skipped, Reason: "not run"}
+		}
+		if res.Status != Pass {
+			regressions = append(regressions, res)
+		}
+	}
+	return regressions
+}
+
+// ReadPassList reads the tests that are expected to pass, one ID a line, with # for comments.
+func ReadPassList(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	passList := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			passList = append(passList, line)
+		}
+	}
+	return passList, nil
+}
+
+// WritePassList records the tests that passed, as the list to expect from now on.
+func WritePassList(file string, r *Report) error {
+	var b strings.Builder
+	b.WriteString("# litmus tests that are expected to pass, written by webdavctl litmus -update\n")
+	for _, id := range r.Passed() {
+		b.WriteString(id + "\n")
+	}
+	return ioutil.WriteFile(file, []byte(b.String()), 0644)
+}
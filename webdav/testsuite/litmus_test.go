@@ -0,0 +1,12 @@
+package testsuite
+
+import (
+	"testing"
+
+	"github.com/rfielding/webdev/webdav"
+)
+
+// The handler over a file system in memory, so that what fails is the protocol.
+func TestLitmus(t *testing.T) {
+	AssertLitmus(t, Options{FileSystem: webdav.NewMemFS()}, Litmus{}, "testdata/litmus.pass")
+}
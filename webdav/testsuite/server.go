@@ -0,0 +1,137 @@
+/*
+  Package testsuite runs the handler as a server that the litmus
+  WebDAV compliance suite can be pointed at, and compares what litmus
+  reports with a list of the tests that are known to pass, so that CI
+  can tell when a part of RFC 4918 regresses.
+
+    srv, err := testsuite.Start(testsuite.Options{Username: "litmus", Password: "litmus"})
+    ...
+    defer srv.Close()
+    report, err := testsuite.Litmus{}.Run(ctx, srv.URL, "litmus", "litmus")
+
+  The server is the handler over this package's file system in a
+  temporary directory, with a policy that allows everything, so that
+  what litmus finds is the protocol and not the policy, or over the
+  FileSystem in Options, such as webdav.NewMemFS(), to see what the
+  handler does with nothing under it.
+*/
+package testsuite
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/rfielding/webdev/webdav"
+	"github.com/rfielding/webdev/webdav/fs"
+)
+
+// Options are the knobs of the server that litmus runs against.
+type Options struct {
+	// Where to listen.  Default is any free port on 127.0.0.1.
+	Addr string
+	// The url path to serve under, which litmus is given as part of the url
+	Prefix string
+	// Basic auth to ask for, or none if Username is ""
+	Username string
+	Password string
+	// The directory to serve.  Default is a new temporary one, removed by Close.
+	Root string
+	// What to serve instead of a directory, when it is not nil
+	FileSystem webdav.FileSystem
+	// The policy of the directory.  Default is one that allows everything.
+	Policy func(ctx context.Context, action fs.Action) map[string]interface{}
+	// Called for every request, as webdav.Handler's Logger is
+	Logger func(*http.Request, error)
+}
+
+// Server is a running handler, at URL.
+type Server struct {
+	URL  string
+	Root string
+
+	srv     *http.Server
+	tempDir bool
+}
+
+// AllowAll is a policy that allows everything to everybody.
+func AllowAll(ctx context.Context, action fs.Action) map[string]interface{} {
+	return map[string]interface{}{
+		string(fs.AllowCreate): true,
+		string(fs.AllowRead):   true,
+		string(fs.AllowWrite):  true,
+		string(fs.AllowDelete): true,
+		string(fs.AllowStat):   true,
+	}
+}
+
+// Start serves the handler as opts say, until Close.
+func Start(opts Options) (*Server, error) {
+	s := &Server{Root: opts.Root}
+	if s.Root == "" && opts.FileSystem == nil {
+		dir, err := ioutil.TempDir("", "webdev-litmus")
+		if err != nil {
+			return nil, err
+		}
+		s.Root, s.tempDir = dir, true
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy = AllowAll
+	}
+	locks := fs.NewMemLS()
+	var fsys webdav.FileSystem = fs.FS{Root: s.Root, Locks: locks, PermissionHandler: policy}
+	if opts.FileSystem != nil {
+		fsys = opts.FileSystem
+	}
+	var handler http.Handler = &webdav.Handler{
+		Prefix:     opts.Prefix,
+		FileSystem: fsys,
+		LockSystem: locks,
+		Logger:     opts.Logger,
+	}
+	if opts.Username != "" {
+		handler = basicAuth(opts.Username, opts.Password, handler)
+	}
+	addr := opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.removeRoot()
+		return nil, err
+	}
+	s.URL = "http://" + ln.Addr().String() + opts.Prefix + "/"
+	s.srv = &http.Server{Handler: handler}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// Close stops the server, and removes what it served if it made the directory.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	s.removeRoot()
+	return err
+}
+
+func (s *Server) removeRoot() {
+	if s.tempDir {
+		os.RemoveAll(s.Root)
+	}
+}
+
+// The user is in the context as username, as the example server puts it.
+func basicAuth(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="litmus"`)
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "username", u)))
+	})
+}
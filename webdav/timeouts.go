@@ -0,0 +1,220 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+  Timeouts bound how long a request may take, by method, and how slowly
+  a client may send a body or take a response, so that clients that
+  stall cannot hold goroutines and open files forever.
+
+  A request that goes over its time has its context cancelled, and a
+  client that is slower than MinRate has its connection closed, which
+  is the only way to stop a handler that is blocked reading from it or
+  writing to it.  The rate is only measured over the time that the
+  handler spends waiting on the client, so a COPY that takes a while
+  on the volume is not taken for a stall.
+*/
+type Timeouts struct {
+	// How long requests of each method may take.  Zero is no limit, for
+	// methods such as GET and PUT that are only as long as their body.
+	Methods map[string]time.Duration
+	// How long requests of methods that are not in Methods may take
+	Default time.Duration
+	// The slowest, in bytes a second, that a client may send or take a body
+	MinRate int64
+	// How long the rate is measured over.  Default is 30 seconds.
+	RateWindow time.Duration
+}
+
+// DefaultTimeouts suit a server of files of any size, over any link that is
+// not dead.
+func DefaultTimeouts() *Timeouts {
+	return &Timeouts{
+		Methods: map[string]time.Duration{
+			"GET":       0,
+			"HEAD":      time.Minute,
+			"PUT":       0,
+			"POST":      0,
+			"PROPFIND":  2 * time.Minute,
+			"PROPPATCH": time.Minute,
+			"LOCK":      time.Minute,
+			"UNLOCK":    time.Minute,
+			"MKCOL":     time.Minute,
+			"DELETE":    10 * time.Minute,
+			"COPY":      30 * time.Minute,
+			"MOVE":      30 * time.Minute,
+		},
+		Default:    5 * time.Minute,
+		MinRate:    1024,
+		RateWindow: 30 * time.Second,
+	}
+}
+
+// Timeout is how long a request of method may take, or zero for no limit.
+func (t *Timeouts) Timeout(method string) time.Duration {
+	if d, ok := t.Methods[method]; ok {
+		return d
+	}
+	return t.Default
+}
+
+type connKey struct{}
+
+// ConnContext is for http.Server's ConnContext, so that WithTimeouts can
+// close the connection of a client that stalls.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// WithTimeouts holds requests to h to t.  It does nothing if t is nil.
+func WithTimeouts(h http.Handler, t *Timeouts) http.Handler {
+	if t == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if d := t.Timeout(r.Method); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		// only a connection of its own can be closed without hurting other requests
+		conn, _ := ctx.Value(connKey{}).(net.Conn)
+		if t.MinRate <= 0 || conn == nil || r.ProtoMajor != 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		m := &transferMeter{}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &meteredBody{ReadCloser: r.Body, m: m}
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go m.watch(ctx, done, conn, t, r)
+		h.ServeHTTP(&meteredWriter{ResponseWriter: w, m: m}, r)
+	})
+}
+
+/*
+  transferMeter counts the bytes of a request and response, and the
+  time spent waiting for the client to send or take them.
+*/
+type transferMeter struct {
+	mu      sync.Mutex
+	bytes   int64
+	waited  time.Duration
+	started time.Time
+	busy    int
+}
+
+func (m *transferMeter) begin() {
+	m.mu.Lock()
+	if m.busy == 0 {
+		m.started = time.Now()
+	}
+	m.busy++
+	m.mu.Unlock()
+}
+
+func (m *transferMeter) end(n int) {
+	m.mu.Lock()
+	m.busy--
+	if m.busy == 0 {
+		m.waited += time.Since(m.started)
+	}
+	m.bytes += int64(n)
+	m.mu.Unlock()
+}
+
+// What has been transferred, and waited for, so far.
+func (m *transferMeter) sample() (int64, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	waited := m.waited
+	if m.busy > 0 {
+		waited += time.Since(m.started)
+	}
+	return m.bytes, waited
+}
+
+/*
+  Close the connection when the client is too slow, or the request
+  has gone over its time.  A window in which the handler mostly did
+  not wait on the client says nothing about the client.
+*/
+func (m *transferMeter) watch(ctx context.Context, done chan struct{}, conn net.Conn, t *Timeouts, r *http.Request) {
+	window := t.RateWindow
+	if window <= 0 {
+		window = 30 * time.Second
+	}
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	lastBytes, lastWaited := int64(0), time.Duration(0)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			// the handler should stop, but may be blocked on the client
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				Log().Warn("closing connection of request over its time", "request_id", RequestID(ctx), "method", r.Method, "url", r.URL)
+				conn.Close()
+			}
+			return
+		case <-ticker.C:
+			bytes, waited := m.sample()
+			b, w := bytes-lastBytes, waited-lastWaited
+			lastBytes, lastWaited = bytes, waited
+			if w < window/2 {
+				continue
+			}
+			if rate := int64(float64(b) / w.Seconds()); rate < t.MinRate {
+				Log().Warn("closing connection of slow client", "request_id", RequestID(ctx), "method", r.Method, "url", r.URL, "rate", rate)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	m *transferMeter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	b.m.begin()
+	n, err := b.ReadCloser.Read(p)
+	b.m.end(n)
+	return n, err
+}
+
+type meteredWriter struct {
+	http.ResponseWriter
+	m *transferMeter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	w.m.begin()
+	n, err := w.ResponseWriter.Write(p)
+	w.m.end(n)
+	return n, err
+}
+
+func (w *meteredWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.m.begin()
+		f.Flush()
+		w.m.end(0)
+	}
+}
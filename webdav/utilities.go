@@ -100,6 +100,9 @@ func CopyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		return http.StatusInternalServerError, err
 	}
 	srcPerm := srcStat.Mode() & os.ModePerm
+	if rf, ok := srcFile.(ReadCheckedFile); ok && !srcStat.IsDir() && !rf.CanRead() {
+		return http.StatusForbidden, ErrNotAllowed
+	}
 
 	created := false
 	if _, err := fs.Stat(ctx, dst); err != nil {
@@ -119,6 +122,9 @@ func CopyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 
 	if srcStat.IsDir() {
 		if err := fs.Mkdir(ctx, dst, srcPerm); err != nil {
+			if err == ErrNameCollision {
+				return http.StatusConflict, err
+			}
 			return http.StatusForbidden, err
 		}
 		if depth == InfiniteDepth {
@@ -127,12 +133,17 @@ func CopyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 				return http.StatusForbidden, err
 			}
 			for _, c := range children {
+				if err := ctx.Err(); err != nil {
+					// the client, or the job, has given up
+					return http.StatusInternalServerError, err
+				}
 				name := c.Name()
 				s := path.Join(src, name)
 				d := path.Join(dst, name)
 				cStatus, cErr := CopyFiles(ctx, fs, s, d, overwrite, depth, recursion)
 				if cErr != nil {
 					// TODO: MultiStatus.
+					ProgressOf(ctx).Fail(s, cErr)
 					return cStatus, cErr
 				}
 			}
@@ -141,7 +152,7 @@ func CopyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	} else {
 		dstFile, err := fs.OpenFile(ctx, dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, srcPerm)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if os.IsNotExist(err) || err == ErrNameCollision {
 				return http.StatusConflict, err
 			}
 			return http.StatusForbidden, err
@@ -161,6 +172,7 @@ func CopyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		}
 	}
 
+	ProgressOf(ctx).Add(1)
 	if created {
 		return http.StatusCreated, nil
 	}
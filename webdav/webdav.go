@@ -6,6 +6,7 @@
 package webdav
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +15,8 @@ import (
 	"path"
 	"strings"
 	"time"
+
+	"github.com/rfielding/webdev/webdav/client"
 )
 
 type Handler struct {
@@ -26,6 +29,54 @@ type Handler struct {
 	// Logger is an optional error logger. If non-nil, it will be called
 	// for all HTTP requests.
 	Logger func(*http.Request, error)
+	// Obligations carry out the obligations that a FileSystem attaches
+	// to files, keyed by obligation name.
+	Obligations map[string]ObligationProcessor
+	// Filters transform content as it is served or uploaded, selected by
+	// obligation or content type.  They are applied in order.
+	Filters []FilterRule
+	// PropertyFilter hides properties from PROPFIND responses.
+	PropertyFilter PropertyFilter
+	// PropertyValidator restricts which properties PROPPATCH may change.
+	PropertyValidator PropertyValidator
+	// FileNamePolicy, if non-nil, restricts the names that resources may
+	// be made with.
+	FileNamePolicy FileNamePolicy
+	// LockModes restricts locking in the subtrees under some paths.  The
+	// longest path that covers a resource decides its mode.
+	LockModes map[string]LockMode
+	// SecurityHeaders, if non-nil, are set on every response.
+	SecurityHeaders *SecurityHeaders
+	// XMLLimits bound request bodies.  If nil, DefaultXMLLimits are used.
+	XMLLimits *XMLLimits
+	// Jobs, if non-nil, run COPY, MOVE and DELETE of collections in the
+	// background for clients that prefer it.
+	Jobs *Jobs
+	// Remote, if non-nil, is the client to COPY or MOVE with to a
+	// Destination on another server, which it refuses with
+	// ErrInvalidDestination if it is not one that may be sent to.
+	Remote func(r *http.Request, dst *url.URL) (*client.Client, error)
+	// Methods serve the methods they have in place of the built in
+	// ones, or as well as them.  See DefaultMethod.
+	Methods map[string]MethodHandler
+	// Hooks, if non-nil, can veto, redirect or annotate every request.
+	Hooks *Hooks
+	// Mounts serve other FileSystems under paths below Prefix.  Once the
+	// Handler is serving, they are replaced with SetMounts.
+	Mounts map[string]Mount
+	// Principal, if non-nil, gives the href of the principal of the user
+	// of a request, for DAV:current-user-principal, or "" for none.
+	Principal func(ctx context.Context) string
+	// Destinations, if non-nil, names the Handler as a proxy in front of
+	// it publishes it, for Destination headers.
+	Destinations *DestinationPolicy
+	// Paths, if non-nil, decodes request paths more strictly than
+	// net/http does, or reports those it would refuse.
+	Paths *PathPolicy
+
+	// for the Handler of a mount, what it is mounted on, and where
+	mountOf   *Handler
+	mountedAt string
 }
 
 func (h *Handler) stripPrefix(p string) (string, int, error) {
@@ -39,37 +90,50 @@ func (h *Handler) stripPrefix(p string) (string, int, error) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if mh, ok := h.mounted(r.URL.Path); ok {
+		mh.ServeHTTP(w, r)
+		return
+	}
+	r = withRequestID(w, r)
+	r = h.withPrincipal(r)
+	h.SecurityHeaders.Set(w, r)
 	status, err := http.StatusBadRequest, ErrUnsupportedMethod
 	if h.FileSystem == nil {
 		status, err = http.StatusInternalServerError, ErrNoFileSystem
 	} else if h.LockSystem == nil {
 		status, err = http.StatusInternalServerError, ErrNoLockSystem
+	} else if err = h.checkPath(r, r.URL.EscapedPath()); err != nil {
+		status = http.StatusBadRequest
+	} else if w, r, status, err = h.before(w, r); status != 0 {
+		// a hook has vetoed it
+	} else if status, err = h.redirect(w, r); status != 0 {
+		// it is of a redirect reference
+	} else if h.wantsJob(r) && h.startJob(w, r) {
+		// the job logs the request when it is over
+		return
+	} else if m := h.method(r.Method); m != nil {
+		status, err = m(w, r)
 	} else {
-		switch r.Method {
-		case "OPTIONS":
-			status, err = h.handleOptions(w, r)
-		case "GET", "HEAD", "POST":
-			status, err = h.handleGetHeadPost(w, r)
-		case "DELETE":
-			status, err = h.handleDelete(w, r)
-		case "PUT":
-			status, err = h.handlePut(w, r)
-		case "MKCOL":
-			status, err = h.handleMkcol(w, r)
-		case "COPY", "MOVE":
-			status, err = h.handleCopyMove(w, r)
-		case "LOCK":
-			status, err = h.handleLock(w, r)
-		case "UNLOCK":
-			status, err = h.handleUnlock(w, r)
-		case "PROPFIND":
-			status, err = h.handlePropfind(w, r)
-		case "PROPPATCH":
-			status, err = h.handleProppatch(w, r)
-		}
-	}
-
-	if status != 0 {
+		status, err = http.StatusBadRequest, ErrUnsupportedMethod
+	}
+
+	if e, ok := errorCodeOf(err); ok && status != 0 {
+		// whatever the method made of it, it is answered the same way
+		status = e.status
+	}
+	if status == http.StatusForbidden || status == http.StatusNotFound {
+		if name, _, perr := h.stripPrefix(r.URL.Path); perr == nil {
+			status, err = h.disclose(r.Context(), name, status, err)
+		}
+	}
+	if hw, ok := w.(*hookWriter); ok {
+		hw.err = err
+		// for a method that wrote nothing, which is a 200
+		defer hw.after(http.StatusOK)
+	}
+	if e, ok := errorCodeOf(err); ok && status == e.status {
+		writeError(w, r, e, err)
+	} else if status != 0 {
 		w.WriteHeader(status)
 		if status != http.StatusNoContent {
 			w.Write([]byte(StatusText(status)))
@@ -96,6 +160,23 @@ func (h *Handler) lock(now time.Time, root string) (token string, status int, er
 }
 
 func (h *Handler) confirmLocks(r *http.Request, src, dst string) (release func(), status int, err error) {
+	unlock, status, err := h.lockBindings(r, src, dst)
+	if err != nil {
+		return nil, status, err
+	}
+	confirmed, status, err := h.confirmNames(r, src, dst)
+	if err != nil {
+		unlock()
+		return nil, status, err
+	}
+	return func() {
+		confirmed()
+		unlock()
+	}, 0, nil
+}
+
+// confirmLocks for src and dst themselves, without their other bindings.
+func (h *Handler) confirmNames(r *http.Request, src, dst string) (release func(), status int, err error) {
 	hdr := r.Header.Get("If")
 	if hdr == "" {
 		// An empty If header means that the client hasn't previously created locks.
@@ -140,11 +221,8 @@ func (h *Handler) confirmLocks(r *http.Request, src, dst string) (release func()
 		if lsrc == "" {
 			lsrc = src
 		} else {
-			u, err := url.Parse(lsrc)
-			if err != nil {
-				continue
-			}
-			if u.Host != r.Host {
+			u, local, err := h.destination(r, lsrc)
+			if err != nil || !local {
 				continue
 			}
 			lsrc, status, err = h.stripPrefix(u.Path)
@@ -153,6 +231,9 @@ func (h *Handler) confirmLocks(r *http.Request, src, dst string) (release func()
 			}
 		}
 		release, err = h.LockSystem.Confirm(time.Now(), lsrc, dst, l.conditions...)
+		if err == ErrConfirmationFailed {
+			release, err = h.confirmBound(r.Context(), time.Now(), lsrc, dst, l.conditions)
+		}
 		if err == ErrConfirmationFailed {
 			continue
 		}
@@ -174,17 +255,20 @@ func (h *Handler) handleOptions(w http.ResponseWriter, r *http.Request) (status
 		return status, err
 	}
 	ctx := r.Context()
-	allow := "OPTIONS, LOCK, PUT, MKCOL"
-	if fi, err := h.FileSystem.Stat(ctx, reqPath); err == nil {
-		if fi.IsDir() {
-			allow = "OPTIONS, LOCK, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND"
-		} else {
-			allow = "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT"
-		}
+	_, allow, err := h.Allowed(ctx, reqPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
 	}
-	w.Header().Set("Allow", allow)
 	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
-	w.Header().Set("DAV", "1, 2")
+	class := "1, 2, redirectrefs"
+	if h.lockMode(reqPath) == LockNone {
+		class = "1, redirectrefs"
+	}
+	if _, ok := h.FileSystem.(BindingFileSystem); ok {
+		class += ", bind"
+	}
+	w.Header().Set("Allow", strings.Join(append(allow, h.addedMethods()...), ", "))
+	w.Header().Set("DAV", class)
 	// http://msdn.microsoft.com/en-au/library/cc250217.aspx
 	w.Header().Set("MS-Author-Via", "DAV")
 	return 0, nil
@@ -197,6 +281,7 @@ func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (sta
 	}
 	// TODO: check locks for read-only access??
 	ctx := r.Context()
+	limitRanges(r)
 	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDONLY, 0)
 	if err != nil {
 		return http.StatusNotFound, err
@@ -209,13 +294,54 @@ func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (sta
 	if fi.IsDir() {
 		return http.StatusMethodNotAllowed, nil
 	}
-	etag, err := findETag(ctx, h.FileSystem, h.LockSystem, reqPath, fi)
-	if err != nil {
-		return http.StatusInternalServerError, err
+	if rf, ok := f.(ReadCheckedFile); ok && !rf.CanRead() {
+		return http.StatusForbidden, ErrNotAllowed
+	}
+	var content io.ReadSeeker = f
+	var obligations Obligations
+	if of, ok := f.(ObligatedFile); ok {
+		obligations = of.Obligations()
+	}
+	var ctype string
+	var rules []FilterRule
+	if len(obligations) > 0 || len(h.Filters) > 0 {
+		ctype = contentTypeOf(reqPath, content)
+		rules = h.filtersFor(obligations, ctype, false)
+	}
+	if len(obligations) > 0 {
+		content, status, err = h.fulfill(w, r, obligations, ctype, content)
+		if err != nil {
+			return status, err
+		}
+		// What one user gets is not necessarily what another gets
+		w.Header().Set("Cache-Control", "private")
+	}
+	if len(rules) > 0 {
+		// Filtered content is spooled before any of it is sent, so that a
+		// filter that fails is answered with an error rather than a short
+		// 200.  It has a length, and ranges, but no ETag.
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Cache-Control", "private")
+		if r.Method == "HEAD" {
+			return 0, nil
+		}
+		spool, status, err := spoolFiltered(ctx, r, rules, obligations, ctype, content)
+		if err != nil {
+			return status, err
+		}
+		defer spool.Close()
+		http.ServeContent(w, r, reqPath, fi.ModTime(), spool)
+		return 0, nil
+	}
+	if content == io.ReadSeeker(f) {
+		etag, err := findETag(ctx, h.FileSystem, h.LockSystem, reqPath, fi)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		w.Header().Set("ETag", etag)
 	}
-	w.Header().Set("ETag", etag)
 	// Let ServeContent determine the Content-Type header.
-	http.ServeContent(w, r, reqPath, fi.ModTime(), f)
+	http.ServeContent(w, r, reqPath, fi.ModTime(), content)
 	return 0, nil
 }
 
@@ -254,6 +380,9 @@ func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) (status int,
 	if err != nil {
 		return status, err
 	}
+	if reqPath, status, err = h.checkName(w, r, reqPath, true); err != nil {
+		return status, err
+	}
 	release, status, err := h.confirmLocks(r, reqPath, "")
 	if err != nil {
 		return status, err
@@ -263,11 +392,67 @@ func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) (status int,
 	// comments in http.checkEtag.
 	ctx := r.Context()
 
+	sums, err := requestChecksums(r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if expectsContinue(r) || len(sums) > 0 {
+		// refuse it before the body is sent, or spooled
+		if err := h.confirmPut(ctx, reqPath); err != nil {
+			return http.StatusNotFound, err
+		}
+	}
+	var body io.Reader = r.Body
+	if len(sums) > 0 {
+		spool, expected, actual, err := spoolVerified(r.Body, sums)
+		if err == ErrChecksumMismatch {
+			writeChecksumMismatch(w, expected, actual)
+			return 0, err
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		defer spool.Close()
+		body = spool
+	}
+	if len(h.Filters) > 0 {
+		// The obligations of the file as it is, since what is written is
+		// filtered before the file is opened to be replaced.  A new file
+		// has none until it is there.
+		var obligations Obligations
+		if existing, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDONLY, 0); err == nil {
+			if of, ok := existing.(ObligatedFile); ok {
+				obligations = of.Obligations()
+			}
+			existing.Close()
+		}
+		ctype := r.Header.Get("Content-Type")
+		if ctype == "" {
+			ctype = contentTypeOf(reqPath, nil)
+		}
+		rules := h.filtersFor(obligations, ctype, true)
+		if len(rules) > 0 {
+			// what is kept is not what was verified
+			sums = nil
+			spool, status, err := spoolFiltered(ctx, r, rules, obligations, ctype, body)
+			if err != nil {
+				return status, err
+			}
+			defer spool.Close()
+			body = spool
+		}
+	}
 	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
+		if err == ErrNameCollision {
+			return http.StatusConflict, err
+		}
 		return http.StatusNotFound, err
 	}
-	_, copyErr := io.Copy(f, r.Body)
+	_, copyErr := io.Copy(f, body)
+	if copyErr == nil {
+		recordChecksums(f, sums)
+	}
 	fi, statErr := f.Stat()
 	closeErr := f.Close()
 	// TODO(rost): Returning 405 Method Not Allowed might not be appropriate.
@@ -293,6 +478,9 @@ func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status in
 	if err != nil {
 		return status, err
 	}
+	if reqPath, status, err = h.checkName(w, r, reqPath, true); err != nil {
+		return status, err
+	}
 	release, status, err := h.confirmLocks(r, reqPath, "")
 	if err != nil {
 		return status, err
@@ -305,7 +493,7 @@ func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status in
 		return http.StatusUnsupportedMediaType, nil
 	}
 	if err := h.FileSystem.Mkdir(ctx, reqPath, 0777); err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) || err == ErrNameCollision {
 			return http.StatusConflict, err
 		}
 		return http.StatusMethodNotAllowed, err
@@ -318,11 +506,17 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 	if hdr == "" {
 		return http.StatusBadRequest, ErrInvalidDestination
 	}
-	u, err := url.Parse(hdr)
+	u, local, err := h.destination(r, hdr)
 	if err != nil {
-		return http.StatusBadRequest, ErrInvalidDestination
+		return http.StatusBadRequest, err
+	}
+	if !local {
+		if h.Remote == nil {
+			return http.StatusBadGateway, ErrInvalidDestination
+		}
+		return h.handleRemoteCopyMove(w, r, u)
 	}
-	if u.Host != "" && u.Host != r.Host {
+	if h.crossesMount(u.Path) {
 		return http.StatusBadGateway, ErrInvalidDestination
 	}
 
@@ -342,6 +536,9 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 	if dst == src {
 		return http.StatusForbidden, ErrDestinationEqualsSource
 	}
+	if dst, status, err = h.checkName(w, r, dst, true); err != nil {
+		return status, err
+	}
 
 	ctx := r.Context()
 
@@ -393,7 +590,7 @@ func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
-	li, status, err := readLockInfo(r.Body)
+	li, status, err := readLockInfo(r.Body, h.xmlLimits())
 	if err != nil {
 		return status, err
 	}
@@ -442,6 +639,18 @@ func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus
 			OwnerXML:  li.Owner.InnerXML,
 			ZeroDepth: depth == 0,
 		}
+		if !h.canLock(reqPath, ld.ZeroDepth) {
+			if h.lockMode(reqPath) == LockNone {
+				return http.StatusMethodNotAllowed, ErrLockingDisabled
+			}
+			return http.StatusConflict, ErrLockingDisabled
+		}
+		// a resource is locked through all of its bindings
+		unlock, status, err := h.lockBindings(r, reqPath, "")
+		if err != nil {
+			return status, err
+		}
+		defer unlock()
 		token, err = h.LockSystem.Create(now, ld)
 		if err != nil {
 			if err == ErrLocked {
@@ -457,6 +666,10 @@ func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus
 
 		// Create the resource if it didn't previously exist.
 		if _, err := h.FileSystem.Stat(ctx, reqPath); err != nil {
+			// the lock is on reqPath, so it is not sanitized
+			if _, status, err := h.checkName(w, r, reqPath, false); err != nil {
+				return status, err
+			}
 			f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 			if err != nil {
 				// TODO: detect missing intermediate dirs and return http.StatusConflict?
@@ -525,7 +738,15 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			return http.StatusBadRequest, ErrInvalidDepth
 		}
 	}
-	pf, status, err := readPropfind(r.Body)
+	offset, limit, paged, err := parsePage(r.Header)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if paged && depth == InfiniteDepth {
+		// there is no order to page a whole tree in
+		return http.StatusBadRequest, ErrInvalidPage
+	}
+	pf, status, err := readPropfind(r.Body, h.xmlLimits())
 	if err != nil {
 		return status, err
 	}
@@ -537,8 +758,13 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			return err
 		}
 		var pstats []Propstat
+		// Without locking, there are no locks to discover or support
+		ls := h.LockSystem
+		if h.lockMode(reqPath) == LockNone {
+			ls = nil
+		}
 		if pf.Propname != nil {
-			pnames, err := propnames(ctx, h.FileSystem, h.LockSystem, reqPath)
+			pnames, err := propnames(ctx, h.FileSystem, ls, reqPath)
 			if err != nil {
 				return err
 			}
@@ -548,13 +774,14 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			}
 			pstats = append(pstats, pstat)
 		} else if pf.Allprop != nil {
-			pstats, err = allprop(ctx, h.FileSystem, h.LockSystem, reqPath, pf.Prop)
+			pstats, err = allprop(ctx, h.FileSystem, ls, reqPath, pf.Prop)
 		} else {
-			pstats, err = props(ctx, h.FileSystem, h.LockSystem, reqPath, pf.Prop)
+			pstats, err = props(ctx, h.FileSystem, ls, reqPath, pf.Prop)
 		}
 		if err != nil {
 			return err
 		}
+		pstats = h.filterPropstats(ctx, reqPath, pstats, pf.Propname == nil && pf.Allprop == nil)
 		href := path.Join(h.Prefix, reqPath)
 		if href != "/" && info.IsDir() {
 			href += "/"
@@ -562,7 +789,12 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 		return mw.write(makePropstatResponse(href, pstats))
 	}
 
-	walkErr := WalkFS(ctx, h.FileSystem, depth, reqPath, fi, walkFn)
+	var walkErr error
+	if paged && depth == 1 && fi.IsDir() {
+		walkErr = walkPage(ctx, h.FileSystem, w, reqPath, fi, offset, limit, walkFn)
+	} else {
+		walkErr = WalkFS(ctx, h.FileSystem, depth, reqPath, fi, walkFn)
+	}
 	closeErr := mw.close()
 	if walkErr != nil {
 		return http.StatusInternalServerError, walkErr
@@ -592,13 +824,16 @@ func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request) (statu
 		}
 		return http.StatusMethodNotAllowed, err
 	}
-	patches, status, err := readProppatch(r.Body)
+	patches, status, err := readProppatch(r.Body, h.xmlLimits())
 	if err != nil {
 		return status, err
 	}
-	pstats, err := patch(ctx, h.FileSystem, h.LockSystem, reqPath, patches)
-	if err != nil {
-		return http.StatusInternalServerError, err
+	pstats := h.validatePatches(ctx, reqPath, patches)
+	if pstats == nil {
+		pstats, err = patch(ctx, h.FileSystem, h.LockSystem, reqPath, patches)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
 	}
 	mw := multistatusWriter{w: w}
 	writeErr := mw.write(makePropstatResponse(r.URL.Path, pstats))
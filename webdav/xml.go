@@ -49,11 +49,14 @@ type owner struct {
 	InnerXML string `xml:",innerxml"`
 }
 
-func readLockInfo(r io.Reader) (li lockInfo, status int, err error) {
-	c := &countingReader{r: r}
-	if err = ixml.NewDecoder(c).Decode(&li); err != nil {
+func readLockInfo(r io.Reader, limits XMLLimits) (li lockInfo, status int, err error) {
+	body, status, err := limits.read(r)
+	if err != nil {
+		return lockInfo{}, status, err
+	}
+	if err = ixml.NewDecoder(bytes.NewReader(body)).Decode(&li); err != nil {
 		if err == io.EOF {
-			if c.n == 0 {
+			if len(body) == 0 {
 				// An empty body means to refresh the lock.
 				// http://www.webdav.org/specs/rfc4918.html#refreshing-locks
 				return lockInfo{}, 0, nil
@@ -70,34 +73,34 @@ func readLockInfo(r io.Reader) (li lockInfo, status int, err error) {
 	return li, 0, nil
 }
 
-type countingReader struct {
-	n int
-	r io.Reader
-}
-
-func (c *countingReader) Read(p []byte) (int, error) {
-	n, err := c.r.Read(p)
-	c.n += n
-	return n, err
+func writeLockInfo(w io.Writer, token string, ld LockDetails) (int, error) {
+	return fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
+		"<D:prop xmlns:D=\"DAV:\"><D:lockdiscovery>%s</D:lockdiscovery></D:prop>",
+		activeLockXML(token, ld, ld.Duration),
+	)
 }
 
-func writeLockInfo(w io.Writer, token string, ld LockDetails) (int, error) {
+// activeLockXML describes a lock, with the time that it has left.  A negative
+// timeout means that it never times out.
+func activeLockXML(token string, ld LockDetails, timeout time.Duration) string {
 	depth := "infinity"
 	if ld.ZeroDepth {
 		depth = "0"
 	}
-	timeout := ld.Duration / time.Second
-	return fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"+
-		"<D:prop xmlns:D=\"DAV:\"><D:lockdiscovery><D:activelock>\n"+
+	seconds := "Infinite"
+	if timeout >= 0 {
+		seconds = fmt.Sprintf("Second-%d", (timeout+time.Second-1)/time.Second)
+	}
+	return fmt.Sprintf("<D:activelock>\n"+
 		"	<D:locktype><D:write/></D:locktype>\n"+
 		"	<D:lockscope><D:exclusive/></D:lockscope>\n"+
 		"	<D:depth>%s</D:depth>\n"+
 		"	<D:owner>%s</D:owner>\n"+
-		"	<D:timeout>Second-%d</D:timeout>\n"+
+		"	<D:timeout>%s</D:timeout>\n"+
 		"	<D:locktoken><D:href>%s</D:href></D:locktoken>\n"+
 		"	<D:lockroot><D:href>%s</D:href></D:lockroot>\n"+
-		"</D:activelock></D:lockdiscovery></D:prop>",
-		depth, ld.OwnerXML, timeout, escape(token), escape(ld.Root),
+		"</D:activelock>",
+		depth, ld.OwnerXML, seconds, escape(token), escape(ld.Root),
 	)
 }
 
@@ -175,11 +178,14 @@ type propfind struct {
 	Include  propfindProps `xml:"DAV: include"`
 }
 
-func readPropfind(r io.Reader) (pf propfind, status int, err error) {
-	c := countingReader{r: r}
-	if err = ixml.NewDecoder(&c).Decode(&pf); err != nil {
+func readPropfind(r io.Reader, limits XMLLimits) (pf propfind, status int, err error) {
+	body, status, err := limits.read(r)
+	if err != nil {
+		return propfind{}, status, err
+	}
+	if err = ixml.NewDecoder(bytes.NewReader(body)).Decode(&pf); err != nil {
 		if err == io.EOF {
-			if c.n == 0 {
+			if len(body) == 0 {
 				// An empty body means to propfind allprop.
 				// http://www.webdav.org/specs/rfc4918.html#METHOD_PROPFIND
 				return propfind{Allprop: new(struct{})}, 0, nil
@@ -493,9 +499,13 @@ type propertyupdate struct {
 	SetRemove []setRemove `xml:",any"`
 }
 
-func readProppatch(r io.Reader) (patches []Proppatch, status int, err error) {
+func readProppatch(r io.Reader, limits XMLLimits) (patches []Proppatch, status int, err error) {
+	body, status, err := limits.read(r)
+	if err != nil {
+		return nil, status, err
+	}
 	var pu propertyupdate
-	if err = ixml.NewDecoder(r).Decode(&pu); err != nil {
+	if err = ixml.NewDecoder(bytes.NewReader(body)).Decode(&pu); err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 	for _, op := range pu.SetRemove {
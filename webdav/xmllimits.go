@@ -0,0 +1,106 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	ixml "github.com/rfielding/webdev/webdav/internal/xml"
+)
+
+// XMLLimits bound the bodies of PROPFIND, PROPPATCH and LOCK, which are
+// parsed before anything else about the request is checked, so that anybody
+// who can reach the server can send them.  A limit that is not more than
+// zero is not checked.
+type XMLLimits struct {
+	// MaxBytes is the most that a body may be.  More is 413.
+	MaxBytes int64
+	// MaxDepth is how deeply elements may nest, property values included.
+	MaxDepth int
+	// MaxProperties is how many properties one request may name or set.
+	MaxProperties int
+	// MaxAttributeSize is how long the name or value of an attribute,
+	// namespace declarations included, may be.
+	MaxAttributeSize int
+}
+
+// DefaultXMLLimits are the limits of a Handler whose XMLLimits are nil.
+var DefaultXMLLimits = XMLLimits{
+	MaxBytes:         1 << 20,
+	MaxDepth:         32,
+	MaxProperties:    1000,
+	MaxAttributeSize: 4096,
+}
+
+var (
+	// ErrXMLLimit is returned when a body is over one of the XMLLimits.
+	ErrXMLLimit = errors.New("webdav: xml body is over a limit")
+	// ErrXMLEntity is returned for a body that declares entities, which are
+	// only there to be expanded into something bigger than the body.
+	ErrXMLEntity = errors.New("webdav: xml entity declarations are not allowed")
+)
+
+func (h *Handler) xmlLimits() XMLLimits {
+	if h.XMLLimits != nil {
+		return *h.XMLLimits
+	}
+	return DefaultXMLLimits
+}
+
+var davProp = ixml.Name{Space: "DAV:", Local: "prop"}
+
+// read reads all of a body, and checks it against the limits, so that the
+// decoder that unmarshals it afterwards has nothing pathological to work on.
+// Bodies that are not well formed are left for the decoder to say so.
+func (l XMLLimits) read(r io.Reader) ([]byte, int, error) {
+	if l.MaxBytes > 0 {
+		r = io.LimitReader(r, l.MaxBytes+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if l.MaxBytes > 0 && int64(len(body)) > l.MaxBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("%w: more than %d bytes", ErrXMLLimit, l.MaxBytes)
+	}
+	d := ixml.NewDecoder(bytes.NewReader(body))
+	// whether each open element is a DAV:prop, whose children are properties
+	var open []bool
+	properties := 0
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return body, 0, nil
+		}
+		switch t := t.(type) {
+		case ixml.StartElement:
+			if l.MaxDepth > 0 && len(open) >= l.MaxDepth {
+				return nil, http.StatusBadRequest, fmt.Errorf("%w: nested more than %d deep", ErrXMLLimit, l.MaxDepth)
+			}
+			if len(open) > 0 && open[len(open)-1] {
+				properties++
+				if l.MaxProperties > 0 && properties > l.MaxProperties {
+					return nil, http.StatusBadRequest, fmt.Errorf("%w: more than %d properties", ErrXMLLimit, l.MaxProperties)
+				}
+			}
+			for _, attr := range t.Attr {
+				size := len(attr.Name.Space) + len(attr.Name.Local) + len(attr.Value)
+				if l.MaxAttributeSize > 0 && size > l.MaxAttributeSize {
+					return nil, http.StatusBadRequest, fmt.Errorf("%w: an attribute of more than %d bytes", ErrXMLLimit, l.MaxAttributeSize)
+				}
+			}
+			open = append(open, t.Name == davProp)
+		case ixml.EndElement:
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		case ixml.Directive:
+			if bytes.Contains(t, []byte("ENTITY")) {
+				return nil, http.StatusBadRequest, ErrXMLEntity
+			}
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rfielding/webdev/webdav/fs"
+	"github.com/rfielding/webdev/webdav/fs/example1"
+	"github.com/rfielding/webdev/webdav/testsuite"
+)
+
+/*
+  Administrative commands that work directly against the volume,
+  for when the server is not running, or from cron.
+*/
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: webdavctl <command> [flags]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  gc    report or remove metadata whose file no longer exists\n")
+	fmt.Fprintf(os.Stderr, "  du    report bytes and file counts per directory as json\n")
+	fmt.Fprintf(os.Stderr, "  policytest  run the .__policy_tests.json for a directory\n")
+	fmt.Fprintf(os.Stderr, "  hash  hash a password from stdin, for a users file\n")
+	fmt.Fprintf(os.Stderr, "  snapshot  write a directory and its metadata as a tar\n")
+	fmt.Fprintf(os.Stderr, "  restore   unpack a snapshot into a new directory, or a new volume\n")
+	fmt.Fprintf(os.Stderr, "  migrate   import properties kept by another server, and write starting policies\n")
+	fmt.Fprintf(os.Stderr, "  litmus    run the litmus compliance suite against the handler, and check the pass list\n")
+	fmt.Fprintf(os.Stderr, "  collisions  report names in a directory that normalize to the same name\n")
+	fmt.Fprintf(os.Stderr, "  chown     give files to another owner\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "gc":
+		err = gc(os.Args[2:])
+	case "du":
+		err = du(os.Args[2:])
+	case "policytest":
+		err = policytest(os.Args[2:])
+	case "hash":
+		err = hash(os.Args[2:])
+	case "snapshot":
+		err = snapshot(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "litmus":
+		err = litmus(os.Args[2:])
+	case "collisions":
+		err = collisions(os.Args[2:])
+	case "chown":
+		err = chown(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[x] %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func gc(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	remove := flags.Bool("r", false, "Remove the orphans, rather than just reporting them")
+	flags.Parse(args)
+
+	fsys := fs.FS{Root: *dir}
+	orphans, err := fsys.CollectOrphans(context.Background(), !*remove)
+	for _, o := range orphans {
+		fmt.Printf("%s\t%s\n", o.Sidecar, o.Primary)
+	}
+	return err
+}
+
+func collisions(args []string) error {
+	flags := flag.NewFlagSet("collisions", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	name := flags.String("p", "/", "Path within the directory to check")
+	names := flags.String("names", "nfc,fold", "Which names are the same name, as the server's -names")
+	flags.Parse(args)
+
+	n, err := fs.ParseNormalization(*names)
+	if err != nil {
+		return err
+	}
+	fsys := fs.FS{Root: *dir, Names: n}
+	found, err := fsys.Collisions(context.Background(), *name)
+	for _, c := range found {
+		fmt.Println(strings.Join(c, "\t"))
+	}
+	if err == nil && len(found) > 0 {
+		err = fmt.Errorf("%d names collide", len(found))
+	}
+	return err
+}
+
+func chown(args []string) error {
+	flags := flag.NewFlagSet("chown", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	name := flags.String("p", "/", "Path within the directory to give away")
+	owner := flags.String("o", "", "Who to give it to")
+	from := flags.String("from", "", "Only give away what this user owns")
+	recursive := flags.Bool("r", false, "Give away everything under the path too")
+	flags.Parse(args)
+
+	if *owner == "" {
+		return fmt.Errorf("-o is required")
+	}
+	fsys := fs.FS{Root: *dir}
+	changed, err := fsys.Chown(context.Background(), *name, *from, *owner, *recursive)
+	fmt.Printf("%d changed\n", changed)
+	return err
+}
+
+func du(args []string) error {
+	flags := flag.NewFlagSet("du", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	name := flags.String("p", "/", "Path within the directory to report on")
+	flags.Parse(args)
+
+	fsys := fs.FS{Root: *dir}
+	usage, err := fsys.Usage(context.Background(), *name)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(j))
+	return nil
+}
+
+func policytest(args []string) error {
+	flags := flag.NewFlagSet("policytest", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	name := flags.String("p", "/", "Path within the directory whose tests to run")
+	regoFile := flags.String("r", "", "Rego file to test, rather than the policy in effect")
+	flags.Parse(args)
+
+	opaObj := ""
+	if *regoFile != "" {
+		data, err := ioutil.ReadFile(*regoFile)
+		if err != nil {
+			return err
+		}
+		opaObj = string(data)
+	}
+	fsys := fs.FS{Root: *dir}
+	report, err := example1.RunPolicyTests(fsys.Root, fsys.Resolve(*name), opaObj)
+	if err != nil {
+		return err
+	}
+	for _, c := range report.Cases {
+		if c.Pass {
+			fmt.Printf("ok   %s\n", c.Name)
+			continue
+		}
+		fmt.Printf("FAIL %s\n", c.Name)
+		for _, m := range c.Mismatch {
+			fmt.Printf("     %s\n", m)
+		}
+		if c.Error != "" {
+			fmt.Printf("     %s\n", c.Error)
+		}
+	}
+	fmt.Printf("%d passed, %d failed in %s\n", report.Passed, report.Failed, report.Tests)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d policy tests failed", report.Failed)
+	}
+	return nil
+}
+
+func hash(args []string) error {
+	flags := flag.NewFlagSet("hash", flag.ExitOnError)
+	flags.Parse(args)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return fmt.Errorf("no password on stdin")
+	}
+	h, err := example1.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(h)
+	return nil
+}
+
+func snapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	name := flags.String("p", "/", "Path within the directory to snapshot")
+	out := flags.String("o", "-", "File to write the tar to, or - for stdout")
+	flags.Parse(args)
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	fsys := fs.FS{Root: *dir}
+	info, err := fsys.Snapshot(context.Background(), *name, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d files, %d directories, %d bytes\n", info.Files, info.Dirs, info.Bytes)
+	return w.Sync()
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := flags.String("f", "-", "Snapshot to restore, or - for stdin")
+	dir := flags.String("d", "", "Directory to restore into, which must not exist yet")
+	flags.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("say where to restore to with -d")
+	}
+	r := os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	info, err := fs.Restore(context.Background(), bufio.NewReader(r), *dir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s taken %s: %d files, %d directories, %d bytes\n", info.Path, info.Time.Format(time.RFC3339), info.Files, info.Dirs, info.Bytes)
+	return nil
+}
+
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := flags.String("d", "./data", "Directory that the server serves from")
+	sidecar := flags.String("sidecar", "", "Suffix of files that hold the properties of the file they are named after, as .props. Default is none")
+	xattrs := flags.Bool("xattrs", false, "Import extended attributes in the user namespace as properties")
+	xattrNS := flags.String("xattrns", "urn:webdev:xattr", "Namespace of properties from extended attributes that do not have one")
+	overwrite := flags.Bool("overwrite", false, "Replace properties that are set already")
+	admins := flags.String("admin", "", "Comma separated users to make admins in a new root policy")
+	noPolicies := flags.Bool("nopolicies", false, "Do not write claims and policies where there are none")
+	dryRun := flags.Bool("n", false, "Report what would be done, without doing it")
+	flags.Parse(args)
+
+	fsys := fs.FS{Root: *dir}
+	migrated, err := fsys.ImportProperties(context.Background(), fs.MigrateOptions{
+		Sidecar:        *sidecar,
+		Xattrs:         *xattrs,
+		XattrNamespace: *xattrNS,
+		Overwrite:      *overwrite,
+		DryRun:         *dryRun,
+	})
+	for _, m := range migrated {
+		for _, k := range m.Imported {
+			fmt.Printf("prop\t%s\t%s\n", m.Path, k)
+		}
+		for _, k := range m.Skipped {
+			fmt.Printf("skip\t%s\t%s\n", m.Path, k)
+		}
+	}
+	if err != nil || *noPolicies {
+		return err
+	}
+	var adminList []string
+	for _, a := range strings.Split(*admins, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			adminList = append(adminList, a)
+		}
+	}
+	written, err := example1.ScaffoldPolicies(fsys.Root, adminList, *dryRun)
+	for _, name := range written {
+		fmt.Printf("wrote\t%s\n", name)
+	}
+	return err
+}
+
+func litmus(args []string) error {
+	flags := flag.NewFlagSet("litmus", flag.ExitOnError)
+	binary := flags.String("litmus", "", "The litmus script. Default is $LITMUS, or litmus on the PATH")
+	suites := flags.String("suites", strings.Join(testsuite.DefaultSuites, ","), "Comma separated litmus suites to run")
+	passList := flags.String("pass", "./litmus.pass", "File of the tests that are expected to pass")
+	update := flags.Bool("update", false, "Write the tests that pass to the pass list, rather than checking it")
+	prefix := flags.String("prefix", "", "Url path to serve under")
+	timeout := flags.Duration("timeout", 5*time.Minute, "How long litmus may take")
+	verbose := flags.Bool("v", false, "Print what litmus prints")
+	flags.Parse(args)
+
+	l := testsuite.Litmus{Binary: *binary, Suites: strings.Split(*suites, ","), Timeout: *timeout}
+	if *verbose {
+		l.Output = os.Stdout
+	}
+	srv, err := testsuite.Start(testsuite.Options{Prefix: *prefix, Username: "litmus", Password: "litmus"})
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	report, err := l.Run(context.Background(), srv.URL, "litmus", "litmus")
+	if err != nil {
+		return err
+	}
+	if *update {
+		fmt.Printf("%d tests pass\n", len(report.Passed()))
+		return testsuite.WritePassList(*passList, report)
+	}
+	expected, err := testsuite.ReadPassList(*passList)
+	if err != nil {
+		return err
+	}
+	regressions := report.Regressions(expected)
+	for _, res := range regressions {
+		fmt.Printf("FAIL %s\t%s\n", res.ID, res.Reason)
+	}
+	fmt.Printf("%d passed, %d expected, %d regressed\n", len(report.Passed()), len(expected), len(regressions))
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d litmus tests regressed", len(regressions))
+	}
+	return nil
+}